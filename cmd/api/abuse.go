@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/abuse"
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// ABUSE DETECTION — every answered request goes to the detector
+// (internal/abuse) under its client's key, as the rate limiter
+// knows it: the API key, the user, or the IP. An anomaly is
+//   - logged at warn level, with the request that tipped it
+//   - counted in anomalies_total{kind}, for alerting rules
+//   - kept in the anomalies table:
+//       GET /admin/anomalies — ?client=&kind=&org_id=&from=&to=
+// Nothing is blocked: the rate limit does that, and revoking a
+// key (POST /apikeys/{id}/revoke) is for a person to decide.
+// -----------------------------------------------------------
+
+// anomalyPurgeLock — one instance purges at a time
+const anomalyPurgeLock = "anomalies:purge"
+
+var anomalyKinds = []string{string(abuse.RateSpike), string(abuse.ErrorSpike), string(abuse.NewEndpoint), string(abuse.Enumeration)}
+
+// detectAbuse runs after authenticate, so requests are known by their
+// credential, and before rateLimit, so the 429s a burst earns are seen
+// too
+func (app *App) detectAbuse(next http.Handler) http.Handler {
+	if app.Abuse == nil {
+		return next // abuse.enabled is off
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := app.routeTemplate(r)
+		for _, a := range app.Abuse.Observe(abuse.Request{
+			Client: clientKey(r),
+			Route:  route,
+			ID:     routeParam(route, r.URL.Path, "id"),
+			Status: rec.status,
+		}) {
+			app.reportAnomaly(r, a)
+		}
+	})
+}
+
+// routeParam — the {name} segment of path, which matched template with
+// or without a version prefix; "" if the template has none
+func routeParam(template, path, name string) string {
+	if template == "" {
+		return ""
+	}
+	tsegs := strings.Split(strings.Trim(template, "/"), "/")
+	psegs := strings.Split(strings.Trim(path, "/"), "/")
+	off := len(psegs) - len(tsegs) // "v1"
+	if off < 0 {
+		return ""
+	}
+	if i := slices.Index(tsegs, "{"+name+"}"); i >= 0 {
+		return psegs[off+i]
+	}
+	return ""
+}
+
+// reportAnomaly logs, counts and stores a; the response has gone out
+// already, so a failure to store it is only logged
+func (app *App) reportAnomaly(r *http.Request, a abuse.Anomaly) {
+	ctx := context.WithoutCancel(r.Context())
+	requestctx.Logger(ctx).Warn("anomaly", "client", a.Client, "kind", a.Kind, "route", a.Route, "detail", a.Detail)
+	app.Metrics.anomalies.WithLabelValues(string(a.Kind)).Inc()
+
+	if app.Anomalies == nil {
+		return
+	}
+	rec := repository.Anomaly{
+		Client:    a.Client,
+		Kind:      string(a.Kind),
+		Route:     a.Route,
+		Detail:    a.Detail,
+		RequestID: requestctx.RequestID(ctx),
+		ClientIP:  requestctx.ClientIP(ctx),
+	}
+	if p, ok := principalCtx.Get(ctx); ok && p.OrgID != 0 {
+		rec.OrgID = &p.OrgID
+	}
+	if err := app.Anomalies.Record(ctx, rec); err != nil {
+		requestctx.Logger(ctx).Error("record anomaly", "err", err)
+	}
+}
+
+// GET /admin/anomalies — newest first; none with abuse.enabled off
+func (app *App) handleListAnomalies(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/admin/anomalies")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f, err := anomalyFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if app.Anomalies == nil {
+		writeList(w, r, page, []repository.Anomaly{})
+		return
+	}
+	list, err := app.Anomalies.List(r.Context(), f, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, list)
+}
+
+// anomalyFilter reads the /admin/anomalies query; every bad parameter
+// is an INVALID_PARAM
+func anomalyFilter(r *http.Request) (repository.AnomalyFilter, error) {
+	var (
+		f    repository.AnomalyFilter
+		errs []error
+	)
+	q := r.URL.Query()
+	f.Client = q.Get("client")
+	if s := q.Get("kind"); s != "" {
+		if !slices.Contains(anomalyKinds, s) {
+			errs = append(errs, fmt.Errorf("kind %q must be one of %v", s, anomalyKinds))
+		}
+		f.Kind = s
+	}
+	if s := q.Get("org_id"); s != "" {
+		id, err := validate.ParseID(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("org_id %q must be an organization ID", s))
+		}
+		f.OrgID = &id
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := q.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q must be an RFC 3339 time (2026-01-31T17:00:00Z)", p.name, s))
+			}
+			*p.dst = t.UTC() // created_at is UTC without a zone
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		errs = append(errs, errors.New("from must be before to"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return f, apperr.New(apperr.InvalidParam, err.Error())
+	}
+	return f, nil
+}
+
+// anomalyPurgeJob deletes anomalies older than cfg.Retention every
+// cfg.PurgeInterval
+func (app *App) anomalyPurgeJob(cfg config.AbuseConfig) schedule.Job {
+	return scheduled("anomaly_purge", anomalyPurgeLock, cfg.PurgeInterval, cfg.Schedule, func(ctx context.Context) error {
+		n, err := app.Anomalies.Purge(ctx, cfg.Retention)
+		if err != nil {
+			app.Log.Error("anomaly purge failed", "err", err)
+			return err
+		}
+		if n > 0 {
+			app.Log.Info("anomalies purged", "anomalies", n, "older_than", cfg.Retention)
+		}
+		return nil
+	})
+}
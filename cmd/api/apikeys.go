@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// API KEYS — how other services authenticate (see authn.go):
+//   Authorization: ApiKey sbx_...   (gRPC: authorization metadata)
+// A key acts as the user who minted it — their organization and
+// their role — and its scope can narrow that to reads. A key
+// with a rate limit of its own is throttled by it instead of
+// rate_limit.rps and burst (which must be on).
+//   GET  /apikeys             — keys (?user_id=), never secrets
+//   POST /apikeys             — mint one; the response has the
+//                               key, and is the only one that does
+//   POST /apikeys/{id}/revoke — for good
+// Members mint and revoke their own keys, admins anyone's.
+// -----------------------------------------------------------
+
+const (
+	apiKeyScheme  = "ApiKey"
+	apiKeyPrefix  = "sbx_"
+	apiKeyShown   = len(apiKeyPrefix) + 8 // characters kept as Prefix
+	apiKeyTouch   = time.Minute           // how stale last_used_at may get
+	maxAPIKeyName = 100                   // api_keys.name VARCHAR(100)
+)
+
+var apiKeyScopes = []string{repository.ScopeRead, repository.ScopeWrite}
+
+type CreateAPIKeyRequest struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Scope  string `json:"scope,omitempty"` // read (the default) or write
+	// RateLimitRPS and RateLimitBurst — both or neither; admins only,
+	// as they replace rate_limit's for the key, higher or not
+	RateLimitRPS   *float64 `json:"rate_limit_rps,omitempty"`
+	RateLimitBurst *int     `json:"rate_limit_burst,omitempty"`
+}
+
+// MintedAPIKey — POST /apikeys's response: the key, and the secret to
+// send as Authorization: ApiKey <key>
+type MintedAPIKey struct {
+	repository.APIKey
+	Key string `json:"key"`
+}
+
+func (req CreateAPIKeyRequest) validate() error {
+	v := validate.New().
+		ID("user_id", req.UserID).
+		Required("name", req.Name).
+		MaxLen("name", req.Name, maxAPIKeyName)
+	if req.Scope != "" {
+		v.OneOf("scope", req.Scope, apiKeyScopes)
+	}
+	v.Check("rate_limit_burst", (req.RateLimitRPS == nil) == (req.RateLimitBurst == nil), "set both rate_limit_rps and rate_limit_burst, or neither")
+	if req.RateLimitRPS != nil {
+		v.Check("rate_limit_rps", *req.RateLimitRPS > 0, "must be positive")
+	}
+	if req.RateLimitBurst != nil {
+		v.Check("rate_limit_burst", *req.RateLimitBurst >= 1, "must be at least 1")
+	}
+	return v.Err()
+}
+
+// newAPIKey — "sbx_" and 32 random bytes, hex
+func newAPIKey() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return apiKeyPrefix + hex.EncodeToString(b)
+}
+
+// hashAPIKey — what api_keys.hash stores. A plain SHA-256 is enough:
+// the secret is random, there is nothing to guess from.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// withAPIKey authenticates key: ctx gains the key and its user
+func (app *App) withAPIKey(ctx context.Context, key string) (context.Context, error) {
+	k, err := app.APIKeys.Lookup(ctx, hashAPIKey(key))
+	if errors.Is(err, repository.ErrNotFound) {
+		return ctx, apperr.New(apperr.Unauthenticated, "unknown or revoked API key")
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if k.LastUsedAt == nil || time.Since(*k.LastUsedAt) > apiKeyTouch {
+		// the request needn't wait for it
+		app.Go(ctx, "apikey.touch", func(ctx context.Context) {
+			if err := app.APIKeys.Touch(ctx, k.ID); err != nil {
+				requestctx.Logger(ctx).Warn("api key last use", "api_key", k.ID, "err", err)
+			}
+		})
+	}
+	ctx = withPrincipal(ctx, principal{UserID: k.UserID, OrgID: k.OrgID, Key: &k})
+	return requestctx.WithLogger(ctx, requestctx.Logger(ctx).With("api_key", k.ID, "user_id", k.UserID)), nil
+}
+
+// GET /apikeys — ?user_id= for one user's
+func (app *App) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/apikeys")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var userID int
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		if userID, err = validate.ParseID(s); err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("user_id %q must be a user ID", s)))
+			return
+		}
+	}
+
+	keys, err := app.APIKeys.List(r.Context(), userID, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, keys)
+}
+
+// POST /apikeys — only the hash is kept: a lost key can't be shown
+// again, only revoked and replaced
+func (app *App) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := app.authorize(r.Context(), authz.Write, req.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.RateLimitRPS != nil {
+		if err := app.authorize(r.Context(), authz.Manage, 0); err != nil {
+			writeError(w, r, err) // 403 "only admins can do this"
+			return
+		}
+	}
+	if req.Scope == "" {
+		req.Scope = repository.ScopeRead
+	}
+
+	key := newAPIKey()
+	k, err := app.APIKeys.Create(r.Context(), repository.NewAPIKey{
+		UserID:         req.UserID,
+		Name:           req.Name,
+		Prefix:         key[:apiKeyShown],
+		Hash:           hashAPIKey(key),
+		Scope:          req.Scope,
+		RateLimitRPS:   req.RateLimitRPS,
+		RateLimitBurst: req.RateLimitBurst,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, MintedAPIKey{APIKey: k, Key: key})
+}
+
+// POST /apikeys/{id}/revoke — again is a no-op
+func (app *App) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+	err := app.authorizeOwned(r.Context(), func() (int, error) {
+		k, err := app.APIKeys.Get(r.Context(), id)
+		return k.UserID, err
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	k, err := app.APIKeys.Revoke(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, k)
+}
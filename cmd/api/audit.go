@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// AUDIT LOG — who changed what and when, written by the
+// repositories (see repository/audit.go); read here:
+//   GET /tasks/{id}/audit — one task's history, newest first
+//   GET /admin/audit      — everything, by entity, organization,
+//                           user, time
+// -----------------------------------------------------------
+
+var auditEntities = []string{repository.AuditTask, repository.AuditUser, repository.AuditWebhook}
+
+// GET /tasks/{id}/audit — also for a task in the trash or purged: the
+// log outlives it
+func (app *App) handleTaskAudit(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/tasks/{id}/audit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	id := pathID(r)
+	list, err := app.Audit.List(r.Context(), repository.AuditFilter{Entity: repository.AuditTask, EntityID: id}, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if len(list) == 0 && page.Offset == 0 {
+		// No history: a task from before the log, or none at all (404)
+		if _, err := app.Tasks.Get(r.Context(), id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeList(w, r, page, list)
+}
+
+// GET /admin/audit — ?entity=, ?entity_id=, ?org_id=, ?user_id= (who
+// made the change), ?from=&to= (RFC 3339, to exclusive)
+func (app *App) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/admin/audit")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	f, err := auditFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	list, err := app.Audit.List(r.Context(), f, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, list)
+}
+
+// auditFilter reads the /admin/audit query; every bad parameter is
+// an INVALID_PARAM
+func auditFilter(r *http.Request) (repository.AuditFilter, error) {
+	var (
+		f    repository.AuditFilter
+		errs []error
+	)
+	q := r.URL.Query()
+	if s := q.Get("entity"); s != "" {
+		if !slices.Contains(auditEntities, s) {
+			errs = append(errs, fmt.Errorf("entity %q must be one of %v", s, auditEntities))
+		}
+		f.Entity = s
+	}
+	if s := q.Get("entity_id"); s != "" {
+		id, err := validate.ParseID(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("entity_id %q must be an ID", s))
+		}
+		f.EntityID = id
+	}
+	if s := q.Get("org_id"); s != "" {
+		id, err := validate.ParseID(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("org_id %q must be an organization ID", s))
+		}
+		f.OrgID = &id
+	}
+	if s := q.Get("user_id"); s != "" {
+		id, err := validate.ParseID(s)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("user_id %q must be a user ID", s))
+		}
+		f.ActorID = &id
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"from", &f.From}, {"to", &f.To}} {
+		if s := q.Get(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s %q must be an RFC 3339 time (2026-01-31T17:00:00Z)", p.name, s))
+			}
+			*p.dst = t.UTC() // created_at is UTC without a zone
+		}
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		errs = append(errs, errors.New("from must be before to"))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return f, apperr.New(apperr.InvalidParam, err.Error())
+	}
+	return f, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/jwt"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// AUTHENTICATION — who a request is, from its Authorization
+// header (gRPC: the authorization metadata):
+//   ApiKey sbx_...  — a service, with an API key (apikeys.go)
+//   Bearer eyJ...   — a user, with the access token a login
+//                     issued (/auth/password in passwords.go,
+//                     OIDC in oidc.go, or a refresh, refresh.go);
+//                     needs auth.token_secret
+// or, without one, its session cookie (sessions.go). Either way
+// the request acts as a user: in their organization (X-Org-ID can
+// be left out; another one is 403) and with their role (authz.go).
+// Credentials that don't check out are 401 UNAUTHENTICATED; a
+// request without any is anonymous, and gets auth.anonymous_role
+// (none by default: 401, see tenancy.go).
+// -----------------------------------------------------------
+
+const (
+	bearerScheme = "Bearer"
+	// tokenIssuer — the iss of our access tokens
+	tokenIssuer = "sandbox-go"
+)
+
+// principal — who authenticated a request, and with what
+type principal struct {
+	UserID  int
+	OrgID   int
+	Key     *repository.APIKey  // the API key, if it was one
+	Session *repository.Session // the session, if it was a cookie
+}
+
+var principalCtx = requestctx.NewKey[principal]("principal")
+
+// withPrincipal — ctx acts as p's user from here on
+func withPrincipal(ctx context.Context, p principal) context.Context {
+	return requestctx.WithUserID(principalCtx.With(ctx, p), p.UserID)
+}
+
+// String names the credential in messages
+func (p principal) String() string {
+	if p.Key != nil {
+		return fmt.Sprintf("API key %d", p.Key.ID)
+	}
+	if p.Session != nil {
+		return fmt.Sprintf("the session of user %d", p.UserID)
+	}
+	return fmt.Sprintf("the token of user %d", p.UserID)
+}
+
+// accessClaims — what our access tokens say
+type accessClaims struct {
+	jwt.Claims
+	OrgID int `json:"org"`
+}
+
+// issueToken — an access token for u, valid for auth.token_ttl
+func (app *App) issueToken(u User) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(app.TokenTTL)
+	token, err := jwt.SignHS256(accessClaims{
+		Claims: jwt.Claims{
+			Issuer:    tokenIssuer,
+			Subject:   strconv.Itoa(u.ID),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: exp.Unix(),
+		},
+		OrgID: u.OrgID,
+	}, app.TokenSecret)
+	return token, exp, err
+}
+
+// withToken authenticates an access token
+func (app *App) withToken(ctx context.Context, token string) (context.Context, error) {
+	var c accessClaims
+	err := jwt.Verify(token, func(jwt.Header) (any, error) { return app.TokenSecret, nil }, &c)
+	if err == nil {
+		err = c.Valid(time.Now(), 0)
+	}
+	if errors.Is(err, jwt.ErrExpired) {
+		return ctx, apperr.New(apperr.Unauthenticated, "the token has expired; log in again")
+	}
+	id, convErr := strconv.Atoi(c.Subject)
+	if err != nil || convErr != nil || c.Issuer != tokenIssuer || id < 1 || c.OrgID < 1 {
+		return ctx, apperr.New(apperr.Unauthenticated, "invalid token")
+	}
+	ctx = withPrincipal(ctx, principal{UserID: id, OrgID: c.OrgID})
+	return requestctx.WithLogger(ctx, requestctx.Logger(ctx).With("user_id", id)), nil
+}
+
+// withCredentials authenticates an Authorization value; "" and schemes
+// that aren't ours leave the request anonymous
+func (app *App) withCredentials(ctx context.Context, authorization string) (context.Context, string, error) {
+	scheme, value, _ := strings.Cut(authorization, " ")
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.EqualFold(scheme, apiKeyScheme) && app.APIKeys != nil:
+		ctx, err := app.withAPIKey(ctx, value)
+		return ctx, apiKeyScheme, err
+	case strings.EqualFold(scheme, bearerScheme) && app.TokenSecret != nil:
+		ctx, err := app.withToken(ctx, value)
+		return ctx, bearerScheme, err
+	}
+	return ctx, "", nil
+}
+
+// authenticate — before rateLimit, which limits by caller, and tenant,
+// which takes the caller's organization
+func (app *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		ctx, scheme, err := app.withCredentials(r.Context(), authorization)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", scheme)
+			writeError(w, r, err)
+			return
+		}
+		if c, cookieErr := r.Cookie(sessionCookie); authorization == "" && cookieErr == nil && app.Sessions != nil {
+			if ctx, err = app.withSession(ctx, r, c.Value); err != nil {
+				if apperr.From(err).Code == apperr.Unauthenticated {
+					http.SetCookie(w, app.sessionCookie("", time.Time{})) // stale: stop sending it
+				}
+				writeError(w, r, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateRPC — authenticate for gRPC calls
+func (app *App) authenticateRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			authorization = v[0]
+		}
+	}
+	ctx, _, err := app.withCredentials(ctx, authorization)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// ROLES — what a caller may do (the rules are internal/authz):
+// admins everything, members their own tasks, webhooks and API keys,
+// viewers only read. Handlers ask before each write, and a no is
+// 403 FORBIDDEN. The caller is the authenticated user
+// (requestctx.UserID, see authn.go) with their users.role — and
+// nothing but reads with a read-scoped key — or, for a request
+// nobody authenticated, auth.anonymous_role ("" by default: no
+// rights, so 401 on every scoped route, reads too — tenancy.go).
+// Reads aren't checked: every role may read.
+//   PUT /admin/users/{id}/role — an admin changes a user's role
+// -----------------------------------------------------------
+
+// caller — who a request acts as
+type caller struct {
+	UserID   int // 0 = anonymous
+	Role     authz.Role
+	ReadOnly bool // an API key of scope read
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+var roleNames = func() []string {
+	names := make([]string, len(authz.Roles))
+	for i, r := range authz.Roles {
+		names[i] = string(r)
+	}
+	return names
+}()
+
+func (req RoleRequest) validate() error {
+	return validate.New().
+		Required("role", req.Role).
+		OneOf("role", req.Role, roleNames).
+		Err()
+}
+
+// caller looks up ctx's user; a user who isn't there (deleted, or of
+// another organization) can't do anything
+func (app *App) caller(ctx context.Context) (caller, error) {
+	id, ok := requestctx.UserID(ctx)
+	if !ok {
+		return caller{Role: app.AnonymousRole}, nil
+	}
+	u, err := app.Users.Get(ctx, id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return caller{}, apperr.New(apperr.Forbidden, fmt.Sprintf("user %d can't act in this organization", id))
+	}
+	if err != nil {
+		return caller{}, err
+	}
+	p, _ := principalCtx.Get(ctx)
+	return caller{UserID: id, Role: authz.Role(u.Role), ReadOnly: p.Key != nil && p.Key.Scope == repository.ScopeRead}, nil
+}
+
+// may — nil if c may do action to a resource owned by owner (a user
+// ID; 0 = nobody's)
+func (c caller) may(action authz.Action, owner int) error {
+	if c.ReadOnly && action != authz.Read {
+		return apperr.New(apperr.Forbidden, "this API key can only read")
+	}
+	if authz.Allow(c.Role, action, owner != 0 && owner == c.UserID) {
+		return nil
+	}
+	switch {
+	case c.UserID == 0 && c.Role == "":
+		return apperr.New(apperr.Unauthenticated, "authenticate to do this: an API key, an access token or a session")
+	case action == authz.Manage:
+		return apperr.New(apperr.Forbidden, "only admins can do this")
+	case c.Role == authz.Member:
+		return apperr.New(apperr.Forbidden, "members can only change their own tasks, webhooks and API keys")
+	case c.Role == authz.Viewer:
+		return apperr.New(apperr.Forbidden, "viewers can only read")
+	}
+	return apperr.New(apperr.Forbidden, fmt.Sprintf("role %q can't %s", c.Role, action))
+}
+
+// authorize — nil if ctx's caller may do action to a resource of
+// owner's (0 = nobody's: Manage, or a Write only admins make)
+func (app *App) authorize(ctx context.Context, action authz.Action, owner int) error {
+	c, err := app.caller(ctx)
+	if err != nil {
+		return err
+	}
+	return c.may(action, owner)
+}
+
+// authorizeOwned — authorize a Write to an existing resource. owner
+// says whose it is, and is only asked when the answer matters: admins
+// write anyone's, viewers no one's.
+func (app *App) authorizeOwned(ctx context.Context, owner func() (int, error)) error {
+	c, err := app.caller(ctx)
+	if err != nil {
+		return err
+	}
+	if c.ReadOnly || authz.Allow(c.Role, authz.Write, false) || !authz.Allow(c.Role, authz.Write, true) {
+		return c.may(authz.Write, 0)
+	}
+	id, err := owner()
+	if err != nil {
+		return err
+	}
+	return c.may(authz.Write, id)
+}
+
+// authorizeTask — may ctx's caller change task id (trashed or not)?
+// Call it in the change's transaction.
+func (app *App) authorizeTask(ctx context.Context, id int) error {
+	return app.authorizeOwned(ctx, func() (int, error) { return app.Tasks.Owner(ctx, id) })
+}
+
+func (app *App) authorizeWebhook(ctx context.Context, id int) error {
+	return app.authorizeOwned(ctx, func() (int, error) {
+		hook, err := app.Webhooks.Get(ctx, id)
+		return hook.UserID, err
+	})
+}
+
+// adminOnly is per-route middleware: authz.Manage, before the handler
+// runs
+// On a route tenant leaves alone (/admin) it scopes the request to the
+// caller's organization, so an admin's changes and lists stop there;
+// a platform admin's to the one X-Org-ID names, or none.
+func (app *App) adminOnly() middleware {
+	return middleware{name: "adminOnly", wrap: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if err := app.authorize(ctx, authz.Manage, 0); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if _, scoped := requestctx.OrgID(ctx); !scoped {
+				var err error
+				if ctx, err = app.adminOrg(ctx, r.Header.Get(orgHeader)); err != nil {
+					writeError(w, r, err)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}}
+}
+
+// platformOnly — adminOnly for what spans organizations (jobs, pools,
+// the organizations themselves): platform admins only
+func (app *App) platformOnly() middleware {
+	return middleware{name: "platformOnly", wrap: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := app.authorize(r.Context(), authz.Manage, 0); err != nil {
+				writeError(w, r, err)
+				return
+			}
+			if !app.platformAdmin(r.Context()) {
+				writeError(w, r, apperr.New(apperr.Forbidden, "only the platform's admins (auth.platform_org) can do this"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
+
+// platformAdmin — is ctx's caller, already known to be an admin, one of
+// the platform's? An anonymous admin is: that's the API left open.
+func (app *App) platformAdmin(ctx context.Context) bool {
+	p, authenticated := principalCtx.Get(ctx)
+	return !authenticated || app.PlatformOrg != 0 && p.OrgID == app.PlatformOrg
+}
+
+// adminOrg — the organization an admin's /admin request acts in: their
+// own; for a platform admin the one header names, "" = every one
+func (app *App) adminOrg(ctx context.Context, header string) (context.Context, error) {
+	if !app.platformAdmin(ctx) {
+		return app.withOrg(ctx, header)
+	}
+	if header == "" {
+		return ctx, nil
+	}
+	id, err := validate.ParseID(header)
+	if err != nil {
+		return ctx, apperr.New(apperr.OrgRequired, fmt.Sprintf("%s %q is not an organization ID", orgHeader, header))
+	}
+	return app.enterOrg(ctx, id)
+}
+
+// PUT /admin/users/{id}/role — 409 LAST_ADMIN rather than leave an
+// organization without an admin
+func (app *App) handleSetRole(w http.ResponseWriter, r *http.Request) {
+	var req RoleRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := app.Users.Update(r.Context(), pathID(r), repository.UserUpdate{Role: &req.Role})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"sandbox-go/internal/broker"
+	"sandbox-go/internal/dlock"
+)
+
+// -----------------------------------------------------------
+// BROKER — relayed task events are also published to a message
+// broker (broker.type: nats or kafka-rest), for other services.
+// One instance at a time reads the outbox after the relay, from
+// a saved cursor, so the relay, SSE and webhooks don't wait for
+// the broker, and an outage only holds this loop up — it starts
+// again from the cursor. At least once: a batch that fails is
+// sent again whole; consumers dedupe on the envelope's id.
+// Events the loop hasn't reached are kept past the outbox
+// retention, unless it has been stuck for longer than that.
+// -----------------------------------------------------------
+
+const (
+	brokerLockName = "outbox:broker"
+	brokerCursor   = "broker"
+)
+
+// runBrokerPublish publishes new events every interval until ctx is
+// cancelled
+func (app *App) runBrokerPublish(ctx context.Context, locks *dlock.Locker, pub broker.Publisher, topic string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer pub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lock, ok, err := locks.TryAcquire(ctx, brokerLockName)
+		if err != nil {
+			app.Log.Warn("broker: lock", "err", err)
+			continue
+		}
+		if !ok {
+			continue // another instance is on it
+		}
+		start := time.Now()
+		err = app.publishAll(ctx, pub, topic)
+		lock.Release()
+		app.Metrics.observeScheduler("broker_publish", start, err)
+	}
+}
+
+// publishAll publishes everything after the cursor, a batch at a
+// time, saving the cursor after each
+func (app *App) publishAll(ctx context.Context, pub broker.Publisher, topic string) error {
+	seq, ok, err := app.Outbox.Cursor(ctx, brokerCursor)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// First run: from now on. What is still in the outbox was
+		// committed before the broker was configured.
+		if seq, err = app.Outbox.LastSeq(ctx); err != nil {
+			return err
+		}
+		if err := app.Outbox.SaveCursor(ctx, brokerCursor, seq); err != nil {
+			return err
+		}
+		app.Log.Info("broker: publishing task events", "topic", topic, "after_seq", seq)
+	}
+
+	defer func() {
+		// Lag as of this tick, for broker_lag_events; a failed lookup
+		// leaves the last value
+		if last, err := app.Outbox.LastSeq(context.WithoutCancel(ctx)); err == nil {
+			app.Metrics.brokerLag.Set(float64(last - seq))
+		}
+	}()
+
+	for ctx.Err() == nil {
+		list, err := app.Outbox.Since(ctx, seq, outboxBatch)
+		if err != nil || len(list) == 0 {
+			return err
+		}
+		msgs := make([]broker.Message, 0, len(list))
+		for _, e := range list {
+			m, err := broker.NewMessage(topic, broker.Envelope{
+				ID: e.ID, Type: e.Type, Time: e.CreatedAt, TaskID: e.TaskID, OrgID: e.OrgID, RequestID: e.RequestID, Data: e.Data,
+			})
+			if err != nil {
+				return err
+			}
+			msgs = append(msgs, m)
+		}
+
+		err = pub.Publish(ctx, msgs) // within broker.timeout
+		app.Metrics.observeBroker(len(msgs), err)
+		if err != nil {
+			app.Log.Error("broker: publish failed", "after_seq", seq, "events", len(msgs), "err", err)
+			return err
+		}
+
+		seq = list[len(list)-1].Seq
+		if err := app.Outbox.SaveCursor(ctx, brokerCursor, seq); err != nil {
+			return err // the batch goes out again next tick
+		}
+		if len(list) < outboxBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// GET /schemas/task-event.json — JSON Schema of the broker messages
+func (app *App) handleTaskEventSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(broker.EnvelopeSchema)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// CANARY ROUTES — a rewritten handler goes live next to the one
+// it replaces, on the same route, before it takes over:
+//   rt.canary(http.MethodGet, "/tasks/{id}", http.HandlerFunc(app.handleGetTaskV2))
+// A request gets the canary when it sends X-Canary: true, or by
+// chance, for server.canary_percent of the rest; X-Canary: false
+// always gets the stable handler. The canary's responses say so
+// (X-Canary: true), and request metrics and logs carry the
+// variant, so the two can be compared side by side.
+// -----------------------------------------------------------
+
+const (
+	canaryHeader  = "X-Canary"
+	variantStable = "stable"
+	variantCanary = "canary"
+)
+
+// variantKey — which handler serves the request; absent = stable
+var variantKey = requestctx.NewKey[string]("variant")
+
+func variantOf(r *http.Request) string {
+	if v, ok := variantKey.Get(r.Context()); ok {
+		return v
+	}
+	return variantStable
+}
+
+// canary registers h as the alternate handler of every route with this
+// method and template ("/tasks/{id}": the /v1 route and its legacy
+// alias alike). The route's own middleware wraps it too. A route that
+// doesn't exist, or already has a canary, is reported by err().
+func (rt *router) canary(method, template string, h http.Handler) {
+	found := false
+	for _, rte := range rt.routes {
+		if rte.Method != method || rte.template != template {
+			continue
+		}
+		found = true
+		if rte.canary != nil {
+			rt.errs = append(rt.errs, fmt.Errorf("%s %s already has a canary (%s)", method, rte.Pattern, rte.Canary))
+			continue
+		}
+		rte.Canary = handlerName(h)
+		rte.canary = h
+		for i := len(rte.local) - 1; i >= 0; i-- {
+			rte.canary = rte.local[i].wrap(rte.canary)
+		}
+	}
+	if !found {
+		rt.errs = append(rt.errs, fmt.Errorf("canary %s for %s %s: no such route", handlerName(h), method, template))
+	}
+}
+
+// canaryRouting picks the variant of requests to routes with a canary;
+// percent is server.canary_percent. It runs before the metrics and the
+// request log, which label requests with it.
+func (rt *router) canaryRouting(percent float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rte := rt.match(r)
+			if rte == nil || rte.canary == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			canary, err := strconv.ParseBool(r.Header.Get(canaryHeader))
+			if err != nil { // absent or not a boolean: leave it to chance
+				canary = percent > 0 && rand.Float64()*100 < percent
+			}
+			if !canary {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set(canaryHeader, "true")
+			next.ServeHTTP(w, r.WithContext(variantKey.With(r.Context(), variantCanary)))
+		})
+	}
+}
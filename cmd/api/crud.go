@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// CRUD — the five standard routes of a resource that has
+// nothing more to it, from its repository and request types:
+//   registerCRUD(app, api, crudResource[Label, CreateLabelRequest, UpdateLabelRequest]{
+//       Path: "/labels", Repo: app.Labels, Write: []middleware{admin},
+//   })
+//   GET    /labels      — a page of them (pageParams, writeList)
+//   POST   /labels      — 201 with the new one
+//   GET    /labels/{id}
+//   PUT    /labels/{id}
+//   DELETE /labels/{id} — 204
+// Bodies go through decodeJSON and the request type's validate();
+// every error, the repository's apperr ones included, through
+// writeError. A repository whose Create and Update take their own
+// types gets a small adapter (see userCRUD). The routes still need
+// their entries in apiOperations.
+// -----------------------------------------------------------
+
+// validator — a request body that checks itself (validate.New()...Err())
+type validator interface {
+	validate() error
+}
+
+// crudRepository — what registerCRUD needs of a repository: the
+// usual five methods, taking the request types as they are
+type crudRepository[T any, C, U validator] interface {
+	List(ctx context.Context, page repository.Page) ([]T, error)
+	Get(ctx context.Context, id int) (T, error)
+	Create(ctx context.Context, req C) (T, error)
+	Update(ctx context.Context, id int, req U) (T, error)
+	Delete(ctx context.Context, id int) error
+}
+
+type crudResource[T any, C, U validator] struct {
+	Path  string // the collection, "/labels"; items are Path + "/{id}"
+	Repo  crudRepository[T, C, U]
+	Write []middleware // on POST, PUT and DELETE (admin, say)
+
+	app *App // for pageParams; set by registerCRUD
+}
+
+// crudHandler — a handler with a name for /admin/routes and the
+// canary log: "labels.list" rather than a generic closure's
+type crudHandler struct {
+	name string
+	http.HandlerFunc
+}
+
+func (h crudHandler) String() string { return h.name }
+
+func registerCRUD[T any, C, U validator](app *App, api apiVersions, res crudResource[T, C, U]) {
+	res.app = app
+	item := res.Path + "/{id}"
+	name := strings.ReplaceAll(strings.Trim(res.Path, "/"), "/", ".") + "."
+	api.handle(http.MethodGet, res.Path, crudHandler{name + "list", res.list})
+	api.handle(http.MethodPost, res.Path, crudHandler{name + "create", res.create}, res.Write...)
+	api.handle(http.MethodGet, item, crudHandler{name + "get", res.get})
+	api.handle(http.MethodPut, item, crudHandler{name + "update", res.update}, res.Write...)
+	api.handle(http.MethodDelete, item, crudHandler{name + "delete", res.delete}, res.Write...)
+}
+
+func (res crudResource[T, C, U]) list(w http.ResponseWriter, r *http.Request) {
+	page, err := res.app.pageParams(w, r, res.Path)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	items, err := res.Repo.List(r.Context(), page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeList(w, r, page, items)
+}
+
+func (res crudResource[T, C, U]) create(w http.ResponseWriter, r *http.Request) {
+	var req C
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := res.Repo.Create(r.Context(), req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func (res crudResource[T, C, U]) get(w http.ResponseWriter, r *http.Request) {
+	item, err := res.Repo.Get(r.Context(), pathID(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (res crudResource[T, C, U]) update(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	var req U
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := res.Repo.Update(r.Context(), id, req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+func (res crudResource[T, C, U]) delete(w http.ResponseWriter, r *http.Request) {
+	if err := res.Repo.Delete(r.Context(), pathID(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
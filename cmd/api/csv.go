@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// CSV — GET /tasks/export.csv and POST /tasks/import
+// Export takes the GET /tasks filters and streams every match,
+// a row at a time, so a large export costs no more memory than
+// a small one. Import reads the same columns back (id, version,
+// updated_at and deleted_at are ignored: every row is a new
+// task), validates each row like POST /tasks and creates them
+// in batches of importBatch, one transaction per batch.
+// -----------------------------------------------------------
+
+// csvColumns — export order; import accepts any order and subset that
+// includes the required ones
+var csvColumns = []string{"id", "user_id", "parent_id", "title", "done", "priority", "due_date", "recurrence", "tags", "version", "updated_at", "deleted_at"}
+
+var (
+	csvRequired = []string{"user_id", "title"}
+	csvIgnored  = []string{"id", "version", "updated_at", "deleted_at"} // set by the server
+)
+
+const (
+	maxImportBytes = 10 << 20 // 10 MiB upload
+	maxImportRows  = 10000
+	importBatch    = 100    // rows per transaction
+	csvFlushEvery  = 100    // export rows between writes to the client
+	csvTagSep      = " "    // tags can't contain spaces (tagPattern)
+	csvUploadField = "file" // multipart form field holding the CSV
+)
+
+// csvFile marks an operation whose body is CSV: a text/csv response,
+// or a text/csv or multipart/form-data upload
+type csvFile struct{}
+
+// csvCell guards against formula injection: a spreadsheet runs a cell
+// starting with = + - @ as a formula, so those get a leading ' (which
+// import strips again)
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvUncell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvRecord(t Task) []string {
+	parent, recurrence := "", ""
+	if t.ParentID != nil {
+		parent = strconv.Itoa(*t.ParentID)
+	}
+	if t.Recurrence != nil {
+		recurrence = *t.Recurrence
+	}
+	return []string{
+		strconv.Itoa(t.ID), strconv.Itoa(t.UserID), parent, csvCell(t.Title), strconv.FormatBool(t.Done),
+		t.Priority, csvTime(t.DueDate), recurrence, strings.Join(t.Tags, csvTagSep),
+		strconv.Itoa(t.Version), csvTime(&t.UpdatedAt), csvTime(t.DeletedAt),
+	}
+}
+
+// GET /tasks/export.csv — every task matching the GET /tasks filters
+func (app *App) handleExportTasks(w http.ResponseWriter, r *http.Request) {
+	filter, err := taskFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(csvColumns)
+
+	n, sent := 0, false
+	err = app.Tasks.Each(r.Context(), filter, func(t Task) error {
+		cw.Write(csvRecord(t))
+		if n++; n%csvFlushEvery == 0 {
+			cw.Flush()
+			sent = true
+		}
+		return cw.Error() // the client went away
+	})
+	if err != nil && !sent {
+		w.Header().Del("Content-Disposition")
+		writeError(w, r, err) // nothing sent yet: still a proper error response
+		return
+	}
+	if err != nil {
+		// Half a file is worse than none: abort the response so the
+		// client sees a broken transfer, not a short export
+		requestctx.Logger(r.Context()).Error("export failed mid-stream", "rows", n, "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	cw.Flush()
+}
+
+// ImportResult — POST /tasks/import. Rows lists every data row (row 2
+// is the first line after the header) with the created task's ID or
+// why it was rejected.
+type ImportResult struct {
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+	// Complete is false when a server error stopped the import: rows up
+	// to the last one listed were handled, the rest weren't read
+	Complete bool `json:"complete"`
+}
+
+type ImportRow struct {
+	Row    int                 `json:"row"`
+	ID     int                 `json:"id,omitempty"`
+	Code   apperr.Code         `json:"code,omitempty"`
+	Error  string              `json:"error,omitempty"`
+	Fields []apperr.FieldError `json:"fields,omitempty"`
+}
+
+// POST /tasks/import — a CSV of tasks to create, as a text/csv body or
+// the "file" field of a multipart/form-data upload; the route caps the
+// body at maxImportBytes (limitBody in routes())
+func (app *App) handleImportTasks(w http.ResponseWriter, r *http.Request) {
+	upload, err := csvUpload(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cr := csv.NewReader(upload)
+	header, err := cr.Read()
+	if err != nil {
+		writeError(w, r, csvReadError(err, "header row"))
+		return
+	}
+	columns, err := csvHeader(header)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cr.FieldsPerRecord = len(header)
+
+	imp := &importer{app: app, r: r, result: ImportResult{Rows: []ImportRow{}, Complete: true}}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			imp.reject(ImportRow{Row: line, Code: apperr.InvalidCSV, Error: parseErr.Err.Error()})
+			continue
+		}
+		if err != nil {
+			writeError(w, r, csvReadError(err, "upload")) // e.g. too large
+			return
+		}
+		if imp.rows == maxImportRows {
+			writeError(w, r, apperr.New(apperr.PayloadTooLarge, fmt.Sprintf("at most %d rows per import", maxImportRows)))
+			return
+		}
+		imp.rows++
+		if !imp.add(line, columns, record) {
+			break
+		}
+	}
+	if imp.result.Complete {
+		imp.flush()
+	}
+	// Rejected rows were listed as they were read, created ones per batch
+	slices.SortStableFunc(imp.result.Rows, func(a, b ImportRow) int { return a.Row - b.Row })
+	writeJSON(w, http.StatusOK, imp.result)
+}
+
+// csvUpload — the CSV stream, read straight from the request body
+func csvUpload(r *http.Request) (io.Reader, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "text/csv" {
+		return r.Body, nil
+	}
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, apperr.New(apperr.InvalidCSV, "send text/csv, or multipart/form-data with the CSV in the \"file\" field")
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, apperr.Wrap(apperr.InvalidCSV, "unreadable multipart body", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, apperr.New(apperr.InvalidCSV, `multipart field "file" is missing`)
+		}
+		if err != nil {
+			return nil, csvReadError(err, "multipart body")
+		}
+		if part.FormName() == csvUploadField {
+			return part, nil
+		}
+	}
+}
+
+func csvReadError(err error, what string) error {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return apperr.New(apperr.PayloadTooLarge, fmt.Sprintf("upload exceeds %d bytes", maxImportBytes))
+	case errors.Is(err, io.EOF):
+		return apperr.New(apperr.InvalidCSV, "the CSV is empty; the first row must name the columns")
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return apperr.Wrap(apperr.InvalidCSV, fmt.Sprintf("%s: %v", what, parseErr.Err), err)
+	}
+	return fmt.Errorf("read %s: %w", what, err)
+}
+
+// csvHeader maps column name → index, rejecting unknown or missing
+// columns up front rather than on every row
+func csvHeader(header []string) (map[string]int, error) {
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) // BOM from Excel
+		if !slices.Contains(csvColumns, name) {
+			return nil, apperr.New(apperr.InvalidCSV, fmt.Sprintf("unknown column %q; columns are %s", name, strings.Join(csvColumns, ", ")))
+		}
+		if _, dup := columns[name]; dup {
+			return nil, apperr.New(apperr.InvalidCSV, fmt.Sprintf("column %q appears twice", name))
+		}
+		columns[name] = i
+	}
+	for _, name := range csvRequired {
+		if _, ok := columns[name]; !ok {
+			return nil, apperr.New(apperr.InvalidCSV, fmt.Sprintf("column %q is required", name))
+		}
+	}
+	return columns, nil
+}
+
+// importRow — a valid row waiting for its batch
+type importRow struct {
+	line int
+	req  CreateTaskRequest
+	done bool
+}
+
+type importer struct {
+	app    *App
+	r      *http.Request
+	rows   int
+	users  map[int]bool // user_id → exists, checked once per import
+	batch  []importRow
+	result ImportResult
+}
+
+func (imp *importer) reject(row ImportRow) {
+	imp.result.Failed++
+	imp.result.Rows = append(imp.result.Rows, row)
+}
+
+// add validates a row and queues it; false = a server error ended the
+// import
+func (imp *importer) add(line int, columns map[string]int, record []string) bool {
+	cell := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	// Values that don't even parse are reported like validation errors
+	v := validate.New()
+	row := importRow{line: line, req: CreateTaskRequest{
+		Title:      csvUncell(cell("title")),
+		Priority:   cell("priority"),
+		Recurrence: cell("recurrence"),
+		Tags:       normalizeTags(strings.Fields(cell("tags"))),
+	}}
+	if s := cell("user_id"); s != "" {
+		n, err := strconv.Atoi(s)
+		v.Check("user_id", err == nil, "must be a number")
+		row.req.UserID = n
+	}
+	if s := cell("parent_id"); s != "" {
+		n, err := strconv.Atoi(s)
+		v.Check("parent_id", err == nil, "must be a number")
+		row.req.ParentID = &n
+	}
+	if s := cell("due_date"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		v.Check("due_date", err == nil, "must be an RFC 3339 time (2026-01-31T17:00:00Z)")
+		row.req.DueDate = &t
+	}
+	if s := cell("done"); s != "" {
+		b, err := strconv.ParseBool(s)
+		v.Check("done", err == nil, "must be true or false")
+		row.done = b
+	}
+
+	err := v.Err()
+	if err == nil {
+		err = row.req.validate()
+	}
+	if err == nil {
+		err = imp.checkUser(row.req.UserID)
+	}
+	if err != nil {
+		e := apperr.From(err)
+		if serverFault(e) {
+			return imp.abort(line, err)
+		}
+		imp.reject(ImportRow{Row: line, Code: e.Code, Error: e.Message, Fields: e.Fields})
+		return true
+	}
+
+	imp.batch = append(imp.batch, row)
+	if len(imp.batch) == importBatch {
+		return imp.flush()
+	}
+	return true
+}
+
+// checkUser — tasks.user_id is a foreign key; checked here so a wrong
+// ID is a row error (USER_NOT_FOUND), not a failed insert
+func (imp *importer) checkUser(id int) error {
+	if imp.users == nil {
+		imp.users = map[int]bool{}
+	}
+	exists, seen := imp.users[id]
+	if !seen {
+		_, err := imp.app.Users.Get(imp.r.Context(), id)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		exists = err == nil
+		imp.users[id] = exists
+	}
+	if !exists {
+		return apperr.New(apperr.UserNotFound, fmt.Sprintf("user %d not found", id))
+	}
+	return nil
+}
+
+// flush creates the batch in one transaction. A row the repository
+// refuses (PARENT_NOT_FOUND, ...) only undoes itself — each Create is a
+// savepoint — and is reported; a server error undoes the whole batch
+// and ends the import.
+func (imp *importer) flush() bool {
+	batch := imp.batch
+	imp.batch = nil
+	if len(batch) == 0 {
+		return true
+	}
+
+	ctx := imp.r.Context()
+	tx, err := repository.Begin(ctx, imp.app.DB)
+	if err != nil {
+		return imp.abort(batch[0].line, err)
+	}
+	defer tx.Rollback(ctx) // no-op after Commit
+	txCtx := repository.WithTx(ctx, tx)
+
+	var created []Task
+	var rows []ImportRow // reported once the batch is committed
+	for _, row := range batch {
+		task, err := imp.app.Tasks.Create(txCtx, repository.NewTask{
+			UserID:     row.req.UserID,
+			ParentID:   row.req.ParentID,
+			Title:      row.req.Title,
+			Priority:   row.req.Priority,
+			DueDate:    row.req.DueDate,
+			Tags:       row.req.Tags,
+			Recurrence: row.req.Recurrence,
+		})
+		if err == nil && row.done {
+			task, err = imp.app.Tasks.Update(txCtx, task.ID, repository.TaskUpdate{Done: &row.done})
+		}
+		if err != nil {
+			e := apperr.From(err)
+			if serverFault(e) {
+				return imp.abort(batch[0].line, err)
+			}
+			rows = append(rows, ImportRow{Row: row.line, Code: e.Code, Error: e.Message, Fields: e.Fields})
+			continue
+		}
+		created = append(created, task)
+		rows = append(rows, ImportRow{Row: row.line, ID: task.ID})
+	}
+	for _, task := range created {
+		if err := imp.app.record(txCtx, taskCreated, task.ID, task); err != nil {
+			return imp.abort(batch[0].line, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return imp.abort(batch[0].line, err)
+	}
+
+	imp.result.Created += len(created)
+	imp.result.Failed += len(rows) - len(created)
+	imp.result.Rows = append(imp.result.Rows, rows...)
+	return true
+}
+
+// abort ends the import on a server error (the database went away, the
+// request timed out): the current batch, starting at row from, is
+// rolled back and nothing after it is read
+func (imp *importer) abort(from int, err error) bool {
+	requestctx.Logger(imp.r.Context()).Error("import stopped", "row", from, "err", err)
+	e := apperr.From(err)
+	imp.result.Complete = false
+	imp.result.Rows = append(imp.result.Rows, ImportRow{Row: from, Code: e.Code, Error: e.Message + "; this and later rows were not imported"})
+	return false
+}
+
+// serverFault — errors about the server, not about the row
+func serverFault(e *apperr.Error) bool {
+	return e.Code == apperr.Internal || e.Code == apperr.Timeout
+}
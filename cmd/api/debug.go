@@ -0,0 +1,77 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// DEBUG — profiling and runtime state on their own listener,
+// server.debug_addr, which Validate only lets bind to loopback:
+// reach it from the host, or through kubectl port-forward / an
+// SSH tunnel, never from the load balancer. Nothing here asks
+// who is calling.
+//   /debug/pprof/  net/http/pprof: profile?seconds=30, heap,
+//                  goroutine?debug=2, trace, ...
+//   /debug/vars    expvar: memstats, cmdline, the pool
+//   POST /debug/gc a collection now, and the memory given back
+// The REST API's mux is its own, so the default one these
+// packages register on is served nowhere else.
+// -----------------------------------------------------------
+
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // heap, goroutine, ... by name
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /debug/gc", handleGC)
+	return mux
+}
+
+// newDebugServer — no write timeout: a CPU profile or trace takes as
+// long as asked
+func newDebugServer(addr string) *http.Server {
+	return &http.Server{Addr: addr, Handler: debugHandler(), ReadHeaderTimeout: 5 * time.Second}
+}
+
+// publishVars adds the pool's numbers to /debug/vars; once per process
+// (expvar names are global)
+func publishVars(pool *pgxpool.Pool) {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("pgxpool", expvar.Func(func() any {
+		s := pool.Stat()
+		return map[string]any{
+			"acquired_conns": s.AcquiredConns(),
+			"idle_conns":     s.IdleConns(),
+			"total_conns":    s.TotalConns(),
+			"max_conns":      s.MaxConns(),
+		}
+	}))
+}
+
+// POST /debug/gc — runs a collection and returns freed memory to the
+// OS; the heap before and after, in bytes
+func handleGC(w http.ResponseWriter, r *http.Request) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	debug.FreeOSMemory() // a GC first
+	took := time.Since(start)
+	runtime.ReadMemStats(&after)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"heap_alloc_before": before.HeapAlloc,
+		"heap_alloc_after":  after.HeapAlloc,
+		"heap_sys_after":    after.HeapSys,
+		"heap_released":     after.HeapReleased,
+		"duration_ms":       float64(took.Microseconds()) / 1000,
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// DECODE — the one way handlers read a JSON body
+// Stricter than a bare json.NewDecoder(r.Body).Decode:
+//   - unknown fields are rejected ("titel" is a typo, not a no-op)
+//   - type mismatches say where: "user_id must be an integer, got
+//     string at offset 27"
+//   - nesting is capped, so a body of 100k '[' can't eat the stack,
+//     and so is the size (server.max_body_bytes, see limitBodies):
+//     413 PAYLOAD_TOO_LARGE
+//   - exactly one JSON value; trailing garbage is an error
+// Failures are 400 INVALID_JSON; field-level ones also carry
+// "errors": [{"field": ..., "message": ...}] like validation does.
+// -----------------------------------------------------------
+
+// maxJSONDepth — objects/arrays nested deeper than this are refused.
+// Our bodies are flat; GraphQL variables get some room.
+const maxJSONDepth = 32
+
+// decodeJSON — decode the request body into dst
+func decodeJSON(r *http.Request, dst any) error {
+	defer startPhase(r.Context(), "decode")()
+
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		return readError(err)
+	}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return apperr.New(apperr.InvalidJSON, "request body is empty")
+	}
+	if depth := jsonDepth(raw); depth > maxJSONDepth {
+		return apperr.New(apperr.InvalidJSON, fmt.Sprintf("request body is nested %d levels deep, the limit is %d", depth, maxJSONDepth))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return decodeError(err)
+	}
+	if dec.More() {
+		return apperr.New(apperr.InvalidJSON, fmt.Sprintf("unexpected data after the JSON value at offset %d", dec.InputOffset()))
+	}
+	return nil
+}
+
+// readError — for a failure to read a request body; over the limit is
+// the client's fault, anything else (a dropped connection) isn't
+func readError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apperr.Wrap(apperr.PayloadTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), err)
+	}
+	return fmt.Errorf("read body: %w", err)
+}
+
+// decodeError turns encoding/json's errors into messages a client can
+// act on
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return apperr.Wrap(apperr.InvalidJSON, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset), err)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return apperr.Wrap(apperr.InvalidJSON, "request body ends in the middle of a JSON value", err)
+
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return apperr.Wrap(apperr.InvalidJSON, fmt.Sprintf("request body must be %s, got %s", kindOf(typeErr.Type), typeErr.Value), err)
+		}
+		msg := fmt.Sprintf("must be %s, got %s", kindOf(typeErr.Type), typeErr.Value)
+		e := apperr.Wrap(apperr.InvalidJSON, fmt.Sprintf("%s %s at offset %d", typeErr.Field, msg, typeErr.Offset), err)
+		e.Fields = []apperr.FieldError{{Field: typeErr.Field, Message: msg}}
+		return e
+
+	case errors.As(err, &timeErr):
+		// time.Time's UnmarshalJSON doesn't say which field it was
+		return apperr.Wrap(apperr.InvalidJSON, fmt.Sprintf("%q is not an RFC 3339 date-time (e.g. 2026-05-01T17:00:00Z)", timeErr.Value), err)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this one
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		e := apperr.Wrap(apperr.InvalidJSON, fmt.Sprintf("unknown field %q", field), err)
+		e.Fields = []apperr.FieldError{{Field: field, Message: "unknown field"}}
+		return e
+
+	default:
+		return apperr.Wrap(apperr.InvalidJSON, "request body is not valid JSON", err)
+	}
+}
+
+// kindOf names a Go type the way a JSON client thinks of it
+func kindOf(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer" // "got number 1.5" then makes sense
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	default:
+		return t.String()
+	}
+}
+
+// jsonDepth — the deepest object/array nesting in raw, skipping
+// brackets inside strings. Doesn't validate; Decode does that.
+func jsonDepth(raw []byte) int {
+	depth, max := 0, 0
+	inString, escaped := false, false
+	for _, c := range raw {
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{' || c == '[':
+			depth++
+			if depth > max {
+				max = depth
+			}
+		case c == '}' || c == ']':
+			depth--
+		}
+	}
+	return max
+}
+
+// nullable is a body field with three states — absent (leave it
+// alone), null (clear it) and a value (set it). A plain pointer can't
+// tell the first two apart.
+type nullable[T any] struct {
+	Set   bool // the field was in the body
+	Value *T   // nil = null
+}
+
+// UnmarshalJSON only runs for fields present in the body
+func (n *nullable[T]) UnmarshalJSON(b []byte) error {
+	n.Set = true
+	if string(b) == "null" {
+		n.Value = nil
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	n.Value = &v
+	return nil
+}
+
+// schemaOf — openapi.go documents a nullable[T] as a nullable T
+func (nullable[T]) schemaOf() reflect.Type {
+	return reflect.TypeOf((*T)(nil))
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/schedule"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// ESCALATION — configured rules (escalation.rules) for open
+// tasks that stay overdue: bump the priority, add tags, and
+// tell whoever is watching via a task.escalated event on
+// /tasks/events. Each rule fires once per task; the log is at
+// GET /escalations. Same scheduling as the recurrence job:
+// every instance has the schedule, an advisory lock picks one.
+// -----------------------------------------------------------
+
+const (
+	escalationLockName = "tasks:escalation"
+	escalationBatch    = 100 // tasks per transaction; a run repeats until done
+)
+
+// taskEscalated — published for every firing, after task.updated when
+// the rule changed the task
+const taskEscalated = "task.escalated"
+
+// TaskEscalated — data of a task.escalated event
+type TaskEscalated struct {
+	Escalation repository.Escalation `json:"escalation"`
+	Task       Task                  `json:"task"`
+}
+
+// escalationRules maps the config onto the repository's rule type
+func escalationRules(rules []config.EscalationRule) []repository.EscalationRule {
+	out := make([]repository.EscalationRule, len(rules))
+	for i, r := range rules {
+		out[i] = repository.EscalationRule{
+			Name: r.Name, Priority: r.Priority, Tag: r.Tag, OverdueFor: r.OverdueFor,
+			BumpPriority: r.BumpPriority, AddTags: r.AddTags,
+		}
+	}
+	return out
+}
+
+// escalationJob applies every rule each cfg.Interval, in each dedicated
+// organization too
+func (app *App) escalationJob(cfg config.EscalationConfig) schedule.Job {
+	rules := escalationRules(cfg.Rules)
+	return scheduled("escalation", escalationLockName, cfg.Interval, cfg.Schedule, func(ctx context.Context) error {
+		return app.eachTenantDB(ctx, func(ctx context.Context) error {
+			var errs []error
+			for _, rule := range rules {
+				errs = append(errs, app.escalateAll(ctx, rule))
+			}
+			return errors.Join(errs...)
+		})
+	})
+}
+
+// escalateAll drains one rule's backlog in batches; a failing rule is
+// logged and retried next run, the others still run
+func (app *App) escalateAll(ctx context.Context, rule repository.EscalationRule) error {
+	for ctx.Err() == nil {
+		var fired []repository.Escalated
+		err := app.inTx(ctx, func(ctx context.Context) (err error) {
+			if fired, err = app.Escalations.Escalate(ctx, rule, escalationBatch); err != nil {
+				return err
+			}
+			for _, f := range fired {
+				if f.Changed {
+					if err := app.record(ctx, taskUpdated, f.Task.ID, f.Task); err != nil {
+						return err
+					}
+				}
+				if err := app.record(ctx, taskEscalated, f.Task.ID, TaskEscalated{Escalation: f.Escalation, Task: f.Task}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			app.Log.Error("escalation failed", "rule", rule.Name, "err", err)
+			return err
+		}
+		for _, f := range fired {
+			app.Log.Warn("task escalated",
+				"rule", rule.Name, "task_id", f.Task.ID, "user_id", f.Task.UserID,
+				"priority", f.Escalation.PriorityTo, "due_date", f.Task.DueDate)
+		}
+		if len(fired) < escalationBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// GET /escalations — the escalation log, newest first (?task_id=, ?rule=)
+func (app *App) handleListEscalations(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/escalations")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var f repository.EscalationFilter
+	q := r.URL.Query()
+	if s := q.Get("task_id"); s != "" {
+		id, err := validate.ParseID(s)
+		if err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("task_id %q must be a task ID", s)))
+			return
+		}
+		f.TaskID = id
+	}
+	f.Rule = q.Get("rule")
+
+	log, err := app.Escalations.List(r.Context(), f, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, log)
+}
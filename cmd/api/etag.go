@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// VERSIONS AND ETAGS — optimistic locking on /tasks/{id}
+// Every task has a version, bumped by every write; it is also
+// the ETag, so plain HTTP caching works on top of it.
+//   GET    If-None-Match: "<etag>" → 304, no body (cache is fresh)
+//   PUT    "version": n in the body → 409 VERSION_CONFLICT with
+//   PATCH                             the current task if it moved on
+//          or If-Match: "<etag>"   → 412 if it moved on
+//          neither                 → 428
+// gRPC's UpdateTask and GraphQL's updateTask take the version
+// only, and just as required (requireVersion): every transport
+// that updates a task says which version it read.
+// The repository compares the version inside the UPDATE itself
+// (no read-check-write race).
+// -----------------------------------------------------------
+
+// taskETag — a strong validator: same ETag, byte-identical task
+func taskETag(t Task) string {
+	return `"` + strconv.Itoa(t.Version) + `"`
+}
+
+// setTaskETag — on every response carrying a single task, so clients
+// always have something to send back in If-Match
+func setTaskETag(w http.ResponseWriter, t Task) {
+	w.Header().Set("ETag", taskETag(t))
+}
+
+// notModified reports whether If-None-Match already names the current
+// version. Comparison is weak (RFC 9110 §13.1.2): W/ prefixes are ignored.
+func notModified(r *http.Request, t Task) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	current := taskETag(t)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// taskVersions turns the body's version, or else If-Match, into the
+// repository's precondition: nil for If-Match "*" (any current
+// version), otherwise the versions the client may overwrite.
+func taskVersions(r *http.Request, version *int) ([]int, error) {
+	if version != nil {
+		return []int{*version}, nil
+	}
+	return ifMatch(r)
+}
+
+// requireVersion — the precondition of an update that came with only a
+// version (gRPC, GraphQL): required like If-Match, 0 = not sent
+func requireVersion(version int) ([]int, error) {
+	if version < 1 {
+		return nil, apperr.New(apperr.PreconditionRequired, "send the task's version, as last read")
+	}
+	return []int{version}, nil
+}
+
+// ifMatch reads If-Match. Weak or foreign tags can never match (strong
+// comparison), so they simply don't make the list — an all-foreign
+// header fails with 412.
+func ifMatch(r *http.Request) ([]int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return nil, apperr.New(apperr.PreconditionRequired, `send the task's "version" (from GET /tasks/{id}), or its ETag as If-Match`)
+	}
+	if header == "*" {
+		return nil, nil
+	}
+
+	versions := []int{} // non-nil: the update is conditional even if nothing parses
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) || len(tag) < 2 {
+			continue
+		}
+		v, err := strconv.Atoi(strings.Trim(tag, `"`))
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// conflictStatus — a stale If-Match is 412 as HTTP has it; only a stale
+// body version is the repository's 409 (both carry the current task)
+func conflictStatus(err error, version *int) error {
+	var e *apperr.Error
+	if version == nil && errors.As(err, &e) && e.Code == apperr.VersionConflict {
+		return &apperr.Error{Code: apperr.PreconditionFailed, Message: e.Message, Current: e.Current}
+	}
+	return err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/events"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// SERVER-SENT EVENTS — GET /tasks/events
+// A plain HTTP response that never ends: the server writes
+// "id: / event: / data:" blocks as tasks change. Browsers use
+// EventSource, which reconnects by itself and sends the last ID
+// it saw in Last-Event-ID so we can replay what it missed.
+// -----------------------------------------------------------
+
+const (
+	eventBufferSize = 256              // events kept for Last-Event-ID replay
+	sseHeartbeat    = 15 * time.Second // keeps proxies from closing idle streams
+	sseRetry        = 3 * time.Second  // reconnect delay we suggest to clients
+	// sseWriteTimeout — a client that can't take an event in this long
+	// is dropped (and reconnects)
+	sseWriteTimeout = 2 * sseHeartbeat
+)
+
+// eventStream marks an operation whose response is an SSE stream
+// (see apiOperations)
+type eventStream struct{}
+
+const (
+	taskCreated   = "task.created"
+	taskUpdated   = "task.updated"
+	taskCompleted = "task.completed" // follows the task.updated of an update that sets done
+	taskDeleted   = "task.deleted"
+	taskRestored  = "task.restored"
+)
+
+// TaskDeleted — data of a task.deleted event (the task itself is gone)
+type TaskDeleted struct {
+	ID int `json:"id"`
+}
+
+// GET /tasks/events — stream task changes
+func (app *App) handleTaskEvents(w http.ResponseWriter, r *http.Request) {
+	// Not a number (or absent) = a fresh client, no replay
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	sub, replay := app.Events.Subscribe(lastID)
+	defer sub.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // nginx: pass events through unbuffered
+	w.WriteHeader(http.StatusOK)
+
+	// ResponseController finds the real writer's Flush through our
+	// middleware wrappers (they implement Unwrap)
+	rc := http.NewResponseController(w)
+	extendDeadlines(rc, sseWriteTimeout)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	for _, e := range replay {
+		if visible(r.Context(), e) {
+			writeEvent(w, e)
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		requestctx.Logger(r.Context()).Error("event stream: flush not supported", "err", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done(): // client went away
+			return
+		case e, ok := <-sub.C():
+			if !ok {
+				return // shutting down, or we fell too far behind — client reconnects
+			}
+			rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			if !visible(r.Context(), e) {
+				continue
+			}
+			writeEvent(w, e)
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			fmt.Fprint(w, ": ping\n\n") // lines starting with ":" are comments
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// extendDeadlines lifts the server's read timeout off a response that
+// outlasts it (server.read_timeout would end its context) and gives its
+// writes d from now instead of server.write_timeout. Writers without
+// deadlines, like a test's recorder, don't mind.
+func extendDeadlines(rc *http.ResponseController, d time.Duration) {
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Now().Add(d))
+}
+
+// visible — a client only gets its organization's events; the others
+// are skipped like IDs it never saw
+func visible(ctx context.Context, e events.Event) bool {
+	org, ok := requestctx.OrgID(ctx)
+	return !ok || e.OrgID == org
+}
+
+// writeEvent — one SSE block; JSON never contains raw newlines, so it
+// always fits on a single data: line
+func writeEvent(w http.ResponseWriter, e events.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+}
+
+// -----------------------------------------------------------
+// LONG POLLING — GET /tasks/events/poll?cursor=&wait=
+// For clients whose proxies buffer or cut SSE: an ordinary
+// request that waits until there is something to say, then
+// ends. Same bus, same IDs, same replay buffer as the stream:
+//   GET /tasks/events/poll           → waits for the next event
+//   ← {"events":[...], "cursor":"42"}
+//   GET /tasks/events/poll?cursor=42 → everything after 42
+// An empty "events" just means the wait ran out; call again
+// with the same cursor.
+// -----------------------------------------------------------
+
+const (
+	pollDefaultWait = 25 * time.Second // under the usual 30s proxy idle timeout
+	pollMaxWait     = 60 * time.Second
+	pollMaxEvents   = 100 // per response; the rest come on the next call
+	// pollWriteGrace — time to write the response once the wait is over
+	pollWriteGrace = 10 * time.Second
+)
+
+// EventPage — one long-poll response
+type EventPage struct {
+	Events []events.Event `json:"events"`
+	Cursor string         `json:"cursor"` // opaque; send it back as ?cursor=
+	// Reset means events after the cursor are no longer buffered (or
+	// the server restarted): reload the data, then poll from Cursor
+	Reset bool `json:"reset,omitempty"`
+}
+
+// GET /tasks/events/poll — wait for task changes after ?cursor=
+func (app *App) handleTaskEventsPoll(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var cursor uint64
+	if s := q.Get("cursor"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("cursor %q is not one we issued", s)))
+			return
+		}
+		cursor = n
+	} else {
+		cursor = app.Events.LastID() // no cursor: only what happens from now on
+	}
+	wait := pollDefaultWait
+	if s := q.Get("wait"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > pollMaxWait {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("wait %q must be 0 to %d seconds", s, int(pollMaxWait.Seconds()))))
+			return
+		}
+		wait = time.Duration(n) * time.Second
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if cursor > app.Events.LastID() {
+		writeJSON(w, http.StatusOK, EventPage{Events: []events.Event{}, Cursor: eventCursor(app.Events.LastID()), Reset: true})
+		return
+	}
+
+	extendDeadlines(http.NewResponseController(w), wait+pollWriteGrace)
+	sub, replay := app.Events.Subscribe(cursor)
+	defer sub.Close()
+
+	page := EventPage{Events: []events.Event{}, Cursor: eventCursor(cursor)}
+	if len(replay) > 0 && replay[0].ID > cursor+1 {
+		// The ring no longer has cursor+1..replay[0]-1
+		page.Reset = true
+	}
+	page.add(r.Context(), replay)
+
+	if len(page.Events) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+	waiting:
+		for len(page.Events) == 0 { // another organization's events don't end the wait
+			select {
+			case <-r.Context().Done():
+				return // client went away
+			case <-timer.C:
+				break waiting
+			case e, ok := <-sub.C():
+				if !ok {
+					break waiting
+				}
+				page.add(r.Context(), []events.Event{e})
+			}
+		}
+	}
+	// Don't split a burst across calls: take whatever else is queued
+	for drained := false; !drained && len(page.Events) < pollMaxEvents; {
+		select {
+		case e, ok := <-sub.C():
+			if ok {
+				page.add(r.Context(), []events.Event{e})
+			}
+			drained = !ok
+		default:
+			drained = true
+		}
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// add appends up to pollMaxEvents events visible in ctx and moves the
+// cursor past them (and past the invisible ones in between)
+func (p *EventPage) add(ctx context.Context, es []events.Event) {
+	for _, e := range es {
+		if len(p.Events) == pollMaxEvents {
+			return
+		}
+		if visible(ctx, e) {
+			p.Events = append(p.Events, e)
+		}
+		p.Cursor = eventCursor(e.ID)
+	}
+}
+
+func eventCursor(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
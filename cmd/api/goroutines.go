@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/tracing"
+)
+
+// -----------------------------------------------------------
+// GOROUTINES — work a handler starts and doesn't wait for (an
+// API key's last use, a cache warm, a notification) goes
+// through App.Go rather than a bare go statement:
+//   - it keeps the request's values (logger, request ID, org,
+//     trace) but not its deadline, cancellation or transaction,
+//     which end with the response
+//   - it shows in tracked_goroutines{name} while it runs, and a
+//     panic is logged and counted instead of killing the process
+//   - shutdown waits for it before the DB pool closes; past
+//     server.shutdown_timeout its context is cancelled, and once
+//     shutdown has begun Go starts nothing new
+// Long-running loops (relays, schedulers) are started in main,
+// on the background WaitGroup, not here.
+// -----------------------------------------------------------
+
+// goroutineGrace — how long shutdown still waits for goroutines once
+// their contexts are cancelled
+const goroutineGrace = 5 * time.Second
+
+// goroutines — what App.Go started and hasn't finished. The zero value
+// is ready.
+type goroutines struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closed  bool           // shutting down: Go starts nothing
+	running map[string]int // by name, for the shutdown log
+	stop    context.Context
+	cancel  context.CancelFunc // cancels stop, and so every goroutine
+}
+
+// Go runs fn in a goroutine tied to the app's lifetime; name labels it
+// in metrics and logs ("apikey.touch"). fn's context ends when fn has
+// to stop: the deadline of shutdown.
+func (app *App) Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	g := &app.goroutines
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		requestctx.Logger(ctx).Warn("goroutine not started: shutting down", "goroutine", name)
+		app.Metrics.goroutineEnded(name, "dropped")
+		return
+	}
+	if g.stop == nil {
+		g.stop, g.cancel = context.WithCancel(context.Background())
+		g.running = make(map[string]int)
+	}
+	g.running[name]++
+	g.wg.Add(1)
+	stop := g.stop
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(repository.WithoutTx(context.WithoutCancel(ctx)))
+	unhook := context.AfterFunc(stop, cancel)
+	app.Metrics.goroutineStarted(name)
+	go func() {
+		defer g.wg.Done()
+		defer cancel()
+		defer unhook()
+		outcome := app.runGoroutine(ctx, name, fn)
+		g.mu.Lock()
+		g.running[name]--
+		g.mu.Unlock()
+		app.Metrics.goroutineEnded(name, outcome)
+	}()
+}
+
+// runGoroutine runs fn in its own span; "panic" if it did, else "ok"
+func (app *App) runGoroutine(ctx context.Context, name string, fn func(ctx context.Context)) (outcome string) {
+	ctx, span := tracing.Child(ctx, name, tracing.KindInternal)
+	defer span.End()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			requestctx.Logger(ctx).Error("panic in goroutine",
+				"goroutine", name, "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+			span.Fail(fmt.Sprint(recovered))
+			outcome = "panic"
+		}
+	}()
+	fn(ctx)
+	return "ok"
+}
+
+// waitGoroutines — for shutdown, once no handler can call Go any more:
+// waits for the goroutines until ctx is done, then cancels them and
+// waits goroutineGrace more. Those still running are only logged.
+func (app *App) waitGoroutines(ctx context.Context) {
+	g := &app.goroutines
+	g.mu.Lock()
+	g.closed = true
+	g.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	if g.cancel == nil {
+		return // none ever ran; done was closed as well
+	}
+	app.Log.Warn("shutdown timeout: cancelling goroutines", "running", g.names())
+	g.cancel()
+	select {
+	case <-done:
+	case <-time.After(goroutineGrace):
+		app.Log.Error("goroutines left running at exit", "running", g.names())
+	}
+}
+
+// names — of the goroutines running, with a count where more than one
+func (g *goroutines) names() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var names []string
+	for name, n := range g.running {
+		switch {
+		case n == 1:
+			names = append(names, name)
+		case n > 1:
+			names = append(names, fmt.Sprintf("%s×%d", name, n))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
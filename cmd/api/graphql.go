@@ -0,0 +1,570 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	graphql "github.com/graph-gophers/graphql-go"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// GRAPHQL — POST /graphql
+// Schema first (below), resolvers are plain Go methods matched
+// to fields by name. Reads and writes go through the same
+// repositories and validation as the REST handlers.
+// -----------------------------------------------------------
+
+const graphqlSchema = `
+	schema {
+		query: Query
+		mutation: Mutation
+	}
+
+	scalar Time
+
+	type Query {
+		tasks(userId: ID, done: Boolean, includeDeleted: Boolean, limit: Int, offset: Int): [Task!]!
+		task(id: ID!): Task
+		users(limit: Int, offset: Int): [User!]!
+		user(id: ID!): User
+	}
+
+	type Mutation {
+		createTask(userId: ID!, title: String!): Task!
+		# version: required, the task's as last read; VERSION_CONFLICT
+		# unless the task is still at it
+		updateTask(id: ID!, title: String, done: Boolean, version: Int!): Task!
+		deleteTask(id: ID!): ID!
+		restoreTask(id: ID!): Task!
+		createUser(name: String!, email: String!): User!
+		updateUser(id: ID!, name: String, email: String): User!
+		deleteUser(id: ID!): ID!
+	}
+
+	type Task {
+		id: ID!
+		orgId: ID!
+		userId: ID!
+		parentId: ID
+		title: String!
+		done: Boolean!
+		priority: String!
+		dueDate: Time
+		tags: [String!]!
+		recurrence: String
+		version: Int!
+		updatedAt: Time!
+		deletedAt: Time
+		user: User!
+	}
+
+	type User {
+		id: ID!
+		orgId: ID!
+		name: String!
+		email: String!
+		# admin, member or viewer
+		role: String!
+		createdAt: Time!
+		tasks: [Task!]!
+	}
+`
+
+// graphqlMaxDepth — stops absurdly nested queries
+// (user { tasks { user { tasks { ... } } } })
+const graphqlMaxDepth = 8
+
+func newGraphQLSchema(app *App) *graphql.Schema {
+	return graphql.MustParseSchema(graphqlSchema, &gqlRoot{app: app},
+		graphql.MaxDepth(graphqlMaxDepth),
+	)
+}
+
+// graphqlRequest — the standard POST body every GraphQL client sends
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	Extensions    map[string]any `json:"extensions"` // e.g. persisted-query hashes; accepted, unused
+}
+
+// POST /graphql — always 200 once the body parses; GraphQL reports
+// failures per field in "errors" (with extensions.code from apperr)
+func (app *App) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	ctx := withLoaders(r.Context(), app)
+	resp := app.GraphQL.Exec(ctx, req.Query, req.OperationName, req.Variables)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// -----------------------------------------------------------
+// LOADERS — batch the nested lookups (dataloader pattern)
+// Listing 50 tasks with { user { name } } would otherwise run
+// 1 + 50 queries (N+1). Every object fetched for a list "primes"
+// the keys of its relations; the first Load then fetches all
+// primed keys in ONE query and the rest are served from the
+// cache. Loaders live for one request, so nothing is cached
+// across requests.
+// -----------------------------------------------------------
+
+type loaders struct {
+	users *batchLoader[User]   // user by ID
+	tasks *batchLoader[[]Task] // tasks by user ID
+}
+
+func withLoaders(ctx context.Context, app *App) context.Context {
+	l := &loaders{}
+	l.users = newBatchLoader(func(ctx context.Context, ids []int) (map[int]User, error) {
+		users, err := app.Users.GetMany(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[int]User, len(users))
+		for _, u := range users {
+			byID[u.ID] = u
+			l.tasks.prime(u.ID) // in case the query asks for user.tasks
+		}
+		return byID, nil
+	})
+	l.tasks = newBatchLoader(func(ctx context.Context, userIDs []int) (map[int][]Task, error) {
+		// No limit: the set of users is already bounded by the page
+		tasks, err := app.Tasks.List(ctx, repository.TaskFilter{UserIDs: userIDs}, repository.Page{})
+		if err != nil {
+			return nil, err
+		}
+		byUser := make(map[int][]Task, len(userIDs))
+		for _, id := range userIDs {
+			byUser[id] = []Task{} // users without tasks get [], not a miss
+		}
+		for _, t := range tasks {
+			byUser[t.UserID] = append(byUser[t.UserID], t)
+		}
+		return byUser, nil
+	})
+	return loadersKey.With(ctx, l)
+}
+
+var loadersKey = requestctx.NewKey[*loaders]("graphql_loaders")
+
+func loadersFrom(ctx context.Context) *loaders {
+	l, _ := loadersKey.Get(ctx)
+	return l
+}
+
+type batchLoader[V any] struct {
+	fetch func(ctx context.Context, keys []int) (map[int]V, error)
+
+	mu      sync.Mutex
+	pending map[int]bool
+	cache   map[int]V
+}
+
+func newBatchLoader[V any](fetch func(context.Context, []int) (map[int]V, error)) *batchLoader[V] {
+	return &batchLoader[V]{fetch: fetch, pending: map[int]bool{}, cache: map[int]V{}}
+}
+
+// prime schedules key for the next batch
+func (b *batchLoader[V]) prime(key int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.cache[key]; !ok {
+		b.pending[key] = true
+	}
+}
+
+// load returns the value for key, fetching every pending key with it.
+// Resolvers run concurrently; the lock makes the others wait for the
+// one batch instead of each firing its own query. ok is false when
+// the key doesn't exist.
+func (b *batchLoader[V]) load(ctx context.Context, key int) (v V, ok bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if v, ok := b.cache[key]; ok {
+		return v, true, nil
+	}
+
+	b.pending[key] = true
+	keys := make([]int, 0, len(b.pending))
+	for k := range b.pending {
+		keys = append(keys, k)
+	}
+	found, err := b.fetch(ctx, keys)
+	if err != nil {
+		return v, false, err
+	}
+	for k, val := range found {
+		b.cache[k] = val
+	}
+	b.pending = map[int]bool{}
+
+	v, ok = b.cache[key]
+	return v, ok, nil
+}
+
+// -----------------------------------------------------------
+// RESOLVERS
+// -----------------------------------------------------------
+
+type gqlRoot struct {
+	app *App
+}
+
+// gqlError — the GraphQL twin of writeError: apperr code and message
+// (generic for internal errors, which go to the log instead)
+type gqlError struct {
+	e *apperr.Error
+}
+
+func (g gqlError) Error() string { return g.e.Message }
+func (g gqlError) Extensions() map[string]any {
+	ext := map[string]any{"code": g.e.Code}
+	if len(g.e.Fields) > 0 {
+		ext["errors"] = g.e.Fields // same shape as the REST 422 body
+	}
+	return ext
+}
+
+func toGQLError(ctx context.Context, err error) error {
+	e := apperr.From(err)
+	if e.Code == apperr.Internal {
+		requestctx.Logger(ctx).Error("graphql resolver failed", "err", err)
+	}
+	return gqlError{e}
+}
+
+// parseID — GraphQL IDs are strings on the wire; same rules as paths
+func parseID(id graphql.ID) (int, error) {
+	return validate.ParseID(string(id))
+}
+
+func gqlID(id int) graphql.ID {
+	return graphql.ID(strconv.Itoa(id))
+}
+
+func int32OrZero(p *int32) int {
+	if p == nil {
+		return 0
+	}
+	return int(*p)
+}
+
+// --- Query ---
+
+func (q *gqlRoot) Tasks(ctx context.Context, args struct {
+	UserID         *graphql.ID
+	Done           *bool
+	IncludeDeleted *bool
+	Limit, Offset  *int32
+}) ([]*taskResolver, error) {
+	page, err := newPage(int32OrZero(args.Limit), int32OrZero(args.Offset), q.app.Pages.For("/tasks"))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	filter := repository.TaskFilter{Done: args.Done, IncludeDeleted: args.IncludeDeleted != nil && *args.IncludeDeleted}
+	if args.UserID != nil {
+		id, err := parseID(*args.UserID)
+		if err != nil {
+			return nil, toGQLError(ctx, err)
+		}
+		filter.UserIDs = []int{id}
+	}
+
+	tasks, err := q.app.Tasks.List(ctx, filter, page)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return newTaskResolvers(ctx, tasks), nil
+}
+
+func (q *gqlRoot) Task(ctx context.Context, args struct{ ID graphql.ID }) (*taskResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	task, err := q.app.Tasks.Get(ctx, id)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &taskResolver{task}, nil
+}
+
+func (q *gqlRoot) Users(ctx context.Context, args struct{ Limit, Offset *int32 }) ([]*userResolver, error) {
+	page, err := newPage(int32OrZero(args.Limit), int32OrZero(args.Offset), q.app.Pages.For("/users"))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	users, err := q.app.Users.List(ctx, page)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	l := loadersFrom(ctx)
+	res := make([]*userResolver, 0, len(users))
+	for _, u := range users {
+		l.tasks.prime(u.ID)
+		res = append(res, &userResolver{u})
+	}
+	return res, nil
+}
+
+func (q *gqlRoot) User(ctx context.Context, args struct{ ID graphql.ID }) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	user, err := q.app.Users.Get(ctx, id)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &userResolver{user}, nil
+}
+
+// --- Mutation ---
+
+func (q *gqlRoot) CreateTask(ctx context.Context, args struct {
+	UserID graphql.ID
+	Title  string
+}) (*taskResolver, error) {
+	userID, err := parseID(args.UserID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := (CreateTaskRequest{UserID: userID, Title: args.Title}).validate(); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := q.app.authorize(ctx, authz.Write, userID); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	task, err := q.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		return q.app.Tasks.Create(ctx, repository.NewTask{UserID: userID, Title: args.Title})
+	}, taskCreated)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	return &taskResolver{task}, nil
+}
+
+func (q *gqlRoot) UpdateTask(ctx context.Context, args struct {
+	ID      graphql.ID
+	Title   *string
+	Done    *bool
+	Version int32
+}) (*taskResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := (UpdateTaskRequest{Title: args.Title, Done: args.Done}).validate(); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if args.Title == nil && args.Done == nil {
+		return nil, toGQLError(ctx, apperr.New(apperr.NoFieldsToUpdate, "send at least one of title, done"))
+	}
+
+	versions, err := requireVersion(int(args.Version))
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	u := repository.TaskUpdate{Title: args.Title, Done: args.Done, IfVersion: versions}
+	task, err := q.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		if err := q.app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return q.app.Tasks.Update(ctx, id, u)
+	}, updateEvents(args.Done)...)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	return &taskResolver{task}, nil
+}
+
+func (q *gqlRoot) DeleteTask(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return "", toGQLError(ctx, err)
+	}
+	err = q.app.inTx(ctx, func(ctx context.Context) error {
+		if err := q.app.authorizeTask(ctx, id); err != nil {
+			return err
+		}
+		if err := q.app.Tasks.Delete(ctx, id); err != nil {
+			return err
+		}
+		return q.app.record(ctx, taskDeleted, id, TaskDeleted{ID: id})
+	})
+	if err != nil {
+		return "", toGQLError(ctx, err)
+	}
+
+	return args.ID, nil
+}
+
+func (q *gqlRoot) RestoreTask(ctx context.Context, args struct{ ID graphql.ID }) (*taskResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	task, err := q.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		if err := q.app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return q.app.Tasks.Restore(ctx, id)
+	}, taskRestored)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+
+	return &taskResolver{task}, nil
+}
+
+func (q *gqlRoot) CreateUser(ctx context.Context, args struct{ Name, Email string }) (*userResolver, error) {
+	if err := (CreateUserRequest{Name: args.Name, Email: args.Email}).validate(); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := q.app.authorize(ctx, authz.Manage, 0); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	user, err := q.app.Users.Create(ctx, repository.NewUser{Name: args.Name, Email: args.Email})
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (q *gqlRoot) UpdateUser(ctx context.Context, args struct {
+	ID          graphql.ID
+	Name, Email *string
+}) (*userResolver, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := (UpdateUserRequest{Name: args.Name, Email: args.Email}).validate(); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if err := q.app.authorize(ctx, authz.Manage, 0); err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	user, err := q.app.Users.Update(ctx, id, repository.UserUpdate{Name: args.Name, Email: args.Email})
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return &userResolver{user}, nil
+}
+
+func (q *gqlRoot) DeleteUser(ctx context.Context, args struct{ ID graphql.ID }) (graphql.ID, error) {
+	id, err := parseID(args.ID)
+	if err != nil {
+		return "", toGQLError(ctx, err)
+	}
+	if err := q.app.authorize(ctx, authz.Manage, 0); err != nil {
+		return "", toGQLError(ctx, err)
+	}
+	if err := q.app.Users.Delete(ctx, id); err != nil {
+		return "", toGQLError(ctx, err)
+	}
+	return args.ID, nil
+}
+
+// --- Task ---
+
+type taskResolver struct {
+	t Task
+}
+
+// newTaskResolvers primes the user loader with every task's owner, so
+// resolving task.user for the whole list is one query
+func newTaskResolvers(ctx context.Context, tasks []Task) []*taskResolver {
+	l := loadersFrom(ctx)
+	res := make([]*taskResolver, 0, len(tasks))
+	for _, t := range tasks {
+		l.users.prime(t.UserID)
+		res = append(res, &taskResolver{t})
+	}
+	return res
+}
+
+func (r *taskResolver) ID() graphql.ID     { return gqlID(r.t.ID) }
+func (r *taskResolver) OrgID() graphql.ID  { return gqlID(r.t.OrgID) }
+func (r *taskResolver) UserID() graphql.ID { return gqlID(r.t.UserID) }
+
+func (r *taskResolver) ParentID() *graphql.ID {
+	if r.t.ParentID == nil {
+		return nil
+	}
+	id := gqlID(*r.t.ParentID)
+	return &id
+}
+
+func (r *taskResolver) Title() string { return r.t.Title }
+func (r *taskResolver) Done() bool    { return r.t.Done }
+
+func (r *taskResolver) Priority() string        { return r.t.Priority }
+func (r *taskResolver) Tags() []string          { return r.t.Tags }
+func (r *taskResolver) Recurrence() *string     { return r.t.Recurrence }
+func (r *taskResolver) Version() int32          { return int32(r.t.Version) }
+func (r *taskResolver) UpdatedAt() graphql.Time { return graphql.Time{Time: r.t.UpdatedAt} }
+
+func (r *taskResolver) DueDate() *graphql.Time {
+	if r.t.DueDate == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.t.DueDate}
+}
+
+func (r *taskResolver) DeletedAt() *graphql.Time {
+	if r.t.DeletedAt == nil {
+		return nil
+	}
+	return &graphql.Time{Time: *r.t.DeletedAt}
+}
+
+func (r *taskResolver) User(ctx context.Context) (*userResolver, error) {
+	user, ok, err := loadersFrom(ctx).users.load(ctx, r.t.UserID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	if !ok { // FK makes this impossible unless the user was deleted mid-request
+		return nil, toGQLError(ctx, apperr.New(apperr.UserNotFound, "user "+strconv.Itoa(r.t.UserID)+" not found"))
+	}
+	return &userResolver{user}, nil
+}
+
+// --- User ---
+
+type userResolver struct {
+	u User
+}
+
+func (r *userResolver) ID() graphql.ID          { return gqlID(r.u.ID) }
+func (r *userResolver) OrgID() graphql.ID       { return gqlID(r.u.OrgID) }
+func (r *userResolver) Name() string            { return r.u.Name }
+func (r *userResolver) Email() string           { return r.u.Email }
+func (r *userResolver) Role() string            { return r.u.Role }
+func (r *userResolver) CreatedAt() graphql.Time { return graphql.Time{Time: r.u.CreatedAt} }
+
+func (r *userResolver) Tasks(ctx context.Context) ([]*taskResolver, error) {
+	tasks, _, err := loadersFrom(ctx).tasks.load(ctx, r.u.ID)
+	if err != nil {
+		return nil, toGQLError(ctx, err)
+	}
+	return newTaskResolvers(ctx, tasks), nil
+}
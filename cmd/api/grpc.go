@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/taskspb"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// gRPC — TaskService (proto/tasks/v1/tasks.proto) on its own
+// port. Same repository, same validation and same events as the
+// REST handlers; only the transport differs.
+// -----------------------------------------------------------
+
+type taskServer struct {
+	taskspb.UnimplementedTaskServiceServer
+	app *App
+}
+
+func newGRPCServer(app *App) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(app.logRPC, app.authenticateRPC, app.tenantRPC))
+	taskspb.RegisterTaskServiceServer(s, &taskServer{app: app})
+	reflection.Register(s) // lets grpcurl list and call methods without the .proto
+	return s
+}
+
+// logRPC — one log line per call, like logRequests for HTTP
+func (app *App) logRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	app.Log.Info("rpc",
+		"method", info.FullMethod,
+		"code", status.Code(err),
+		"latency", time.Since(start),
+	)
+	return resp, err
+}
+
+// grpcError — the gRPC twin of writeError: the status code is derived
+// from the apperr code's HTTP status, and the apperr code itself is sent
+// in the "error-code" trailer so clients can branch on it.
+func grpcError(ctx context.Context, err error) error {
+	e := apperr.From(err)
+	if e.Code == apperr.Internal {
+		requestctx.Logger(ctx).Error("rpc failed", "err", err)
+	}
+	grpc.SetTrailer(ctx, metadata.Pairs("error-code", string(e.Code)))
+
+	var code codes.Code
+	switch e.Code.Status() {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusPreconditionRequired:
+		code = codes.FailedPrecondition
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+		if e.Code == apperr.VersionConflict {
+			code = codes.Aborted // the task moved on since it was read: read it again
+		}
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	default:
+		code = codes.Internal
+	}
+	st := status.New(code, e.Message)
+	if len(e.Fields) > 0 {
+		// the standard detail type for field errors; grpcurl and most
+		// client libraries know how to show it
+		br := &errdetails.BadRequest{}
+		for _, f := range e.Fields {
+			br.FieldViolations = append(br.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       f.Field,
+				Description: f.Message,
+			})
+		}
+		if withDetails, err := st.WithDetails(br); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+func toProto(t Task) *taskspb.Task {
+	pt := &taskspb.Task{
+		Id: int64(t.ID), UserId: int64(t.UserID), Title: t.Title, Done: t.Done,
+		Priority: t.Priority, Tags: t.Tags, UpdatedAt: timestamppb.New(t.UpdatedAt),
+		Version: int64(t.Version),
+	}
+	if t.ParentID != nil {
+		pt.ParentId = int64(*t.ParentID)
+	}
+	if t.Recurrence != nil {
+		pt.Recurrence = *t.Recurrence
+	}
+	if t.DueDate != nil {
+		pt.DueDate = timestamppb.New(*t.DueDate)
+	}
+	if t.DeletedAt != nil {
+		pt.DeletedAt = timestamppb.New(*t.DeletedAt)
+	}
+	return pt
+}
+
+func (s *taskServer) ListTasks(ctx context.Context, req *taskspb.ListTasksRequest) (*taskspb.ListTasksResponse, error) {
+	page, err := newPage(int(req.Limit), int(req.Offset), s.app.Pages.For("/tasks"))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	tasks, err := s.app.Tasks.List(ctx, repository.TaskFilter{IncludeDeleted: req.IncludeDeleted}, page)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	resp := &taskspb.ListTasksResponse{Tasks: make([]*taskspb.Task, 0, len(tasks))}
+	for _, t := range tasks {
+		resp.Tasks = append(resp.Tasks, toProto(t))
+	}
+	return resp, nil
+}
+
+func (s *taskServer) GetTask(ctx context.Context, req *taskspb.GetTaskRequest) (*taskspb.Task, error) {
+	id, err := validate.CheckID(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	task, err := s.app.Tasks.Get(ctx, id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return toProto(task), nil
+}
+
+func (s *taskServer) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	if err := (CreateTaskRequest{UserID: int(req.UserId), Title: req.Title}).validate(); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if err := s.app.authorize(ctx, authz.Write, int(req.UserId)); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	task, err := s.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		return s.app.Tasks.Create(ctx, repository.NewTask{
+			UserID: int(req.UserId),
+			Title:  req.Title,
+		})
+	}, taskCreated)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	return toProto(task), nil
+}
+
+func (s *taskServer) UpdateTask(ctx context.Context, req *taskspb.UpdateTaskRequest) (*taskspb.Task, error) {
+	id, err := validate.CheckID(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if err := (UpdateTaskRequest{Title: req.Title, Done: req.Done}).validate(); err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	if req.Title == nil && req.Done == nil {
+		return nil, grpcError(ctx, apperr.New(apperr.NoFieldsToUpdate, "send at least one of title, done"))
+	}
+	versions, err := requireVersion(int(req.Version))
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	task, err := s.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		if err := s.app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return s.app.Tasks.Update(ctx, id, repository.TaskUpdate{
+			Title:     req.Title,
+			Done:      req.Done,
+			IfVersion: versions,
+		})
+	}, updateEvents(req.Done)...)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	return toProto(task), nil
+}
+
+func (s *taskServer) DeleteTask(ctx context.Context, req *taskspb.DeleteTaskRequest) (*taskspb.DeleteTaskResponse, error) {
+	id, err := validate.CheckID(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	err = s.app.inTx(ctx, func(ctx context.Context) error {
+		if err := s.app.authorizeTask(ctx, id); err != nil {
+			return err
+		}
+		if err := s.app.Tasks.Delete(ctx, id); err != nil {
+			return err
+		}
+		return s.app.record(ctx, taskDeleted, id, TaskDeleted{ID: id})
+	})
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	return &taskspb.DeleteTaskResponse{}, nil
+}
+
+func (s *taskServer) RestoreTask(ctx context.Context, req *taskspb.RestoreTaskRequest) (*taskspb.Task, error) {
+	id, err := validate.CheckID(req.Id)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	task, err := s.app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+		if err := s.app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return s.app.Tasks.Restore(ctx, id)
+	}, taskRestored)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+
+	return toProto(task), nil
+}
+
+// stopGRPC waits for in-flight calls like srv.Shutdown does for HTTP,
+// and cuts them off when ctx expires
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// HEALTH — two questions, two endpoints:
+//   /healthz — liveness: is the process alive? Never touches
+//              the DB, so a Postgres outage doesn't get every
+//              instance restarted at once.
+//   /readyz  — readiness: should this instance get traffic?
+//              Pings the pool; 503 takes it out of the load
+//              balancer until the database is back. With
+//              db.schema_check it also compares the schema
+//              with this build's (repository.CheckSchema): a
+//              database behind it — a column init.sql added
+//              but nobody ALTERed in — is reported ("log"),
+//              or also a 503 ("enforce") until it's there.
+// -----------------------------------------------------------
+
+const (
+	// readyTimeout bounds the readiness ping; a probe that hangs is as
+	// bad as one that fails
+	readyTimeout = 2 * time.Second
+	// schemaRecheck — how long a schema check's answer is reused: probes
+	// come every few seconds, schema changes rarely
+	schemaRecheck = 30 * time.Second
+)
+
+type component struct {
+	Status    string   `json:"status"` // "up" or "down"; the schema: "up" or "behind"
+	LatencyMS float64  `json:"latency_ms"`
+	Error     string   `json:"error,omitempty"`
+	LagMS     float64  `json:"lag_ms,omitempty"`  // the replica's
+	Missing   []string `json:"missing,omitempty"` // the schema's tables and columns
+}
+
+// schemaState — the last schema check (see schemaComponent)
+type schemaState struct {
+	mu      sync.Mutex
+	checked time.Time
+	missing []string
+}
+
+type poolStats struct {
+	Acquired int32 `json:"acquired"`
+	Idle     int32 `json:"idle"`
+	Total    int32 `json:"total"`
+	Max      int32 `json:"max"`
+}
+
+type readiness struct {
+	Status     string               `json:"status"` // "ready", "warming up" or "unavailable"
+	Components map[string]component `json:"components,omitempty"`
+	Pool       *poolStats           `json:"pool,omitempty"`
+}
+
+// GET /healthz — liveness: the process is up and serving HTTP
+func (app *App) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// GET /readyz — 503 until the pool is warmed up, and again whenever a
+// ping to the database fails or times out
+func (app *App) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !app.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, readiness{Status: "warming up"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	db := component{Status: "up"}
+	start := time.Now()
+	err := app.DB.Ping(ctx)
+	db.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		db.Status = "down"
+		db.Error = err.Error()
+	}
+
+	stat := app.DB.Stat()
+	resp := readiness{
+		Status:     "ready",
+		Components: map[string]component{"database": db},
+		Pool: &poolStats{
+			Acquired: stat.AcquiredConns(),
+			Idle:     stat.IdleConns(),
+			Total:    stat.TotalConns(),
+			Max:      stat.MaxConns(),
+		},
+	}
+	if err != nil {
+		requestctx.Logger(r.Context()).Warn("readiness check failed", "component", "database", "err", err)
+		resp.Status = "unavailable"
+		writeJSON(w, http.StatusServiceUnavailable, resp)
+		return
+	}
+	if app.Replica != nil {
+		resp.Components["replica"] = app.replicaComponent() // down: reads go to the primary
+	}
+	if app.SchemaCheck != validationOff {
+		schema := app.schemaComponent(ctx)
+		resp.Components["schema"] = schema
+		if schema.Status != "up" && app.SchemaCheck == validationEnforce {
+			resp.Status = "unavailable"
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// schemaComponent checks the schema at most once per schemaRecheck;
+// a failed check isn't kept, the next probe tries again
+func (app *App) schemaComponent(ctx context.Context) component {
+	s := &app.schema
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.checked) > schemaRecheck {
+		start := time.Now()
+		missing, err := repository.CheckSchema(ctx, app.DB)
+		if err != nil {
+			requestctx.Logger(ctx).Warn("readiness check failed", "component", "schema", "err", err)
+			return component{Status: "down", LatencyMS: float64(time.Since(start).Microseconds()) / 1000, Error: err.Error()}
+		}
+		if len(missing) > 0 {
+			requestctx.Logger(ctx).Warn("database schema is behind this build (see \"Existing databases\" in init.sql)", "missing", missing)
+		}
+		s.checked, s.missing = time.Now(), missing
+	}
+	if len(s.missing) > 0 {
+		return component{Status: "behind", Missing: s.missing}
+	}
+	return component{Status: "up"}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/dedup"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// INTEGRATIONS — webhooks from third parties become task updates
+//   POST /integrations/github            GitHub's own format
+//   POST /integrations/inbound/{source}  anything that signs
+//                                        its body with HMAC-SHA256
+// Each source has a secret and mapping rules (config YAML):
+//   integrations:
+//     github:
+//       rules:
+//         - event: issues.closed    # "close task #12" in the issue
+//           task_id: issue.body
+//           match: 'task #(\d+)'
+//           done: true
+// Unsigned or wrongly signed requests are 401 before the body is
+// even parsed. Deliveries are recorded (internal/dedup), so a
+// retried webhook does nothing the second time.
+// -----------------------------------------------------------
+
+const (
+	maxWebhookBody = 1 << 20 // 1 MiB; GitHub payloads are far smaller
+	// webhookDedupTTL — senders retry for hours, people hit
+	// "Redeliver" for days; a week covers both
+	webhookDedupTTL = 7 * 24 * time.Hour
+)
+
+// webhookPayload documents the body in the spec: any JSON object
+type webhookPayload map[string]any
+
+// WebhookResult — what a delivery did
+type WebhookResult struct {
+	Event     string        `json:"event"`
+	Duplicate bool          `json:"duplicate,omitempty"` // seen before, nothing done
+	Updated   []int         `json:"updated"`             // tasks changed
+	Skipped   []WebhookSkip `json:"skipped,omitempty"`   // rules that matched but couldn't apply
+}
+
+type WebhookSkip struct {
+	Rule   int    `json:"rule"` // index in the source's rules
+	Reason string `json:"reason"`
+}
+
+type webhookSource struct {
+	name  string // dedup consumer and log name: "github", "inbound/zapier"
+	cfg   config.WebhookSource
+	rules []mappingRule
+}
+
+type mappingRule struct {
+	config.MappingRule
+	match *regexp.Regexp // nil = the field is the ID
+}
+
+type integrations struct {
+	github  *webhookSource // nil = not configured
+	inbound map[string]*webhookSource
+	dedup   *dedup.Store // nil = no redelivery detection
+}
+
+// newIntegrations compiles the config; Validate already checked the
+// regexps, so only cmd/api-level rules (priorities) can fail here
+func newIntegrations(cfg config.IntegrationsConfig, store *dedup.Store) (*integrations, error) {
+	in := &integrations{inbound: map[string]*webhookSource{}, dedup: store}
+	var err error
+	if cfg.GitHub.Secret != "" {
+		if in.github, err = newWebhookSource("github", cfg.GitHub); err != nil {
+			return nil, err
+		}
+	}
+	for id, src := range cfg.Inbound {
+		if src.Secret == "" {
+			continue
+		}
+		if src.SignatureHeader == "" {
+			src.SignatureHeader = "X-Signature"
+		}
+		if src.DeliveryHeader == "" {
+			src.DeliveryHeader = "X-Delivery-ID"
+		}
+		if src.EventField == "" {
+			src.EventField = "type"
+		}
+		if in.inbound[id], err = newWebhookSource("inbound/"+id, src); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+// source finds a configured sender by its name; nil-safe, like the
+// other optional App parts
+func (in *integrations) source(name string) *webhookSource {
+	if in == nil {
+		return nil
+	}
+	if name == "github" {
+		return in.github
+	}
+	return in.inbound[strings.TrimPrefix(name, "inbound/")]
+}
+
+// sources — the enabled ones, GitHub first
+func (in *integrations) sources() []*webhookSource {
+	var out []*webhookSource
+	if in.github != nil {
+		out = append(out, in.github)
+	}
+	for _, src := range in.inbound {
+		out = append(out, src)
+	}
+	return out
+}
+
+func newWebhookSource(name string, cfg config.WebhookSource) (*webhookSource, error) {
+	src := &webhookSource{name: name, cfg: cfg}
+	for i, r := range cfg.Rules {
+		if r.Priority != "" && !slices.Contains(repository.Priorities, r.Priority) {
+			return nil, fmt.Errorf("%s rule %d: priority %q must be one of %s", name, i, r.Priority, strings.Join(repository.Priorities, ", "))
+		}
+		rule := mappingRule{MappingRule: r}
+		if r.Match != "" {
+			rule.match = regexp.MustCompile(r.Match)
+		}
+		rule.AddTags = normalizeTags(r.AddTags)
+		src.rules = append(src.rules, rule)
+	}
+	return src, nil
+}
+
+// POST /integrations/github — GitHub webhook (Content type: application/json)
+func (app *App) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	src := app.Integrations.source("github")
+	if src == nil {
+		writeError(w, r, apperr.New(apperr.IntegrationNotFound, "the github integration is not configured"))
+		return
+	}
+
+	body, err := readWebhook(r, src, r.Header.Get("X-Hub-Signature-256"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "ping" { // sent once when the webhook is created
+		writeJSON(w, http.StatusOK, WebhookResult{Event: event, Updated: []int{}})
+		return
+	}
+	payload, err := decodePayload(body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if action, ok := payload["action"].(string); ok && action != "" {
+		event += "." + action // "issues" + "closed"
+	}
+
+	app.receiveWebhook(w, r, src, event, r.Header.Get("X-GitHub-Delivery"), payload)
+}
+
+// POST /integrations/inbound/{source} — a generic signed webhook
+func (app *App) handleInboundWebhook(w http.ResponseWriter, r *http.Request) {
+	name := pathParam(r, "source")
+	src := app.Integrations.source("inbound/" + name)
+	if src == nil {
+		writeError(w, r, apperr.New(apperr.IntegrationNotFound, fmt.Sprintf("no integration named %q", name)))
+		return
+	}
+
+	body, err := readWebhook(r, src, r.Header.Get(src.cfg.SignatureHeader))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	payload, err := decodePayload(body)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	event, _ := lookupField(payload, src.cfg.EventField)
+
+	app.receiveWebhook(w, r, src, event, r.Header.Get(src.cfg.DeliveryHeader), payload)
+}
+
+// readWebhook reads the body and checks its signature: hex HMAC-SHA256
+// of the raw bytes, with or without a "sha256=" prefix
+func readWebhook(r *http.Request, src *webhookSource, signature string) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
+	if err != nil {
+		return nil, fmt.Errorf("read webhook: %w", err)
+	}
+	if len(body) > maxWebhookBody {
+		return nil, apperr.New(apperr.PayloadTooLarge, fmt.Sprintf("webhook body exceeds %d bytes", maxWebhookBody))
+	}
+
+	if signature == "" {
+		return nil, apperr.New(apperr.InvalidSignature, "webhook is not signed")
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	mac := hmac.New(sha256.New, []byte(src.cfg.Secret))
+	mac.Write(body)
+	if err != nil || !hmac.Equal(got, mac.Sum(nil)) { // constant time
+		return nil, apperr.New(apperr.InvalidSignature, "webhook signature does not match")
+	}
+	return body, nil
+}
+
+func decodePayload(body []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber() // IDs stay exact
+	var payload map[string]any
+	if err := dec.Decode(&payload); err != nil {
+		return nil, decodeError(err)
+	}
+	return payload, nil
+}
+
+// receiveWebhook applies the rules once per delivery ID. The task
+// writes go through the repository, outside the dedup transaction;
+// rules only set fields, so the rare replay after a crash between the
+// two is harmless.
+func (app *App) receiveWebhook(w http.ResponseWriter, r *http.Request, src *webhookSource, event, delivery string, payload map[string]any) {
+	logger := requestctx.Logger(r.Context()).With("integration", src.name, "event", event, "delivery", delivery)
+
+	var res WebhookResult
+	apply := func(ctx context.Context) (err error) {
+		res, err = app.applyRules(ctx, src, event, payload)
+		return err
+	}
+
+	var err error
+	if delivery == "" || app.Integrations.dedup == nil {
+		err = apply(r.Context())
+	} else {
+		var ran bool
+		ran, err = app.Integrations.dedup.Process(r.Context(), src.name, delivery, func(ctx context.Context, _ pgx.Tx) error {
+			return apply(ctx)
+		})
+		if err == nil && !ran {
+			res = WebhookResult{Event: event, Duplicate: true, Updated: []int{}}
+		}
+	}
+	if err != nil {
+		writeError(w, r, err) // 5xx: the sender retries
+		return
+	}
+
+	logger.Info("webhook received", "updated", res.Updated, "skipped", len(res.Skipped), "duplicate", res.Duplicate)
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (app *App) applyRules(ctx context.Context, src *webhookSource, event string, payload map[string]any) (WebhookResult, error) {
+	res := WebhookResult{Event: event, Updated: []int{}}
+	if src.cfg.OrgID != 0 {
+		ctx = requestctx.WithOrgID(ctx, src.cfg.OrgID) // another organization's task is not found
+	}
+	for i, rule := range src.rules {
+		if rule.Event != event {
+			continue
+		}
+		ids, reason := rule.taskIDs(payload)
+		if reason != "" {
+			res.Skipped = append(res.Skipped, WebhookSkip{Rule: i, Reason: reason})
+			continue
+		}
+		for _, id := range ids {
+			_, err := app.changeTask(ctx, func(ctx context.Context) (Task, error) {
+				return app.applyRule(ctx, rule, id)
+			}, updateEvents(rule.Done)...)
+			if errors.Is(err, repository.ErrNotFound) {
+				res.Skipped = append(res.Skipped, WebhookSkip{Rule: i, Reason: fmt.Sprintf("task %d not found", id)})
+				continue
+			}
+			if err != nil {
+				return res, err
+			}
+			if !slices.Contains(res.Updated, id) {
+				res.Updated = append(res.Updated, id)
+			}
+		}
+	}
+	return res, nil
+}
+
+// applyRule — unconditional (no If-Match): the sender's event is news,
+// not an edit of a version it has seen
+func (app *App) applyRule(ctx context.Context, rule mappingRule, id int) (Task, error) {
+	var task Task
+	var err error
+	if rule.Done != nil || rule.Priority != "" {
+		u := repository.TaskUpdate{Done: rule.Done}
+		if rule.Priority != "" {
+			u.Priority = &rule.Priority
+		}
+		if task, err = app.Tasks.Update(ctx, id, u); err != nil {
+			return task, err
+		}
+	}
+	if len(rule.AddTags) > 0 {
+		task, err = app.Tasks.AddTags(ctx, id, rule.AddTags)
+	}
+	return task, err
+}
+
+// taskIDs finds the tasks a payload refers to, or says why it can't
+func (rule mappingRule) taskIDs(payload map[string]any) ([]int, string) {
+	value, ok := lookupField(payload, rule.TaskID)
+	if !ok {
+		return nil, fmt.Sprintf("no %s in the payload", rule.TaskID)
+	}
+
+	refs := []string{value}
+	if rule.match != nil {
+		refs = refs[:0]
+		for _, m := range rule.match.FindAllStringSubmatch(value, -1) {
+			if len(m) > 1 {
+				refs = append(refs, m[1])
+			}
+		}
+		if len(refs) == 0 {
+			return nil, fmt.Sprintf("%s doesn't match %s", rule.TaskID, rule.match)
+		}
+	}
+
+	var ids []int
+	for _, ref := range refs {
+		id, err := validate.ParseID(ref)
+		if err != nil {
+			return nil, fmt.Sprintf("%q in %s is not a task ID", ref, rule.TaskID)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, ""
+}
+
+// lookupField follows a dotted path ("issue.labels.0.name"); numbers
+// index arrays. Strings, numbers and booleans come back as text.
+func lookupField(payload map[string]any, path string) (string, bool) {
+	var cur any = payload
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			cur = node[key]
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			cur = node[i]
+		default:
+			return "", false
+		}
+	}
+	switch v := cur.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
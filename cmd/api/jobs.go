@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/jobs"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// JOB QUEUE ADMIN — the dead letters of internal/jobs: jobs
+// that used up their attempts wait here for someone to look.
+//   GET  /admin/jobs?status=dead — list (default: dead)
+//   POST /admin/jobs/{id}/retry  — queue a dead job again
+// -----------------------------------------------------------
+
+var jobStatuses = []string{jobs.StatusQueued, jobs.StatusRunning, jobs.StatusDone, jobs.StatusDead}
+
+// GET /admin/jobs — jobs in one status, newest first
+func (app *App) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/admin/jobs")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = jobs.StatusDead
+	case jobs.StatusQueued, jobs.StatusRunning, jobs.StatusDone, jobs.StatusDead:
+	default:
+		writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("status %q must be one of %v", status, jobStatuses)))
+		return
+	}
+
+	list, err := app.Jobs.List(r.Context(), status, page.Limit, page.Offset)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, list)
+}
+
+// POST /admin/jobs/{id}/retry — a dead job gets a fresh set of attempts
+func (app *App) handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+	job, err := app.Jobs.Retry(r.Context(), int64(id))
+	if errors.Is(err, jobs.ErrNotFound) {
+		err = apperr.Wrap(apperr.JobNotFound, fmt.Sprintf("no dead job %d", id), err)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	requestctx.Logger(r.Context()).Info("dead job requeued", "job_id", job.ID, "kind", job.Kind)
+	writeJSON(w, http.StatusOK, job)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// -----------------------------------------------------------
+// JSON CASE — snake_case or camelCase keys, per client
+// Handlers, the spec and the database all speak snake_case. A
+// client that wants camelCase asks once per request:
+//   Accept: application/json; profile="camelCase"
+// (or the server default is changed with JSON_CASE=camel), and
+// this middleware converts at the edge, both ways:
+//   response bodies  — every object key, however deeply nested
+//   request bodies   — camelCase keys back to snake_case
+//   query parameters — ?includeDeleted= → ?include_deleted=
+// Values are left alone — except the "field" of a validation
+// error, which names a key — and key order and numbers are kept
+// exactly (token-by-token rewrite, no map round trip).
+// -----------------------------------------------------------
+
+const (
+	caseSnake = "snake"
+	caseCamel = "camel"
+)
+
+// ownNaming — endpoints whose keys are chosen by someone else (GraphQL
+// queries name their own fields, webhook bodies are signed as sent)
+// and are never converted. Keys are unversioned route patterns.
+var ownNaming = map[string]bool{
+	"/graphql":                       true,
+	"/integrations/github":           true,
+	"/integrations/inbound/{source}": true,
+}
+
+// jsonCase runs outside validateSpec, so the validator and everything
+// below it only ever see snake_case
+func (app *App) jsonCase(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if requestedCase(r, app.JSONCase) != caseCamel {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &caseWriter{ResponseWriter: w, status: http.StatusOK}
+		if r.URL.RawQuery != "" {
+			r.URL.RawQuery = convertQuery(r.URL.Query(), camelToSnake)
+		}
+		if r.Body != nil && isJSON(r.Header.Get("Content-Type"), true) {
+			raw, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(cw, r, readError(err))
+				cw.finish()
+				return
+			}
+			if converted, err := convertKeys(raw, camelToSnake); err == nil {
+				raw = converted
+			} // not valid JSON: pass it on as is, decodeJSON reports it
+			r.Body = io.NopCloser(bytes.NewReader(raw))
+		}
+
+		next.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
+
+// requestedCase — the Accept profile if the client sent one, else the
+// server default
+func requestedCase(r *http.Request, def string) string {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		_, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch params["profile"] {
+		case "camelCase":
+			return caseCamel
+		case "snake_case":
+			return caseSnake
+		}
+	}
+	return def
+}
+
+// isJSON — application/json and +json types (problem+json); a missing
+// Content-Type on a request counts, clients often leave it off
+func isJSON(contentType string, emptyOK bool) bool {
+	if contentType == "" {
+		return emptyOK
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// caseWriter holds JSON responses back until the handler is done, then
+// writes them with converted keys. Anything else (event streams, HTML)
+// goes straight through.
+type caseWriter struct {
+	http.ResponseWriter
+	status    int
+	decided   bool // WriteHeader seen
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (cw *caseWriter) WriteHeader(status int) {
+	if cw.decided {
+		return
+	}
+	cw.decided, cw.status = true, status
+	cw.buffering = isJSON(cw.Header().Get("Content-Type"), false)
+	if !cw.buffering {
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (cw *caseWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.buffering {
+		return cw.body.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *caseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *caseWriter) finish() {
+	if !cw.buffering {
+		return
+	}
+	body := cw.body.Bytes()
+	if converted, err := convertKeys(body, snakeToCamel); err == nil {
+		body = converted
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.ResponseWriter.Write(body)
+}
+
+// convertKeys rewrites every object key in raw (one or more JSON
+// values) with conv, leaving values, order and formatting of numbers
+// as they were
+func convertKeys(raw []byte, conv func(string) string) ([]byte, error) {
+	type frame struct {
+		object    bool
+		n         int  // values written so far
+		expectKey bool // objects alternate key, value
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var out bytes.Buffer
+	var stack []*frame
+	lastKey := ""
+
+	valueDone := func() {
+		if len(stack) == 0 {
+			out.WriteByte('\n') // one value per line, like json.Encoder
+			return
+		}
+		top := stack[len(stack)-1]
+		top.n++
+		top.expectKey = top.object
+	}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		closing := tok == json.Delim('}') || tok == json.Delim(']')
+		if len(stack) > 0 && !closing {
+			top := stack[len(stack)-1]
+			switch {
+			case top.object && !top.expectKey:
+				out.WriteByte(':')
+			case top.n > 0:
+				out.WriteByte(',')
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(v))
+			if closing {
+				stack = stack[:len(stack)-1]
+				valueDone()
+			} else {
+				stack = append(stack, &frame{object: v == '{', expectKey: v == '{'})
+			}
+		case string:
+			if top := len(stack) - 1; top >= 0 && stack[top].object && stack[top].expectKey {
+				v = conv(v)
+				lastKey = v
+				stack[top].expectKey = false
+				b, _ := json.Marshal(v)
+				out.Write(b)
+				continue
+			}
+			if lastKey == "field" { // {"field": "user_id", "message": ...}
+				v = conv(v)
+			}
+			b, _ := json.Marshal(v)
+			out.Write(b)
+			valueDone()
+		default: // json.Number, bool, nil
+			b, _ := json.Marshal(v)
+			out.Write(b)
+			valueDone()
+		}
+	}
+}
+
+func convertQuery(q url.Values, conv func(string) string) string {
+	out := url.Values{}
+	for k, vs := range q {
+		out[conv(k)] = append(out[conv(k)], vs...)
+	}
+	return out.Encode()
+}
+
+// snakeToCamel — "user_id" → "userId"; keys without "_" are unchanged
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// camelToSnake — "userId" → "user_id", "requestID" → "request_id"
+func camelToSnake(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, c := range runes {
+		if unicode.IsUpper(c) {
+			prevLower := i > 0 && !unicode.IsUpper(runes[i-1]) && runes[i-1] != '_'
+			endOfAcronym := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || endOfAcronym {
+				b.WriteByte('_')
+			}
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
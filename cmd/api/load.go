@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// LOAD — GET /internal/load, a handful of numbers for an
+// autoscaler (HPA custom metrics adapter, KEDA, ...) to poll
+// every few seconds. Everything is kept in memory per instance:
+// answering is a few atomics and a 60-slot sum, no DB, no
+// Prometheus text to parse. /metrics has the full picture.
+// -----------------------------------------------------------
+
+const (
+	loadWindow     = 60 // the last minute, one slot per second
+	latencyBuckets = 60
+)
+
+// latencyBounds — upper bounds of the latency buckets, 1ms growing by
+// 20% up to ~47s; p95 is reported as the bound of its bucket, so it
+// reads at most 20% high
+var latencyBounds = func() []time.Duration {
+	b := make([]time.Duration, latencyBuckets)
+	for i := range b {
+		b[i] = time.Duration(float64(time.Millisecond) * math.Pow(1.2, float64(i)))
+	}
+	return b
+}()
+
+// loadSlot — one second of traffic
+type loadSlot struct {
+	sec         int64                      // unix second it holds; an older one is reset on use
+	latency     [latencyBuckets + 1]uint32 // the last one: above every bound
+	requests    uint32
+	acquires    uint32
+	acquireWait time.Duration
+}
+
+type loadTracker struct {
+	inFlight atomic.Int64
+	waiting  atomic.Int64 // pool.Acquire calls in progress
+
+	mu    sync.Mutex
+	slots [loadWindow]loadSlot
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{}
+}
+
+// slot returns the slot for now, emptied if it still holds an old
+// second; callers hold mu
+func (l *loadTracker) slot(now time.Time) *loadSlot {
+	sec := now.Unix()
+	s := &l.slots[sec%loadWindow]
+	if s.sec != sec {
+		*s = loadSlot{sec: sec}
+	}
+	return s
+}
+
+func (l *loadTracker) observeRequest(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	l.mu.Lock()
+	s := l.slot(time.Now())
+	s.latency[i]++
+	s.requests++
+	l.mu.Unlock()
+}
+
+func (l *loadTracker) observeAcquire(d time.Duration) {
+	l.mu.Lock()
+	s := l.slot(time.Now())
+	s.acquires++
+	s.acquireWait += d
+	l.mu.Unlock()
+}
+
+// LoadReport — GET /internal/load. The _1m fields cover the last 60
+// seconds.
+type LoadReport struct {
+	InFlight int64 `json:"in_flight"` // requests being served (event streams excluded)
+	// QueueDepth — requests waiting for a database connection; above 0
+	// for long means the pool, not the CPU, is the bottleneck
+	QueueDepth      int64   `json:"queue_depth"`
+	Requests        int     `json:"requests_1m"`
+	P95LatencyMs    float64 `json:"p95_latency_ms_1m"`
+	DBAcquireWaitMs float64 `json:"db_acquire_wait_ms_1m"` // average per acquire
+}
+
+func (l *loadTracker) report() LoadReport {
+	rep := LoadReport{InFlight: l.inFlight.Load(), QueueDepth: l.waiting.Load()}
+
+	var (
+		latency  [latencyBuckets + 1]uint32
+		acquires uint32
+		wait     time.Duration
+	)
+	oldest := time.Now().Unix() - loadWindow
+	l.mu.Lock()
+	for i := range l.slots {
+		s := &l.slots[i]
+		if s.sec <= oldest {
+			continue
+		}
+		for b, n := range s.latency {
+			latency[b] += n
+		}
+		rep.Requests += int(s.requests)
+		acquires += s.acquires
+		wait += s.acquireWait
+	}
+	l.mu.Unlock()
+
+	if rep.Requests > 0 {
+		target := uint32(math.Ceil(0.95 * float64(rep.Requests)))
+		var seen uint32
+		for b, n := range latency {
+			if seen += n; seen >= target {
+				bound := latencyBounds[len(latencyBounds)-1] // the overflow bucket reads as the last bound
+				if b < len(latencyBounds) {
+					bound = latencyBounds[b]
+				}
+				rep.P95LatencyMs = math.Round(float64(bound)/float64(time.Millisecond)*10) / 10
+				break
+			}
+		}
+	}
+	if acquires > 0 {
+		rep.DBAcquireWaitMs = math.Round(float64(wait)/float64(acquires)/float64(time.Millisecond)*100) / 100
+	}
+	return rep
+}
+
+// trackLoad counts in-flight requests and their latency. Probes,
+// scrapes and autoscaler polls (infraPaths) and event streams
+// (longLived) are left out: they'd bury the real traffic.
+func (app *App) trackLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.Load.inFlight.Add(1)
+		defer func() {
+			app.Load.inFlight.Add(-1)
+			app.Load.observeRequest(time.Since(start))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireStartKey — when the pool.Acquire in flight began
+var acquireStartKey = requestctx.NewKey[time.Time]("acquire_start")
+
+// TraceAcquireStart makes dbTracer a pgxpool.AcquireTracer too: every
+// Acquire counts as waiting until it has a connection
+func (t dbTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	if t.load == nil {
+		return ctx
+	}
+	t.load.waiting.Add(1)
+	return acquireStartKey.With(ctx, time.Now())
+}
+
+func (t dbTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireEndData) {
+	if start, ok := acquireStartKey.Get(ctx); ok && t.load != nil {
+		t.load.waiting.Add(-1)
+		t.load.observeAcquire(time.Since(start))
+	}
+}
+
+// GET /internal/load — see LoadReport
+func (app *App) handleLoad(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, app.Load.report())
+}
@@ -1,6 +1,6 @@
 // =============================================================
 // Simple REST API — CRUD for Tasks
-// Run: go run cmd/api/main.go
+// Run: go run ./cmd/api
 // Test: curl http://localhost:8080/tasks
 //
 // This is what they might ask you to build in the live coding.
@@ -15,47 +15,274 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/grpc"
+
+	"sandbox-go/internal/abuse"
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/broker"
+	"sandbox-go/internal/cache"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/dedup"
+	"sandbox-go/internal/dlock"
+	"sandbox-go/internal/events"
+	"sandbox-go/internal/forwarded"
+	"sandbox-go/internal/httpclient"
+	"sandbox-go/internal/jobs"
+	"sandbox-go/internal/oidc"
+	"sandbox-go/internal/ratelimit"
+	"sandbox-go/internal/recur"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+	"sandbox-go/internal/tlscert"
+	"sandbox-go/internal/tracing"
+	"sandbox-go/internal/validate"
 )
 
 // -----------------------------------------------------------
 // MODELS
+// Task itself lives in internal/repository; these are the HTTP
+// request/response shapes.
 // -----------------------------------------------------------
 
-type Task struct {
-	ID     int    `json:"id"`
-	UserID int    `json:"user_id"`
-	Title  string `json:"title"`
-	Done   bool   `json:"done"`
-}
+type Task = repository.Task
 
 type CreateTaskRequest struct {
-	UserID int    `json:"user_id"`
-	Title  string `json:"title"`
+	UserID   int        `json:"user_id"`
+	ParentID *int       `json:"parent_id,omitempty"` // makes it a subtask
+	Title    string     `json:"title"`
+	Priority string     `json:"priority,omitempty"` // low, medium (default) or high
+	DueDate  *time.Time `json:"due_date,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	// Recurrence — daily, weekly or a cron expression such as "0 9 * * 1-5"
+	Recurrence string `json:"recurrence,omitempty"`
 }
 
 type UpdateTaskRequest struct {
-	Title *string `json:"title,omitempty"` // pointer = can detect missing vs empty
-	Done  *bool   `json:"done,omitempty"`
+	Title    *string             `json:"title,omitempty"` // pointer = can detect missing vs empty
+	Done     *bool               `json:"done,omitempty"`
+	Priority *string             `json:"priority,omitempty"`
+	DueDate  nullable[time.Time] `json:"due_date,omitempty"`  // null clears it
+	Tags     *[]string           `json:"tags,omitempty"`      // replaces all tags; [] removes them
+	ParentID nullable[int]       `json:"parent_id,omitempty"` // null = top level
+	// Recurrence — null stops the series after this occurrence
+	Recurrence nullable[string] `json:"recurrence,omitempty"`
+	// Version — the task's version as the client last read it; required
+	// unless If-Match is sent. A stale one is a 409 with the current task.
+	Version *int `json:"version,omitempty"`
+}
+
+// TagsRequest — POST /tasks/{id}/tags
+type TagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// empty — a PATCH that would change nothing
+func (req UpdateTaskRequest) empty() bool {
+	return req.Title == nil && req.Done == nil && req.Priority == nil && !req.DueDate.Set && req.Tags == nil && !req.ParentID.Set &&
+		!req.Recurrence.Set
+}
+
+// maxTitleLen matches tasks.title VARCHAR(255)
+const maxTitleLen = 255
+
+// Tags: tags.name is VARCHAR(50); a task gets at most maxTags per request
+const (
+	maxTagLen = 50
+	maxTags   = 20
+)
+
+// tagPattern — lowercase letters, digits, "-" and "_"; normalizeTags
+// lowercases first, so "Urgent" is accepted as "urgent"
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// normalizeTags — trimmed, lowercased, duplicates dropped, order kept
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
+
+// validateTags — tags[2] names the offending entry
+func validateTags(v *validate.Validator, tags []string) {
+	v.Check("tags", len(tags) <= maxTags, fmt.Sprintf("at most %d tags", maxTags))
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		v.Required(field, tag).
+			MaxLen(field, tag, maxTagLen).
+			Check(field, tagPattern.MatchString(tag), "only letters, digits, - and _")
+	}
+}
+
+// validate — every problem at once, as 422 VALIDATION_FAILED. Used by
+// the REST, gRPC and GraphQL entry points alike.
+func (req CreateTaskRequest) validate() error {
+	v := validate.New().
+		Required("title", req.Title).
+		MaxLen("title", req.Title, maxTitleLen).
+		ID("user_id", req.UserID)
+	if req.ParentID != nil {
+		v.ID("parent_id", *req.ParentID)
+	}
+	if req.Priority != "" {
+		v.OneOf("priority", req.Priority, repository.Priorities)
+	}
+	validateTags(v, req.Tags)
+	if req.Recurrence != "" {
+		validateRecurrence(v, req.Recurrence)
+	}
+	return v.Err()
+}
+
+func (req UpdateTaskRequest) validate() error {
+	v := validate.New()
+	if req.Title != nil {
+		v.Required("title", *req.Title).MaxLen("title", *req.Title, maxTitleLen)
+	}
+	if req.Priority != nil {
+		v.OneOf("priority", *req.Priority, repository.Priorities)
+	}
+	if req.Tags != nil {
+		validateTags(v, *req.Tags)
+	}
+	if req.ParentID.Value != nil {
+		v.ID("parent_id", *req.ParentID.Value)
+	}
+	if req.Recurrence.Value != nil {
+		validateRecurrence(v, *req.Recurrence.Value)
+	}
+	if req.Version != nil {
+		v.Check("version", *req.Version >= 1, "must be at least 1")
+	}
+	return v.Err()
+}
+
+// maxRecurrenceLen matches tasks.recurrence VARCHAR(100)
+const maxRecurrenceLen = 100
+
+// validateRecurrence — the parser's message says what is wrong with the
+// rule ("hour: 25 is outside 0-23")
+func validateRecurrence(v *validate.Validator, rule string) {
+	v.Required("recurrence", rule).MaxLen("recurrence", rule, maxRecurrenceLen)
+	if _, err := recur.Parse(rule); err != nil && rule != "" {
+		v.Check("recurrence", false, err.Error())
+	}
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
+func (req TagsRequest) validate() error {
+	v := validate.New().Check("tags", len(req.Tags) > 0, "required")
+	validateTags(v, req.Tags)
+	return v.Err()
+}
+
+// Problem — RFC 7807 error body (Content-Type: application/problem+json).
+// Clients should branch on Code, not on Detail.
+type Problem struct {
+	Title     string              `json:"title"`
+	Status    int                 `json:"status"`
+	Code      apperr.Code         `json:"code"`
+	Detail    string              `json:"detail,omitempty"`
+	Instance  string              `json:"instance,omitempty"`
+	RequestID string              `json:"request_id,omitempty"` // quote this when reporting a bug
+	Errors    []apperr.FieldError `json:"errors,omitempty"`     // one entry per invalid field (422)
+	Current   any                 `json:"current,omitempty"`    // the resource as it is now (409 VERSION_CONFLICT)
 }
 
 // -----------------------------------------------------------
 // APP — holds dependencies (like a service container in PHP)
 // -----------------------------------------------------------
 type App struct {
-	DB *pgxpool.Pool
+	DB    *pgxpool.Pool
+	Tasks repository.TaskRepository // interface — swap for a fake in tests
+	Users repository.UserRepository
+	Orgs  repository.OrgRepository // nil = tenant takes any organization ID
+	// Escalations — the escalation log (see escalation.go)
+	Escalations repository.EscalationRepository
+	Log         *slog.Logger
+	Metrics     *Metrics
+	Load        *loadTracker  // backs GET /internal/load
+	Panics      PanicReporter // nil = recovered panics are only logged
+	Jobs        *jobs.Queue   // background job queue (internal/jobs)
+	Schedules   *schedule.Scheduler
+	Webhooks    repository.WebhookRepository
+	APIKeys     repository.APIKeyRepository // nil = no API keys (fakes in tests)
+	Identities  repository.IdentityRepository
+	OIDC        *oidc.Provider                // nil = no /auth/oidc routes
+	Sessions    repository.SessionRepository  // nil = no cookie sessions (see sessions.go)
+	Passwords   repository.PasswordRepository // see passwords.go
+	Audit       repository.AuditRepository    // read side; the repositories write it
+	Outbox      repository.OutboxRepository   // nil = events go straight to the bus
+	Broker      broker.Publisher              // nil = no broker configured
+	Limiter     ratelimit.Limiter             // nil = rate limiting disabled
+	Abuse       *abuse.Detector               // nil = no anomaly detection (see abuse.go)
+	Anomalies   repository.AnomalyRepository  // what it found
+	Proxies     *forwarded.Proxies            // trusted; nil = none (see clientInfo)
+	Tenants     *repository.TenantPools       // nil = no dedicated tenants (see tenancy.go)
+	Replica     *repository.Replica           // nil = every read on the primary
+	Spec        *specValidator                // nil = OpenAPI validation off
+	Tracer      *tracing.Tracer               // nil = no tracing (see tracing.go)
+	Events      *events.Bus                   // task changes, streamed at /tasks/events
+	Pages       config.PaginationConfig
+	Password    config.PasswordConfig // policy, argon2id cost, lockout
+	GraphQL     *graphql.Schema
+	// Integrations — inbound webhooks (see integrations.go)
+	Integrations *integrations
+	// WebhookClient posts the outbound ones (newWebhookClient);
+	// PrivateWebhooks — webhooks.allow_private
+	WebhookClient   *http.Client
+	PrivateWebhooks bool
+	// RefreshTokens — nil = logins come without one (see refresh.go)
+	RefreshTokens repository.RefreshTokenRepository
+	// OrgCache — organizations tenant has found (see checkOrg); nil =
+	// looked up on every request
+	OrgCache *cache.Cache[int, repository.Org]
+
+	RequestTimeout time.Duration // 0 = no deadline
+	MaxBodyBytes   int64         // cap on request bodies; 0 = none (see limitBodies)
+	JSONCase       string        // default key style: "snake" or "camel"
+	RequestTx      bool          // one DB transaction per mutating request
+	CanaryPercent  float64       // share of requests to canaried routes the canary gets (see canary.go)
+	DefaultOrg     int           // organization of requests without X-Org-ID; 0 = none
+	AnonymousRole  authz.Role    // role of requests no one authenticated ("" = no rights)
+	PlatformOrg    int           // its admins run the platform (see platformOnly); 0 = none
+	SchemaCheck    string        // db.schema_check: off, log or enforce (see health.go)
+	TokenSecret    []byte        // keys our access tokens; nil = none (see authn.go)
+	TokenTTL       time.Duration
+	OIDCOrg        int    // organization first OIDC logins create users in
+	OIDCRedirect   string // auth.oidc.redirect_url
+	SessionTTL     time.Duration
+	RefreshTTL     time.Duration
+	SessionSecure  bool        // session cookies are Secure
+	Router         *router     // set by routes(); backs /admin/routes
+	ready          atomic.Bool // flipped once the DB pool is warmed up
+	schema         schemaState // last schema check of /readyz
+	goroutines     goroutines  // App.Go's (see goroutines.go)
 }
 
 // -----------------------------------------------------------
@@ -64,250 +291,1146 @@ type App struct {
 
 // writeJSON — helper to send JSON responses
 func writeJSON(w http.ResponseWriter, status int, data any) {
+	// Encode before the status goes out, so Server-Timing includes it
+	start := time.Now()
+	body, err := json.Marshal(data)
+	timingsOf(w).add("encode", time.Since(start))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+	w.Write(append(body, '\n'))
+}
+
+// writeError — the ONE place errors become responses.
+// *apperr.Error values keep their code and message; anything else is
+// an unexpected failure and becomes a 500 INTERNAL with a generic
+// message (the real cause only goes to the log).
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	// Whatever the driver wrapped it in, a failure after our deadline
+	// passed is a timeout (504), not a server bug (500)
+	if errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		err = apperr.Wrap(apperr.Timeout, "the request took too long and was cancelled", err)
+	}
+	e := apperr.From(err)
+	status := e.Code.Status()
+
+	logger := requestctx.Logger(r.Context())
+	if status >= 500 {
+		logger.Error("request failed", "code", e.Code, "err", err)
+	} else {
+		logger.Info("request rejected", "code", e.Code, "detail", e.Message)
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{
+		Title:     e.Code.Title(),
+		Status:    status,
+		Code:      e.Code,
+		Detail:    e.Message,
+		Instance:  r.URL.Path,
+		RequestID: w.Header().Get(requestIDHeader), // set by the requestID middleware
+		Errors:    e.Fields,
+		Current:   e.Current,
+	})
+}
+
+// pathID — the {id} of the matched route. The router has already run
+// validate.ParseID on it (see routes()), so it can't fail here.
+func pathID(r *http.Request) int {
+	id, _ := validate.ParseID(pathParam(r, "id"))
+	return id
+}
+
+// pageParams reads ?limit= and ?offset= for a list route. A missing
+// limit gets the route's default; one above its cap is rejected rather
+// than silently trimmed, so callers notice they aren't getting it all.
+// The effective values go back in X-Limit, X-Max-Limit and X-Offset.
+func (app *App) pageParams(w http.ResponseWriter, r *http.Request, route string) (repository.Page, error) {
+	q := r.URL.Query()
+	var limit, offset int
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return repository.Page{}, apperr.New(apperr.InvalidPage, fmt.Sprintf("limit %q must be a positive integer", s))
+		}
+		limit = n
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return repository.Page{}, apperr.New(apperr.InvalidPage, fmt.Sprintf("offset %q must be a non-negative integer", s))
+		}
+		offset = n
+	}
+
+	limits := app.Pages.For(route)
+	page, err := newPage(limit, offset, limits)
+	if err != nil {
+		return page, err
+	}
+
+	w.Header().Set("X-Limit", strconv.Itoa(page.Limit))
+	w.Header().Set("X-Max-Limit", strconv.Itoa(limits.Max))
+	w.Header().Set("X-Offset", strconv.Itoa(page.Offset))
+	return page, nil
+}
+
+// newPage applies the route's limits; limit 0 = use the default.
+// Shared with the gRPC server.
+func newPage(limit, offset int, limits config.PageLimits) (repository.Page, error) {
+	switch {
+	case limit < 0 || offset < 0:
+		return repository.Page{}, apperr.New(apperr.InvalidPage, "limit and offset cannot be negative")
+	case limit > limits.Max:
+		return repository.Page{}, apperr.New(apperr.LimitTooLarge, fmt.Sprintf("limit %d exceeds the maximum of %d; page through with offset", limit, limits.Max))
+	case limit == 0:
+		limit = limits.Default
+	}
+	return repository.Page{Limit: limit, Offset: offset}, nil
+}
+
+// ListResponse — a list as /v2 sends it: the items in data, and room
+// next to them for whatever a list needs to say later. v1 (and
+// unversioned routes) send data alone, as a bare array.
+type ListResponse[T any] struct {
+	Data  []T       `json:"data"`
+	Meta  ListMeta  `json:"meta"`
+	Links ListLinks `json:"links"`
+}
+
+type ListMeta struct {
+	Pagination *Pagination `json:"pagination,omitempty"` // nil = not paginated (?tree=true)
 }
 
-// writeError — helper to send error responses
-func writeError(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, ErrorResponse{Error: msg})
+type Pagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Count  int `json:"count"` // items on this page
 }
 
-// extractID — get ID from URL path like /tasks/123
-func extractID(path, prefix string) (int, error) {
-	idStr := strings.TrimPrefix(path, prefix)
-	idStr = strings.TrimSuffix(idStr, "/")
-	return strconv.Atoi(idStr)
+// ListLinks — relative URLs, the request's own query kept
+type ListLinks struct {
+	Self string `json:"self"`
+	Next string `json:"next,omitempty"` // only after a full page: there may be more
+	Prev string `json:"prev,omitempty"`
+}
+
+// writeList sends one page of a list (page as from pageParams; the
+// zero Page = the whole list, unpaginated)
+func writeList[T any](w http.ResponseWriter, r *http.Request, page repository.Page, items []T) {
+	if items == nil {
+		items = []T{}
+	}
+	if v := routeVersion(r); v == "" || v == "v1" {
+		writeJSON(w, http.StatusOK, items)
+		return
+	}
+	out := ListResponse[T]{Data: items, Links: ListLinks{Self: r.URL.RequestURI()}}
+	if page.Limit > 0 {
+		out.Meta.Pagination = &Pagination{Limit: page.Limit, Offset: page.Offset, Count: len(items)}
+		if len(items) == page.Limit {
+			out.Links.Next = pageURL(r, page.Limit, page.Offset+page.Limit)
+		}
+		if page.Offset > 0 {
+			out.Links.Prev = pageURL(r, page.Limit, max(page.Offset-page.Limit, 0))
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// pageURL — the request's URL at another page
+func pageURL(r *http.Request, limit, offset int) string {
+	q := r.URL.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	return r.URL.Path + "?" + q.Encode()
 }
 
 // -----------------------------------------------------------
 // HANDLERS
 // -----------------------------------------------------------
 
-// GET /tasks — list all tasks
+// GET /tasks?limit=&offset= — list tasks, one page at a time
+// ?include_deleted=true also lists tasks in the trash
 func (app *App) handleListTasks(w http.ResponseWriter, r *http.Request) {
-	rows, err := app.DB.Query(r.Context(),
-		"SELECT id, user_id, title, done FROM tasks ORDER BY id",
-	)
+	page, err := app.pageParams(w, r, "/tasks")
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to query tasks")
-		log.Printf("listTasks: %v", err)
+		writeError(w, r, err)
 		return
 	}
-	defer rows.Close()
 
-	tasks := []Task{} // empty slice, not nil (so JSON is [] not null)
-	for rows.Next() {
-		var t Task
-		if err := rows.Scan(&t.ID, &t.UserID, &t.Title, &t.Done); err != nil {
-			writeError(w, http.StatusInternalServerError, "failed to scan task")
-			return
-		}
-		tasks = append(tasks, t)
+	filter, err := taskFilter(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
 
-	writeJSON(w, http.StatusOK, tasks)
+	tasks, err := app.Tasks.List(r.Context(), filter, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	writeList(w, r, page, tasks)
+}
+
+// taskFilter reads the GET /tasks query: ?include_deleted=, ?overdue=,
+// ?priority=, ?tag= and ?sort=due_date
+func taskFilter(r *http.Request) (repository.TaskFilter, error) {
+	q := r.URL.Query()
+	var f repository.TaskFilter
+	if s := q.Get("include_deleted"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, apperr.New(apperr.InvalidParam, fmt.Sprintf("include_deleted %q must be true or false", s))
+		}
+		f.IncludeDeleted = b
+	}
+	if s := q.Get("overdue"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return f, apperr.New(apperr.InvalidParam, fmt.Sprintf("overdue %q must be true or false", s))
+		}
+		f.Overdue = &b
+	}
+	if s := q.Get("priority"); s != "" {
+		if !slices.Contains(repository.Priorities, s) {
+			return f, apperr.New(apperr.InvalidParam, fmt.Sprintf("priority %q must be one of %s", s, strings.Join(repository.Priorities, ", ")))
+		}
+		f.Priority = s
+	}
+	if s := q.Get("tag"); s != "" {
+		f.Tag = strings.ToLower(s) // same normalization as on write
+	}
+	switch s := q.Get("sort"); s {
+	case "", "id":
+	case repository.SortDueDate:
+		f.Sort = s
+	default:
+		return f, apperr.New(apperr.InvalidParam, fmt.Sprintf("sort %q must be id or due_date", s))
+	}
+	return f, nil
 }
 
 // POST /tasks — create a task
 func (app *App) handleCreateTask(w http.ResponseWriter, r *http.Request) {
 	var req CreateTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON body")
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
 		return
 	}
+	req.Tags = normalizeTags(req.Tags)
+	req.Recurrence = strings.TrimSpace(req.Recurrence)
 
-	// Validation
-	if req.Title == "" {
-		writeError(w, http.StatusBadRequest, "title is required")
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
 		return
 	}
-	if req.UserID == 0 {
-		writeError(w, http.StatusBadRequest, "user_id is required")
+	if err := app.authorize(r.Context(), authz.Write, req.UserID); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	var task Task
-	err := app.DB.QueryRow(r.Context(),
-		"INSERT INTO tasks (user_id, title) VALUES ($1, $2) RETURNING id, user_id, title, done",
-		req.UserID, req.Title,
-	).Scan(&task.ID, &task.UserID, &task.Title, &task.Done)
+	task, err := app.changeTask(r.Context(), func(ctx context.Context) (Task, error) {
+		return app.Tasks.Create(ctx, repository.NewTask{
+			UserID:   req.UserID,
+			ParentID: req.ParentID,
+			Title:    req.Title,
+			Priority: req.Priority,
+			DueDate:  req.DueDate,
+			Tags:     req.Tags,
 
+			Recurrence: req.Recurrence,
+		})
+	}, taskCreated)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to create task")
-		log.Printf("createTask: %v", err)
+		writeError(w, r, err)
 		return
 	}
 
+	setTaskETag(w, task)
 	writeJSON(w, http.StatusCreated, task)
 }
 
 // GET /tasks/{id} — get single task
 func (app *App) handleGetTask(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r.URL.Path, "/tasks/")
+	id := pathID(r)
+
+	task, err := app.Tasks.Get(r.Context(), id)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid task ID")
+		writeError(w, r, err) // TASK_NOT_FOUND comes from the repository
 		return
 	}
 
-	var task Task
-	err = app.DB.QueryRow(r.Context(),
-		"SELECT id, user_id, title, done FROM tasks WHERE id = $1", id,
-	).Scan(&task.ID, &task.UserID, &task.Title, &task.Done)
-
-	if err != nil {
-		writeError(w, http.StatusNotFound, fmt.Sprintf("task %d not found", id))
+	setTaskETag(w, task)
+	if notModified(r, task) {
+		w.WriteHeader(http.StatusNotModified) // the client's copy is current
 		return
 	}
-
 	writeJSON(w, http.StatusOK, task)
 }
 
 // PUT /tasks/{id} — update a task
+// PATCH /tasks/{id} — same, but at least one field is required
+// Either way the repository applies the change in a single statement,
+// and only if the version the client sent ("version" in the body, or
+// If-Match) is still the current one (see etag.go).
 func (app *App) handleUpdateTask(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r.URL.Path, "/tasks/")
+	id := pathID(r)
+
+	var req UpdateTaskRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	versions, err := taskVersions(r, req.Version)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid task ID")
+		writeError(w, r, err)
 		return
 	}
+	if req.Tags != nil {
+		tags := normalizeTags(*req.Tags)
+		req.Tags = &tags
+	}
+	if req.Recurrence.Value != nil {
+		rule := strings.TrimSpace(*req.Recurrence.Value)
+		req.Recurrence.Value = &rule
+	}
 
-	var req UpdateTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON body")
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
 		return
 	}
-
-	// Build update dynamically (only update provided fields)
-	if req.Title != nil {
-		_, err = app.DB.Exec(r.Context(),
-			"UPDATE tasks SET title = $1 WHERE id = $2", *req.Title, id)
+	if r.Method == http.MethodPatch && req.empty() {
+		writeError(w, r, apperr.New(apperr.NoFieldsToUpdate, "send at least one of title, done, priority, due_date, tags, parent_id, recurrence"))
+		return
 	}
-	if req.Done != nil {
-		_, err = app.DB.Exec(r.Context(),
-			"UPDATE tasks SET done = $1 WHERE id = $2", *req.Done, id)
+
+	task, err := app.changeTask(r.Context(), func(ctx context.Context) (Task, error) {
+		if err := app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return app.Tasks.Update(ctx, id, repository.TaskUpdate{
+			Title:       req.Title,
+			Done:        req.Done,
+			Priority:    req.Priority,
+			SetDueDate:  req.DueDate.Set,
+			DueDate:     req.DueDate.Value,
+			Tags:        req.Tags,
+			SetParentID: req.ParentID.Set,
+			ParentID:    req.ParentID.Value,
+			IfVersion:   versions,
+
+			SetRecurrence: req.Recurrence.Set,
+			Recurrence:    req.Recurrence.Value,
+		})
+	}, updateEvents(req.Done)...)
+	if err != nil {
+		writeError(w, r, conflictStatus(err, req.Version))
+		return
 	}
 
+	setTaskETag(w, task)
+	writeJSON(w, http.StatusOK, task)
+}
+
+// DELETE /tasks/{id} — moves the task to the trash (see purge.go)
+func (app *App) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	err := app.inTx(r.Context(), func(ctx context.Context) error {
+		if err := app.authorizeTask(ctx, id); err != nil {
+			return err
+		}
+		if err := app.Tasks.Delete(ctx, id); err != nil {
+			return err
+		}
+		return app.record(ctx, taskDeleted, id, TaskDeleted{ID: id})
+	})
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to update task")
+		writeError(w, r, err)
 		return
 	}
 
-	// Return updated task
-	var task Task
-	err = app.DB.QueryRow(r.Context(),
-		"SELECT id, user_id, title, done FROM tasks WHERE id = $1", id,
-	).Scan(&task.ID, &task.UserID, &task.Title, &task.Done)
+	w.WriteHeader(http.StatusNoContent) // 204 — success, no body
+}
 
+// POST /tasks/{id}/restore — take a task out of the trash
+func (app *App) handleRestoreTask(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	task, err := app.changeTask(r.Context(), func(ctx context.Context) (Task, error) {
+		if err := app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return app.Tasks.Restore(ctx, id)
+	}, taskRestored)
 	if err != nil {
-		writeError(w, http.StatusNotFound, fmt.Sprintf("task %d not found", id))
+		writeError(w, r, err)
 		return
 	}
 
+	setTaskETag(w, task)
 	writeJSON(w, http.StatusOK, task)
 }
 
-// DELETE /tasks/{id}
-func (app *App) handleDeleteTask(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r.URL.Path, "/tasks/")
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid task ID")
+// POST /tasks/{id}/tags — add tags, keeping the ones it has
+// Adding and removing a tag commute, so unlike PUT/PATCH these don't
+// need If-Match: two clients tagging at once both get their tag.
+func (app *App) handleAddTags(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	var req TagsRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.Tags = normalizeTags(req.Tags)
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	tag, err := app.DB.Exec(r.Context(),
-		"DELETE FROM tasks WHERE id = $1", id)
+	task, err := app.changeTask(r.Context(), func(ctx context.Context) (Task, error) {
+		if err := app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return app.Tasks.AddTags(ctx, id, req.Tags)
+	}, taskUpdated)
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to delete task")
+		writeError(w, r, err)
 		return
 	}
 
-	if tag.RowsAffected() == 0 {
-		writeError(w, http.StatusNotFound, fmt.Sprintf("task %d not found", id))
+	setTaskETag(w, task)
+	writeJSON(w, http.StatusOK, task)
+}
+
+// DELETE /tasks/{id}/tags/{tag} — remove one tag; removing a tag the
+// task doesn't have is not an error
+func (app *App) handleRemoveTag(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	tag := strings.ToLower(pathParam(r, "tag"))
+	task, err := app.changeTask(r.Context(), func(ctx context.Context) (Task, error) {
+		if err := app.authorizeTask(ctx, id); err != nil {
+			return Task{}, err
+		}
+		return app.Tasks.RemoveTag(ctx, id, tag)
+	}, taskUpdated)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusNoContent) // 204 — success, no body
+	setTaskETag(w, task)
+	writeJSON(w, http.StatusOK, task)
 }
 
 // -----------------------------------------------------------
-// ROUTER — simple routing without external libraries
+// ROUTER — simple routing without external libraries; the
+// registry in router.go does the method dispatch
 // -----------------------------------------------------------
-func (app *App) routes() http.Handler {
-	mux := http.NewServeMux()
+func (app *App) routes() (http.Handler, error) {
+	rt := newRouter()
+	app.Router = rt
+	if app.Load == nil {
+		app.Load = newLoadTracker() // no pool tracer: queue_depth and DB wait stay 0
+	}
 
-	// /tasks — collection endpoint
-	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			app.handleListTasks(w, r)
-		case http.MethodPost:
-			app.handleCreateTask(w, r)
-		default:
-			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		}
+	// Zero, negative, non-numeric and overflowing IDs are 400 here,
+	// before any handler (or the database) sees them
+	rt.param("id", func(s string) error {
+		_, err := validate.ParseID(s)
+		return err
 	})
 
+	// Resources are versioned: /v1/tasks and /v2/tasks, the old /tasks
+	// paths stay as deprecated aliases of v1 (see apiVersion in
+	// router.go). Both versions share every handler; it's the
+	// responses of lists that differ (see writeList).
+	api := apiVersions{rt.version("v1").withLegacyPaths(), rt.version("v2")}
+
+	// Writes check the caller's role (authz.go): the handlers for what
+	// members may do to their own resources, admin for the rest
+	admin := app.adminOnly()
+
+	// /tasks — collection endpoint
+	api.handleFunc(http.MethodGet, "/tasks", app.handleListTasks)
+	api.handleFunc(http.MethodPost, "/tasks", app.handleCreateTask)
+
+	// /tasks/events — SSE stream; literal paths win over /tasks/{id}
+	api.handleFunc(http.MethodGet, "/tasks/events", app.handleTaskEvents)
+	api.handleFunc(http.MethodGet, "/tasks/events/poll", app.handleTaskEventsPoll) // same events, for proxies that break SSE
+	api.handleFunc(http.MethodGet, "/tasks/search", app.handleSearchTasks)
+	api.handleFunc(http.MethodGet, "/tasks/export.csv", app.handleExportTasks)
+	api.handleFunc(http.MethodPost, "/tasks/import", app.handleImportTasks, admin, limitBody(maxImportBytes))
+	api.handleFunc(http.MethodPost, "/tasks/reassign", app.handleReassignTasks, admin)
+
 	// /tasks/{id} — single resource endpoint
-	mux.HandleFunc("/tasks/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			app.handleGetTask(w, r)
-		case http.MethodPut:
-			app.handleUpdateTask(w, r)
-		case http.MethodDelete:
-			app.handleDeleteTask(w, r)
-		default:
-			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		}
-	})
+	api.handleFunc(http.MethodGet, "/tasks/{id}", app.handleGetTask)
+	api.handleFunc(http.MethodPut, "/tasks/{id}", app.handleUpdateTask)
+	api.handleFunc(http.MethodPatch, "/tasks/{id}", app.handleUpdateTask)
+	api.handleFunc(http.MethodDelete, "/tasks/{id}", app.handleDeleteTask)
+	api.handleFunc(http.MethodPost, "/tasks/{id}/restore", app.handleRestoreTask)
+	api.handleFunc(http.MethodPost, "/tasks/{id}/tags", app.handleAddTags)
+	api.handleFunc(http.MethodDelete, "/tasks/{id}/tags/{tag}", app.handleRemoveTag)
+	api.handleFunc(http.MethodGet, "/tasks/{id}/subtasks", app.handleListSubtasks)
+	api.handleFunc(http.MethodGet, "/tasks/{id}/audit", app.handleTaskAudit)
 
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	// /users and /users/{id}; DELETE also deletes the user's tasks (ON
+	// DELETE CASCADE)
+	registerCRUD(app, api, crudResource[User, CreateUserRequest, UpdateUserRequest]{
+		Path: "/users", Repo: userCRUD{app.Users}, Write: []middleware{admin},
 	})
 
-	return mux
+	// /escalations — what the escalation rules did
+	api.handleFunc(http.MethodGet, "/escalations", app.handleListEscalations)
+
+	// /webhooks — outbound task notifications (see webhooks.go)
+	api.handleFunc(http.MethodGet, "/webhooks", app.handleListWebhooks)
+	api.handleFunc(http.MethodPost, "/webhooks", app.handleCreateWebhook)
+	api.handleFunc(http.MethodGet, "/webhooks/{id}", app.handleGetWebhook)
+	api.handleFunc(http.MethodPut, "/webhooks/{id}", app.handleUpdateWebhook)
+	api.handleFunc(http.MethodDelete, "/webhooks/{id}", app.handleDeleteWebhook)
+	api.handleFunc(http.MethodGet, "/webhooks/{id}/deliveries", app.handleListDeliveries)
+
+	// /apikeys — credentials for other services (see apikeys.go)
+	api.handleFunc(http.MethodGet, "/apikeys", app.handleListAPIKeys)
+	api.handleFunc(http.MethodPost, "/apikeys", app.handleCreateAPIKey)
+	api.handleFunc(http.MethodPost, "/apikeys/{id}/revoke", app.handleRevokeAPIKey)
+
+	// /users/{id}/password — the handler checks who may (passwords.go)
+	api.handleFunc(http.MethodPost, "/users/{id}/password", app.handleSetPassword)
+
+	// Login — with an OpenID Connect provider (see oidc.go); the
+	// organization comes from the account, not X-Org-ID
+	if app.OIDC != nil {
+		rt.handleFunc(http.MethodGet, "/auth/oidc/login", app.handleOIDCLogin)
+		rt.handleFunc(http.MethodGet, "/auth/oidc/callback", app.handleOIDCCallback)
+	}
+	// Password logins end with an access token, like OIDC ones
+	if app.TokenSecret != nil {
+		rt.handleFunc(http.MethodPost, "/auth/password", app.handlePasswordLogin)
+	}
+	// Sessions — cookie logins for browsers (see sessions.go)
+	if app.Sessions != nil {
+		rt.handleFunc(http.MethodPost, "/auth/login", app.handleLogin)
+		rt.handleFunc(http.MethodGet, "/auth/session", app.handleGetSession)
+		rt.handleFunc(http.MethodPost, "/auth/logout", app.handleLogout)
+	}
+	// Refresh tokens — trade for a new access token (see refresh.go)
+	if app.RefreshTokens != nil {
+		rt.handleFunc(http.MethodPost, "/auth/refresh", app.handleRefresh)
+		rt.handleFunc(http.MethodPost, "/auth/revoke", app.handleRevokeRefresh)
+	}
+
+	// Integrations — signed webhooks from other services
+	rt.handleFunc(http.MethodPost, "/integrations/github", app.handleGitHubWebhook)
+	rt.handleFunc(http.MethodPost, "/integrations/inbound/{source}", app.handleInboundWebhook)
+
+	// GraphQL — one endpoint, the query says what to fetch
+	rt.handleFunc(http.MethodPost, "/graphql", app.handleGraphQL)
+
+	// Probes, docs, metrics
+	rt.handleFunc(http.MethodGet, "/healthz", app.handleHealth)
+	rt.handleFunc(http.MethodGet, "/health", app.handleHealth) // old name, same liveness check
+	rt.handleFunc(http.MethodGet, "/readyz", app.handleReady)
+	rt.handleFunc(http.MethodGet, "/internal/load", app.handleLoad) // for autoscalers
+	rt.handleFunc(http.MethodGet, "/openapi.json", app.handleOpenAPI)
+	rt.handleFunc(http.MethodGet, "/docs", app.handleDocs)
+	rt.handleFunc(http.MethodGet, "/schemas/task-event.json", app.handleTaskEventSchema) // broker messages
+	rt.handle(http.MethodGet, "/metrics", app.Metrics.handler())
+
+	// Admin — an organization's admins, in their organization; the
+	// platform's (auth.platform_org) the rest, and any organization.
+	// Where anonymous requests are admins too (auth.anonymous_role:
+	// admin), keep /admin off the public network.
+	platform := app.platformOnly()
+	rt.handleFunc(http.MethodGet, "/admin/audit", app.handleListAudit, admin)
+	rt.handleFunc(http.MethodGet, "/admin/anomalies", app.handleListAnomalies, admin)
+	rt.handleFunc(http.MethodPut, "/admin/users/{id}/role", app.handleSetRole, admin)
+	rt.handleFunc(http.MethodGet, "/admin/routes", app.handleListRoutes, platform)
+	rt.handleFunc(http.MethodGet, "/admin/jobs", app.handleListJobs, platform)
+	rt.handleFunc(http.MethodPost, "/admin/jobs/{id}/retry", app.handleRetryJob, platform)
+	rt.handleFunc(http.MethodGet, "/admin/schedules", app.handleListSchedules, platform)
+	rt.handleFunc(http.MethodGet, "/admin/selfcheck", app.handleSelfCheck, platform)
+	rt.handleFunc(http.MethodGet, "/admin/orgs", app.handleListOrgs, platform)
+	rt.handleFunc(http.MethodPost, "/admin/orgs", app.handleCreateOrg, platform)
+	rt.handleFunc(http.MethodGet, "/admin/tenants", app.handleListTenantPools, platform)
+
+	// Canaries — rewrites of the handlers above, on live traffic next
+	// to them (see canary.go); none at the moment
+
+	if err := rt.err(); err != nil {
+		return nil, err
+	}
+
+	// Outermost first: the request ID must exist before we log, and
+	// rate limiting runs inside the metrics so 429s are counted; so do
+	// recovered panics, logged and counted as the 500s they become.
+	// authenticate and tenant run under the deadline (they may look the
+	// API key and the organization up), and rate limiting after the key
+	// is known: a key is limited as itself, with its own limit if any.
+	free := tenantFree(rt)
+	return rt.use(
+		middleware{name: "requestID", wrap: requestID},
+		middleware{name: "clientInfo", wrap: app.clientInfo},
+		middleware{name: "deprecation", wrap: rt.deprecation},
+		middleware{name: "canaryRouting", wrap: rt.canaryRouting(app.CanaryPercent)},
+		middleware{name: "traceRequests", wrap: func(next http.Handler) http.Handler {
+			return app.traceRequests(rt.lookup, next)
+		}, skip: infraPaths},
+		middleware{name: "serverTiming", wrap: serverTiming},
+		middleware{name: "logRequests", wrap: app.logRequests},
+		middleware{name: "instrument", wrap: func(next http.Handler) http.Handler {
+			return app.Metrics.instrument(rt.lookup, next)
+		}},
+		middleware{name: "trackLoad", wrap: app.trackLoad, skip: skipEither(infraPaths, longLived)},
+		middleware{name: "recoverPanics", wrap: app.recoverPanics},
+		middleware{name: "withTimeout", wrap: app.withTimeout, skip: longLived},
+		middleware{name: "limitBody", wrap: app.limitBodies, skip: ownBodyLimit},
+		middleware{name: "authenticate", wrap: app.authenticate, skip: infraPaths},
+		middleware{name: "detectAbuse", wrap: app.detectAbuse, skip: infraPaths},
+		middleware{name: "rateLimit", wrap: app.rateLimit, skip: infraPaths},
+		middleware{name: "tenant", wrap: app.tenant, skip: free},
+		middleware{name: "readReplica", wrap: app.readReplica},
+		middleware{name: "jsonCase", wrap: app.jsonCase, skip: ownNaming},
+		middleware{name: "requestTx", wrap: app.requestTx, skip: ownTransactions},
+		middleware{name: "validateSpec", wrap: app.Spec.validateSpec},
+	), nil
+}
+
+// GET /openapi.json — spec generated once at startup (see openapi.go)
+func (app *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, apiSpec)
+}
+
+// GET /docs — Swagger UI pointed at /openapi.json
+func (app *App) handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
 }
 
 // -----------------------------------------------------------
 // MAIN
 // -----------------------------------------------------------
 func main() {
+	// ctx is cancelled on Ctrl+C (SIGINT) or `docker stop` (SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// -check: report on the config and what it points at, then exit
+	if config.SelfCheck(os.Args[1:]) {
+		os.Exit(runSelfCheck(ctx, os.Args[1:], os.Stdout))
+	}
+
+	// Config: defaults < YAML file < env vars < flags (see internal/config)
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("invalid config", "err", err)
+	}
+
+	// Logging: json for log shippers, text for humans
+	logger, err := newLogger(cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("invalid logging config", "err", err)
+	}
+	slog.SetDefault(logger)
+
 	// Connect to database
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5432")
-	user := getEnv("DB_USER", "gouser")
-	pass := getEnv("DB_PASSWORD", "gopass")
-	name := getEnv("DB_NAME", "sandbox")
+	poolCfg, err := pgxpool.ParseConfig(cfg.DB.ConnString())
+	if err != nil {
+		fatal("invalid database config", "err", err)
+	}
+	poolCfg.MinConns = int32(cfg.DB.MinConns)
+	load := newLoadTracker()
+	poolCfg.ConnConfig.Tracer = dbTracer{load: load} // db phase of Server-Timing, pool waits for /internal/load
+	if poolCfg.MaxConns < poolCfg.MinConns {
+		poolCfg.MaxConns = poolCfg.MinConns
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
+	if err != nil {
+		fatal("unable to connect to database", "err", err)
+	}
+
+	app := &App{
+		DB:    pool,
+		Tasks: repository.NewPgxTaskRepository(pool),
+		Users: repository.NewPgxUserRepository(pool),
+		Orgs:  repository.NewPgxOrgRepository(pool),
 
-	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		user, pass, host, port, name)
+		Escalations: repository.NewPgxEscalationRepository(pool),
+		Webhooks:    repository.NewPgxWebhookRepository(pool),
+		APIKeys:     repository.NewPgxAPIKeyRepository(pool),
+		Identities:  repository.NewPgxIdentityRepository(pool),
+		Passwords:   repository.NewPgxPasswordRepository(pool),
+		Outbox:      repository.NewPgxOutboxRepository(pool),
+		Audit:       repository.NewPgxAuditRepository(pool),
+		Log:         logger,
+		Metrics:     newMetrics(pool),
+		Load:        load,
+		Events:      events.NewBus(eventBufferSize),
+		Pages:       cfg.Pagination,
+		Password:    cfg.Auth.Passwords,
 
-	pool, err := pgxpool.New(context.Background(), connStr)
+		RequestTimeout: cfg.Server.RequestTimeout,
+		MaxBodyBytes:   int64(cfg.Server.MaxBodyBytes),
+		JSONCase:       cfg.Server.JSONCase,
+		RequestTx:      cfg.Server.TransactionPerRequest,
+		CanaryPercent:  cfg.Server.CanaryPercent,
+		DefaultOrg:     cfg.Tenancy.DefaultOrg,
+		AnonymousRole:  authz.Role(cfg.Auth.AnonymousRole),
+		PlatformOrg:    cfg.Auth.PlatformOrg,
+		SchemaCheck:    cfg.DB.SchemaCheck,
+		TokenTTL:       cfg.Auth.TokenTTL,
+		OIDCOrg:        cfg.Auth.OIDC.OrgID,
+		OIDCRedirect:   cfg.Auth.OIDC.RedirectURL,
+
+		WebhookClient:   newWebhookClient(cfg.Webhooks.AllowPrivate),
+		PrivateWebhooks: cfg.Webhooks.AllowPrivate,
+	}
+	if cfg.Auth.TokenSecret != "" {
+		app.TokenSecret = []byte(cfg.Auth.TokenSecret)
+	}
+	if t := cfg.Tenancy; len(t.Dedicated) > 0 {
+		catalog := make(map[int]repository.TenantDB, len(t.Dedicated))
+		for org, db := range t.Dedicated {
+			catalog[org] = repository.TenantDB{DSN: db.DSN, Schema: db.Schema, MaxConns: int32(db.MaxConns)}
+		}
+		app.Tenants = repository.NewTenantPools(pool, catalog, t.MaxPools, t.PoolIdle)
+	}
+	// A replica for GET requests' reads (see repository/replica.go);
+	// its pool connects on first use, so a replica that is down now
+	// doesn't keep us from starting
+	if rc := cfg.DB.Replica; rc.DSN != "" {
+		replicaCfg, err := pgxpool.ParseConfig(rc.DSN)
+		if err != nil {
+			fatal("invalid replica config", "err", err)
+		}
+		replicaCfg.ConnConfig.Tracer = poolCfg.ConnConfig.Tracer
+		replicaCfg.MinConns = 0
+		replicaPool, err := pgxpool.NewWithConfig(ctx, replicaCfg)
+		if err != nil {
+			fatal("invalid replica config", "err", err)
+		}
+		app.Replica = repository.NewReplica(pool, replicaPool, rc.MaxLag)
+		app.Metrics.watchReplica(app.Replica)
+	}
+	app.OrgCache = cache.New[int, repository.Org]("orgs", cfg.Cache.Size, cfg.Cache.TTL)
+	app.Metrics.watchCaches(app.OrgCache)
+	if t := cfg.Tracing; t.Endpoint != "" {
+		app.Tracer = tracing.New(tracing.Config{
+			Endpoint:    t.Endpoint,
+			Headers:     t.Headers,
+			ServiceName: t.ServiceName,
+			SampleRatio: t.SampleRatio,
+		}, logger)
+	}
+	if len(cfg.Server.TrustedProxies) > 0 {
+		app.Proxies, _ = forwarded.ParseProxies(cfg.Server.TrustedProxies) // Validate has parsed them
+	}
+	if s := cfg.Auth.Sessions; s.Enabled {
+		app.Sessions = repository.NewPgxSessionRepository(pool)
+		app.SessionTTL = s.TTL
+		app.SessionSecure = s.CookieSecure
+	}
+	if rt := cfg.Auth.Refresh; rt.Enabled {
+		app.RefreshTokens = repository.NewPgxRefreshTokenRepository(pool)
+		app.RefreshTTL = rt.TTL
+	}
+	if o := cfg.Auth.OIDC; o.Issuer != "" {
+		app.OIDC = oidc.New(oidc.Config{
+			Issuer:       o.Issuer,
+			ClientID:     o.ClientID,
+			ClientSecret: o.ClientSecret,
+			RedirectURL:  o.RedirectURL,
+			Scopes:       o.Scopes,
+		}, httpclient.New(httpclient.Config{Name: "oidc", Timeout: oidcTimeout, Retries: 2}))
+		logger.Info("oidc login enabled", "issuer", o.Issuer, "org_id", o.OrgID)
+	}
+	app.GraphQL = newGraphQLSchema(app)
+
+	app.Jobs = jobs.New(pool)
+	app.Jobs.Workers = cfg.Jobs.Workers
+	app.Jobs.PollInterval = cfg.Jobs.PollInterval
+	app.Jobs.Timeout = cfg.Jobs.Timeout
+	app.Jobs.MaxAttempts = cfg.Jobs.MaxAttempts
+	app.Jobs.FairEvery = cfg.Jobs.FairEvery
+	app.Jobs.Log = logger
+	app.Jobs.Observer = app.Metrics
+	httpclient.SetObserver(app.Metrics) // webhooks, OIDC, the broker
+	app.Metrics.watchQueue(app.Jobs)
+	app.Jobs.Handle(reminderKind, app.sendReminder)
+	app.Jobs.Handle(webhookDeliveryKind, app.deliverWebhook)
+
+	app.Integrations, err = newIntegrations(cfg.Integrations, dedup.New(pool, webhookDedupTTL))
 	if err != nil {
-		log.Fatalf("Unable to connect to database: %v\n", err)
+		fatal("integrations", "err", err)
+	}
+	for _, src := range app.Integrations.sources() {
+		logger.Info("integration enabled", "source", src.name, "rules", len(src.rules))
+	}
+
+	if cfg.RateLimit.RPS > 0 {
+		// Keep idle buckets a minute past a full refill, then forget them
+		ttl := time.Duration(float64(cfg.RateLimit.Burst)/cfg.RateLimit.RPS*float64(time.Second)) + time.Minute
+		limiter := ratelimit.NewMemory(cfg.RateLimit.RPS, cfg.RateLimit.Burst, ttl)
+		go limiter.RunCleanup(ctx, time.Minute)
+		app.Limiter = limiter
+	}
+	if a := cfg.Abuse; a.Enabled {
+		app.Abuse = abuse.NewDetector(abuse.Config{
+			Window:       a.Window,
+			MinRequests:  a.MinRequests,
+			RateFactor:   a.RateFactor,
+			ErrorRate:    a.ErrorRate,
+			DistinctIDs:  a.DistinctIDs,
+			LearnWindows: a.LearnWindows,
+			Forget:       24 * time.Hour, // a day's silence, and the baseline starts over
+		})
+		go app.Abuse.RunCleanup(ctx, time.Hour)
+		app.Anomalies = repository.NewPgxAnomalyRepository(pool)
+		logger.Info("abuse detection enabled", "window", a.Window)
 	}
-	defer pool.Close()
 
-	app := &App{DB: pool}
+	if cfg.Server.OpenAPIValidation != validationOff {
+		app.Spec, err = newSpecValidator(cfg.Server.OpenAPIValidation, apiSpec)
+		if err != nil {
+			fatal("openapi validator", "err", err)
+		}
+		logger.Info("OpenAPI validation enabled", "mode", cfg.Server.OpenAPIValidation)
+	}
+	if app.AnonymousRole == authz.Admin {
+		logger.Warn("requests without credentials act as admins (auth.anonymous_role); not for a reachable server")
+	}
+
+	// Background jobs stop when ctx is cancelled; shutdown waits for them
+	// before closing the pool, so none is cut off mid-transaction by it
+	var background sync.WaitGroup
+	locks := dlock.New(pool)
+	app.Schedules = schedule.New(pool, locks)
+	app.Schedules.PollInterval = cfg.Scheduler.PollInterval
+	app.Schedules.CatchUpMax = cfg.Scheduler.CatchUpMax
+	app.Schedules.Log = logger
+	app.Schedules.Observer = app.Metrics
+	if cfg.Trash.PurgeInterval > 0 {
+		app.Schedules.Add(app.trashPurgeJob(cfg.Trash))
+	}
+	if cfg.Recurrence.Interval > 0 {
+		app.Schedules.Add(app.recurrenceJob(cfg.Recurrence))
+	}
+	if cfg.Escalation.Interval > 0 && len(cfg.Escalation.Rules) > 0 {
+		app.Schedules.Add(app.escalationJob(cfg.Escalation))
+	}
+	if cfg.Reminders.Interval > 0 {
+		app.Schedules.Add(app.remindersJob(cfg.Reminders))
+	}
+	if cfg.Auth.Sessions.Enabled && cfg.Auth.Sessions.PurgeInterval > 0 {
+		app.Schedules.Add(app.sessionPurgeJob(cfg.Auth.Sessions))
+	}
+	if cfg.Auth.Refresh.Enabled && cfg.Auth.Refresh.PurgeInterval > 0 {
+		app.Schedules.Add(app.refreshPurgeJob(cfg.Auth.Refresh))
+	}
+	if cfg.Abuse.Enabled && cfg.Abuse.PurgeInterval > 0 {
+		app.Schedules.Add(app.anomalyPurgeJob(cfg.Abuse))
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		if err := app.Schedules.Run(ctx); err != nil {
+			fatal("schedules", "err", err)
+		}
+	}()
+	if cfg.Broker.Type != "" {
+		pub, err := broker.New(cfg.Broker.Type, cfg.Broker.URL, cfg.Broker.Timeout)
+		if err != nil {
+			fatal("broker", "err", err)
+		}
+		app.Broker = pub
+		topic := broker.TasksTopic(cfg.Broker.TopicPrefix)
+		logger.Info("broker enabled", "type", cfg.Broker.Type, "topic", topic)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			app.runBrokerPublish(ctx, locks, pub, topic, cfg.Outbox.PollInterval)
+		}()
+	}
+	if app.Tenants != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			app.Tenants.Run(ctx)
+		}()
+	}
+	if app.Replica != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			app.Replica.Run(ctx, cfg.DB.Replica.CheckInterval)
+		}()
+	}
+	// Workers finish the job in hand (within jobs.timeout) before Run
+	// returns, so shutdown can take that long
+	background.Add(4)
+	go func() {
+		defer background.Done()
+		app.Jobs.Run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		app.runOutboxRelay(ctx, locks, cfg.Outbox.PollInterval, cfg.Outbox.Retention)
+	}()
+	go func() {
+		defer background.Done()
+		app.runOutboxFollow(ctx, cfg.Outbox.PollInterval)
+	}()
+	go func() {
+		defer background.Done()
+		app.Jobs.RunCleanup(ctx, time.Hour, cfg.Jobs.Retention, func(err error) {
+			logger.Warn("jobs cleanup", "err", err)
+		})
+	}()
+
+	// A conflicting registration fails here, before anything listens
+	handler, err := app.routes()
+	if err != nil {
+		fatal("routes", "err", err)
+	}
+
+	// Warm up in the background: the server answers /healthz right away,
+	// /readyz only once connections are open and statements prepared
+	go app.warmUpUntilReady(ctx, 2*time.Second)
+
+	// HTTPS, when configured. A key pair from files is loaded now, so a
+	// bad one stops startup, and re-read whenever it's renewed.
+	var tlsConfig *tls.Config
+	scheme := "http"
+	switch t := cfg.Server.TLS; {
+	case t.CertFile != "":
+		certs, err := tlscert.Load(t.CertFile, t.KeyFile, logger)
+		if err != nil {
+			fatal("tls", "err", err)
+		}
+		tlsConfig = certs.TLSConfig()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			certs.Watch(ctx, t.ReloadInterval)
+		}()
+	case len(t.Domains) > 0:
+		tlsConfig = tlscert.Autocert(t.Domains, t.CacheDir, t.Email)
+		logger.Info("TLS certificates from Let's Encrypt", "domains", t.Domains, "cache_dir", t.CacheDir)
+	}
+	if tlsConfig != nil {
+		scheme = "https"
+	}
 
 	// Start server
-	addr := ":8080"
-	fmt.Printf("🚀 Server starting on http://localhost%s\n", addr)
-	fmt.Println("   GET    /tasks       — list all tasks")
-	fmt.Println("   POST   /tasks       — create task")
-	fmt.Println("   GET    /tasks/{id}  — get task")
-	fmt.Println("   PUT    /tasks/{id}  — update task")
-	fmt.Println("   DELETE /tasks/{id}  — delete task")
-	fmt.Println("   GET    /health      — health check")
+	addr := cfg.Server.Addr
+	fmt.Printf("🚀 Server starting on %s://localhost%s\n", scheme, addr)
+	fmt.Println("   GET    /v1/tasks    — list tasks (?limit=&offset=)")
+	fmt.Println("   POST   /v1/tasks    — create task")
+	fmt.Println("   GET    /v1/tasks/events — task changes (Server-Sent Events)")
+	fmt.Println("   GET    /v1/tasks/search?q= — full-text search over titles")
+	fmt.Println("   GET    /v1/tasks/export.csv — tasks as CSV (GET /tasks filters)")
+	fmt.Println("   POST   /v1/tasks/import — create tasks from a CSV upload")
+	fmt.Println("   POST   /v1/tasks/reassign — move a user's open tasks to another user")
+	fmt.Println("   GET    /v1/tasks/{id} — get task")
+	fmt.Println("   PUT    /v1/tasks/{id} — update task")
+	fmt.Println("   PATCH  /v1/tasks/{id} — partial update (single statement)")
+	fmt.Println("   DELETE /v1/tasks/{id} — move task to the trash")
+	fmt.Println("   POST   /v1/tasks/{id}/restore — restore from the trash")
+	fmt.Println("   GET    /v1/tasks/{id}/audit — who changed the task, when, before and after")
+	fmt.Println("   GET    /v1/users    — list users (?limit=&offset=)")
+	fmt.Println("   POST   /v1/users    — create user")
+	fmt.Println("   GET    /v1/users/{id} — get user")
+	fmt.Println("   PUT    /v1/users/{id} — update user")
+	fmt.Println("   DELETE /v1/users/{id} — delete user")
+	fmt.Println("   POST   /v1/users/{id}/password — set a password (your own: with old_password)")
+	fmt.Println("   GET    /v1/escalations — escalation log (?task_id=&rule=)")
+	fmt.Println("   GET    /v1/webhooks — registered webhooks (?user_id=)")
+	fmt.Println("   POST   /v1/webhooks — register a webhook (the response has its secret)")
+	fmt.Println("   GET    /v1/webhooks/{id} — get webhook")
+	fmt.Println("   PUT    /v1/webhooks/{id} — change url/events, or pause (active: false)")
+	fmt.Println("   DELETE /v1/webhooks/{id} — delete webhook")
+	fmt.Println("   GET    /v1/webhooks/{id}/deliveries — delivery log (?status=)")
+	fmt.Println("   GET    /v1/apikeys  — API keys (?user_id=), without their secrets")
+	fmt.Println("   POST   /v1/apikeys  — mint an API key (the response has the key)")
+	fmt.Println("   POST   /v1/apikeys/{id}/revoke — revoke an API key")
+	fmt.Println("   (the same paths without /v1 still work, deprecated)")
+	fmt.Println("   (/v2: the same, with lists in {data, meta.pagination, links})")
+	fmt.Println("   (/v1 and /graphql act in one organization: X-Org-ID, or tenancy.default_org)")
+	fmt.Println("   (writes and /admin check the caller's role: admin, member or viewer)")
+	fmt.Println("   (services authenticate with Authorization: ApiKey <key>; its user's role and organization)")
+	if app.OIDC != nil {
+		fmt.Println("   GET    /auth/oidc/login — log in with the OIDC provider (then: Authorization: Bearer <token>)")
+		fmt.Println("   GET    /auth/oidc/callback — the provider's redirect back; answers with an access token")
+	}
+	if app.TokenSecret != nil {
+		fmt.Println("   POST   /auth/password — log in with email and password (X-Org-ID); answers with an access token")
+	}
+	if app.Sessions != nil {
+		fmt.Println("   POST   /auth/login  — access token → session cookie (writes then need X-CSRF-Token)")
+		fmt.Println("   GET    /auth/session — the cookie's session and CSRF token")
+		fmt.Println("   POST   /auth/logout — end the session")
+	}
+	if app.RefreshTokens != nil {
+		fmt.Println("   POST   /auth/refresh — refresh token → new access and refresh tokens (the old one is spent)")
+		fmt.Println("   POST   /auth/revoke — revoke a refresh token and its line (logout)")
+	}
+	fmt.Println("   POST   /graphql     — GraphQL (tasks, users, mutations)")
+	fmt.Println("   GET    /healthz     — liveness (process up)")
+	fmt.Println("   GET    /readyz      — readiness (DB warmed up and reachable, schema up to date)")
+	fmt.Println("   GET    /metrics     — Prometheus metrics")
+	fmt.Println("   GET    /internal/load — in-flight, queue depth, p95, DB wait (autoscalers)")
+	fmt.Println("   GET    /docs        — Swagger UI (spec at /openapi.json)")
+	fmt.Println("   GET    /schemas/task-event.json — JSON Schema of broker messages")
+	fmt.Println("   GET    /admin/routes — registered routes and their middleware")
+	fmt.Println("   GET    /admin/jobs  — background jobs (?status=dead: the dead letters)")
+	fmt.Println("   POST   /admin/jobs/{id}/retry — queue a dead job again")
+	fmt.Println("   GET    /admin/schedules — periodic jobs: next run, last outcome")
+	fmt.Println("   GET    /admin/selfcheck — config, database, schema, broker: pass/fail (also: -check)")
+	fmt.Println("   GET    /admin/audit — audit log (?entity=&entity_id=&org_id=&user_id=&from=&to=)")
+	fmt.Println("   GET    /admin/anomalies — unusual client traffic (?client=&kind=&org_id=&from=&to=)")
+	fmt.Println("   GET    /admin/orgs  — organizations")
+	fmt.Println("   POST   /admin/orgs  — create an organization")
+	fmt.Println("   GET    /admin/tenants — open pools of dedicated organizations")
+	fmt.Println("   PUT    /admin/users/{id}/role — make a user admin, member or viewer")
+
+	srv := newServer(addr, handler, cfg.Server, logger)
+	srv.TLSConfig = tlsConfig
+	// Event streams never finish on their own; ending the subscriptions
+	// lets Shutdown drain them instead of waiting for the timeout
+	srv.RegisterOnShutdown(app.Events.Close)
+
+	// ListenAndServe blocks, so run it in a goroutine and wait for
+	// either a startup error or a shutdown signal
+	serverErr := make(chan error, 3)
+	go func() {
+		if tlsConfig != nil {
+			serverErr <- srv.ListenAndServeTLS("", "") // certificates from TLSConfig
+			return
+		}
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	// gRPC on its own port, sharing the repository with the REST API
+	var grpcSrv *grpc.Server
+	if cfg.Server.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+		if err != nil {
+			fatal("grpc listen", "addr", cfg.Server.GRPCAddr, "err", err)
+		}
+		grpcSrv = newGRPCServer(app)
+		fmt.Printf("🚀 gRPC TaskService on %s\n", cfg.Server.GRPCAddr)
+		go func() {
+			serverErr <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	// pprof and expvar on loopback, apart from the API (see debug.go)
+	var debugSrv *http.Server
+	if cfg.Server.DebugAddr != "" {
+		lis, err := net.Listen("tcp", cfg.Server.DebugAddr)
+		if err != nil {
+			fatal("debug listen", "addr", cfg.Server.DebugAddr, "err", err)
+		}
+		publishVars(pool)
+		debugSrv = newDebugServer(cfg.Server.DebugAddr)
+		fmt.Printf("🔍 pprof and expvar on http://%s/debug/\n", cfg.Server.DebugAddr)
+		go func() {
+			serverErr <- debugSrv.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		pool.Close()
+		fatal("server failed", "err", err)
+	case <-ctx.Done():
+	}
+	stop() // a second Ctrl+C now kills the process immediately
 
-	log.Fatal(http.ListenAndServe(addr, app.routes()))
+	// Graceful shutdown:
+	//   1. report not-ready so the load balancer stops routing to us
+	//   2. stop accepting connections, wait for in-flight requests
+	//   3. wait for the background jobs (they saw ctx cancelled), then
+	//      the goroutines handlers and jobs started (App.Go)
+	//   4. only then close the DB pool those requests were using
+	slog.Info("shutting down, draining requests", "timeout", cfg.Server.ShutdownTimeout)
+	app.ready.Store(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if grpcSrv != nil {
+		go stopGRPC(shutdownCtx, grpcSrv)
+	}
+	if debugSrv != nil {
+		debugSrv.Close() // a profile being taken is cut short
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("drain incomplete, closing remaining connections", "err", err)
+		srv.Close()
+	}
+
+	background.Wait()
+	app.waitGoroutines(shutdownCtx) // after the jobs, which may start some too
+	if app.Tracer != nil {
+		if err := app.Tracer.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("traces not all sent", "err", err)
+		}
+	}
+	if app.Tenants != nil {
+		app.Tenants.Close()
+	}
+	if app.Replica != nil {
+		app.Replica.Pool().Close()
+	}
+	pool.Close()
+	slog.Info("server stopped")
+}
+
+// newServer — the API's server, with server.*'s timeouts and header cap
+// (the event stream and long poll extend theirs, see events.go)
+func newServer(addr string, handler http.Handler, cfg config.ServerConfig, logger *slog.Logger) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn), // TLS handshakes, bad requests
+	}
 }
 
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+func newLogger(format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, err
 	}
-	return fallback
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown LOG_FORMAT %q (want json or text)", format)
+	}
+}
+
+// fatal — slog has no Fatal, so log at error level and exit
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
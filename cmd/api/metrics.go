@@ -0,0 +1,445 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sandbox-go/internal/cache"
+	"sandbox-go/internal/httpclient"
+	"sandbox-go/internal/jobs"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// METRICS — Prometheus scrapes GET /metrics
+// -----------------------------------------------------------
+
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+
+	jobRuns           *prometheus.CounterVec
+	jobDuration       *prometheus.HistogramVec
+	jobWait           *prometheus.HistogramVec
+	schedulerRuns     *prometheus.CounterVec
+	schedulerDuration *prometheus.HistogramVec
+	schedulerLate     *prometheus.HistogramVec
+	schedulerMissed   *prometheus.CounterVec
+
+	brokerEvents *prometheus.CounterVec
+	brokerLag    prometheus.Gauge
+
+	outbound         *prometheus.CounterVec
+	outboundDuration *prometheus.HistogramVec
+
+	anomalies *prometheus.CounterVec
+
+	goroutines      *prometheus.GaugeVec
+	goroutinesEnded *prometheus.CounterVec
+}
+
+func newMetrics(pool *pgxpool.Pool) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP requests by route, method, status and variant (stable, or canary: see canary.go).",
+		}, []string{"route", "method", "status", "variant"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route, method, status and variant.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "status", "variant"}),
+
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "jobs_runs_total",
+			Help: "Background job runs by kind and outcome (done, retry, dead).",
+		}, []string{"kind", "outcome"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jobs_run_duration_seconds",
+			Help:    "Background job run time by kind.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9), // 10ms .. ~11m
+		}, []string{"kind"}),
+		jobWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "jobs_wait_seconds",
+			Help:    "How long background jobs were due before a worker took them, by kind.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms .. ~7h
+		}, []string{"kind"}),
+		schedulerRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduler_runs_total",
+			Help: "Scheduler ticks that did work on this instance, by scheduler and outcome (ok, error).",
+		}, []string{"scheduler", "outcome"}),
+		schedulerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "scheduler_run_duration_seconds",
+			Help:    "Time a scheduler tick took, by scheduler.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+		}, []string{"scheduler"}),
+		schedulerLate: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "scheduler_run_delay_seconds",
+			Help:    "How long after its slot a scheduled run started (jitter included), by scheduler.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 10), // 100ms .. ~7h
+		}, []string{"scheduler"}),
+		schedulerMissed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "scheduler_missed_runs_total",
+			Help: "Scheduled runs dropped by a misfire policy, by scheduler and policy (skip, run-once, catch-up).",
+		}, []string{"scheduler", "policy"}),
+
+		brokerEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "broker_events_total",
+			Help: "Task events sent to the message broker by outcome (published, failed; a failed one is sent again).",
+		}, []string{"outcome"}),
+		brokerLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "broker_lag_events",
+			Help: "Relayed task events not yet published to the message broker, as of this instance's last publish.",
+		}),
+
+		outbound: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_requests_total",
+			Help: "Outbound HTTP attempts by client (webhooks, oidc, ...), method and status (\"error\" = no response); retries count again.",
+		}, []string{"client", "method", "status"}),
+		outboundDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "Outbound HTTP attempt latency by client and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"client", "method"}),
+
+		anomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "anomalies_total",
+			Help: "Clients found doing what they don't usually do, by kind (rate_spike, error_spike, new_endpoint, enumeration; see internal/abuse).",
+		}, []string{"kind"}),
+
+		goroutines: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "tracked_goroutines",
+			Help: "Goroutines started with App.Go and still running, by name.",
+		}, []string{"name"}),
+		goroutinesEnded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tracked_goroutines_total",
+			Help: "Goroutines of App.Go by name and outcome (ok, panic; dropped = not started, shutting down).",
+		}, []string{"name", "outcome"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		m.jobRuns,
+		m.jobDuration,
+		m.jobWait,
+		m.schedulerRuns,
+		m.schedulerDuration,
+		m.schedulerLate,
+		m.schedulerMissed,
+		m.brokerEvents,
+		m.brokerLag,
+		m.outbound,
+		m.outboundDuration,
+		m.anomalies,
+		m.goroutines,
+		m.goroutinesEnded,
+		newPoolCollector(pool),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+func (m *Metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// instrument records a counter and latency histogram for every request
+// served by next. route resolves the label — the registered template
+// ("/tasks/{id}", not "/tasks/42"), so task IDs don't each mint a time
+// series; unknown paths all share "unmatched". The variant label
+// splits routes with a canary.
+func (m *Metrics) instrument(route func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		label := route(r)
+		if label == "" {
+			label = "unmatched"
+		}
+		labels := prometheus.Labels{
+			"route":   label,
+			"method":  r.Method,
+			"status":  strconv.Itoa(rec.status),
+			"variant": variantOf(r),
+		}
+		m.requests.With(labels).Inc()
+		m.latency.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// -----------------------------------------------------------
+// POOL COLLECTOR — reads pool.Stat() on every scrape, so the
+// numbers are always current and nothing runs between scrapes.
+// -----------------------------------------------------------
+
+type poolCollector struct {
+	pool *pgxpool.Pool
+
+	acquired      *prometheus.Desc
+	idle          *prometheus.Desc
+	total         *prometheus.Desc
+	max           *prometheus.Desc
+	acquires      *prometheus.Desc
+	emptyAcquires *prometheus.Desc
+	acquireWait   *prometheus.Desc
+}
+
+func newPoolCollector(pool *pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("pgxpool_"+name, help, nil, nil)
+	}
+	return &poolCollector{
+		pool:          pool,
+		acquired:      desc("acquired_conns", "Connections currently checked out of the pool."),
+		idle:          desc("idle_conns", "Idle connections in the pool."),
+		total:         desc("total_conns", "Total connections in the pool."),
+		max:           desc("max_conns", "Maximum pool size."),
+		acquires:      desc("acquires_total", "Successful connection acquires."),
+		emptyAcquires: desc("empty_acquires_total", "Acquires that had to wait because the pool was empty."),
+		acquireWait:   desc("acquire_wait_seconds_total", "Total time spent waiting to acquire a connection."),
+	}
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireWait, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
+
+// -----------------------------------------------------------
+// JOB METRICS — the job queue (Metrics is its jobs.Observer)
+// and the schedulers (and schedule.Observer), next to the HTTP
+// ones. Queue depth and
+// the age of the oldest due job come from the jobs table on
+// every scrape, so they are the same on every instance.
+// -----------------------------------------------------------
+
+// ObserveRun — see jobs.Observer
+func (m *Metrics) ObserveRun(run jobs.RunInfo) {
+	m.jobRuns.WithLabelValues(run.Job.Kind, run.Outcome).Inc()
+	m.jobDuration.WithLabelValues(run.Job.Kind).Observe(run.Duration.Seconds())
+	m.jobWait.WithLabelValues(run.Job.Kind).Observe(run.Wait.Seconds())
+}
+
+// ObserveSchedule — see schedule.Observer
+func (m *Metrics) ObserveSchedule(run schedule.RunInfo) {
+	m.observeScheduler(run.Name, run.Start, run.Err)
+	m.schedulerLate.WithLabelValues(run.Name).Observe(run.Late.Seconds())
+}
+
+// ObserveMisfire — see schedule.Observer
+func (m *Metrics) ObserveMisfire(name, policy string, missed int) {
+	m.schedulerMissed.WithLabelValues(name, policy).Add(float64(missed))
+}
+
+// observeScheduler records one tick of a scheduler that started at
+// start; err is its first failure (nil = ok)
+func (m *Metrics) observeScheduler(name string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	m.schedulerRuns.WithLabelValues(name, outcome).Inc()
+	m.schedulerDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRequest — see httpclient.Observer. The host isn't a label:
+// webhook receivers are whatever users register.
+func (m *Metrics) ObserveRequest(a httpclient.Attempt) {
+	status := "error"
+	if a.Err == nil {
+		status = strconv.Itoa(a.Status)
+	}
+	m.outbound.WithLabelValues(a.Client, a.Method, status).Inc()
+	m.outboundDuration.WithLabelValues(a.Client, a.Method).Observe(a.Duration.Seconds())
+}
+
+// observeBroker records one publish of n events
+func (m *Metrics) observeBroker(n int, err error) {
+	outcome := "published"
+	if err != nil {
+		outcome = "failed"
+	}
+	m.brokerEvents.WithLabelValues(outcome).Add(float64(n))
+}
+
+// watchQueue adds the queue's gauges to /metrics
+func (m *Metrics) goroutineStarted(name string) {
+	m.goroutines.WithLabelValues(name).Inc()
+}
+
+// goroutineEnded — outcome ok or panic; dropped for one never started
+func (m *Metrics) goroutineEnded(name, outcome string) {
+	if outcome != "dropped" {
+		m.goroutines.WithLabelValues(name).Dec()
+	}
+	m.goroutinesEnded.WithLabelValues(name, outcome).Inc()
+}
+
+func (m *Metrics) watchQueue(q *jobs.Queue) {
+	m.registry.MustRegister(newQueueCollector(q))
+}
+
+// watchCaches adds the caches' hit and size counts to /metrics
+func (m *Metrics) watchCaches(caches ...interface{ Stats() cache.Stats }) {
+	m.registry.MustRegister(newCacheCollector(caches))
+}
+
+type cacheCollector struct {
+	caches []interface{ Stats() cache.Stats }
+
+	hits    *prometheus.Desc
+	misses  *prometheus.Desc
+	loads   *prometheus.Desc
+	errors  *prometheus.Desc
+	evicted *prometheus.Desc
+	entries *prometheus.Desc
+}
+
+func newCacheCollector(caches []interface{ Stats() cache.Stats }) *cacheCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("cache_"+name, help, []string{"cache"}, nil)
+	}
+	return &cacheCollector{
+		caches:  caches,
+		hits:    desc("hits_total", "Lookups answered from the in-memory cache."),
+		misses:  desc("misses_total", "Lookups that had to load, or wait for a load."),
+		loads:   desc("loads_total", "Loads from the database; fewer than misses when concurrent ones were shared."),
+		errors:  desc("load_errors_total", "Loads that failed."),
+		evicted: desc("evictions_total", "Entries pushed out to make room."),
+		entries: desc("entries", "Entries in the cache."),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, cc := range c.caches {
+		s := cc.Stats()
+		ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(s.Hits), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(s.Misses), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.loads, prometheus.CounterValue, float64(s.Loads), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(s.Errors), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(s.Evicted), s.Name)
+		ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Entries), s.Name)
+	}
+}
+
+// watchReplica adds the health gauges of the primary and the replica
+// to /metrics, as of the replica's last check
+func (m *Metrics) watchReplica(r *repository.Replica) {
+	m.registry.MustRegister(newReplicaCollector(r))
+}
+
+type replicaCollector struct {
+	replica *repository.Replica
+
+	up        *prometheus.Desc
+	lag       *prometheus.Desc
+	fallbacks *prometheus.Desc
+	conns     *prometheus.Desc
+}
+
+func newReplicaCollector(r *repository.Replica) *replicaCollector {
+	return &replicaCollector{
+		replica:   r,
+		up:        prometheus.NewDesc("db_pool_up", "1 if the pool's database answered the last check; the replica only if within max_lag too.", []string{"pool"}, nil),
+		lag:       prometheus.NewDesc("db_replica_lag_seconds", "How far the replica was behind at the last check.", nil, nil),
+		fallbacks: prometheus.NewDesc("db_replica_fallbacks_total", "Reads run again on the primary because the replica couldn't be reached.", nil, nil),
+		conns:     prometheus.NewDesc("db_replica_total_conns", "Connections in the replica's pool.", nil, nil),
+	}
+}
+
+func (c *replicaCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c *replicaCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.replica.Status()
+	up := func(b bool) float64 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up(s.PrimaryUp), "primary")
+	ch <- prometheus.MustNewConstMetric(c.up, prometheus.GaugeValue, up(s.Up), "replica")
+	ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, s.Lag.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.fallbacks, prometheus.CounterValue, float64(s.Fallbacks))
+	ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(c.replica.Pool().Stat().TotalConns()))
+}
+
+// queueStatsTimeout — a scrape doesn't wait longer for the jobs table;
+// the gauges are left out of that scrape instead
+const queueStatsTimeout = 2 * time.Second
+
+type queueCollector struct {
+	queue *jobs.Queue
+
+	queued    *prometheus.Desc
+	running   *prometheus.Desc
+	dead      *prometheus.Desc
+	oldestDue *prometheus.Desc
+}
+
+func newQueueCollector(q *jobs.Queue) *queueCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc("jobs_"+name, help, []string{"kind"}, nil)
+	}
+	return &queueCollector{
+		queue:     q,
+		queued:    desc("queued", "Queued background jobs (due or not) by kind."),
+		running:   desc("running", "Background jobs leased by a worker, by kind."),
+		dead:      desc("dead", "Dead background jobs waiting for a retry, by kind."),
+		oldestDue: desc("oldest_due_seconds", "How long the longest-waiting due job has waited, by kind."),
+	}
+}
+
+// Describe lists the descriptors itself: DescribeByCollect would query
+// the database at registration
+func (c *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.queued
+	ch <- c.running
+	ch <- c.dead
+	ch <- c.oldestDue
+}
+
+func (c *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queueStatsTimeout)
+	defer cancel()
+	stats, err := c.queue.Stats(ctx)
+	if err != nil {
+		return // the rest of /metrics still works while the DB is away
+	}
+	for _, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.queued, prometheus.GaugeValue, float64(s.Queued), s.Kind)
+		ch <- prometheus.MustNewConstMetric(c.running, prometheus.GaugeValue, float64(s.Running), s.Kind)
+		ch <- prometheus.MustNewConstMetric(c.dead, prometheus.GaugeValue, float64(s.Dead), s.Kind)
+		ch <- prometheus.MustNewConstMetric(c.oldestDue, prometheus.GaugeValue, s.OldestDue.Seconds(), s.Kind)
+	}
+}
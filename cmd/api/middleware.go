@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/forwarded"
+	"sandbox-go/internal/ratelimit"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/tracing"
+)
+
+// -----------------------------------------------------------
+// MIDDLEWARE — func(http.Handler) http.Handler
+// Same idea as PSR-15 middleware in PHP: wrap the next handler,
+// do something before and/or after it runs.
+// -----------------------------------------------------------
+
+// Values go into the request context through internal/requestctx:
+// the request ID and logger set here, anything else with a typed key
+// declared next to the code that uses it.
+
+// requestIDHeader — set by clients/proxies, echoed back on every response
+const requestIDHeader = "X-Request-ID"
+
+// statusRecorder remembers the status code the handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+// (Flush for event streams, deadlines, ...)
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// newRequestID — 16 random hex chars, enough to grep logs by
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID — only accept short, printable IDs from the outside so
+// a client can't inject newlines or huge values into our logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID honors an incoming X-Request-ID (e.g. from the frontend or a
+// proxy) or generates one, stores it in the context and echoes it in
+// the response headers — writeError also copies it into error bodies.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(requestctx.WithRequestID(r.Context(), id))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCtx — where the request comes from (see clientInfo)
+var clientCtx = requestctx.NewKey[forwarded.Client]("client")
+
+// clientInfo works out the client's IP and scheme, believing the
+// forwarding headers of server.trusted_proxies only (internal/forwarded).
+// Logging, rate limiting and the audit log all take the IP from here;
+// RemoteAddr stays the peer's.
+func (app *App) clientInfo(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := app.Proxies.Client(r)
+		ctx := clientCtx.With(r.Context(), c)
+		next.ServeHTTP(w, r.WithContext(requestctx.WithClientIP(ctx, c.IP)))
+	})
+}
+
+// logRequests puts a per-request logger into the context and writes one
+// log line per request once it completes. Runs inside requestID.
+func (app *App) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		logger := app.Log.With("request_id", requestctx.RequestID(r.Context()), "client_ip", requestctx.ClientIP(r.Context()))
+		if span := tracing.FromContext(r.Context()); span != nil {
+			logger = logger.With("trace_id", span.Context().TraceID.String())
+		}
+		r = r.WithContext(requestctx.WithLogger(r.Context(), logger))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"latency", time.Since(start),
+		}
+		if c, ok := clientCtx.Get(r.Context()); ok {
+			attrs = append(attrs, "scheme", c.Scheme)
+		}
+		if t := timingsFrom(r.Context()); t != nil {
+			attrs = append(attrs, t.logAttr())
+		}
+		if v := variantOf(r); v != variantStable {
+			attrs = append(attrs, "variant", v)
+		}
+		logger.Info("request", attrs...)
+	})
+}
+
+// longLived — streams that stay open by design, exempt from the
+// request timeout (the long poll caps its own wait)
+var longLived = map[string]bool{"/tasks/events": true, "/tasks/events/poll": true}
+
+// withTimeout puts a deadline on the request context. Everything below
+// — handlers, repository calls, pgx — gives up once it passes, and
+// writeError answers 504 TIMEOUT instead of hanging the connection.
+func (app *App) withTimeout(next http.Handler) http.Handler {
+	if app.RequestTimeout <= 0 {
+		return next // disabled (REQUEST_TIMEOUT=0)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := requestctx.WithTimeout(r.Context(), app.RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ownBodyLimit — routes that cap their bodies themselves, above or
+// below server.max_body_bytes
+var ownBodyLimit = map[string]bool{
+	"/tasks/import":                  true, // limitBody(maxImportBytes)
+	"/integrations/github":           true, // maxWebhookBody
+	"/integrations/inbound/{source}": true,
+}
+
+// limitBodies caps every request body at server.max_body_bytes, before
+// anything reads it (jsonCase, validateSpec, decodeJSON): more fails
+// with 413 PAYLOAD_TOO_LARGE, and no one buffers the rest
+func (app *App) limitBodies(next http.Handler) http.Handler {
+	if app.MaxBodyBytes <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, app.MaxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitBody is per-route middleware: reading more than n bytes of the
+// body fails with *http.MaxBytesError, which the handler's decoding
+// reports as 413
+func limitBody(n int64) middleware {
+	return middleware{name: "limitBody", wrap: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
+
+// -----------------------------------------------------------
+// RATE LIMITING — token bucket per client (internal/ratelimit)
+// -----------------------------------------------------------
+
+// infraPaths are never rate limited: probes and scrapers poll them
+// constantly and must keep working when a client is being throttled
+var infraPaths = map[string]bool{"/health": true, "/healthz": true, "/readyz": true, "/metrics": true, "/internal/load": true}
+
+// clientKey identifies who is calling: the API key or user it
+// authenticated as (see authn.go), otherwise the client IP (see
+// clientInfo).
+func clientKey(r *http.Request) string {
+	if p, ok := principalCtx.Get(r.Context()); ok {
+		if p.Key != nil {
+			return "key:" + strconv.Itoa(p.Key.ID)
+		}
+		return "user:" + strconv.Itoa(p.UserID)
+	}
+	return "ip:" + requestctx.ClientIP(r.Context())
+}
+
+// rateLimit rejects clients that exhausted their bucket with 429 and a
+// Retry-After header (whole seconds, rounded up).
+func (app *App) rateLimit(next http.Handler) http.Handler {
+	if app.Limiter == nil {
+		return next // disabled (RATE_LIMIT_RPS=0)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			d   ratelimit.Decision
+			err error
+		)
+		if p, _ := principalCtx.Get(r.Context()); p.Key != nil && p.Key.RateLimitRPS != nil && p.Key.RateLimitBurst != nil {
+			d, err = app.Limiter.AllowLimit(r.Context(), clientKey(r), ratelimit.Limit{Rate: *p.Key.RateLimitRPS, Burst: *p.Key.RateLimitBurst})
+		} else {
+			d, err = app.Limiter.Allow(r.Context(), clientKey(r))
+		}
+		if err != nil {
+			// Fail open: a broken limiter backend shouldn't take the API down
+			requestctx.Logger(r.Context()).Error("rate limiter", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		if !d.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.RetryAfter.Seconds()))))
+			writeError(w, r, apperr.New(apperr.RateLimited, "too many requests, retry after the Retry-After delay"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/oidc"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// OIDC LOGIN — logging in with an OpenID Connect provider
+// (auth.oidc), for teams that won't have passwords stored here:
+//   GET /auth/oidc/login    — 302 to the provider's login page;
+//                             ?session=true for a session cookie
+//                             at the end (sessions.go)
+//   GET /auth/oidc/callback — where it sends the browser back;
+//                             answers with one of our access
+//                             tokens (authn.go), or the session
+// The account at the provider (its issuer and subject) is linked
+// to a user here on the first login: the user with its email if
+// the provider has verified it, otherwise a new member of
+// auth.oidc.org_id. Later logins find the user by the link alone.
+// -----------------------------------------------------------
+
+const (
+	oidcCookie = "oidc_login"  // state, nonce, PKCE verifier and mode, until the callback
+	oidcPath   = "/auth/oidc/" // the cookie goes to the callback only
+	oidcMaxAge = 10 * time.Minute
+	// oidcTimeout — for each request to the provider
+	oidcTimeout = 10 * time.Second
+	maxOIDCName = 100 // users.name VARCHAR(100)
+)
+
+// TokenResponse — a login's response: send access_token as
+// Authorization: Bearer <token> until it expires, then refresh_token
+// (if any) to POST /auth/refresh for another
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"` // always Bearer
+	ExpiresIn    int    `json:"expires_in"` // seconds
+	RefreshToken string `json:"refresh_token,omitempty"`
+	User         User   `json:"user"`
+}
+
+// GET /auth/oidc/login
+func (app *App) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	mode := "token"
+	if s := r.URL.Query().Get("session"); s != "" {
+		session, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("session %q must be true or false", s)))
+			return
+		}
+		if session && app.Sessions == nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, "session=true: cookie sessions are off (auth.sessions)"))
+			return
+		}
+		if session {
+			mode = "session"
+		}
+	}
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+	target, err := app.OIDC.AuthURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		writeError(w, r, apperr.Wrap(apperr.ProviderUnavailable, "the identity provider can't be reached", err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    state + "." + nonce + "." + verifier + "." + mode,
+		Path:     oidcPath,
+		MaxAge:   int(oidcMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.OIDCRedirect, "https://"),
+		SameSite: http.SameSiteLaxMode, // the provider's redirect back is a top-level GET
+	})
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// GET /auth/oidc/callback — ?code=&state=, or ?error= if the login
+// didn't happen
+func (app *App) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	// One callback per login, whatever comes of it
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: oidcPath, MaxAge: -1})
+	w.Header().Set("Cache-Control", "no-store")
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		msg := "the identity provider refused the login: " + e
+		if d := q.Get("error_description"); d != "" {
+			msg += ": " + d
+		}
+		writeError(w, r, apperr.New(apperr.LoginFailed, msg))
+		return
+	}
+	var state, nonce, verifier, mode string
+	if c, err := r.Cookie(oidcCookie); err == nil {
+		parts := strings.Split(c.Value, ".")
+		if len(parts) == 4 {
+			state, nonce, verifier, mode = parts[0], parts[1], parts[2], parts[3]
+		}
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+		writeError(w, r, apperr.New(apperr.LoginFailed, "no login in progress in this browser, or it expired; start again at /auth/oidc/login"))
+		return
+	}
+	code := q.Get("code")
+	if code == "" {
+		writeError(w, r, apperr.New(apperr.LoginFailed, "the callback has no code"))
+		return
+	}
+
+	raw, err := app.OIDC.Exchange(r.Context(), code, verifier)
+	if err != nil {
+		writeError(w, r, apperr.Wrap(apperr.LoginFailed, "the identity provider didn't accept the login", err))
+		return
+	}
+	id, err := app.OIDC.Verify(r.Context(), raw, nonce)
+	if err != nil {
+		requestctx.Logger(r.Context()).Warn("oidc id token rejected", "err", err)
+		writeError(w, r, apperr.Wrap(apperr.LoginFailed, "the identity provider's ID token doesn't check out", err))
+		return
+	}
+
+	var user User
+	err = app.inTx(requestctx.WithOrgID(r.Context(), app.OIDCOrg), func(ctx context.Context) error {
+		user, err = app.oidcUser(ctx, id)
+		return err
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	requestctx.Logger(r.Context()).Info("oidc login", "user_id", user.ID, "issuer", id.Issuer)
+	if mode == "session" && app.Sessions != nil {
+		app.startSession(w, r, user)
+		return
+	}
+	resp, err := app.tokenResponse(r.Context(), user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// oidcUser — the user id has logged in as before, or the one it links
+// to now
+func (app *App) oidcUser(ctx context.Context, id oidc.Identity) (User, error) {
+	userID, err := app.Identities.UserOf(ctx, id.Issuer, id.Subject)
+	if err == nil {
+		return app.Users.Get(ctx, userID)
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return User{}, err
+	}
+
+	if id.Email == "" {
+		return User{}, apperr.New(apperr.LoginFailed, "the identity provider sent no email; add the email scope to auth.oidc.scopes")
+	}
+	// An unverified email could be anyone's: it may not take over the
+	// user who has it
+	user, err := app.Users.GetByEmail(ctx, id.Email)
+	switch {
+	case err == nil && !id.EmailVerified:
+		return User{}, apperr.New(apperr.LoginFailed, fmt.Sprintf("%s is taken, and the identity provider hasn't verified it", id.Email))
+	case errors.Is(err, repository.ErrNotFound):
+		name := id.Name
+		if name == "" {
+			name, _, _ = strings.Cut(id.Email, "@")
+		}
+		if utf8.RuneCountInString(name) > maxOIDCName { // characters, like VARCHAR
+			name = string([]rune(name)[:maxOIDCName])
+		}
+		user, err = app.Users.Create(ctx, repository.NewUser{Name: name, Email: id.Email})
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if err := app.Identities.Link(ctx, id.Issuer, id.Subject, user.ID); err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,550 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/events"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// OPENAPI — GET /openapi.json (spec) and GET /docs (Swagger UI)
+// The spec is generated at startup: operations are listed below,
+// request/response schemas are reflected from the Go structs'
+// json tags, so renaming a field updates the docs automatically.
+// -----------------------------------------------------------
+
+// docsPage loads Swagger UI from a CDN and points it at /openapi.json
+//
+//go:embed static/docs.html
+var docsPage []byte
+
+type operation struct {
+	Method   string
+	Path     string // OpenAPI style: /tasks/{id}
+	Summary  string
+	Request  any // zero value of the body type, nil = no body, csvFile{} = CSV upload
+	Response any // zero value of the 2xx body type, nil = no body, eventStream{} = SSE, csvFile{} = CSV
+	Status   int // success status
+}
+
+// apiOperations — keep in sync with routes(); paths are unversioned
+// (buildOpenAPI adds /v1 and /v2, see versionedResources)
+var apiOperations = []operation{
+	{"GET", "/tasks", "List all tasks", nil, []Task{}, http.StatusOK},
+	{"POST", "/tasks", "Create a task", CreateTaskRequest{}, Task{}, http.StatusCreated},
+	{"GET", "/tasks/events", "Stream task changes (Server-Sent Events, supports Last-Event-ID)", nil, eventStream{}, http.StatusOK},
+	{"GET", "/tasks/events/poll", "Wait for task changes after a cursor (long polling)", nil, EventPage{}, http.StatusOK},
+	{"GET", "/tasks/search", "Search task titles, best matches first", nil, []repository.SearchResult{}, http.StatusOK},
+	{"GET", "/tasks/export.csv", "Export tasks as CSV (same filters as GET /tasks, not paginated)", nil, csvFile{}, http.StatusOK},
+	{"POST", "/tasks/import", "Create tasks from CSV (text/csv, or multipart field \"file\"); admins only", csvFile{}, ImportResult{}, http.StatusOK},
+	{"POST", "/tasks/reassign", "Move a user's open tasks to another user (optionally only those with a tag), in batches; admins only", ReassignRequest{}, ReassignResult{}, http.StatusOK},
+	{"GET", "/tasks/{id}", "Get a task", nil, Task{}, http.StatusOK},
+	{"PUT", "/tasks/{id}", "Update a task", UpdateTaskRequest{}, Task{}, http.StatusOK},
+	{"PATCH", "/tasks/{id}", "Partially update a task (at least one field)", UpdateTaskRequest{}, Task{}, http.StatusOK},
+	{"DELETE", "/tasks/{id}", "Move a task to the trash", nil, nil, http.StatusNoContent},
+	{"POST", "/tasks/{id}/restore", "Restore a task from the trash", nil, Task{}, http.StatusOK},
+	{"POST", "/tasks/{id}/tags", "Add tags to a task", TagsRequest{}, Task{}, http.StatusOK},
+	{"DELETE", "/tasks/{id}/tags/{tag}", "Remove a tag from a task", nil, Task{}, http.StatusOK},
+	{"GET", "/tasks/{id}/subtasks", "List a task's subtasks (?tree=true: the whole subtree, nested)", nil, []TaskNode{}, http.StatusOK},
+	{"GET", "/tasks/{id}/audit", "A task's audit log, newest first: each write with the task before and after", nil, []repository.AuditEntry{}, http.StatusOK},
+	{"POST", "/integrations/github", "GitHub webhook (X-Hub-Signature-256 required)", webhookPayload{}, WebhookResult{}, http.StatusOK},
+	{"POST", "/integrations/inbound/{source}", "Signed webhook from a configured integration", webhookPayload{}, WebhookResult{}, http.StatusOK},
+	{"GET", "/users", "List all users", nil, []User{}, http.StatusOK},
+	{"POST", "/users", "Create a user; admins only", CreateUserRequest{}, User{}, http.StatusCreated},
+	{"GET", "/users/{id}", "Get a user", nil, User{}, http.StatusOK},
+	{"PUT", "/users/{id}", "Update a user; admins only", UpdateUserRequest{}, User{}, http.StatusOK},
+	{"DELETE", "/users/{id}", "Delete a user and their tasks; admins only", nil, nil, http.StatusNoContent},
+	{"POST", "/users/{id}/password", "Set a user's password: your own with old_password, anyone's as an admin", ChangePasswordRequest{}, nil, http.StatusNoContent},
+	{"GET", "/escalations", "List what the escalation rules did, newest first", nil, []repository.Escalation{}, http.StatusOK},
+	{"GET", "/webhooks", "List registered webhooks (secrets omitted)", nil, []repository.Webhook{}, http.StatusOK},
+	{"POST", "/webhooks", "Register a webhook; the response is the only one with its signing secret", CreateWebhookRequest{}, repository.Webhook{}, http.StatusCreated},
+	{"GET", "/webhooks/{id}", "Get a webhook", nil, repository.Webhook{}, http.StatusOK},
+	{"PUT", "/webhooks/{id}", "Update a webhook (active: false pauses deliveries)", UpdateWebhookRequest{}, repository.Webhook{}, http.StatusOK},
+	{"DELETE", "/webhooks/{id}", "Delete a webhook and its delivery log", nil, nil, http.StatusNoContent},
+	{"GET", "/webhooks/{id}/deliveries", "A webhook's delivery log, newest first", nil, []repository.Delivery{}, http.StatusOK},
+	{"GET", "/apikeys", "List API keys, revoked ones included (secrets never shown)", nil, []repository.APIKey{}, http.StatusOK},
+	{"POST", "/apikeys", "Mint an API key; the response is the only one with the key", CreateAPIKeyRequest{}, MintedAPIKey{}, http.StatusCreated},
+	{"POST", "/apikeys/{id}/revoke", "Revoke an API key for good", nil, repository.APIKey{}, http.StatusOK},
+	{"GET", "/auth/oidc/login", "Log in with the OpenID Connect provider: redirects to its login page (?session=true: end with a session)", nil, nil, http.StatusFound},
+	{"GET", "/auth/oidc/callback", "Where the provider sends the browser back; answers with an access token", nil, TokenResponse{}, http.StatusOK},
+	{"POST", "/auth/password", "Log in with an email and password; answers with an access token (429 ACCOUNT_LOCKED after too many wrong ones)", PasswordLoginRequest{}, TokenResponse{}, http.StatusOK},
+	{"POST", "/auth/login", "Trade a Bearer access token for a session cookie", nil, SessionResponse{}, http.StatusOK},
+	{"GET", "/auth/session", "The session of this cookie, with its CSRF token", nil, SessionResponse{}, http.StatusOK},
+	{"POST", "/auth/logout", "End the session and clear its cookie", nil, nil, http.StatusNoContent},
+	{"POST", "/auth/refresh", "Trade a refresh token for a new access token and refresh token; the one sent is spent", RefreshRequest{}, TokenResponse{}, http.StatusOK},
+	{"POST", "/auth/revoke", "Revoke a refresh token, and every one since its login", RefreshRequest{}, nil, http.StatusNoContent},
+}
+
+// apiSpec — built once; served at /openapi.json and used by the
+// optional runtime validator
+var apiSpec = buildOpenAPI(apiOperations)
+
+// buildOpenAPI assembles the OpenAPI 3 document as plain maps —
+// encoding/json turns it into the spec.
+func buildOpenAPI(ops []operation) map[string]any {
+	g := &schemaGen{schemas: map[string]any{}}
+	problemRef := g.schemaFor(reflect.TypeOf(Problem{}))
+	paths := map[string]map[string]any{}
+
+	for _, op := range ops {
+		success := map[string]any{"description": http.StatusText(op.Status)}
+		switch op.Response.(type) {
+		case nil:
+		case eventStream:
+			// each data: line is one events.Event as JSON
+			success["content"] = map[string]any{"text/event-stream": map[string]any{
+				"schema": g.schemaFor(reflect.TypeOf(events.Event{})),
+			}}
+		case csvFile:
+			success["content"] = map[string]any{"text/csv": map[string]any{
+				"schema": map[string]any{"type": "string", "description": "header row: " + strings.Join(csvColumns, ",")},
+			}}
+		default:
+			success["content"] = jsonContent(g.schemaFor(reflect.TypeOf(op.Response)))
+		}
+		errorResponse := map[string]any{
+			"description": "Error (see code for the machine-readable reason)",
+			"content":     map[string]any{"application/problem+json": map[string]any{"schema": problemRef}},
+		}
+
+		o := map[string]any{
+			"summary":     op.Summary,
+			"operationId": operationID(op),
+			"tags":        []string{strings.Split(strings.Trim(op.Path, "/"), "/")[0]},
+			"responses": map[string]any{
+				strconv.Itoa(op.Status): success,
+				"default":               errorResponse,
+			},
+		}
+		if strings.Contains(op.Path, "{id}") {
+			o["parameters"] = []any{map[string]any{
+				"name": "id", "in": "path", "required": true,
+				"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": validate.MaxID},
+			}}
+		}
+		if strings.Contains(op.Path, "{source}") {
+			params, _ := o["parameters"].([]any)
+			o["parameters"] = append(params, map[string]any{
+				"name": "source", "in": "path", "required": true,
+				"description": "a key of integrations.inbound in the config",
+				"schema":      map[string]any{"type": "string"},
+			})
+		}
+		if strings.Contains(op.Path, "{tag}") {
+			params, _ := o["parameters"].([]any)
+			o["parameters"] = append(params, map[string]any{
+				"name": "tag", "in": "path", "required": true,
+				"schema": map[string]any{"type": "string", "maxLength": maxTagLen},
+			})
+		}
+		if isList(op) {
+			o["parameters"] = append(pageParameters, queryParameters[op.Method+" "+op.Path]...)
+			success["headers"] = pageHeaders
+		} else if query, ok := queryParameters[op.Method+" "+op.Path]; ok {
+			params, _ := o["parameters"].([]any)
+			o["parameters"] = append(params, query...)
+		}
+		if reflect.TypeOf(op.Response) == reflect.TypeOf(Task{}) {
+			success["headers"] = etagHeaders
+		}
+		if header, ok := conditionalHeaders[op.Method+" "+op.Path]; ok {
+			params, _ := o["parameters"].([]any)
+			o["parameters"] = append(params, header)
+			if op.Method == "GET" {
+				o["responses"].(map[string]any)["304"] = map[string]any{"description": "Not modified (If-None-Match matched)", "headers": etagHeaders}
+			}
+		}
+		switch op.Request.(type) {
+		case nil:
+		case csvFile:
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"text/csv": map[string]any{"schema": map[string]any{"type": "string"}},
+					"multipart/form-data": map[string]any{"schema": map[string]any{
+						"type":       "object",
+						"required":   []string{csvUploadField},
+						"properties": map[string]any{csvUploadField: map[string]any{"type": "string", "format": "binary"}},
+					}},
+				},
+			}
+		default:
+			o["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(g.schemaFor(reflect.TypeOf(op.Request))),
+			}
+		}
+		if versionedResources[o["tags"].([]string)[0]] {
+			params, _ := o["parameters"].([]any)
+			o["parameters"] = append(params, orgParameter)
+		}
+		method := strings.ToLower(op.Method)
+		if !versionedResources[o["tags"].([]string)[0]] {
+			pathItem(paths, op.Path)[method] = o
+			continue
+		}
+		// canonical under /v1, same operationId as before versioning;
+		// the bare path is documented as the deprecated alias it is
+		pathItem(paths, "/v1"+op.Path)[method] = o
+		legacy := maps.Clone(o)
+		legacy["operationId"] = operationID(op) + "Deprecated"
+		legacy["deprecated"] = true
+		legacy["description"] = "Deprecated alias of /v1" + op.Path + `: responses carry "Deprecation: true" and a successor-version Link.`
+		pathItem(paths, op.Path)[method] = legacy
+		// /v2: the same, but lists come in an envelope (writeList)
+		v2 := maps.Clone(o)
+		v2["operationId"] = operationID(op) + "V2"
+		if isList(op) {
+			responses := maps.Clone(o["responses"].(map[string]any))
+			enveloped := maps.Clone(success)
+			enveloped["content"] = jsonContent(g.listSchema(reflect.TypeOf(op.Response).Elem()))
+			responses[strconv.Itoa(op.Status)] = enveloped
+			v2["responses"] = responses
+		}
+		pathItem(paths, "/v2"+op.Path)[method] = v2
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "sandbox-go API",
+			"version": "1.0.0",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas":         g.schemas,
+			"securitySchemes": map[string]any{apiKeyScheme: apiKeySecurity, bearerScheme: bearerSecurity, sessionSecurityName: sessionSecurity},
+		},
+		// an API key, an access token, a session cookie, or nothing
+		// (auth.anonymous_role)
+		"security": []any{
+			map[string]any{apiKeyScheme: []string{}},
+			map[string]any{bearerScheme: []string{}},
+			map[string]any{sessionSecurityName: []string{}},
+			map[string]any{},
+		},
+	}
+}
+
+// listSchema — ListResponse[T] of elements of type elem
+func (g *schemaGen) listSchema(elem reflect.Type) map[string]any {
+	return map[string]any{
+		"type":     "object",
+		"required": []string{"data", "meta", "links"},
+		"properties": map[string]any{
+			"data":  map[string]any{"type": "array", "items": g.schemaFor(elem)},
+			"meta":  g.schemaFor(reflect.TypeOf(ListMeta{})),
+			"links": g.schemaFor(reflect.TypeOf(ListLinks{})),
+		},
+	}
+}
+
+// versionedResources — path roots served under /v1 and /v2, with a
+// deprecated bare alias of /v1; keep in sync with the v1 routes in routes()
+var versionedResources = map[string]bool{"tasks": true, "users": true, "escalations": true, "webhooks": true, "apikeys": true}
+
+func pathItem(paths map[string]map[string]any, path string) map[string]any {
+	item := paths[path]
+	if item == nil {
+		item = map[string]any{}
+		paths[path] = item
+	}
+	return item
+}
+
+// isList — GET returning a slice; those are paginated (see pageParams)
+func isList(op operation) bool {
+	return op.Method == "GET" && op.Response != nil && reflect.TypeOf(op.Response).Kind() == reflect.Slice
+}
+
+var pageParameters = []any{
+	map[string]any{
+		"name": "limit", "in": "query",
+		"description": "page size; defaults and maximum are configurable per route (see X-Max-Limit)",
+		"schema":      map[string]any{"type": "integer", "minimum": 1},
+	},
+	map[string]any{
+		"name": "offset", "in": "query",
+		"schema": map[string]any{"type": "integer", "minimum": 0},
+	},
+}
+
+// queryParameters — route-specific query parameters (on lists, on top
+// of limit/offset)
+var queryParameters = map[string][]any{
+	"POST /auth/password": {orgParameter}, // the organization whose user it is
+	"GET /tasks/search": {
+		map[string]any{
+			"name": "q", "in": "query", "required": true,
+			"description": "words to find in the title; each also matches as a prefix",
+			"schema":      map[string]any{"type": "string", "minLength": 1, "maxLength": maxSearchLen},
+		},
+	},
+	"GET /tasks/{id}/subtasks": {
+		map[string]any{
+			"name": "tree", "in": "query",
+			"description": "every level below the task, nested in subtasks (not paginated)",
+			"schema":      map[string]any{"type": "boolean"},
+		},
+	},
+	"GET /tasks/events/poll": {
+		map[string]any{
+			"name": "cursor", "in": "query",
+			"description": "cursor from the previous response; absent = only new events",
+			"schema":      map[string]any{"type": "string"},
+		},
+		map[string]any{
+			"name": "wait", "in": "query",
+			"description": "seconds to wait for an event before answering with none",
+			"schema":      map[string]any{"type": "integer", "minimum": 0, "maximum": int(pollMaxWait.Seconds()), "default": int(pollDefaultWait.Seconds())},
+		},
+	},
+	"GET /escalations": {
+		map[string]any{
+			"name": "task_id", "in": "query",
+			"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": validate.MaxID},
+		},
+		map[string]any{
+			"name": "rule", "in": "query",
+			"description": "a rule name from escalation.rules in the config",
+			"schema":      map[string]any{"type": "string"},
+		},
+	},
+	"GET /webhooks": {
+		map[string]any{
+			"name": "user_id", "in": "query",
+			"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": validate.MaxID},
+		},
+	},
+	"GET /apikeys": {
+		map[string]any{
+			"name": "user_id", "in": "query",
+			"schema": map[string]any{"type": "integer", "minimum": 1, "maximum": validate.MaxID},
+		},
+	},
+	"GET /auth/oidc/login": {
+		map[string]any{
+			"name": "session", "in": "query",
+			"description": "true: the callback starts a session (cookie) instead of answering with a token",
+			"schema":      map[string]any{"type": "boolean"},
+		},
+	},
+	"GET /auth/oidc/callback": {
+		map[string]any{"name": "code", "in": "query", "schema": map[string]any{"type": "string"}},
+		map[string]any{"name": "state", "in": "query", "schema": map[string]any{"type": "string"}},
+		map[string]any{
+			"name": "error", "in": "query",
+			"description": "set by the provider instead of code when the login didn't happen",
+			"schema":      map[string]any{"type": "string"},
+		},
+	},
+	"GET /webhooks/{id}/deliveries": {
+		map[string]any{
+			"name": "status", "in": "query",
+			"schema": map[string]any{"type": "string", "enum": []string{repository.DeliveryPending, repository.DeliveryDelivered, repository.DeliveryFailed}},
+		},
+	},
+	"GET /tasks":            taskFilterParameters,
+	"GET /tasks/export.csv": taskFilterParameters,
+}
+
+// taskFilterParameters — see taskFilter
+var taskFilterParameters = []any{
+	map[string]any{
+		"name": "include_deleted", "in": "query",
+		"description": "also list tasks in the trash",
+		"schema":      map[string]any{"type": "boolean"},
+	},
+	map[string]any{
+		"name": "overdue", "in": "query",
+		"description": "true: past their due date and not done; false: all others",
+		"schema":      map[string]any{"type": "boolean"},
+	},
+	map[string]any{
+		"name": "priority", "in": "query",
+		"schema": map[string]any{"type": "string", "enum": repository.Priorities},
+	},
+	map[string]any{
+		"name": "tag", "in": "query",
+		"description": "only tasks with this tag",
+		"schema":      map[string]any{"type": "string"},
+	},
+	map[string]any{
+		"name": "sort", "in": "query",
+		"description": "due_date: soonest first, tasks without one last",
+		"schema":      map[string]any{"type": "string", "enum": []string{"id", repository.SortDueDate}, "default": "id"},
+	},
+}
+
+// conditionalHeaders — operations guarded by ETags (see etag.go)
+var conditionalHeaders = map[string]any{
+	"GET /tasks/{id}":   etagParameter("If-None-Match", false, "ETag of the cached copy; 304 if still current"),
+	"PUT /tasks/{id}":   etagParameter("If-Match", false, ifMatchDescription),
+	"PATCH /tasks/{id}": etagParameter("If-Match", false, ifMatchDescription),
+}
+
+const ifMatchDescription = `ETag from the last read, unless the body has "version"; 412 if the task changed since, 428 if neither is sent`
+
+// apiKeySecurity — see apikeys.go
+var apiKeySecurity = map[string]any{
+	"type": "apiKey", "in": "header", "name": "Authorization",
+	"description": "ApiKey <key>, a key from POST /v1/apikeys",
+}
+
+// bearerSecurity — see authn.go
+var bearerSecurity = map[string]any{
+	"type": "http", "scheme": "bearer", "bearerFormat": "JWT",
+	"description": "an access_token from GET /auth/oidc/callback",
+}
+
+// sessionSecurity — see sessions.go
+const sessionSecurityName = "Session"
+
+var sessionSecurity = map[string]any{
+	"type": "apiKey", "in": "cookie", "name": sessionCookie,
+	"description": "set by POST /auth/login; writes also need X-CSRF-Token (GET /auth/session has it)",
+}
+
+// orgParameter — on every versioned operation (see tenancy.go)
+var orgParameter = map[string]any{
+	"name": orgHeader, "in": "header",
+	"description": "the organization to act in; required unless the server has a tenancy.default_org, or the request credentials (whose organization it must be)",
+	"schema":      map[string]any{"type": "integer", "minimum": 1, "maximum": validate.MaxID},
+}
+
+func etagParameter(name string, required bool, description string) map[string]any {
+	return map[string]any{
+		"name": name, "in": "header", "required": required,
+		"description": description,
+		"schema":      map[string]any{"type": "string"},
+	}
+}
+
+var etagHeaders = map[string]any{
+	"ETag": map[string]any{"description": "current version; send it back in If-Match / If-None-Match", "schema": map[string]any{"type": "string"}},
+}
+
+var pageHeaders = map[string]any{
+	"X-Limit":     map[string]any{"description": "page size used", "schema": map[string]any{"type": "integer"}},
+	"X-Max-Limit": map[string]any{"description": "largest limit accepted on this route", "schema": map[string]any{"type": "integer"}},
+	"X-Offset":    map[string]any{"description": "rows skipped", "schema": map[string]any{"type": "integer"}},
+}
+
+func jsonContent(schema any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID — "GET /tasks/{id}" → "getTasksId"
+func operationID(op operation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.Split(op.Path, "/") {
+		part = strings.Trim(part, "{}")
+		if part != "" {
+			id += strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return id
+}
+
+// -----------------------------------------------------------
+// SCHEMAS — reflect Go types into JSON Schema (OpenAPI flavour)
+// -----------------------------------------------------------
+
+type schemaGen struct {
+	schemas map[string]any // components/schemas, by type name
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	rawJSONType = reflect.TypeOf(json.RawMessage(nil))
+)
+
+func (g *schemaGen) schemaFor(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		s := g.schemaFor(t.Elem())
+		if _, isRef := s["$ref"]; !isRef {
+			s["nullable"] = true
+		}
+		return s
+	}
+
+	if alias, ok := reflect.Zero(t).Interface().(interface{ schemaOf() reflect.Type }); ok {
+		return g.schemaFor(alias.schemaOf()) // e.g. nullable[time.Time] → nullable date-time
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == rawJSONType:
+		return map[string]any{} // any JSON value, null included
+	case t.Kind() == reflect.Struct:
+		if _, done := g.schemas[t.Name()]; !done {
+			g.schemas[t.Name()] = map[string]any{} // placeholder for recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]any{"type": "number"}
+	case t.Kind() == reflect.String:
+		return map[string]any{"type": "string"}
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema — one property per exported json field; fields without
+// omitempty that aren't pointers or otherwise nullable are required
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := map[string]any{}
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			// embedded struct: encoding/json inlines its fields, so do we
+			embedded := g.structSchema(f.Type)
+			for k, v := range embedded["properties"].(map[string]any) {
+				props[k] = v
+			}
+			if req, ok := embedded["required"].([]string); ok {
+				required = append(required, req...)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		props[name] = g.schemaFor(f.Type)
+		if _, nullable := props[name].(map[string]any)["nullable"]; !nullable && !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	s := map[string]any{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
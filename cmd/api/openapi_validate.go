@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// SPEC VALIDATION — optional strict mode for staging
+//   off     — nothing checked (default, zero overhead)
+//   log     — mismatches are logged, responses pass through
+//   enforce — bad requests get 400, bad responses become 500
+// Catches drift between handlers and /openapi.json before
+// clients notice it.
+// -----------------------------------------------------------
+
+const (
+	validationOff     = "off"
+	validationLog     = "log"
+	validationEnforce = "enforce"
+)
+
+// specValidator checks traffic against the generated spec. It works on
+// the spec as decoded JSON, exactly what clients download.
+type specValidator struct {
+	mode    string
+	schemas map[string]any            // components/schemas
+	paths   map[string]map[string]any // template → method → operation
+}
+
+func newSpecValidator(mode string, spec map[string]any) (*specValidator, error) {
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	return &specValidator{mode: mode, schemas: doc.Components.Schemas, paths: doc.Paths}, nil
+}
+
+// match finds the operation for a request; params holds the values of
+// {placeholders} in the path.
+func (v *specValidator) match(method, path string) (op map[string]any, params map[string]string) {
+	// Literal paths win over templates: /tasks/events is not /tasks/{id}
+	if methods, ok := v.paths[path]; ok {
+		o, _ := methods[strings.ToLower(method)].(map[string]any)
+		if o == nil {
+			return nil, nil
+		}
+		return o, map[string]string{}
+	}
+
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for tmpl, methods := range v.paths {
+		tsegs := strings.Split(strings.Trim(tmpl, "/"), "/")
+		if len(tsegs) != len(segs) {
+			continue
+		}
+		p := map[string]string{}
+		ok := true
+		for i, t := range tsegs {
+			if strings.HasPrefix(t, "{") {
+				p[strings.Trim(t, "{}")] = segs[i]
+			} else if t != segs[i] {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		if o, found := methods[strings.ToLower(method)].(map[string]any); found {
+			return o, p
+		}
+	}
+	return nil, nil
+}
+
+// validateSpec is the middleware; a nil validator (mode off) is a no-op.
+func (v *specValidator) validateSpec(next http.Handler) http.Handler {
+	if v == nil || v.mode == validationOff {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, params := v.match(r.Method, r.URL.Path)
+		if op == nil {
+			next.ServeHTTP(w, r) // undocumented (health, metrics, ...) or 404/405
+			return
+		}
+		logger := requestctx.Logger(r.Context())
+
+		// Request: path params + body
+		problems := v.checkParams(op, params)
+		if body, ok := op["requestBody"].(map[string]any); ok {
+			raw, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, r, readError(err))
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(raw)) // let the handler read it again
+			problems = append(problems, v.checkBody(contentSchema(body, "application/json"), raw, "request")...)
+		}
+		if len(problems) > 0 {
+			logger.Warn("request does not match the API spec", "problems", problems)
+			if v.mode == validationEnforce {
+				writeError(w, r, apperr.New(apperr.RequestInvalid, strings.Join(problems, "; ")))
+				return
+			}
+		}
+
+		// A stream may never complete (SSE) or not fit in memory (CSV
+		// export), so it isn't buffered and checked
+		if streams(op) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Response: buffer it so it can be checked (and replaced)
+		buf := &bufferedResponse{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(buf, r)
+
+		if problems := v.checkResponse(op, buf); len(problems) > 0 {
+			logger.Error("response does not match the API spec", "status", buf.status, "problems", problems)
+			if v.mode == validationEnforce {
+				writeError(w, r, apperr.New(apperr.ResponseInvalid, "the server produced an undocumented response"))
+				return
+			}
+		}
+		w.WriteHeader(buf.status)
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// bufferedResponse holds the status and body back; headers go straight
+// to the real writer
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) WriteHeader(status int) { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// Unwrap — for timingsOf; streams, the only flushers, aren't buffered
+func (b *bufferedResponse) Unwrap() http.ResponseWriter {
+	return b.ResponseWriter
+}
+
+func (v *specValidator) checkParams(op map[string]any, params map[string]string) []string {
+	var problems []string
+	list, _ := op["parameters"].([]any)
+	for _, item := range list {
+		p, _ := item.(map[string]any)
+		name, _ := p["name"].(string)
+		schema, _ := p["schema"].(map[string]any)
+		if p["in"] != "path" || schema["type"] != "integer" {
+			continue
+		}
+		if _, err := strconv.Atoi(params[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("path parameter %s: %q is not an integer", name, params[name]))
+		}
+	}
+	return problems
+}
+
+func (v *specValidator) checkResponse(op map[string]any, buf *bufferedResponse) []string {
+	responses, _ := op["responses"].(map[string]any)
+	resp, ok := responses[strconv.Itoa(buf.status)].(map[string]any)
+	if !ok {
+		if buf.status < 400 {
+			return []string{fmt.Sprintf("status %d is not documented", buf.status)}
+		}
+		resp, _ = responses["default"].(map[string]any)
+	}
+
+	content, _ := resp["content"].(map[string]any)
+	if len(content) == 0 {
+		if buf.body.Len() > 0 {
+			return []string{fmt.Sprintf("status %d must not have a body", buf.status)}
+		}
+		return nil
+	}
+
+	mediaType, _, _ := strings.Cut(buf.Header().Get("Content-Type"), ";")
+	schema := contentSchema(resp, mediaType)
+	if schema == nil {
+		return []string{fmt.Sprintf("content type %q is not documented for status %d", mediaType, buf.status)}
+	}
+	return v.checkBody(schema, buf.body.Bytes(), "response")
+}
+
+// streams — the operation answers with Server-Sent Events or CSV
+func streams(op map[string]any) bool {
+	responses, _ := op["responses"].(map[string]any)
+	for _, resp := range responses {
+		content, _ := resp.(map[string]any)["content"].(map[string]any)
+		if _, ok := content["text/event-stream"]; ok {
+			return true
+		}
+		if _, ok := content["text/csv"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// contentSchema — responses/requestBody → content → mediaType → schema
+func contentSchema(holder map[string]any, mediaType string) map[string]any {
+	content, _ := holder["content"].(map[string]any)
+	media, _ := content[mediaType].(map[string]any)
+	schema, _ := media["schema"].(map[string]any)
+	return schema
+}
+
+func (v *specValidator) checkBody(schema map[string]any, raw []byte, where string) []string {
+	if schema == nil {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return []string{where + " body is not valid JSON"}
+	}
+	return v.check(schema, value, where)
+}
+
+// check validates value against a JSON schema (the subset the generator
+// emits: $ref, type, nullable, required, properties, items,
+// additionalProperties, format date-time).
+func (v *specValidator) check(schema map[string]any, value any, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		target, _ := v.schemas[strings.TrimPrefix(ref, "#/components/schemas/")].(map[string]any)
+		return v.check(target, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		if _, typed := schema["type"]; typed {
+			return []string{at + ": must not be null"}
+		}
+		return nil
+	}
+
+	mismatch := func() []string {
+		return []string{fmt.Sprintf("%s: expected %v, got %s", at, schema["type"], jsonType(value))}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		var problems []string
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, present := obj[name.(string)]; !present {
+				problems = append(problems, fmt.Sprintf("%s.%s: required field missing", at, name))
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		extra, _ := schema["additionalProperties"].(map[string]any)
+		for name, fieldValue := range obj {
+			if fieldSchema, ok := props[name].(map[string]any); ok {
+				problems = append(problems, v.check(fieldSchema, fieldValue, at+"."+name)...)
+			} else if extra != nil {
+				problems = append(problems, v.check(extra, fieldValue, at+"."+name)...)
+			}
+		}
+		return problems
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			return mismatch()
+		}
+		items, _ := schema["items"].(map[string]any)
+		var problems []string
+		for i, item := range arr {
+			problems = append(problems, v.check(items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []string{at + ": not an RFC 3339 date-time"}
+			}
+		}
+	case "integer":
+		n, ok := value.(float64)
+		if !ok || n != math.Trunc(n) {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sandbox-go/internal/dlock"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// OUTBOX — task events are recorded in the outbox table, in the
+// transaction of the change (changeTask, or inTx + record), not
+// sent on the spot: a rolled-back change sends nothing, and an
+// event isn't lost if the process dies right after the commit.
+// Two loops take them from there:
+//   - the relay (one instance at a time) takes new events in
+//     order, queues their webhooks and numbers them;
+//   - every instance follows the numbered events into its own
+//     event bus, so /tasks/events anywhere sees all of them, in
+//     the same order.
+// (With a broker configured, a third publishes them: broker.go.)
+// At least once: events taken by a relay that fails before
+// numbering them are relayed again (webhook receivers dedupe on
+// the event id).
+// -----------------------------------------------------------
+
+const (
+	outboxLockName     = "outbox:relay"
+	outboxBatch        = 100 // events per relay or follow query
+	outboxCleanupEvery = time.Hour
+)
+
+// inTx runs fn in a transaction — a savepoint inside a request
+// transaction — so the events it records commit with its changes.
+// fn's error comes back as it is.
+func (app *App) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if app.DB == nil {
+		return fn(ctx) // no database: fakes in a test
+	}
+	tx, err := repository.Begin(ctx, app.DB)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx)) // no-op after Commit
+
+	if err := fn(repository.WithTx(ctx, tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// record adds a task event to the outbox, in ctx's transaction
+func (app *App) record(ctx context.Context, typ string, taskID int, data any) error {
+	if app.Outbox == nil {
+		org, _ := requestctx.OrgID(ctx)
+		app.Events.Publish(typ, org, data) // no outbox: fakes in a test
+		return nil
+	}
+	return app.Outbox.Add(ctx, typ, taskID, data)
+}
+
+// changeTask runs a change to one task and records the task as an
+// event of each of types, in one transaction
+func (app *App) changeTask(ctx context.Context, change func(ctx context.Context) (Task, error), types ...string) (Task, error) {
+	var task Task
+	err := app.inTx(ctx, func(ctx context.Context) (err error) {
+		if task, err = change(ctx); err != nil {
+			return err
+		}
+		for _, typ := range types {
+			if err := app.record(ctx, typ, task.ID, task); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return task, err
+}
+
+// updateEvents — an update is task.updated, and also task.completed
+// if it sets done (done: the update's field, nil = not touched)
+func updateEvents(done *bool) []string {
+	if done != nil && *done {
+		return []string{taskUpdated, taskCompleted}
+	}
+	return []string{taskUpdated}
+}
+
+// runOutboxRelay relays new events every interval until ctx is
+// cancelled, and now and then deletes old ones
+func (app *App) runOutboxRelay(ctx context.Context, locks *dlock.Locker, interval, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var cleaned time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		lock, ok, err := locks.TryAcquire(ctx, outboxLockName)
+		if err != nil {
+			app.Log.Warn("outbox: lock", "err", err)
+			continue
+		}
+		if !ok {
+			continue // another instance is on it
+		}
+		start := time.Now()
+		err = app.relayAll(ctx)
+		app.Metrics.observeScheduler("outbox_relay", start, err)
+		if time.Since(cleaned) >= outboxCleanupEvery {
+			if n, err := app.Outbox.Cleanup(ctx, retention); err != nil {
+				app.Log.Warn("outbox: cleanup", "err", err)
+			} else {
+				cleaned = time.Now()
+				if n > 0 {
+					app.Log.Info("outbox cleaned up", "deleted", n)
+				}
+			}
+		}
+		lock.Release()
+	}
+}
+
+// relayAll drains the backlog in batches; a failed batch is retried
+// next tick, from the same event
+func (app *App) relayAll(ctx context.Context) error {
+	for ctx.Err() == nil {
+		n, err := app.Outbox.Relay(ctx, outboxBatch, func(list []repository.OutboxEvent) error {
+			for _, e := range list {
+				if err := app.dispatchWebhooks(ctx, e); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			app.Log.Error("outbox: relay failed", "err", err)
+			return err
+		}
+		if n < outboxBatch {
+			return nil
+		}
+	}
+	return nil
+}
+
+// runOutboxFollow publishes relayed events to this instance's bus
+// every interval until ctx is cancelled. It starts from the newest:
+// like the bus itself, a new process has no history.
+func (app *App) runOutboxFollow(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seq := int64(-1) // not known yet: the database may not be up
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if seq < 0 {
+			last, err := app.Outbox.LastSeq(ctx)
+			if err != nil {
+				app.Log.Warn("outbox: find newest event", "err", err)
+				continue
+			}
+			seq = last
+		}
+		for ctx.Err() == nil {
+			list, err := app.Outbox.Since(ctx, seq, outboxBatch)
+			if err != nil {
+				app.Log.Warn("outbox: follow", "after_seq", seq, "err", err)
+				break
+			}
+			for _, e := range list {
+				app.Events.Publish(e.Type, e.OrgID, e.Data)
+				seq = e.Seq
+			}
+			if len(list) < outboxBatch {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/password"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// PASSWORDS — for users who log in here rather than with an
+// identity provider (auth.passwords):
+//   POST /users/{id}/password — set or change it, 204; users
+//                               changing their own send their
+//                               old_password too, admins setting
+//                               someone else's don't
+//   POST /auth/password       — email and password in (X-Org-ID:
+//                               emails are per organization), an
+//                               access token out, as from OIDC;
+//                               needs auth.token_secret
+// Hashes are argon2id (internal/password); a login with a hash
+// made before the parameters were raised makes it again. Wrong
+// passwords count, and auth.passwords.max_failures of them in a
+// row lock the account for auth.passwords.lockout: 429
+// ACCOUNT_LOCKED with Retry-After, whatever the password.
+// -----------------------------------------------------------
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password,omitempty"` // required to change your own
+	NewPassword string `json:"new_password"`
+}
+
+type PasswordLoginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// maxPasswordLen — argon2 takes any length; this keeps a request from
+// having it hash megabytes
+const maxPasswordLen = 1024
+
+func (req ChangePasswordRequest) validate(minLen int) error {
+	n := utf8.RuneCountInString(req.NewPassword)
+	return validate.New().
+		Required("new_password", req.NewPassword).
+		Check("new_password", n >= minLen, fmt.Sprintf("must be at least %d characters", minLen)).
+		MaxLen("new_password", req.NewPassword, maxPasswordLen).
+		MaxLen("old_password", req.OldPassword, maxPasswordLen).
+		Err()
+}
+
+func (req PasswordLoginRequest) validate() error {
+	return validate.New().
+		Required("email", req.Email).
+		MaxLen("email", req.Email, maxEmailLen).
+		Required("password", req.Password).
+		MaxLen("password", req.Password, maxPasswordLen).
+		Err()
+}
+
+func (app *App) passwordParams() password.Params {
+	return password.Params{
+		Memory:      app.Password.Memory,
+		Iterations:  app.Password.Iterations,
+		Parallelism: app.Password.Parallelism,
+	}
+}
+
+// dummyHash — what is checked when there's no hash to check, so an
+// unknown email takes as long to refuse as a wrong password (at the
+// default cost)
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := password.Hash("no such user", password.Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 2})
+	return h
+})
+
+var errWrongPassword = apperr.New(apperr.LoginFailed, "wrong email or password")
+
+// checkPassword verifies pw against c. A wrong one counts toward the
+// lockout, a right one with an outdated hash is hashed again; a locked
+// account refuses either, with Retry-After set on w.
+func (app *App) checkPassword(ctx context.Context, w http.ResponseWriter, c repository.Credential, pw string) error {
+	if c.Locked(time.Now()) {
+		return accountLocked(w, *c.LockedUntil)
+	}
+	if c.Hash == "" {
+		password.Verify(dummyHash(), pw, app.passwordParams())
+		return errWrongPassword
+	}
+	rehash, err := password.Verify(c.Hash, pw, app.passwordParams())
+	if errors.Is(err, password.ErrMismatch) {
+		c, err := app.Passwords.Fail(ctx, c.UserID, app.Password.MaxFailures, app.Password.Lockout)
+		if err != nil {
+			return err
+		}
+		if c.Locked(time.Now()) {
+			requestctx.Logger(ctx).Warn("account locked", "user_id", c.UserID, "until", *c.LockedUntil)
+			return accountLocked(w, *c.LockedUntil)
+		}
+		return errWrongPassword
+	}
+	if err != nil {
+		return fmt.Errorf("check password of user %d: %w", c.UserID, err)
+	}
+
+	if rehash {
+		hash, err := password.Hash(pw, app.passwordParams())
+		if err != nil {
+			return err
+		}
+		return app.Passwords.Set(ctx, c.UserID, hash) // clears the failures too
+	}
+	if c.FailedLogins > 0 {
+		return app.Passwords.Succeed(ctx, c.UserID)
+	}
+	return nil
+}
+
+func accountLocked(w http.ResponseWriter, until time.Time) error {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+	return apperr.New(apperr.AccountLocked, "too many wrong passwords; the account is locked until "+until.UTC().Format(time.RFC3339))
+}
+
+// POST /users/{id}/password
+func (app *App) handleSetPassword(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	var req ChangePasswordRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(app.Password.MinLength); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Members and viewers may change their own; only admins someone
+	// else's
+	c, err := app.caller(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	own := c.UserID != 0 && c.UserID == id
+	if !own {
+		if err := c.may(authz.Manage, 0); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	} else if c.ReadOnly {
+		writeError(w, r, c.may(authz.Write, id))
+		return
+	}
+
+	cred, err := app.Passwords.Get(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if own && cred.Hash != "" {
+		if req.OldPassword == "" {
+			writeError(w, r, validate.New().Check("old_password", false, "required to change your own password").Err())
+			return
+		}
+		if err := app.checkPassword(r.Context(), w, cred, req.OldPassword); err != nil {
+			if errors.Is(err, errWrongPassword) {
+				err = apperr.New(apperr.Forbidden, "old_password is wrong")
+			}
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	hash, err := password.Hash(req.NewPassword, app.passwordParams())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := app.Passwords.Set(r.Context(), id, hash); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	requestctx.Logger(r.Context()).Info("password set", "user_id", id, "by", c.UserID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /auth/password
+func (app *App) handlePasswordLogin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	var req PasswordLoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// An unversioned route: tenant leaves the organization to us
+	ctx, err := app.withOrg(r.Context(), r.Header.Get(orgHeader))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := app.Users.GetByEmail(ctx, req.Email)
+	if errors.Is(err, repository.ErrNotFound) {
+		password.Verify(dummyHash(), req.Password, app.passwordParams())
+		writeError(w, r, errWrongPassword)
+		return
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	cred, err := app.Passwords.Get(ctx, user.ID)
+	if err == nil {
+		err = app.checkPassword(ctx, w, cred, req.Password)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	requestctx.Logger(r.Context()).Info("password login", "user_id", user.ID)
+	resp, err := app.tokenResponse(ctx, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// TRASH PURGE — DELETE /tasks/{id} only moves a task to the
+// trash; this job removes trashed tasks for good once they are
+// older than the retention window.
+// -----------------------------------------------------------
+
+// purgeLockName — every instance runs the schedule, the advisory lock
+// makes sure only one of them purges at a time
+const purgeLockName = "tasks:purge-trash"
+
+// trashPurgeJob purges every cfg.PurgeInterval, each dedicated
+// organization's trash too
+func (app *App) trashPurgeJob(cfg config.TrashConfig) schedule.Job {
+	return scheduled("trash_purge", purgeLockName, cfg.PurgeInterval, cfg.Schedule, func(ctx context.Context) error {
+		return app.eachTenantDB(ctx, func(ctx context.Context) error {
+			n, err := app.Tasks.Purge(ctx, cfg.Retention)
+			if err != nil {
+				requestctx.Logger(ctx).Error("trash purge failed", "err", err)
+				return err
+			}
+			if n > 0 {
+				requestctx.Logger(ctx).Info("trash purged", "tasks", n, "older_than", cfg.Retention)
+			}
+			return nil
+		})
+	})
+}
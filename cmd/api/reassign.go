@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// BULK REASSIGN — POST /tasks/reassign
+// Moves every open task of one user to another, e.g. when
+// someone leaves the team. Tasks move reassignBatch at a time,
+// each batch its own transaction, so a user with thousands of
+// tasks never has them all locked at once. A failure part-way
+// keeps the batches already moved; sending the same request
+// again moves the rest.
+// -----------------------------------------------------------
+
+const reassignBatch = 100
+
+type ReassignRequest struct {
+	FromUserID int    `json:"from_user_id"`
+	ToUserID   int    `json:"to_user_id"`
+	Tag        string `json:"tag,omitempty"` // only move tasks carrying this tag
+}
+
+func (req ReassignRequest) validate() error {
+	v := validate.New().
+		ID("from_user_id", req.FromUserID).
+		ID("to_user_id", req.ToUserID).
+		Check("to_user_id", req.ToUserID != req.FromUserID, "must differ from from_user_id")
+	if req.Tag != "" {
+		v.MaxLen("tag", req.Tag, maxTagLen).
+			Check("tag", tagPattern.MatchString(req.Tag), "only letters, digits, - and _")
+	}
+	return v.Err()
+}
+
+type ReassignResult struct {
+	Reassigned int   `json:"reassigned"`
+	Batches    int   `json:"batches"`
+	TaskIDs    []int `json:"task_ids"`
+	// Complete is false when a server error stopped the run after some
+	// batches were moved; Code and Error say why
+	Complete bool        `json:"complete"`
+	Code     apperr.Code `json:"code,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// POST /tasks/reassign — hand one user's open tasks to another
+func (app *App) handleReassignTasks(w http.ResponseWriter, r *http.Request) {
+	var req ReassignRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req.Tag = strings.ToLower(strings.TrimSpace(req.Tag)) // same normalization as on write
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	// Both must exist: a mistyped from_user_id would otherwise "succeed"
+	// with nothing moved
+	for _, id := range []int{req.FromUserID, req.ToUserID} {
+		if _, err := app.Users.Get(r.Context(), id); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	ra := repository.Reassignment{FromUserID: req.FromUserID, ToUserID: req.ToUserID, Tag: req.Tag}
+	result := ReassignResult{TaskIDs: []int{}, Complete: true}
+	for {
+		var moved []Task
+		err := app.inTx(r.Context(), func(ctx context.Context) (err error) {
+			if moved, err = app.Tasks.Reassign(ctx, ra, reassignBatch); err != nil {
+				return err
+			}
+			for _, task := range moved {
+				if err := app.record(ctx, taskUpdated, task.ID, task); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && result.Batches == 0 {
+			writeError(w, r, err) // nothing changed yet: a plain error
+			return
+		}
+		if err != nil {
+			requestctx.Logger(r.Context()).Error("reassign stopped", "from_user_id", ra.FromUserID, "reassigned", result.Reassigned, "err", err)
+			e := apperr.From(err)
+			result.Complete = false
+			result.Code, result.Error = e.Code, e.Message
+			break
+		}
+		if len(moved) > 0 {
+			result.Batches++
+		}
+		for _, task := range moved {
+			result.TaskIDs = append(result.TaskIDs, task.ID)
+		}
+		result.Reassigned += len(moved)
+		if len(moved) < reassignBatch {
+			break
+		}
+	}
+
+	requestctx.Logger(r.Context()).Info("tasks reassigned",
+		"from_user_id", ra.FromUserID, "to_user_id", ra.ToUserID, "tag", ra.Tag, "tasks", result.Reassigned)
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// PANIC RECOVERY — a panicking handler would otherwise take the
+// connection down with it and the client gets an empty reply.
+// recoverPanics turns it into a logged stack trace and a normal
+// 500 INTERNAL problem+json, and hands the panic to App.Panics
+// so it can go on to Sentry or whatever else is watching.
+// -----------------------------------------------------------
+
+// PanicReporter is the hook for error trackers. ReportPanic runs on
+// the request's goroutine before the 500 goes out, so it should hand
+// off rather than block; the request ID is in r's context
+// (requestctx.RequestID).
+type PanicReporter interface {
+	ReportPanic(r *http.Request, recovered any, stack []byte)
+}
+
+// PanicReporterFunc lets a plain function be a PanicReporter
+type PanicReporterFunc func(r *http.Request, recovered any, stack []byte)
+
+func (f PanicReporterFunc) ReportPanic(r *http.Request, recovered any, stack []byte) {
+	f(r, recovered, stack)
+}
+
+// recoverPanics runs inside logging, metrics and load tracking, so a
+// recovered panic shows up there as the 500 it became
+func (app *App) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &panicWriter{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				panic(recovered) // a deliberate abort: let net/http drop the connection quietly
+			}
+			stack := debug.Stack()
+			requestctx.Logger(r.Context()).Error("panic serving request",
+				"method", r.Method, "path", r.URL.Path, "panic", fmt.Sprint(recovered), "stack", string(stack))
+			app.reportPanic(r, recovered, stack)
+
+			if pw.wrote {
+				// Part of the response is already out (an event stream, say):
+				// too late for a 500, so cut the connection instead of leaving
+				// the client with a truncated body it might take as complete
+				panic(http.ErrAbortHandler)
+			}
+			writeError(w, r, apperr.Wrap(apperr.Internal, "the server hit an unexpected error", panicError(recovered)))
+		}()
+		next.ServeHTTP(pw, r)
+	})
+}
+
+// reportPanic calls the hook; a reporter that panics itself is logged,
+// not allowed to replace the original failure
+func (app *App) reportPanic(r *http.Request, recovered any, stack []byte) {
+	if app.Panics == nil {
+		return
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			requestctx.Logger(r.Context()).Error("panic reporter failed", "panic", fmt.Sprint(p))
+		}
+	}()
+	app.Panics.ReportPanic(r, recovered, stack)
+}
+
+// panicError keeps an error value as the cause (errors.Is still works
+// on it in the log), anything else becomes one
+func panicError(recovered any) error {
+	if err, ok := recovered.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return errors.New("panic: " + fmt.Sprint(recovered))
+}
+
+// panicWriter remembers whether the handler got as far as writing
+type panicWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (pw *panicWriter) WriteHeader(status int) {
+	pw.wrote = true
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *panicWriter) Write(p []byte) (int, error) {
+	pw.wrote = true
+	return pw.ResponseWriter.Write(p)
+}
+
+func (pw *panicWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// RECURRING TASKS — a task with a recurrence rule (daily,
+// weekly or a cron expression) comes back: once it is done or
+// its due date passes, this scheduler creates the next
+// occurrence and moves the rule onto it (see
+// repository/recurring.go). Like the trash purge it is
+// scheduled on every instance, and an advisory lock picks one
+// per run.
+// -----------------------------------------------------------
+
+const (
+	recurLockName = "tasks:recurrence"
+	recurBatch    = 100 // occurrences per transaction; a run repeats until done
+)
+
+// recurrenceJob checks every cfg.Interval, in each dedicated
+// organization too. A materialized occurrence is a created task and an
+// updated one, and is published as such.
+func (app *App) recurrenceJob(cfg config.RecurrenceConfig) schedule.Job {
+	return scheduled("recurrence", recurLockName, cfg.Interval, cfg.Schedule, func(ctx context.Context) error {
+		return app.eachTenantDB(ctx, app.recurAll)
+	})
+}
+
+// recurAll drains the backlog in batches, so a burst (many daily tasks
+// due at midnight) is handled within one run
+func (app *App) recurAll(ctx context.Context) error {
+	for ctx.Err() == nil {
+		var occs []repository.Occurrence
+		err := app.inTx(ctx, func(ctx context.Context) (err error) {
+			if occs, err = app.Tasks.Recur(ctx, recurBatch); err != nil {
+				return err
+			}
+			for _, occ := range occs {
+				if err := app.record(ctx, taskUpdated, occ.Previous.ID, occ.Previous); err != nil {
+					return err
+				}
+				if occ.Next.ID == 0 {
+					continue
+				}
+				if err := app.record(ctx, taskCreated, occ.Next.ID, occ.Next); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			app.Log.Error("recurrence failed", "err", err)
+			return err
+		}
+		for _, occ := range occs {
+			if occ.Next.ID == 0 {
+				app.Log.Warn("recurrence rule no longer valid, series ended",
+					"task_id", occ.Previous.ID)
+				continue
+			}
+			app.Log.Info("next occurrence created",
+				"task_id", occ.Next.ID, "previous", occ.Previous.ID, "due_date", occ.Next.DueDate)
+		}
+		if len(occs) < recurBatch {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/oidc"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// REFRESH TOKENS — so access tokens can be short-lived without
+// logging in again each time (auth.refresh). A login, OIDC or
+// password, comes with a refresh_token as well:
+//   POST /auth/refresh — refresh_token in, a new access token and
+//                        a new refresh_token out; the one sent
+//                        is spent
+//   POST /auth/revoke  — refresh_token in, 204: it and every
+//                        token since its login stop working (a
+//                        logout)
+// A spent token sent again means someone has a copy: its whole
+// family is revoked, and the user has to log in again.
+// -----------------------------------------------------------
+
+// refreshPurgeLock — one instance purges at a time
+const refreshPurgeLock = "refresh_tokens:purge"
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (req RefreshRequest) validate() error {
+	return validate.New().
+		Required("refresh_token", req.RefreshToken).
+		MaxLen("refresh_token", req.RefreshToken, 256).
+		Err()
+}
+
+var errBadRefreshToken = apperr.New(apperr.Unauthenticated, "the refresh token has expired or been revoked; log in again")
+
+// tokenResponse — what a login answers with: an access token for u,
+// and a refresh token when they are on
+func (app *App) tokenResponse(ctx context.Context, u User) (TokenResponse, error) {
+	token, exp, err := app.issueToken(u)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	resp := TokenResponse{
+		AccessToken: token,
+		TokenType:   bearerScheme,
+		ExpiresIn:   int(time.Until(exp) / time.Second),
+		User:        u,
+	}
+	if app.RefreshTokens != nil {
+		secret := oidc.RandomString()
+		if _, err := app.RefreshTokens.Create(ctx, u.ID, hashAPIKey(secret), app.RefreshTTL); err != nil {
+			return TokenResponse{}, err
+		}
+		resp.RefreshToken = secret
+	}
+	return resp, nil
+}
+
+// POST /auth/refresh
+func (app *App) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	var req RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	secret := oidc.RandomString()
+	t, err := app.RefreshTokens.Rotate(r.Context(), hashAPIKey(req.RefreshToken), hashAPIKey(secret), app.RefreshTTL)
+	switch {
+	case errors.Is(err, repository.ErrTokenReused):
+		requestctx.Logger(r.Context()).Warn("refresh token reused; family revoked")
+		writeError(w, r, errBadRefreshToken)
+		return
+	case errors.Is(err, repository.ErrNotFound):
+		writeError(w, r, errBadRefreshToken)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	user, err := app.Users.Get(requestctx.WithOrgID(r.Context(), t.OrgID), t.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	token, exp, err := app.issueToken(user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	requestctx.Logger(r.Context()).Info("token refreshed", "user_id", user.ID, "family", t.Family)
+	writeJSON(w, http.StatusOK, TokenResponse{
+		AccessToken:  token,
+		TokenType:    bearerScheme,
+		ExpiresIn:    int(time.Until(exp) / time.Second),
+		RefreshToken: secret,
+		User:         user,
+	})
+}
+
+// POST /auth/revoke — whoever holds the token may revoke it; one that
+// doesn't exist is revoked already
+func (app *App) handleRevokeRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := app.RefreshTokens.Revoke(r.Context(), hashAPIKey(req.RefreshToken)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshPurgeJob deletes expired refresh tokens every cfg.PurgeInterval
+func (app *App) refreshPurgeJob(cfg config.RefreshConfig) schedule.Job {
+	return scheduled("refresh_token_purge", refreshPurgeLock, cfg.PurgeInterval, cfg.Schedule, func(ctx context.Context) error {
+		n, err := app.RefreshTokens.Purge(ctx)
+		if err != nil {
+			app.Log.Error("refresh token purge failed", "err", err)
+			return err
+		}
+		if n > 0 {
+			app.Log.Info("refresh tokens purged", "tokens", n)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/jobs"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// DUE REMINDERS — the first consumer of the job queue. A scan
+// (one instance per run, like the other schedules) queues a
+// task.reminder job for every open task due within
+// reminders.before; a worker then sends it as a task.due_soon
+// event on /tasks/events. The job key is the task and its due
+// date, so rescanning queues nothing twice, and moving the due
+// date earns the task a new reminder.
+// -----------------------------------------------------------
+
+const (
+	reminderKind     = "task.reminder"
+	reminderLockName = "tasks:reminders"
+	reminderBatch    = 100 // tasks per query while scanning
+)
+
+// taskDueSoon — published once per task and due date
+const taskDueSoon = "task.due_soon"
+
+// TaskDueSoon — data of a task.due_soon event
+type TaskDueSoon struct {
+	Task  Task    `json:"task"`
+	DueIn float64 `json:"due_in_seconds"`
+}
+
+// reminderJob — payload of a task.reminder job
+type reminderJob struct {
+	TaskID  int       `json:"task_id"`
+	DueDate time.Time `json:"due_date"`
+	// OrgID — a dedicated organization's, whose schema has the task;
+	// 0 = the shared tasks table
+	OrgID int `json:"org_id,omitempty"`
+}
+
+// remindersJob scans every cfg.Interval
+func (app *App) remindersJob(cfg config.RemindersConfig) schedule.Job {
+	return scheduled("reminders", reminderLockName, cfg.Interval, cfg.Schedule, func(ctx context.Context) error {
+		return app.eachTenantDB(ctx, func(ctx context.Context) error {
+			return app.queueReminders(ctx, cfg.Before)
+		})
+	})
+}
+
+// queueReminders queues a job for each task due within before; one
+// already queued (or sent) is skipped by its key
+func (app *App) queueReminders(ctx context.Context, before time.Duration) error {
+	queued, after := 0, 0
+	org, _ := requestctx.OrgID(ctx) // set by eachTenantDB for a dedicated one
+	for ctx.Err() == nil {
+		tasks, err := app.Tasks.DueSoon(ctx, before, after, reminderBatch)
+		if err != nil {
+			requestctx.Logger(ctx).Error("reminders: scan failed", "err", err)
+			return err
+		}
+		for _, t := range tasks {
+			key := fmt.Sprintf("%s:%d:%d", reminderKind, t.ID, t.DueDate.Unix())
+			if org != 0 {
+				key = fmt.Sprintf("%s:org%d", key, org) // its IDs may repeat the shared table's
+			}
+			added, err := app.Jobs.Enqueue(ctx, jobs.NewJob{
+				Kind:     reminderKind,
+				Payload:  reminderJob{TaskID: t.ID, DueDate: *t.DueDate, OrgID: org},
+				Key:      key,
+				Priority: jobs.PriorityHigh, // late is as good as lost
+			})
+			if err != nil {
+				requestctx.Logger(ctx).Error("reminders: enqueue failed", "task_id", t.ID, "err", err)
+				return err
+			}
+			if added {
+				queued++
+			}
+		}
+		if len(tasks) < reminderBatch {
+			break
+		}
+		after = tasks[len(tasks)-1].ID
+	}
+	if queued > 0 {
+		requestctx.Logger(ctx).Info("reminders queued", "count", queued)
+	}
+	return nil
+}
+
+// sendReminder handles a task.reminder job. The task may have changed
+// since the job was queued: done, deleted or rescheduled tasks get no
+// reminder (a rescheduled one has a job of its own).
+func (app *App) sendReminder(ctx context.Context, job jobs.Job) error {
+	var rj reminderJob
+	if err := json.Unmarshal(job.Payload, &rj); err != nil {
+		return jobs.Permanent(fmt.Errorf("payload: %w", err))
+	}
+	if rj.OrgID != 0 { // the task is in the organization's schema
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		var err error
+		if ctx, err = app.enterOrg(ctx, rj.OrgID); err != nil {
+			return err
+		}
+	}
+
+	t, err := app.Tasks.Get(ctx, rj.TaskID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if t.Done || t.DueDate == nil || !t.DueDate.Equal(rj.DueDate) {
+		return nil
+	}
+
+	due := TaskDueSoon{Task: t, DueIn: time.Until(*t.DueDate).Round(time.Second).Seconds()}
+	if err := app.record(ctx, taskDueSoon, t.ID, due); err != nil {
+		return err
+	}
+	app.Log.Info("reminder sent", "task_id", t.ID, "user_id", t.UserID, "due_date", t.DueDate)
+	return nil
+}
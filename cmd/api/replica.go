@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// READ REPLICA — GET and HEAD requests may read from the
+// replica (db.replica.dsn); the routing and the fallback to the
+// primary are the repository's (see repository/replica.go).
+// /readyz reports the replica without depending on it: down,
+// reads go to the primary, and the instance is still ready.
+// -----------------------------------------------------------
+
+// readReplica lets safe requests' reads go to the replica
+func (app *App) readReplica(next http.Handler) http.Handler {
+	if app.Replica == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			r = r.WithContext(repository.WithReplica(r.Context(), app.Replica))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// replicaComponent — the replica as of its last check
+func (app *App) replicaComponent() component {
+	s := app.Replica.Status()
+	c := component{Status: "up", LagMS: float64(s.Lag.Microseconds()) / 1000}
+	if !s.Up {
+		c.Status, c.Error = "down", s.Error
+	}
+	return c
+}
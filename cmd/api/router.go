@@ -0,0 +1,438 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// ROUTE REGISTRY — every (method, path template) pair is
+// registered once, up front. http.ServeMux only sees one
+// dispatcher per prefix; the registry picks the handler, answers
+// 404/405 itself and remembers what went where, so:
+//   - a duplicate registration is a startup error, not a silent
+//     override or a panic deep inside ServeMux
+//   - GET /admin/routes lists method, pattern, middleware, handler
+//   - a route can bring its own middleware on top of the shared
+//     chain (limitBody on uploads, ...), run after the path checks
+//   - metrics can label requests by template ("/v1/tasks/{id}")
+//   - a route can have a canary: a second handler some requests
+//     take instead (see canary.go)
+// Like PHP's `Route::list` in Laravel, minus the framework.
+// -----------------------------------------------------------
+
+// route is one registered endpoint, as /admin/routes shows it
+type route struct {
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Handler    string   `json:"handler"`
+	Middleware []string `json:"middleware"`
+	Version    string   `json:"version,omitempty"`    // "v1"; empty for unversioned routes (probes, webhooks, ...)
+	Deprecated bool     `json:"deprecated,omitempty"` // bare-path alias of a versioned route
+	Canary     string   `json:"canary,omitempty"`     // handler of the canary variant, if any
+
+	template string       // Pattern without the version: "/tasks/{id}"
+	segs     []string     // pattern split on "/"; "{...}" matches one segment
+	local    []middleware // this route's own, innermost
+	handler  http.Handler // wrapped in local
+	canary   http.Handler // likewise; nil = none
+}
+
+// middleware is one layer of the chain; skip lists the unversioned
+// route patterns where it steps aside (see infraPaths, longLived).
+// use honours skip, so a middleware doesn't check it itself.
+type middleware struct {
+	name string
+	wrap func(http.Handler) http.Handler
+	skip map[string]bool
+}
+
+type router struct {
+	mux    *http.ServeMux
+	routes []*route                      // registration order
+	byMux  map[string][]*route           // ServeMux pattern → routes it dispatches
+	params map[string]func(string) error // {name} → check, run before the handler
+	chain  []middleware                  // outermost first
+	errs   []error
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux(), byMux: map[string][]*route{}, params: map[string]func(string) error{}}
+}
+
+// param registers a check for every {name} placeholder: a value it
+// rejects gets its error as the response, and the handler never runs.
+// Handlers read the checked value with pathParam.
+func (rt *router) param(name string, check func(string) error) {
+	rt.params[name] = check
+}
+
+// handle registers h for method + pattern. Patterns use {name} for a
+// path segment: "/tasks/{id}/restore". Conflicts are collected and
+// reported by err() — two registrations conflict when they'd match the
+// same requests, whatever the placeholders are called. mw wraps h for
+// this route only, outermost first.
+func (rt *router) handle(method, pattern string, h http.Handler, mw ...middleware) {
+	rt.add(&route{Method: method, Pattern: pattern, Handler: handlerName(h), template: pattern, local: mw, handler: h})
+}
+
+func (rt *router) handleFunc(method, pattern string, f func(http.ResponseWriter, *http.Request), mw ...middleware) {
+	rt.handle(method, pattern, http.HandlerFunc(f), mw...)
+}
+
+func (rt *router) add(rte *route) {
+	for i := len(rte.local) - 1; i >= 0; i-- {
+		rte.handler = rte.local[i].wrap(rte.handler)
+	}
+	rte.segs = strings.Split(strings.Trim(rte.Pattern, "/"), "/")
+	for _, prev := range rt.routes {
+		if prev.Method == rte.Method && sameShape(prev.segs, rte.segs) {
+			rt.errs = append(rt.errs, fmt.Errorf("%s %s (%s) conflicts with %s %s (%s)",
+				rte.Method, rte.Pattern, rte.Handler, prev.Method, prev.Pattern, prev.Handler))
+			return
+		}
+	}
+	rt.routes = append(rt.routes, rte)
+
+	// Everything up to the first placeholder is the ServeMux pattern:
+	// "/tasks/{id}" → subtree "/tasks/", "/tasks/events" → exact path
+	prefix := rte.Pattern
+	if i := strings.Index(prefix, "{"); i >= 0 {
+		prefix = prefix[:i]
+	}
+	if _, seen := rt.byMux[prefix]; !seen {
+		rt.mux.Handle(prefix, rt.dispatch(prefix))
+	}
+	rt.byMux[prefix] = append(rt.byMux[prefix], rte)
+}
+
+// -----------------------------------------------------------
+// API VERSIONS — resource routes live under /v1/... and /v2/...;
+// a version that changes response shapes says so to its handlers
+// (routeVersion), and older clients keep what they had. v2 so
+// far only wraps lists in an envelope (see writeList). The paths
+// from before versioning (/tasks) stay as deprecated aliases of
+// v1: same handler, plus Deprecation and a Link to the /v1 path.
+// -----------------------------------------------------------
+
+// apiVersion registers routes under one version prefix
+type apiVersion struct {
+	rt     *router
+	name   string // "v1" → /v1/...
+	legacy bool   // also answer at the bare path, deprecated
+}
+
+// version returns the registration layer for /<name>/...
+func (rt *router) version(name string) *apiVersion {
+	return &apiVersion{rt: rt, name: name}
+}
+
+// withLegacyPaths also registers every route of v without the prefix.
+// Only one version can own the bare paths — a second one's routes
+// conflict like any other duplicate.
+func (v *apiVersion) withLegacyPaths() *apiVersion {
+	v.legacy = true
+	return v
+}
+
+// handle registers h at /<version>pattern (and at pattern itself for
+// the legacy version). pattern is unversioned: "/tasks/{id}".
+func (v *apiVersion) handle(method, pattern string, h http.Handler, mw ...middleware) {
+	name := handlerName(h)
+	v.rt.add(&route{Method: method, Pattern: "/" + v.name + pattern, Handler: name,
+		Version: v.name, template: pattern, local: mw, handler: h})
+	if v.legacy {
+		v.rt.add(&route{Method: method, Pattern: pattern, Handler: name,
+			Version: v.name, Deprecated: true, template: pattern, local: mw, handler: h})
+	}
+}
+
+func (v *apiVersion) handleFunc(method, pattern string, f func(http.ResponseWriter, *http.Request), mw ...middleware) {
+	v.handle(method, pattern, http.HandlerFunc(f), mw...)
+}
+
+// apiVersions registers a route in several versions at once — the
+// routes every version has, until one of them needs its own handler
+type apiVersions []*apiVersion
+
+func (vs apiVersions) handle(method, pattern string, h http.Handler, mw ...middleware) {
+	for _, v := range vs {
+		v.handle(method, pattern, h, mw...)
+	}
+}
+
+func (vs apiVersions) handleFunc(method, pattern string, f func(http.ResponseWriter, *http.Request), mw ...middleware) {
+	for _, v := range vs {
+		v.handleFunc(method, pattern, f, mw...)
+	}
+}
+
+// versionKey — the version of the matched route ("" = unversioned)
+var versionKey = requestctx.NewKey[string]("api_version")
+
+// routeVersion — "v1", "v2", or "" for unversioned routes (and
+// handlers called without the router)
+func routeVersion(r *http.Request) string {
+	v, _ := versionKey.Get(r.Context())
+	return v
+}
+
+// deprecation marks responses from legacy paths (draft-ietf-httpapi-
+// deprecation-header) and links the versioned path. It runs near the
+// top of the chain, so rejections (429, spec violations, bad IDs)
+// carry the headers too.
+func (rt *router) deprecation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rte := rt.match(r); rte != nil && rte.Deprecated {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", fmt.Sprintf(`</%s%s>; rel="successor-version"`, rte.Version, r.URL.EscapedPath()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// err reports every conflicting registration at once
+func (rt *router) err() error {
+	if len(rt.errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(rt.errs))
+	for i, err := range rt.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Errorf("conflicting routes: %s", strings.Join(msgs, "; "))
+}
+
+// dispatch serves one ServeMux pattern: the path picks the route(s),
+// the method picks the handler. A known path with the wrong method is
+// 405 with an Allow header; an unknown path is 404.
+func (rt *router) dispatch(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, rte := range rt.byMux[prefix] {
+			if !rte.matches(r.URL.Path) {
+				continue
+			}
+			if rte.Method == r.Method {
+				params, err := rt.pathParams(rte, r.URL.Path)
+				if err != nil {
+					writeError(w, r, err)
+					return
+				}
+				h := rte.handler
+				if rte.canary != nil && variantOf(r) == variantCanary {
+					h = rte.canary
+				}
+				ctx := versionKey.With(paramsKey.With(r.Context(), params), rte.Version)
+				h.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			allowed = append(allowed, rte.Method)
+		}
+		if len(allowed) == 0 {
+			notFound(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		methodNotAllowed(w, r)
+	})
+}
+
+// pathParams pulls the {placeholder} values out of path and runs their
+// checks
+func (rt *router) pathParams(rte *route, path string) (map[string]string, error) {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	params := map[string]string{}
+	for i, s := range rte.segs {
+		if !isParam(s) {
+			continue
+		}
+		name := strings.Trim(s, "{}")
+		if check := rt.params[name]; check != nil {
+			if err := check(segs[i]); err != nil {
+				return nil, err
+			}
+		}
+		params[name] = segs[i]
+	}
+	return params, nil
+}
+
+// paramsKey — {placeholder} values of the matched route
+var paramsKey = requestctx.NewKey[map[string]string]("route_params")
+
+// pathParam — the value of {name} in the matched route ("" if none)
+func pathParam(r *http.Request, name string) string {
+	params, _ := paramsKey.Get(r.Context())
+	return params[name]
+}
+
+// lookup returns the pattern a request was routed by, or "" — metrics
+// use it so "/v1/tasks/42" and "/v1/tasks/43" share one label (and
+// calls to the deprecated "/tasks/{id}" show up on their own)
+func (rt *router) lookup(r *http.Request) string {
+	if rte := rt.match(r); rte != nil {
+		return rte.Pattern
+	}
+	return ""
+}
+
+// template is lookup without the version: "/tasks/{id}" for both
+// /v1/tasks/42 and /tasks/42. The middleware exemptions (longLived,
+// ownNaming, ...) are keyed by it, so they hold for every version.
+func (rt *router) template(r *http.Request) string {
+	if rte := rt.match(r); rte != nil {
+		return rte.template
+	}
+	return ""
+}
+
+func (rt *router) match(r *http.Request) *route {
+	_, prefix := rt.mux.Handler(r)
+	for _, rte := range rt.byMux[prefix] {
+		if rte.matches(r.URL.Path) {
+			return rte
+		}
+	}
+	return nil
+}
+
+// use sets the middleware chain, outermost first, and returns the
+// wrapped handler
+func (rt *router) use(chain ...middleware) http.Handler {
+	rt.chain = chain
+	rt.mux.HandleFunc("/", notFound) // problem+json instead of ServeMux's text 404
+
+	var h http.Handler = rt.mux
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = rt.skipping(chain[i], h)
+	}
+	return h
+}
+
+// skipping — m around next, but for the routes in m.skip, which go
+// straight to next. The one place skip is applied, so what list shows
+// is what runs.
+func (rt *router) skipping(m middleware, next http.Handler) http.Handler {
+	wrapped := m.wrap(next)
+	if len(m.skip) == 0 {
+		return wrapped
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.skip[rt.template(r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		wrapped.ServeHTTP(w, r)
+	})
+}
+
+// skipEither — the routes in any of sets, for a middleware that steps
+// aside for more than one reason
+func skipEither(sets ...map[string]bool) map[string]bool {
+	out := map[string]bool{}
+	for _, set := range sets {
+		for t := range set {
+			out[t] = true
+		}
+	}
+	return out
+}
+
+// list is what GET /admin/routes returns: each route with the
+// middleware that actually runs for it, shared chain then its own
+func (rt *router) list() []route {
+	out := make([]route, len(rt.routes))
+	for i, rte := range rt.routes {
+		out[i] = *rte
+		out[i].Middleware = []string{}
+		for _, m := range rt.chain {
+			if !m.skip[rte.template] {
+				out[i].Middleware = append(out[i].Middleware, m.name)
+			}
+		}
+		for _, m := range rte.local {
+			out[i].Middleware = append(out[i].Middleware, m.name)
+		}
+	}
+	return out
+}
+
+func (rte *route) matches(path string) bool {
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	if len(segs) != len(rte.segs) {
+		return false
+	}
+	for i, s := range rte.segs {
+		if isParam(s) {
+			if segs[i] == "" {
+				return false
+			}
+		} else if s != segs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameShape — "/tasks/{id}" and "/tasks/{taskID}" are the same route
+func sameShape(a, b []string) bool {
+	return slices.EqualFunc(a, b, func(x, y string) bool {
+		return x == y || (isParam(x) && isParam(y))
+	})
+}
+
+func isParam(seg string) bool {
+	return strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}")
+}
+
+// handlerName — "(*App).handleListTasks" for methods, the type name for
+// other handlers (e.g. promhttp's)
+func handlerName(h http.Handler) string {
+	if s, ok := h.(fmt.Stringer); ok { // crudHandler
+		return s.String()
+	}
+	f, ok := h.(http.HandlerFunc)
+	if !ok {
+		return fmt.Sprintf("%T", h)
+	}
+	// "main.(*App).handleListTasks-fm" — drop the package, and the
+	// suffix the compiler gives method values
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	if _, rest, ok := strings.Cut(name, "."); ok {
+		name = rest
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apperr.New(apperr.RouteNotFound, "no route for "+r.URL.Path))
+}
+
+// methodNotAllowed — the path exists, the method doesn't (Allow is set
+// by dispatch)
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apperr.New(apperr.MethodNotAllowed, r.Method+" is not supported on "+r.URL.Path))
+}
+
+// routeTemplate — app.Router.template, or the raw path when there's
+// no router (handlers wired up by hand)
+func (app *App) routeTemplate(r *http.Request) string {
+	if app.Router == nil {
+		return r.URL.Path
+	}
+	return app.Router.template(r)
+}
+
+// GET /admin/routes — the registry, for "which handler matched?"
+func (app *App) handleListRoutes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.Router.list())
+}
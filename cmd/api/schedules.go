@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// SCHEDULES — the periodic jobs (trash purge, recurrence,
+// escalation, reminders) run on internal/schedule: their next
+// run is kept in the schedules table, so it survives restarts,
+// each run is spread by its jitter, and a misfire policy
+// decides about runs missed while nothing was up.
+//   GET /admin/schedules — every job's interval, next run and
+//                          last outcome
+// -----------------------------------------------------------
+
+// scheduled — the schedule.Job for a job running every interval. The
+// advisory locks are the ones the old tickers held, so an instance
+// still on them never runs a job at the same time as the scheduler.
+func scheduled(name, lock string, every time.Duration, cfg config.ScheduleConfig, run func(ctx context.Context) error) schedule.Job {
+	return schedule.Job{
+		Name: name, Lock: lock, Every: every,
+		Jitter: cfg.Jitter, Misfire: cfg.Misfire,
+		Run: run,
+	}
+}
+
+// GET /admin/schedules
+func (app *App) handleListSchedules(w http.ResponseWriter, r *http.Request) {
+	list, err := app.Schedules.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
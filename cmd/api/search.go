@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// SEARCH — GET /tasks/search?q=write te
+// Live tasks whose title has every word of q (or a word
+// starting with it), best matches first, paginated like
+// GET /tasks. The matching itself is Postgres full-text
+// search; see repository/search.go.
+// -----------------------------------------------------------
+
+const (
+	maxSearchLen   = 200 // characters of q
+	maxSearchTerms = 10
+)
+
+// searchTerms splits q into lowercase words of letters and digits;
+// punctuation separates words and is otherwise ignored
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// GET /tasks/search?q= — full-text search over task titles
+func (app *App) handleSearchTasks(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/tasks/search")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	q := r.URL.Query().Get("q")
+	terms := searchTerms(q)
+	switch {
+	case len([]rune(q)) > maxSearchLen:
+		err = apperr.New(apperr.InvalidParam, fmt.Sprintf("q is longer than %d characters", maxSearchLen))
+	case len(terms) == 0:
+		err = apperr.New(apperr.InvalidParam, "q must contain at least one word")
+	case len(terms) > maxSearchTerms:
+		err = apperr.New(apperr.InvalidParam, fmt.Sprintf("q has more than %d words", maxSearchTerms))
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	results, err := app.Tasks.Search(r.Context(), terms, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, results)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/broker"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// SELF-CHECK — misconfiguration found before traffic finds it:
+//   go run ./cmd/api -check  — a report, exit status 1 on a failure
+//   GET /admin/selfcheck     — the same from a running instance
+//                              (503 on a failure)
+// In order: the config loads and is valid, the database answers,
+// its schema has everything the code queries (see
+// repository/schema.go), the broker (if one is configured)
+// answers and takes our credentials. Each check gets
+// checkTimeout; nothing is written anywhere.
+// -----------------------------------------------------------
+
+const checkTimeout = 3 * time.Second
+
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+type CheckResult struct {
+	Name   string  `json:"name"`
+	Status string  `json:"status"`           // pass, fail or skip
+	Detail string  `json:"detail,omitempty"` // what was found, the failure, or why it was skipped
+	TookMS float64 `json:"took_ms"`
+}
+
+type SelfCheckReport struct {
+	Status string        `json:"status"` // fail if any check failed
+	Checks []CheckResult `json:"checks"`
+}
+
+// check — one entry of the report; a nil run is skipped, for skip
+type check struct {
+	name string
+	run  func(ctx context.Context) (detail string, err error)
+	skip string
+}
+
+// skipped — what run returns when the check can't be made (a check it
+// depends on failed)
+type skipped string
+
+func (s skipped) Error() string { return string(s) }
+
+// selfChecks — the checks for a config (cfgErr when it didn't load)
+// and the database pool and broker it describes (nil = none)
+func selfChecks(cfg *config.Config, cfgErr error, db *pgxpool.Pool, pub broker.Publisher) []check {
+	checks := []check{{name: "config", run: func(context.Context) (string, error) { return "", cfgErr }}}
+	if cfgErr != nil {
+		for _, name := range []string{"database", "schema", "broker"} {
+			checks = append(checks, check{name: name, skip: "needs a valid config"})
+		}
+		return checks
+	}
+
+	dbUp := false
+	checks = append(checks,
+		check{name: "database", run: func(ctx context.Context) (string, error) {
+			var version string
+			if err := db.QueryRow(ctx, "SHOW server_version").Scan(&version); err != nil {
+				return "", fmt.Errorf("%s:%d/%s: %w", cfg.DB.Host, cfg.DB.Port, cfg.DB.Name, err)
+			}
+			dbUp = true
+			return fmt.Sprintf("%s:%d/%s, PostgreSQL %s", cfg.DB.Host, cfg.DB.Port, cfg.DB.Name, version), nil
+		}},
+		check{name: "schema", run: func(ctx context.Context) (string, error) {
+			if !dbUp {
+				return "", skipped("needs the database")
+			}
+			missing, err := repository.CheckSchema(ctx, db)
+			if err != nil {
+				return "", err
+			}
+			if len(missing) > 0 {
+				return "", fmt.Errorf(`missing %s (see "Existing databases" in init.sql)`, strings.Join(missing, ", "))
+			}
+			return "up to date", nil
+		}},
+	)
+	if pub == nil {
+		return append(checks, check{name: "broker", skip: "not configured (broker.type)"})
+	}
+	return append(checks, check{name: "broker", run: func(ctx context.Context) (string, error) {
+		return cfg.Broker.Type, pub.Ping(ctx)
+	}})
+}
+
+// runChecks runs checks in order, each with checkTimeout
+func runChecks(ctx context.Context, checks []check) SelfCheckReport {
+	report := SelfCheckReport{Status: checkPass, Checks: []CheckResult{}}
+	for _, c := range checks {
+		res := CheckResult{Name: c.name, Status: checkSkip, Detail: c.skip}
+		if c.run != nil {
+			cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			start := time.Now()
+			detail, err := c.run(cctx)
+			cancel()
+			res.TookMS = float64(time.Since(start).Microseconds()) / 1000
+			var skip skipped
+			switch {
+			case errors.As(err, &skip):
+				res.Status, res.Detail, res.TookMS = checkSkip, string(skip), 0
+			case err != nil:
+				res.Status, res.Detail = checkFail, err.Error()
+				report.Status = checkFail
+			default:
+				res.Status, res.Detail = checkPass, detail
+			}
+		}
+		report.Checks = append(report.Checks, res)
+	}
+	return report
+}
+
+// runSelfCheck is cmd/api -check: the exit status is 1 if a check
+// failed. args are os.Args[1:].
+func runSelfCheck(ctx context.Context, args []string, out io.Writer) int {
+	cfg, err := config.Load(args)
+	var (
+		db  *pgxpool.Pool
+		pub broker.Publisher
+	)
+	if err == nil {
+		if db, err = pgxpool.New(ctx, cfg.DB.ConnString()); err == nil { // connects on first use
+			defer db.Close()
+		}
+	}
+	if err == nil && cfg.Broker.Type != "" {
+		if pub, err = broker.New(cfg.Broker.Type, cfg.Broker.URL, cfg.Broker.Timeout); err == nil {
+			defer pub.Close()
+		}
+	}
+	report := runChecks(ctx, selfChecks(cfg, err, db, pub))
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, c := range report.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.ToUpper(c.Status), c.name(), c.Detail)
+	}
+	tw.Flush()
+	if report.Status == checkFail {
+		return 1
+	}
+	return 0
+}
+
+func (c CheckResult) name() string {
+	if c.Status == checkSkip {
+		return c.Name
+	}
+	return fmt.Sprintf("%s (%.0fms)", c.Name, c.TookMS)
+}
+
+// GET /admin/selfcheck — the config is loaded again from the same
+// arguments, env and file: it fails if a restart would
+func (app *App) handleSelfCheck(w http.ResponseWriter, r *http.Request) {
+	cfg, err := config.Load(os.Args[1:])
+	report := runChecks(r.Context(), selfChecks(cfg, err, app.DB, app.Broker))
+	status := http.StatusOK
+	if report.Status == checkFail {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/oidc"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/schedule"
+)
+
+// -----------------------------------------------------------
+// SESSIONS — cookie logins (auth.sessions), for server-rendered
+// apps that won't keep a bearer token:
+//   POST /auth/login   — Authorization: Bearer <access token> in,
+//                        session cookie out (HttpOnly, Secure,
+//                        SameSite=Lax); or log in with OIDC at
+//                        /auth/oidc/login?session=true
+//   GET  /auth/session — the session and its CSRF token
+//   POST /auth/logout  — ends it, clears the cookie
+// A request with the cookie and no Authorization acts as the
+// session's user. Its writes (anything but GET, HEAD, OPTIONS)
+// must also send X-CSRF-Token: the session's token, which a
+// page on another site can't read — otherwise 403 CSRF_FAILED.
+// -----------------------------------------------------------
+
+const (
+	sessionCookie = "sandbox_session"
+	csrfHeader    = "X-CSRF-Token"
+	// sessionPurgeLock — one instance purges at a time
+	sessionPurgeLock = "sessions:purge"
+)
+
+// SessionResponse — a session as its browser sees it; send csrf_token
+// as X-CSRF-Token with every write
+type SessionResponse struct {
+	User      User      `json:"user"`
+	CSRFToken string    `json:"csrf_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// safeMethods — reads, which need no CSRF token
+var safeMethods = map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true}
+
+// withSession authenticates a session cookie's value; a write must
+// carry the session's CSRF token as well
+func (app *App) withSession(ctx context.Context, r *http.Request, value string) (context.Context, error) {
+	s, err := app.Sessions.Lookup(ctx, hashAPIKey(value))
+	if errors.Is(err, repository.ErrNotFound) {
+		return ctx, apperr.New(apperr.Unauthenticated, "the session has expired or ended; log in again")
+	}
+	if err != nil {
+		return ctx, err
+	}
+	if !safeMethods[r.Method] && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(s.CSRFToken)) != 1 {
+		return ctx, apperr.New(apperr.CSRFFailed, "writes with a session cookie need its token in "+csrfHeader+" (see GET /auth/session)")
+	}
+	ctx = withPrincipal(ctx, principal{UserID: s.UserID, OrgID: s.OrgID, Session: &s})
+	return requestctx.WithLogger(ctx, requestctx.Logger(ctx).With("user_id", s.UserID, "session", s.ID)), nil
+}
+
+// startSession logs u in on this browser: a new session and its cookie
+func (app *App) startSession(w http.ResponseWriter, r *http.Request, u User) {
+	secret := oidc.RandomString()
+	s, err := app.Sessions.Create(r.Context(), repository.NewSession{
+		UserID:    u.ID,
+		Hash:      hashAPIKey(secret),
+		CSRFToken: oidc.RandomString(),
+		TTL:       app.SessionTTL,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	http.SetCookie(w, app.sessionCookie(secret, s.ExpiresAt))
+	w.Header().Set("Cache-Control", "no-store")
+	requestctx.Logger(r.Context()).Info("session started", "user_id", u.ID, "session", s.ID)
+	writeJSON(w, http.StatusOK, SessionResponse{User: u, CSRFToken: s.CSRFToken, ExpiresAt: s.ExpiresAt})
+}
+
+// sessionCookie — value "" with a past expiry clears it
+func (app *App) sessionCookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   app.SessionSecure,
+		SameSite: http.SameSiteLaxMode,
+		Expires:  expires,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// POST /auth/login — the caller must have authenticated with an access
+// token; an API key is a service's, and doesn't get a session
+func (app *App) handleLogin(w http.ResponseWriter, r *http.Request) {
+	p, ok := principalCtx.Get(r.Context())
+	if !ok || p.Key != nil || p.Session != nil {
+		w.Header().Set("WWW-Authenticate", bearerScheme)
+		writeError(w, r, apperr.New(apperr.Unauthenticated, "log in with Authorization: Bearer <access token>, from GET /auth/oidc/callback"))
+		return
+	}
+	u, err := app.Users.Get(requestctx.WithOrgID(r.Context(), p.OrgID), p.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	app.startSession(w, r, u)
+}
+
+// GET /auth/session
+func (app *App) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	p, _ := principalCtx.Get(r.Context())
+	if p.Session == nil {
+		writeError(w, r, apperr.New(apperr.Unauthenticated, "no session: log in at POST /auth/login"))
+		return
+	}
+	u, err := app.Users.Get(requestctx.WithOrgID(r.Context(), p.OrgID), p.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, SessionResponse{User: u, CSRFToken: p.Session.CSRFToken, ExpiresAt: p.Session.ExpiresAt})
+}
+
+// POST /auth/logout — without a session, only clears the cookie
+func (app *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if p, _ := principalCtx.Get(r.Context()); p.Session != nil {
+		if err := app.Sessions.Delete(r.Context(), p.Session.ID); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	http.SetCookie(w, app.sessionCookie("", time.Time{}))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessionPurgeJob deletes expired sessions every cfg.PurgeInterval
+func (app *App) sessionPurgeJob(cfg config.SessionConfig) schedule.Job {
+	return scheduled("session_purge", sessionPurgeLock, cfg.PurgeInterval, cfg.Schedule, func(ctx context.Context) error {
+		n, err := app.Sessions.Purge(ctx)
+		if err != nil {
+			app.Log.Error("session purge failed", "err", err)
+			return err
+		}
+		if n > 0 {
+			app.Log.Info("sessions purged", "sessions", n)
+		}
+		return nil
+	})
+}
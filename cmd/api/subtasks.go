@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// SUBTASKS — GET /tasks/{id}/subtasks
+//   default     direct subtasks, paginated like GET /tasks
+//   ?tree=true  every level below, nested:
+//               [{"id":2, ..., "subtasks":[{"id":5, ...}]}]
+// A task gets a parent with parent_id on create or update;
+// the repository refuses cycles (TASK_CYCLE).
+// -----------------------------------------------------------
+
+// maxTreeDepth — levels returned by ?tree=true; real task trees are a
+// handful deep, this only stops a runaway query
+const maxTreeDepth = 32
+
+// TaskNode — a task with its subtasks; in flat mode subtasks is empty
+type TaskNode struct {
+	Task
+	Subtasks []*TaskNode `json:"subtasks,omitempty"`
+}
+
+// GET /tasks/{id}/subtasks — subtasks of a task
+func (app *App) handleListSubtasks(w http.ResponseWriter, r *http.Request) {
+	id := pathID(r)
+
+	q := r.URL.Query()
+	tree := false
+	if s := q.Get("tree"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("tree %q must be true or false", s)))
+			return
+		}
+		tree = b
+	}
+
+	if !tree {
+		page, err := app.pageParams(w, r, "/tasks/{id}/subtasks")
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		if _, err := app.Tasks.Get(r.Context(), id); err != nil {
+			writeError(w, r, err) // 404, not an empty list, for a missing parent
+			return
+		}
+		tasks, err := app.Tasks.List(r.Context(), repository.TaskFilter{ParentID: &id}, page)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		writeList(w, r, page, tasks)
+		return
+	}
+
+	// A tree is all or nothing — a page of it would have holes
+	if q.Has("limit") || q.Has("offset") {
+		writeError(w, r, apperr.New(apperr.InvalidPage, "limit and offset don't apply with tree=true"))
+		return
+	}
+	tasks, err := app.Tasks.Subtree(r.Context(), id, maxTreeDepth)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, repository.Page{}, nest(id, tasks))
+}
+
+// nest builds the tree under root from tasks ordered parents first
+// (as Subtree returns them)
+func nest(root int, tasks []Task) []*TaskNode {
+	nodes := make(map[int]*TaskNode, len(tasks))
+	top := []*TaskNode{}
+	for _, t := range tasks {
+		n := &TaskNode{Task: t}
+		nodes[t.ID] = n
+		switch parent := nodes[*t.ParentID]; {
+		case *t.ParentID == root:
+			top = append(top, n)
+		case parent != nil:
+			parent.Subtasks = append(parent.Subtasks, n)
+		}
+	}
+	return top
+}
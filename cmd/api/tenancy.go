@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// TENANCY — users and tasks belong to an organization, and a
+// request acts in exactly one:
+//   X-Org-ID: 2        (gRPC: x-org-id metadata)
+// or tenancy.default_org when it sends none; an authenticated
+// request acts in its user's (authn.go), and naming another is
+// 403. tenant checks the organization exists and puts it in the
+// context; from there the repositories scope every query to it
+// (repository/org.go), so another organization's rows are 404s,
+// whatever a handler does. An anonymous request is 401 unless
+// auth.anonymous_role gives it a role; then it may name any.
+// Unversioned routes are left alone — probes, docs, /admin
+// (which scopes itself, see authz.go) and /integrations (each
+// source's org_id) — except /graphql, which is scoped too.
+// An organization in tenancy.dedicated has its data apart: the
+// request's repository calls go to its pool (repository/
+// tenantdb.go), held until the request ends.
+// -----------------------------------------------------------
+
+const orgHeader = "X-Org-ID"
+
+type Org = repository.Org
+
+type CreateOrgRequest struct {
+	Name string `json:"name"`
+}
+
+func (req CreateOrgRequest) validate() error {
+	return validate.New().
+		Required("name", req.Name).
+		MaxLen("name", req.Name, maxNameLen). // organizations.name VARCHAR(100)
+		Err()
+}
+
+// tenantFree — templates of the routes tenant leaves alone: every
+// unversioned route but /graphql
+func tenantFree(rt *router) map[string]bool {
+	free := map[string]bool{}
+	for _, rte := range rt.routes {
+		if rte.Version == "" && rte.template != "/graphql" {
+			free[rte.template] = true
+		}
+	}
+	return free
+}
+
+// tenant rejects a request to a scoped route that names no existing
+// organization (400 ORG_REQUIRED, 404 ORG_NOT_FOUND); unknown paths go
+// on to their 404
+func (app *App) tenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.routeTemplate(r) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, err := app.withOrg(r.Context(), r.Header.Get(orgHeader))
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tenantRPC — tenant for gRPC calls, on the x-org-id metadata
+func (app *App) tenantRPC(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(orgHeader); len(v) > 0 {
+			header = v[0]
+		}
+	}
+	ctx, err := app.withOrg(ctx, header)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return handler(ctx, req)
+}
+
+// withOrg resolves the organization named by header ("" = the
+// default, or the caller's) and adds it to ctx and its logger
+func (app *App) withOrg(ctx context.Context, header string) (context.Context, error) {
+	id := app.DefaultOrg
+	p, authenticated := principalCtx.Get(ctx)
+	if authenticated {
+		id = p.OrgID
+	} else if app.AnonymousRole == "" {
+		// No rights, reading included: any organization's data is
+		// someone's
+		return ctx, apperr.New(apperr.Unauthenticated, "authenticate to do this: an API key, an access token or a session")
+	}
+	if header != "" {
+		n, err := validate.ParseID(header)
+		if err != nil {
+			return ctx, apperr.New(apperr.OrgRequired, fmt.Sprintf("%s %q is not an organization ID", orgHeader, header))
+		}
+		if authenticated && n != p.OrgID {
+			return ctx, apperr.New(apperr.Forbidden, fmt.Sprintf("%s is for organization %d", p, p.OrgID))
+		}
+		id = n
+	}
+	if id == 0 {
+		return ctx, apperr.New(apperr.OrgRequired, "send the organization's ID in "+orgHeader)
+	}
+	return app.enterOrg(ctx, id)
+}
+
+// enterOrg — ctx acting in organization id, which must exist
+func (app *App) enterOrg(ctx context.Context, id int) (context.Context, error) {
+	if err := app.checkOrg(ctx, id); err != nil {
+		return ctx, err
+	}
+	if app.Tenants != nil && app.Tenants.Dedicated(id) {
+		pool, release, err := app.Tenants.Acquire(ctx, id)
+		if errors.Is(err, repository.ErrPoolsExhausted) {
+			return ctx, apperr.Wrap(apperr.TenantUnavailable, "the organization's database is busy; try again", err)
+		}
+		if err != nil {
+			return ctx, err
+		}
+		context.AfterFunc(ctx, release) // the request's context ends with it
+		ctx = repository.WithDB(ctx, pool)
+	}
+	ctx = requestctx.WithOrgID(ctx, id)
+	return requestctx.WithLogger(ctx, requestctx.Logger(ctx).With("org_id", id)), nil
+}
+
+// eachTenantDB runs fn on the shared database, then in each dedicated
+// organization — for the sweeps over tasks, whose schema holds its own.
+// One failing doesn't stop the rest; the errors are joined.
+func (app *App) eachTenantDB(ctx context.Context, fn func(ctx context.Context) error) error {
+	errs := []error{fn(ctx)}
+	if app.Tenants == nil {
+		return errs[0]
+	}
+	for _, org := range app.Tenants.Orgs() {
+		if ctx.Err() != nil {
+			break
+		}
+		errs = append(errs, func() error {
+			ctx, cancel := context.WithCancel(ctx) // releases the pool enterOrg holds
+			defer cancel()
+			ctx, err := app.enterOrg(ctx, org)
+			if err != nil {
+				app.Log.Error("dedicated organization unreachable", "org_id", org, "err", err)
+				return err
+			}
+			return fn(ctx)
+		}())
+	}
+	return errors.Join(errs...)
+}
+
+// checkOrg — organizations are never deleted, so one found lately is
+// taken from OrgCache; an ID that isn't there is looked up every time
+func (app *App) checkOrg(ctx context.Context, id int) error {
+	if app.Orgs == nil {
+		return nil // no database: fakes in a test
+	}
+	if app.OrgCache == nil {
+		_, err := app.Orgs.Get(ctx, id)
+		return err
+	}
+	_, err := app.OrgCache.Get(ctx, id, func(ctx context.Context) (repository.Org, error) {
+		return app.Orgs.Get(ctx, id)
+	})
+	return err
+}
+
+// GET /admin/orgs
+func (app *App) handleListOrgs(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/admin/orgs")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	list, err := app.Orgs.List(r.Context(), page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, list)
+}
+
+// GET /admin/tenants — the dedicated organizations' open pools
+func (app *App) handleListTenantPools(w http.ResponseWriter, r *http.Request) {
+	stats := []repository.TenantPoolStat{}
+	if app.Tenants != nil {
+		stats = app.Tenants.Stats()
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// POST /admin/orgs — create an organization
+func (app *App) handleCreateOrg(w http.ResponseWriter, r *http.Request) {
+	var req CreateOrgRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	org, err := app.Orgs.Create(r.Context(), req.Name)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, org)
+}
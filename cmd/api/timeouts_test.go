@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/config"
+	"sandbox-go/internal/events"
+)
+
+// The server's timeouts, short enough for a test; the event stream and
+// the long poll have to outlast them
+const (
+	testHeaderTimeout = 200 * time.Millisecond
+	testWriteTimeout  = 200 * time.Millisecond
+)
+
+// timeoutServer — the API's routes behind newServer's timeouts, on a
+// real listener (a recorder has no deadlines to test)
+func timeoutServer(t *testing.T) (*httptest.Server, *App) {
+	t.Helper()
+	// Never dialled: these routes don't touch the database
+	pool, err := pgxpool.New(context.Background(), "postgres://test@127.0.0.1:1/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	app := &App{
+		Metrics:       newMetrics(pool),
+		Events:        events.NewBus(16),
+		Log:           log,
+		Pages:         config.Defaults().Pagination,
+		AnonymousRole: "admin",
+		DefaultOrg:    1,
+	}
+	app.ready.Store(true)
+	handler, err := app.routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Defaults().Server
+	cfg.ReadHeaderTimeout = testHeaderTimeout
+	cfg.ReadTimeout = testWriteTimeout
+	cfg.WriteTimeout = testWriteTimeout
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config = newServer("", handler, cfg, log)
+	srv.Start()
+	t.Cleanup(srv.Close)
+	t.Cleanup(app.Events.Close)
+	return srv, app
+}
+
+// A client trickling its headers is cut off after read_header_timeout
+func TestSlowHeadersAreCut(t *testing.T) {
+	srv, _ := timeoutServer(t)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn) // returns when the server hangs up
+		close(closed)
+	}()
+	if _, err := io.WriteString(conn, "GET /healthz HTTP/1.1\r\nHost: test\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		select {
+		case <-closed:
+			if elapsed := time.Since(start); elapsed < testHeaderTimeout {
+				t.Fatalf("connection closed after %v, before the header timeout", elapsed)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+		if time.Since(start) > 10*testHeaderTimeout {
+			t.Fatal("a client that never finishes its headers is still connected")
+		}
+		io.WriteString(conn, "X") // one byte of a header that never ends
+	}
+}
+
+// The long poll waits past write_timeout and still answers
+func TestLongPollOutlastsWriteTimeout(t *testing.T) {
+	srv, app := timeoutServer(t)
+	time.AfterFunc(3*testWriteTimeout, func() {
+		app.Events.Publish(taskCreated, 1, map[string]int{"id": 1})
+	})
+
+	res, err := http.Get(srv.URL + "/v1/tasks/events/poll?wait=1")
+	if err != nil {
+		t.Fatalf("long poll cut off: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", res.StatusCode)
+	}
+	var page EventPage
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatalf("long poll response: %v", err)
+	}
+	if len(page.Events) != 1 || page.Events[0].Type != taskCreated {
+		t.Fatalf("events %+v, want the one task.created", page.Events)
+	}
+}
+
+// The event stream still delivers once write_timeout has passed
+func TestEventStreamOutlastsWriteTimeout(t *testing.T) {
+	srv, app := timeoutServer(t)
+	res, err := http.Get(srv.URL + "/v1/tasks/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d, want 200", res.StatusCode)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	time.AfterFunc(3*testWriteTimeout, func() {
+		app.Events.Publish(taskCreated, 1, map[string]int{"id": 1})
+	})
+	deadline := time.After(10 * testWriteTimeout)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("event stream ended before the event")
+			}
+			if strings.HasPrefix(line, "event: ") {
+				if got := strings.TrimPrefix(line, "event: "); got != taskCreated {
+					t.Fatalf("event %q, want %q", got, taskCreated)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("no event on the stream")
+		}
+	}
+}
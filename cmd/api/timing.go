@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// SERVER-TIMING — where did the 300ms go?
+// Handlers (and helpers) record phases into the request
+// context; the response carries them in a Server-Timing header
+// that browser devtools show under "Timing":
+//   Server-Timing: decode;dur=0.21, db;dur=12.53;desc="3 queries",
+//                  encode;dur=0.40, total;dur=14.08
+// The same numbers go into the request log line.
+//   decode — decodeJSON
+//   db     — every pgx query, via dbTracer (no handler changes)
+//   encode — writeJSON
+// Custom phases: defer startPhase(r.Context(), "render")()
+// -----------------------------------------------------------
+
+type phase struct {
+	name  string
+	dur   time.Duration
+	count int
+}
+
+// timings collects the phases of one request. GraphQL resolvers run
+// concurrently, hence the mutex.
+type timings struct {
+	start time.Time
+
+	mu     sync.Mutex
+	phases []phase // first-recorded order; repeats add up
+}
+
+var timingsKey = requestctx.NewKey[*timings]("timings")
+
+func timingsFrom(ctx context.Context) *timings {
+	t, _ := timingsKey.Get(ctx)
+	return t
+}
+
+// add records d under name; nil-safe, so code running outside a
+// request (warm-up, background jobs) can call it freely
+func (t *timings) add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.phases {
+		if t.phases[i].name == name {
+			t.phases[i].dur += d
+			t.phases[i].count++
+			return
+		}
+	}
+	t.phases = append(t.phases, phase{name: name, dur: d, count: 1})
+}
+
+// startPhase starts timing name; call the returned func when it's done
+func startPhase(ctx context.Context, name string) func() {
+	t := timingsFrom(ctx)
+	start := time.Now()
+	return func() { t.add(name, time.Since(start)) }
+}
+
+// header renders the Server-Timing value, with total up to now
+func (t *timings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, 0, len(t.phases)+1)
+	for _, p := range t.phases {
+		s := fmt.Sprintf("%s;dur=%.2f", p.name, ms(p.dur))
+		if p.name == "db" {
+			s += fmt.Sprintf(`;desc="%d queries"`, p.count)
+		}
+		parts = append(parts, s)
+	}
+	parts = append(parts, fmt.Sprintf("total;dur=%.2f", ms(time.Since(t.start))))
+	return strings.Join(parts, ", ")
+}
+
+// logAttr — the phases as one "timing" group for the request log
+func (t *timings) logAttr() slog.Attr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	attrs := make([]any, 0, len(t.phases))
+	for _, p := range t.phases {
+		attrs = append(attrs, slog.Float64(p.name+"_ms", ms(p.dur)))
+	}
+	return slog.Group("timing", attrs...)
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// serverTiming puts a timings collector into the context and sets the
+// header just before the status line goes out. Runs inside requestID,
+// outside logRequests so the log line can include the phases.
+func serverTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := &timings{start: time.Now()}
+		r = r.WithContext(timingsKey.With(r.Context(), t))
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, t: t}, r)
+	})
+}
+
+// timingWriter adds Server-Timing to the headers on the first write
+type timingWriter struct {
+	http.ResponseWriter
+	t     *timings
+	wrote bool
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	if !tw.wrote {
+		tw.wrote = true
+		tw.Header().Set("Server-Timing", tw.t.header())
+	}
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(p []byte) (int, error) {
+	if !tw.wrote {
+		tw.WriteHeader(http.StatusOK)
+	}
+	return tw.ResponseWriter.Write(p)
+}
+
+func (tw *timingWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// timingsOf finds the collector from a response writer, through the
+// middleware wrappers — writeJSON gets no request to ask
+func timingsOf(w http.ResponseWriter) *timings {
+	for {
+		switch x := w.(type) {
+		case *timingWriter:
+			return x.t
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = x.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// dbTracer is a pgx.QueryTracer that charges every query's time to the
+// request's "db" phase and gives it a span (see tracing.go), and a
+// pgxpool.AcquireTracer feeding load (see load.go)
+type dbTracer struct {
+	load *loadTracker // nil = don't track acquires
+}
+
+// queryStartKey — start of the query in flight; pgx hands the context
+// from TraceQueryStart to TraceQueryEnd
+var queryStartKey = requestctx.NewKey[time.Time]("query_start")
+
+func (dbTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx = startQuerySpan(ctx, data.SQL)
+	return queryStartKey.With(ctx, time.Now())
+}
+
+func (dbTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if start, ok := queryStartKey.Get(ctx); ok {
+		timingsFrom(ctx).add("db", time.Since(start))
+	}
+	endQuerySpan(ctx, data)
+}
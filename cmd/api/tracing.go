@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/tracing"
+)
+
+// -----------------------------------------------------------
+// TRACING — OpenTelemetry spans, when tracing.endpoint is set
+// (internal/tracing sends them):
+//   GET /v1/tasks/{id}   the request, child of the caller's
+//                        traceparent if it sent one
+//     SELECT             each pgx query (dbTracer)
+//     POST               each outbound call (internal/httpclient,
+//                        which passes traceparent on)
+// The request log line has the trace_id, to go from one to the
+// other. Health checks and /metrics aren't traced.
+// -----------------------------------------------------------
+
+// maxStatement — db.statement is cut here; the queries are ours and
+// carry no values ($1, $2, ...), only their length is a concern
+const maxStatement = 2048
+
+// traceRequests starts the request's server span. Runs outside
+// logRequests, so the logger can carry the trace ID.
+func (app *App) traceRequests(route func(*http.Request) string, next http.Handler) http.Handler {
+	if app.Tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, ok := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); ok {
+			ctx = tracing.WithRemote(ctx, sc)
+		}
+		name, pattern := r.Method, route(r)
+		if pattern != "" {
+			name += " " + pattern
+		}
+		ctx, span := app.Tracer.Start(ctx, name, tracing.KindServer,
+			tracing.String("http.request.method", r.Method),
+			tracing.String("http.route", pattern),
+			tracing.String("url.path", r.URL.Path),
+			tracing.String("client.address", requestctx.ClientIP(ctx)),
+			tracing.String("user_agent.original", r.UserAgent()),
+			tracing.String("request_id", requestctx.RequestID(ctx)),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(tracing.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.Fail(http.StatusText(rec.status))
+		}
+	})
+}
+
+// querySpanKey — the span of the query in flight; a key of its own, so
+// a query outside a trace doesn't end the request's span
+var querySpanKey = requestctx.NewKey[*tracing.Span]("query_span")
+
+func startQuerySpan(ctx context.Context, sql string) context.Context {
+	op := "query"
+	if f := strings.Fields(sql[:min(len(sql), 32)]); len(f) > 0 {
+		op = strings.ToUpper(f[0]) // SELECT, INSERT, WITH, ...
+	}
+	ctx, span := tracing.Child(ctx, op, tracing.KindClient,
+		tracing.String("db.system", "postgresql"),
+		tracing.String("db.statement", sql[:min(len(sql), maxStatement)]),
+	)
+	if span == nil {
+		return ctx
+	}
+	return querySpanKey.With(ctx, span)
+}
+
+func endQuerySpan(ctx context.Context, data pgx.TraceQueryEndData) {
+	span, ok := querySpanKey.Get(ctx)
+	if !ok {
+		return
+	}
+	span.SetError(data.Err)
+	span.SetAttributes(tracing.Int("db.rows_affected", int(data.CommandTag.RowsAffected())))
+	span.End()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// TRANSACTION PER REQUEST — TRANSACTION_PER_REQUEST=true runs
+// every mutating request in one transaction: each repository
+// call the handler makes joins it (see repository/tx.go), and it
+// commits only if the response is 2xx/3xx. A 4xx/5xx, a panic or
+// a failed commit rolls back everything the request wrote, so a
+// multi-step handler never leaves half its work behind.
+// The response is held back until the commit: a client is never
+// told 200 for writes that were then lost. Events the handler
+// records go to the outbox in this transaction (see outbox.go), so
+// a rolled-back request sends none.
+// -----------------------------------------------------------
+
+// ownTransactions — routes left out: GraphQL resolves fields
+// concurrently, and one transaction is one connection
+var ownTransactions = map[string]bool{"/graphql": true}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func (app *App) requestTx(next http.Handler) http.Handler {
+	if !app.RequestTx {
+		return next // disabled (TRANSACTION_PER_REQUEST=false)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tx, err := repository.Begin(r.Context(), app.DB) // a dedicated tenant's pool, if tenant chose one
+		if err != nil {
+			writeError(w, r, fmt.Errorf("begin request transaction: %w", err))
+			return
+		}
+		// Also on panic, which then carries on up; a timed-out context
+		// must not stop the rollback itself
+		defer tx.Rollback(context.WithoutCancel(r.Context())) // no-op after Commit
+
+		tw := &txWriter{ResponseWriter: w, header: w.Header().Clone(), status: http.StatusOK}
+		next.ServeHTTP(tw, r.WithContext(repository.WithTx(r.Context(), tx)))
+
+		if tw.status >= http.StatusBadRequest {
+			tx.Rollback(context.WithoutCancel(r.Context()))
+		} else if err := tx.Commit(r.Context()); err != nil {
+			writeError(w, r, fmt.Errorf("commit request transaction: %w", err)) // the handler's response is dropped
+			return
+		}
+		tw.flush()
+	})
+}
+
+// txWriter holds the whole response — headers included, so a dropped
+// response leaves no ETag or Location behind — until the outcome of
+// the transaction is known
+type txWriter struct {
+	http.ResponseWriter
+	header  http.Header
+	status  int
+	decided bool
+	body    bytes.Buffer
+}
+
+func (tw *txWriter) Header() http.Header { return tw.header }
+
+func (tw *txWriter) WriteHeader(status int) {
+	if !tw.decided {
+		tw.decided, tw.status = true, status
+	}
+}
+
+func (tw *txWriter) Write(p []byte) (int, error) {
+	tw.WriteHeader(http.StatusOK)
+	return tw.body.Write(p)
+}
+
+func (tw *txWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+func (tw *txWriter) flush() {
+	maps.Copy(tw.ResponseWriter.Header(), tw.header)
+	tw.ResponseWriter.WriteHeader(tw.status)
+	tw.ResponseWriter.Write(tw.body.Bytes())
+}
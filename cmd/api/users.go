@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// MODELS
+// User itself lives in internal/repository.
+// -----------------------------------------------------------
+
+type User = repository.User
+
+type CreateUserRequest struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  string `json:"role,omitempty"` // admin, member (default) or viewer
+}
+
+type UpdateUserRequest struct {
+	Name  *string `json:"name,omitempty"`
+	Email *string `json:"email,omitempty"`
+}
+
+// users.name VARCHAR(100), users.email VARCHAR(255)
+const (
+	maxNameLen  = 100
+	maxEmailLen = 255
+)
+
+func (req CreateUserRequest) validate() error {
+	v := validate.New().
+		Required("name", req.Name).
+		MaxLen("name", req.Name, maxNameLen).
+		Required("email", req.Email).
+		MaxLen("email", req.Email, maxEmailLen).
+		Email("email", req.Email)
+	if req.Role != "" {
+		v.OneOf("role", req.Role, roleNames)
+	}
+	return v.Err()
+}
+
+func (req UpdateUserRequest) validate() error {
+	v := validate.New()
+	if req.Name != nil {
+		v.Required("name", *req.Name).MaxLen("name", *req.Name, maxNameLen)
+	}
+	if req.Email != nil {
+		v.Required("email", *req.Email).MaxLen("email", *req.Email, maxEmailLen).Email("email", *req.Email)
+	}
+	return v.Err()
+}
+
+// -----------------------------------------------------------
+// HANDLERS
+// The five routes of /users are registerCRUD's (crud.go); EMAIL_TAKEN
+// and LAST_ADMIN come from the repository.
+// -----------------------------------------------------------
+
+// userCRUD — the user repository as registerCRUD takes it
+type userCRUD struct {
+	repository.UserRepository
+}
+
+func (u userCRUD) Create(ctx context.Context, req CreateUserRequest) (User, error) {
+	return u.UserRepository.Create(ctx, repository.NewUser{
+		Name:  req.Name,
+		Email: req.Email,
+		Role:  req.Role,
+	})
+}
+
+func (u userCRUD) Update(ctx context.Context, id int, req UpdateUserRequest) (User, error) {
+	return u.UserRepository.Update(ctx, id, repository.UserUpdate{
+		Name:  req.Name,
+		Email: req.Email,
+	})
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/repository"
+)
+
+// -----------------------------------------------------------
+// WARM-UP
+// Without this, the first requests after a deploy pay for the
+// TCP + TLS + auth handshake and for preparing each statement.
+// -----------------------------------------------------------
+
+// warmUp opens MinConns connections at once (holding them so the pool
+// can't hand back the same one twice), pings each and prepares the hot
+// statements on it. pgx looks prepared statements up by SQL text, so
+// naming them after their own SQL makes handlers reuse them.
+func warmUp(ctx context.Context, pool *pgxpool.Pool) error {
+	n := int(pool.Config().MinConns)
+	if n < 1 {
+		n = 1
+	}
+
+	conns := make([]*pgxpool.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Release()
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		c, err := pool.Acquire(ctx)
+		if err != nil {
+			return fmt.Errorf("acquire conn %d/%d: %w", i+1, n, err)
+		}
+		conns = append(conns, c)
+
+		if err := c.Ping(ctx); err != nil {
+			return fmt.Errorf("ping conn %d/%d: %w", i+1, n, err)
+		}
+		for _, sql := range repository.HotStatements {
+			if _, err := c.Conn().Prepare(ctx, sql, sql); err != nil {
+				return fmt.Errorf("prepare %q: %w", sql, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// warmUpUntilReady retries warm-up until it succeeds (e.g. the database
+// is still starting), then marks the app ready so /readyz returns 200.
+func (app *App) warmUpUntilReady(ctx context.Context, retryEvery time.Duration) {
+	for {
+		start := time.Now()
+		err := warmUp(ctx, app.DB)
+		if err == nil {
+			app.ready.Store(true)
+			app.Log.Info("database pool warmed up, ready for traffic", "took", time.Since(start))
+			return
+		}
+		app.Log.Warn("warm-up failed, retrying", "retry_in", retryEvery, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryEvery):
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/authz"
+	"sandbox-go/internal/httpclient"
+	"sandbox-go/internal/jobs"
+	"sandbox-go/internal/repository"
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/validate"
+)
+
+// -----------------------------------------------------------
+// OUTBOUND WEBHOOKS — users register URLs (/webhooks) and get a
+// signed POST whenever one of their tasks is created, updated,
+// completed or deleted. The outbox relay (outbox.go) logs a
+// delivery per webhook; a webhook.delivery job posts it, so
+// failures retry with the queue's backoff and every attempt ends
+// up in GET /webhooks/{id}/deliveries. Deliveries run in parallel:
+// a receiver that cares about order goes by the payload's id,
+// which follows the commits within one task.
+// (Inbound webhooks from other services are integrations.go.)
+//
+// Receivers verify X-Webhook-Signature: "sha256=" + hex
+// HMAC-SHA256(secret, X-Webhook-Timestamp + "." + body), and may
+// reject old timestamps to stop replays. X-Webhook-Delivery is
+// the same on every retry of one delivery, and the payload's id
+// on every delivery of one event (to dedupe on).
+// -----------------------------------------------------------
+
+const (
+	webhookDeliveryKind = "webhook.delivery"
+	webhookTimeout      = 10 * time.Second // per POST
+	maxWebhookURLLen    = 2048
+	maxWebhookResponse  = 64 << 10 // read (and drop) at most this much of a reply
+)
+
+// webhookEvents — what a webhook can subscribe to
+var webhookEvents = []string{taskCreated, taskUpdated, taskCompleted, taskDeleted}
+
+// newWebhookClient — App.WebhookClient. It doesn't follow redirects: a
+// receiver that moved has to be updated, not silently followed
+// somewhere else. Nor does it retry: the job queue does, with the
+// delivery log to show for it. Unless allowPrivate, it dials public
+// addresses only.
+func newWebhookClient(allowPrivate bool) *http.Client {
+	cfg := httpclient.Config{
+		Name:        "webhooks",
+		Timeout:     webhookTimeout,
+		NoRedirects: true,
+	}
+	if !allowPrivate {
+		cfg.DialControl = publicOnly
+	}
+	return httpclient.New(cfg)
+}
+
+// errInternalAddr — a webhook resolved to an address it may not reach
+var errInternalAddr = errors.New("not a public address")
+
+// errDeliveryFailed — what the delivery log shows of a POST that got no
+// response: the dial error itself would map the network behind us
+var errDeliveryFailed = errors.New("connection failed")
+
+// publicOnly — the webhook client's DialControl. It sees the address
+// after DNS, so a name that resolves inside (or starts to, once
+// registered) is refused like a literal one.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if internalAddr(ip) {
+		return errInternalAddr
+	}
+	return nil
+}
+
+// internalAddr — loopback, private, link-local (169.254.169.254: a
+// cloud's metadata service), unspecified or multicast
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// internalHost — the URL's host is internal as written: localhost, or
+// an address internalAddr refuses. Names are left to publicOnly.
+func internalHost(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && internalAddr(ip)
+}
+
+type CreateWebhookRequest struct {
+	UserID int      `json:"user_id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+type UpdateWebhookRequest struct {
+	URL    *string   `json:"url,omitempty"`
+	Events *[]string `json:"events,omitempty"`
+	Active *bool     `json:"active,omitempty"`
+}
+
+// webhookURL — absolute http(s) with a host
+func webhookURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkWebhook — allowPrivate is webhooks.allow_private
+func checkWebhook(v *validate.Validator, u string, events []string, allowPrivate bool) {
+	v.Required("url", u).
+		MaxLen("url", u, maxWebhookURLLen).
+		Check("url", webhookURL(u), "must be an absolute http or https URL").
+		Check("url", allowPrivate || !internalHost(u), "must not be a loopback, private or link-local address").
+		Check("events", len(events) > 0, "required")
+	for _, e := range events {
+		v.OneOf("events", e, webhookEvents)
+	}
+}
+
+func (req CreateWebhookRequest) validate(allowPrivate bool) error {
+	v := validate.New().ID("user_id", req.UserID)
+	checkWebhook(v, req.URL, req.Events, allowPrivate)
+	return v.Err()
+}
+
+func (req UpdateWebhookRequest) validate(allowPrivate bool) error {
+	v := validate.New()
+	if req.URL != nil || req.Events != nil {
+		u, events := "http://unchanged", []string{taskCreated}
+		if req.URL != nil {
+			u = *req.URL
+		}
+		if req.Events != nil {
+			events = *req.Events
+		}
+		checkWebhook(v, u, events, allowPrivate)
+	}
+	return v.Err()
+}
+
+// normalizeEvents — sorted, each once
+func normalizeEvents(events []string) []string {
+	events = slices.Clone(events)
+	slices.Sort(events)
+	return slices.Compact(events)
+}
+
+// newWebhookSecret — 32 random bytes, hex
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// GET /webhooks — registered webhooks (?user_id=), secrets left out
+func (app *App) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/webhooks")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	var userID int
+	if s := r.URL.Query().Get("user_id"); s != "" {
+		if userID, err = validate.ParseID(s); err != nil {
+			writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("user_id %q must be a user ID", s)))
+			return
+		}
+	}
+
+	hooks, err := app.Webhooks.List(r.Context(), userID, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeList(w, r, page, hooks)
+}
+
+// POST /webhooks — the response is the only time the secret is shown
+func (app *App) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req CreateWebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := req.validate(app.PrivateWebhooks); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := app.authorize(r.Context(), authz.Write, req.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if _, err := app.Users.Get(r.Context(), req.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hook, err := app.Webhooks.Create(r.Context(), repository.NewWebhook{
+		UserID: req.UserID,
+		URL:    req.URL,
+		Events: normalizeEvents(req.Events),
+		Secret: newWebhookSecret(),
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, hook)
+}
+
+// GET /webhooks/{id}
+func (app *App) handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, err := app.Webhooks.Get(r.Context(), pathID(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// PUT /webhooks/{id} — change the URL or events, or pause it
+// (active: false)
+func (app *App) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req UpdateWebhookRequest
+	if err := decodeJSON(r, &req); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := req.validate(app.PrivateWebhooks); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Events != nil {
+		events := normalizeEvents(*req.Events)
+		req.Events = &events
+	}
+	if err := app.authorizeWebhook(r.Context(), pathID(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hook, err := app.Webhooks.Update(r.Context(), pathID(r), repository.WebhookUpdate{
+		URL:    req.URL,
+		Events: req.Events,
+		Active: req.Active,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	hook.Secret = ""
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// DELETE /webhooks/{id} — its delivery log goes with it
+func (app *App) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if err := app.authorizeWebhook(r.Context(), pathID(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := app.Webhooks.Delete(r.Context(), pathID(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /webhooks/{id}/deliveries — the delivery log, newest first
+// (?status=pending|delivered|failed)
+func (app *App) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	page, err := app.pageParams(w, r, "/webhooks/{id}/deliveries")
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", repository.DeliveryPending, repository.DeliveryDelivered, repository.DeliveryFailed:
+	default:
+		writeError(w, r, apperr.New(apperr.InvalidParam, fmt.Sprintf("status %q must be pending, delivered or failed", status)))
+		return
+	}
+	id := pathID(r)
+	if _, err := app.Webhooks.Get(r.Context(), id); err != nil {
+		writeError(w, r, err) // 404 rather than an empty log
+		return
+	}
+
+	log, err := app.Webhooks.Deliveries(r.Context(), id, status, page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeList(w, r, page, log)
+}
+
+// -----------------------------------------------------------
+// DISPATCH AND DELIVERY
+// -----------------------------------------------------------
+
+// WebhookPayload — the body of every delivery
+type WebhookPayload struct {
+	ID    int64           `json:"id"` // the event's; the same in a repeated delivery
+	Event string          `json:"event"`
+	Time  time.Time       `json:"time"`
+	Data  json.RawMessage `json:"data"` // a Task; TaskDeleted for task.deleted
+}
+
+// deliveryJob — payload of a webhook.delivery job
+type deliveryJob struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// dispatchWebhooks logs and queues one delivery per webhook subscribed
+// to an outbox event. The relay may pass the same event again; both
+// steps are then no-ops.
+func (app *App) dispatchWebhooks(ctx context.Context, e repository.OutboxEvent) error {
+	if !slices.Contains(webhookEvents, e.Type) {
+		return nil
+	}
+	hooks, err := app.Webhooks.Subscribed(ctx, e.Type, e.TaskID)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	body, err := json.Marshal(WebhookPayload{ID: e.ID, Event: e.Type, Time: e.CreatedAt, Data: e.Data})
+	if err != nil {
+		return fmt.Errorf("encode %s payload: %w", e.Type, err)
+	}
+	for _, hook := range hooks {
+		d, err := app.Webhooks.CreateDelivery(ctx, hook.ID, e.ID, e.Type, body)
+		if err != nil {
+			return err
+		}
+		_, err = app.Jobs.Enqueue(ctx, jobs.NewJob{
+			Kind:      webhookDeliveryKind,
+			Payload:   deliveryJob{DeliveryID: d.ID},
+			Key:       fmt.Sprintf("%s:%d", webhookDeliveryKind, d.ID),
+			RequestID: e.RequestID, // the change's, not the relay's
+		})
+		if err != nil {
+			return fmt.Errorf("queue delivery %d: %w", d.ID, err)
+		}
+	}
+	return nil
+}
+
+// deliverWebhook handles a webhook.delivery job: one POST, recorded in
+// the delivery log. An error makes the queue retry it.
+func (app *App) deliverWebhook(ctx context.Context, job jobs.Job) error {
+	var dj deliveryJob
+	if err := json.Unmarshal(job.Payload, &dj); err != nil {
+		return jobs.Permanent(fmt.Errorf("payload: %w", err))
+	}
+	d, hook, err := app.Webhooks.Delivery(ctx, dj.DeliveryID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil // the webhook was deleted
+	}
+	if err != nil {
+		return err
+	}
+	if d.Status == repository.DeliveryDelivered {
+		return nil // a repeat run of a job that got through
+	}
+
+	var status int
+	if !hook.Active {
+		// Paused after this was queued: fail it now rather than retry
+		// into a pause that may last for good
+		err = jobs.Permanent(errors.New("webhook is paused"))
+	} else {
+		status, err = app.postWebhook(ctx, hook, d)
+	}
+
+	attempt := repository.DeliveryAttempt{ResponseStatus: status}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.Final = job.Attempts >= job.MaxAttempts || jobs.IsPermanent(err)
+	}
+	if recErr := app.Webhooks.RecordAttempt(ctx, d.ID, attempt); recErr != nil {
+		app.Log.Error("webhooks: record attempt", "delivery_id", d.ID, "err", recErr)
+	}
+	return err
+}
+
+// postWebhook sends a delivery; anything but a 2xx is a failure
+func (app *App) postWebhook(ctx context.Context, hook repository.Webhook, d repository.Delivery) (int, error) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, jobs.Permanent(errors.New("invalid URL"))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "sandbox-go-webhooks")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", ts)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signWebhook(hook.Secret, ts, d.Payload))
+	// The client adds X-Request-ID: the request that made the change
+
+	resp, err := app.WebhookClient.Do(req)
+	if err != nil {
+		// Ours to see, not the webhook's owner: it tells one port, or
+		// host, inside from another
+		requestctx.Logger(ctx).Warn("webhook delivery failed",
+			"webhook_id", hook.ID, "delivery_id", d.ID, "err", err)
+		return 0, errDeliveryFailed
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxWebhookResponse)) // lets the connection be reused
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook — hex HMAC-SHA256 of "timestamp.body"
+func signWebhook(secret, ts string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
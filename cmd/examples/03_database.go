@@ -19,6 +19,8 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/config"
 )
 
 // -----------------------------------------------------------
@@ -46,17 +48,13 @@ type Task struct {
 // Go:  pgxpool.New(ctx, connString)
 // -----------------------------------------------------------
 func connectDB() *pgxpool.Pool {
-	// Build connection string from environment
-	host := getEnv("DB_HOST", "localhost")
-	port := getEnv("DB_PORT", "5432")
-	user := getEnv("DB_USER", "gouser")
-	pass := getEnv("DB_PASSWORD", "gopass")
-	name := getEnv("DB_NAME", "sandbox")
-
-	connStr := fmt.Sprintf(
-		"postgres://%s:%s@%s:%s/%s?sslmode=disable",
-		user, pass, host, port, name,
-	)
+	// Same settings as the API: env vars (DB_HOST, ...), flags or a YAML
+	// file — see internal/config
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid config: %v\n", err)
+	}
+	connStr := cfg.DB.ConnString()
 
 	// pgxpool = connection pool (like PHP's persistent connections)
 	ctx := context.Background()
@@ -74,13 +72,6 @@ func connectDB() *pgxpool.Pool {
 	return pool
 }
 
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
-	}
-	return fallback
-}
-
 // -----------------------------------------------------------
 // SELECT MULTIPLE ROWS
 // PHP: $stmt = $pdo->query("SELECT ..."); $rows = $stmt->fetchAll();
@@ -1,28 +1,331 @@
 -- Sample schema for practicing Go + DB
-CREATE TABLE IF NOT EXISTS users (
+
+-- Organizations: the tenants. Users belong to one, tasks to their
+-- user's; every request acts in one (X-Org-ID, see cmd/api/tenancy.go)
+CREATE TABLE IF NOT EXISTS organizations (
     id          SERIAL PRIMARY KEY,
     name        VARCHAR(100) NOT NULL,
-    email       VARCHAR(255) UNIQUE NOT NULL,
     created_at  TIMESTAMP DEFAULT NOW()
 );
 
+CREATE TABLE IF NOT EXISTS users (
+    id          SERIAL PRIMARY KEY,
+    org_id      INT NOT NULL REFERENCES organizations(id),
+    name        VARCHAR(100) NOT NULL,
+    email       VARCHAR(255) NOT NULL,
+    -- admin: everything; member: their own tasks and webhooks; viewer: reads (internal/authz)
+    role        VARCHAR(16) NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member', 'viewer')),
+    created_at  TIMESTAMP DEFAULT NOW(),
+    -- argon2id (or bcrypt) hash; NULL = no password, logs in with OIDC if at all
+    password_hash  TEXT,
+    failed_logins  INT NOT NULL DEFAULT 0,   -- wrong passwords since the last right one
+    locked_until   TIMESTAMPTZ,              -- after auth.passwords.max_failures of them
+    UNIQUE (org_id, email)   -- taken within one organization; also lists an organization's users
+);
+-- Existing databases: INSERT INTO organizations (name) VALUES ('Default');  -- id 1
+--                     ALTER TABLE users ADD COLUMN IF NOT EXISTS org_id INT NOT NULL DEFAULT 1 REFERENCES organizations(id);
+--                     ALTER TABLE users ALTER COLUMN org_id DROP DEFAULT;
+--                     ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+--                     ALTER TABLE users ADD CONSTRAINT users_org_id_email_key UNIQUE (org_id, email);
+--                     ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(16) NOT NULL DEFAULT 'member'
+--                         CHECK (role IN ('admin', 'member', 'viewer'));
+--                     UPDATE users SET role = 'admin' WHERE id IN (SELECT min(id) FROM users GROUP BY org_id);
+--                     ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT,
+--                         ADD COLUMN IF NOT EXISTS failed_logins INT NOT NULL DEFAULT 0,
+--                         ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
 CREATE TABLE IF NOT EXISTS tasks (
     id          SERIAL PRIMARY KEY,
+    org_id      INT NOT NULL REFERENCES organizations(id),  -- the user's; here so every query can filter on it
     user_id     INT REFERENCES users(id) ON DELETE CASCADE,
+    parent_id   INT REFERENCES tasks(id) ON DELETE SET NULL,  -- NULL = top level; purging a parent promotes its subtasks
     title       VARCHAR(255) NOT NULL,
     done        BOOLEAN DEFAULT FALSE,
-    created_at  TIMESTAMP DEFAULT NOW()
+    priority    VARCHAR(10) NOT NULL DEFAULT 'medium' CHECK (priority IN ('low', 'medium', 'high')),
+    due_date    TIMESTAMP,          -- NULL = no deadline
+    recurrence  VARCHAR(100),       -- NULL = one-off; daily, weekly or a cron expression
+    created_at  TIMESTAMP DEFAULT NOW(),
+    version     INT NOT NULL DEFAULT 1,            -- +1 on every write; optimistic locking and the ETag
+    updated_at  TIMESTAMP NOT NULL DEFAULT NOW(),  -- bumped on every write
+    title_search TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', title)) STORED,  -- GET /tasks/search
+    deleted_at  TIMESTAMP           -- NULL = live, set = in the trash
 );
+-- Existing databases: ALTER TABLE tasks ADD COLUMN IF NOT EXISTS org_id INT NOT NULL DEFAULT 1 REFERENCES organizations(id);
+--                     ALTER TABLE tasks ALTER COLUMN org_id DROP DEFAULT;
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT NOW();
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS priority VARCHAR(10) NOT NULL DEFAULT 'medium'
+--                         CHECK (priority IN ('low', 'medium', 'high'));
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS due_date TIMESTAMP;
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS parent_id INT REFERENCES tasks(id) ON DELETE SET NULL;
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS recurrence VARCHAR(100);
+--                     ALTER TABLE tasks ADD COLUMN IF NOT EXISTS title_search TSVECTOR
+--                         GENERATED ALWAYS AS (to_tsvector('english', title)) STORED;
+-- Every request's task queries filter on the organization
+CREATE INDEX IF NOT EXISTS tasks_org_id_idx ON tasks (org_id, id);
+-- The purge job scans trashed rows only
+CREATE INDEX IF NOT EXISTS tasks_deleted_at_idx ON tasks (deleted_at) WHERE deleted_at IS NOT NULL;
+-- GET /tasks/{id}/subtasks and the recursive tree walk
+CREATE INDEX IF NOT EXISTS tasks_parent_id_idx ON tasks (parent_id) WHERE parent_id IS NOT NULL;
+-- ?overdue=true and ?sort=due_date
+CREATE INDEX IF NOT EXISTS tasks_due_date_idx ON tasks (due_date) WHERE due_date IS NOT NULL;
+-- Full-text search on titles (the @@ in GET /tasks/search)
+CREATE INDEX IF NOT EXISTS tasks_title_search_idx ON tasks USING GIN (title_search);
+-- The recurrence scheduler only looks at the newest occurrence of each series
+CREATE INDEX IF NOT EXISTS tasks_recurrence_idx ON tasks (id) WHERE recurrence IS NOT NULL AND deleted_at IS NULL;
+
+-- Tags: created on first use, shared by every task that carries them
+CREATE TABLE IF NOT EXISTS tags (
+    id    SERIAL PRIMARY KEY,
+    name  VARCHAR(50) UNIQUE NOT NULL   -- lowercase; the API normalizes
+);
+
+CREATE TABLE IF NOT EXISTS task_tags (
+    task_id  INT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,  -- purge drops the links too
+    tag_id   INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (task_id, tag_id)
+);
+-- ?tag=urgent looks up tasks by tag; the primary key covers task → tags
+CREATE INDEX IF NOT EXISTS task_tags_tag_id_idx ON task_tags (tag_id);
+
+-- Escalation log: one row per (task, rule) that fired; the unique key
+-- is what keeps a rule from firing twice (see repository/escalation.go)
+CREATE TABLE IF NOT EXISTS task_escalations (
+    id            SERIAL PRIMARY KEY,
+    task_id       INT NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    rule          VARCHAR(100) NOT NULL,   -- escalation.rules[].name in the config
+    priority_from VARCHAR(10) NOT NULL,
+    priority_to   VARCHAR(10) NOT NULL,
+    escalated_at  TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (task_id, rule)
+);
+
+-- Background job queue (see internal/jobs). Done jobs stay until the
+-- cleanup so their key keeps deduplicating; dead ones until retried.
+CREATE TABLE IF NOT EXISTS jobs (
+    id           BIGSERIAL PRIMARY KEY,
+    kind         VARCHAR(100) NOT NULL,
+    key          VARCHAR(255) UNIQUE,      -- optional; enqueueing a known key is a no-op
+    payload      JSONB NOT NULL DEFAULT '{}',
+    priority     INT NOT NULL DEFAULT 0,   -- higher runs first (jobs.PriorityHigh, ...)
+    status       VARCHAR(10) NOT NULL DEFAULT 'queued'
+                 CHECK (status IN ('queued', 'running', 'done', 'dead')),
+    attempts     INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at       TIMESTAMP NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP,                -- lease of the worker running it
+    last_error   TEXT,
+    request_id   VARCHAR(128),             -- of the request that enqueued it, for the logs
+    created_at   TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at  TIMESTAMP
+);
+-- What workers claim: due queued jobs, and running ones whose lease ran
+-- out — by priority, and oldest first for the fair claims
+-- Existing databases: ALTER TABLE jobs ADD COLUMN IF NOT EXISTS priority INT NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS jobs_due_idx ON jobs (run_at, id) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS jobs_due_priority_idx ON jobs (priority DESC, run_at, id) WHERE status IN ('queued', 'running');
+
+-- Periodic jobs (see internal/schedule): the slot of each one's next
+-- run and when it actually starts (slot + jitter), by the database's
+-- clock, so restarts and replicas agree on it
+CREATE TABLE IF NOT EXISTS schedules (
+    name             VARCHAR(50) PRIMARY KEY,
+    due_at           TIMESTAMP NOT NULL,
+    next_run_at      TIMESTAMP NOT NULL,
+    last_started_at  TIMESTAMP,
+    last_finished_at TIMESTAMP,
+    last_error       TEXT,              -- NULL = the last run went fine
+    runs             BIGINT NOT NULL DEFAULT 0,
+    missed           BIGINT NOT NULL DEFAULT 0  -- slots a misfire policy didn't run
+);
+
+-- Outbox: task events, written in the transaction of the change
+-- (see repository/outbox.go). The relay numbers them (seq) in id
+-- order; relayed ones are deleted after outbox.retention.
+CREATE SEQUENCE IF NOT EXISTS outbox_seq;
+CREATE TABLE IF NOT EXISTS outbox (
+    id         BIGSERIAL PRIMARY KEY,
+    type       VARCHAR(50) NOT NULL,       -- task.created, task.updated, ...
+    task_id    INT NOT NULL,               -- no FK: a purged task keeps its history
+    org_id     INT,                        -- the task's; event streams only pass on the caller's
+    data       JSONB NOT NULL,
+    request_id VARCHAR(128),               -- of the change; passed on to the jobs it leads to
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    seq        BIGINT UNIQUE,              -- NULL = not relayed yet
+    relayed_at TIMESTAMP
+);
+-- Existing databases: ALTER TABLE outbox ADD COLUMN IF NOT EXISTS org_id INT;
+-- What the relay takes next
+CREATE INDEX IF NOT EXISTS outbox_unrelayed_idx ON outbox (id) WHERE seq IS NULL;
+
+-- How far a reader of the relayed events has got (the broker
+-- publisher: cmd/api/broker.go); cleanup keeps what it hasn't read
+CREATE TABLE IF NOT EXISTS outbox_cursors (
+    name       VARCHAR(50) PRIMARY KEY,
+    seq        BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Outbound webhooks (see cmd/api/webhooks.go): a user's URL and the
+-- task events it wants, signed with its secret
+CREATE TABLE IF NOT EXISTS webhooks (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url        TEXT NOT NULL,
+    events     TEXT[] NOT NULL,            -- task.created, task.updated, task.completed, task.deleted
+    active     BOOLEAN NOT NULL DEFAULT TRUE,
+    secret     VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+-- Delivery log: one row per (webhook, event), updated on every attempt
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    webhook_id      INT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_id        BIGINT NOT NULL,       -- outbox.id; relaying an event twice logs it once
+    event           VARCHAR(50) NOT NULL,
+    payload         JSONB NOT NULL,
+    status          VARCHAR(10) NOT NULL DEFAULT 'pending'
+                    CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts        INT NOT NULL DEFAULT 0,
+    response_status INT,                   -- of the last attempt; NULL = no response
+    error           TEXT,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMP,
+    delivered_at    TIMESTAMP,
+    UNIQUE (webhook_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, id);
+
+-- API keys (see cmd/api/apikeys.go): a key acts as its user; only the
+-- SHA-256 of the secret is kept
+-- Existing databases: run this CREATE TABLE and its indexes
+CREATE TABLE IF NOT EXISTS api_keys (
+    id               SERIAL PRIMARY KEY,
+    user_id          INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name             VARCHAR(100) NOT NULL,
+    prefix           VARCHAR(16) NOT NULL,       -- the key's first characters, shown in lists
+    hash             CHAR(64) NOT NULL UNIQUE,   -- hex SHA-256 of the key; looked up on every request
+    scope            VARCHAR(8) NOT NULL DEFAULT 'read' CHECK (scope IN ('read', 'write')),
+    rate_limit_rps   DOUBLE PRECISION CHECK (rate_limit_rps > 0),  -- NULL = rate_limit.rps
+    rate_limit_burst INT CHECK (rate_limit_burst >= 1),           -- NULL = rate_limit.burst
+    created_at       TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_used_at     TIMESTAMP,                  -- updated at most once a minute
+    revoked_at       TIMESTAMP,
+    CHECK ((rate_limit_rps IS NULL) = (rate_limit_burst IS NULL))
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id, id);
+
+-- Accounts at OpenID Connect providers, linked to the users who log in
+-- with them (see cmd/api/oidc.go): the subject is the provider's
+-- stable ID for the account
+-- Existing databases: run this CREATE TABLE and its index
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer     TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (issuer, subject)
+);
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+
+-- Cookie sessions (see cmd/api/sessions.go): only the SHA-256 of the
+-- cookie is kept; expired ones are purged
+-- Existing databases: run this CREATE TABLE and its index
+CREATE TABLE IF NOT EXISTS sessions (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    hash       CHAR(64) NOT NULL UNIQUE,   -- hex SHA-256 of the cookie; looked up on every request
+    csrf_token VARCHAR(64) NOT NULL,       -- sent back as X-CSRF-Token on writes
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
+
+-- Refresh tokens (see cmd/api/refresh.go): single-use, each replaced
+-- by the next of its family; only the SHA-256 is kept, and rows stay
+-- until they expire, so a reused one can be recognized
+-- Existing databases: run this CREATE TABLE and its indexes
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         SERIAL PRIMARY KEY,
+    user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family     INT NOT NULL,               -- id of the login's first token
+    hash       CHAR(64) NOT NULL UNIQUE,   -- hex SHA-256 of the token
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP                   -- rotated or revoked; NULL = good
+);
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family);
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);
+
+-- Events already handled by an internal consumer (see internal/dedup)
+CREATE TABLE IF NOT EXISTS processed_events (
+    consumer     VARCHAR(100) NOT NULL,
+    event_id     VARCHAR(255) NOT NULL,
+    processed_at TIMESTAMP DEFAULT NOW(),
+    expires_at   TIMESTAMP NOT NULL,
+    PRIMARY KEY (consumer, event_id)
+);
+CREATE INDEX IF NOT EXISTS processed_events_expires_at_idx ON processed_events (expires_at);
+
+-- Audit log: every write to a task, user or webhook, with the record
+-- before and after (see repository/audit.go). Not purged: it outlives
+-- the rows it describes.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id         BIGSERIAL PRIMARY KEY,
+    entity     VARCHAR(20) NOT NULL,       -- task, user, webhook
+    entity_id  INT NOT NULL,               -- no FK: entries stay after a delete
+    action     VARCHAR(20) NOT NULL,       -- create, update, delete, restore, purge
+    org_id     INT,                        -- the record's organization; NULL = from before tenancy
+    actor_id   INT,                        -- the authenticated user; NULL = anonymous or background
+    request_id VARCHAR(128),
+    client_ip  INET,                       -- the request's client, past trusted proxies
+    changed    TEXT[] NOT NULL DEFAULT '{}',
+    old_values JSONB,                      -- NULL on create
+    new_values JSONB,                      -- NULL when the row is gone
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+-- Existing databases: ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS org_id INT;
+--                     ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS client_ip INET;
+-- GET /tasks/{id}/audit, and ?org_id= / ?user_id= / ?from=&to= on /admin/audit
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_org_idx ON audit_log (org_id, id);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, created_at) WHERE actor_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+
+-- Unusual client traffic found by internal/abuse, for GET /admin/anomalies;
+-- purged after abuse.retention
+-- Existing databases: run this CREATE TABLE and its indexes
+CREATE TABLE IF NOT EXISTS anomalies (
+    id         BIGSERIAL PRIMARY KEY,
+    client     VARCHAR(128) NOT NULL,      -- key:<id>, user:<id> or ip:<address>
+    kind       VARCHAR(32) NOT NULL,       -- rate_spike, error_spike, new_endpoint, enumeration
+    route      TEXT NOT NULL DEFAULT '',   -- template of the request that tipped it
+    detail     TEXT NOT NULL,
+    org_id     INT,                        -- the client's, if it authenticated
+    request_id VARCHAR(128),
+    client_ip  INET,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS anomalies_client_idx ON anomalies (client, id);
+CREATE INDEX IF NOT EXISTS anomalies_org_idx ON anomalies (org_id, id);
+CREATE INDEX IF NOT EXISTS anomalies_created_at_idx ON anomalies (created_at);
+
+-- Seed data: one organization (send X-Org-ID: 1, or run with
+-- TENANCY_DEFAULT_ORG=1)
+INSERT INTO organizations (name) VALUES ('Demo');
 
--- Seed data
-INSERT INTO users (name, email) VALUES
-    ('Alice', 'alice@example.com'),
-    ('Bob', 'bob@example.com'),
-    ('Charlie', 'charlie@example.com');
+INSERT INTO users (org_id, name, email, role) VALUES
+    (1, 'Alice', 'alice@example.com', 'admin'),
+    (1, 'Bob', 'bob@example.com', 'member'),
+    (1, 'Charlie', 'charlie@example.com', 'viewer');
 
-INSERT INTO tasks (user_id, title, done) VALUES
-    (1, 'Learn Go basics', TRUE),
-    (1, 'Build REST API', FALSE),
-    (2, 'Study goroutines', FALSE),
-    (2, 'Practice live coding', FALSE),
-    (3, 'Read about AWS Glue', FALSE);
+INSERT INTO tasks (org_id, user_id, title, done) VALUES
+    (1, 1, 'Learn Go basics', TRUE),
+    (1, 1, 'Build REST API', FALSE),
+    (1, 2, 'Study goroutines', FALSE),
+    (1, 2, 'Practice live coding', FALSE),
+    (1, 3, 'Read about AWS Glue', FALSE);
@@ -0,0 +1,211 @@
+// Package abuse watches each client's traffic for what it doesn't
+// usually do: a burst far above its request rate, a run of failed
+// requests, an endpoint it has never called, a walk through IDs
+// (GET /tasks/1, /tasks/2, ...). It only reports; what to do about an
+// anomaly — alert someone, revoke the key — is up to the caller.
+//
+// Requests are counted in fixed windows, a minute by default. A
+// client's baseline is a moving average over the windows it was
+// active in, so a key that is always busy isn't flagged for being
+// busy, and it only counts once the client has been active in
+// LearnWindows of them. Each kind of anomaly is reported at most once
+// per client and window. Baselines live in memory: a restart learns
+// them again, and each instance learns from the share of the traffic
+// it gets.
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Kind — what was unusual
+type Kind string
+
+const (
+	// RateSpike — far more requests in a window than the client's usual
+	RateSpike Kind = "rate_spike"
+	// ErrorSpike — most of a window's requests failed, unlike before
+	ErrorSpike Kind = "error_spike"
+	// NewEndpoint — a route the client hadn't called in all its windows
+	NewEndpoint Kind = "new_endpoint"
+	// Enumeration — many different IDs in one window: someone listing
+	// records by guessing
+	Enumeration Kind = "enumeration"
+)
+
+type Config struct {
+	Window time.Duration
+	// MinRequests — a window with fewer is never a rate or error spike
+	MinRequests int
+	// RateFactor — a window this many times the baseline is a spike
+	RateFactor float64
+	// ErrorRate — the share of failed requests (4xx but 429, and 5xx)
+	// that is a spike
+	ErrorRate float64
+	// DistinctIDs — as many different {id}s in a window are an
+	// enumeration, with or without a baseline
+	DistinctIDs int
+	// LearnWindows — active windows before the baseline (and the set of
+	// endpoints seen) is trusted
+	LearnWindows int
+	// Forget — a client idle this long is forgotten (see Cleanup)
+	Forget time.Duration
+}
+
+// Request — one answered request
+type Request struct {
+	Client string // who: "key:7", "ip:203.0.113.9", ...
+	Route  string // its template, "/tasks/{id}"; "" if none matched
+	ID     string // its {id}, if the route has one
+	Status int
+}
+
+type Anomaly struct {
+	Client string
+	Kind   Kind
+	Route  string // of the request that tipped it
+	Detail string // for people: "412 requests in 1m0s, usually 20"
+}
+
+// alpha — the newest window's weight in the baselines
+const alpha = 0.2
+
+// maxRoutes — endpoints remembered per client; more than the API has
+const maxRoutes = 512
+
+type Detector struct {
+	cfg Config
+
+	mu      sync.Mutex
+	clients map[string]*client
+	now     func() time.Time
+}
+
+type client struct {
+	start            time.Time // of the current window
+	requests, errors int
+	ids              map[string]struct{} // route and ID, this window
+	reported         map[Kind]bool       // this window
+
+	windows int     // active ones before this
+	rate    float64 // requests per window, moving average
+	errRate float64 // share failed, moving average
+	routes  map[string]bool
+	last    time.Time
+}
+
+func NewDetector(cfg Config) *Detector {
+	return &Detector{cfg: cfg, clients: map[string]*client{}, now: time.Now}
+}
+
+// Observe counts req and returns what it made unusual, if anything
+func (d *Detector) Observe(req Request) []Anomaly {
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c, ok := d.clients[req.Client]
+	if !ok {
+		c = &client{start: now, ids: map[string]struct{}{}, reported: map[Kind]bool{}, routes: map[string]bool{}}
+		d.clients[req.Client] = c
+	}
+	c.roll(now, d.cfg.Window)
+	c.last = now
+	c.requests++
+	if req.Status >= 400 && req.Status != http.StatusTooManyRequests {
+		c.errors++
+	}
+	learned := c.windows >= d.cfg.LearnWindows
+
+	var found []Anomaly
+	report := func(k Kind, format string, args ...any) {
+		if c.reported[k] {
+			return
+		}
+		c.reported[k] = true
+		found = append(found, Anomaly{Client: req.Client, Kind: k, Route: req.Route, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if req.Route != "" && !c.routes[req.Route] {
+		if learned {
+			report(NewEndpoint, "first call to %s after %d active windows", req.Route, c.windows)
+		}
+		if len(c.routes) < maxRoutes {
+			c.routes[req.Route] = true
+		}
+	}
+	if req.ID != "" && len(c.ids) < d.cfg.DistinctIDs {
+		c.ids[req.Route+" "+req.ID] = struct{}{}
+		if len(c.ids) == d.cfg.DistinctIDs {
+			report(Enumeration, "%d different IDs in %s (%d of %d requests failed)",
+				len(c.ids), d.cfg.Window, c.errors, c.requests)
+		}
+	}
+	if c.requests >= d.cfg.MinRequests {
+		if learned && float64(c.requests) > d.cfg.RateFactor*max(c.rate, 1) {
+			report(RateSpike, "%d requests in %s, usually %.0f", c.requests, d.cfg.Window, c.rate)
+		}
+		if share := float64(c.errors) / float64(c.requests); share >= d.cfg.ErrorRate && (!learned || c.errRate < d.cfg.ErrorRate/2) {
+			report(ErrorSpike, "%d of %d requests failed in %s, usually %.0f%%",
+				c.errors, c.requests, d.cfg.Window, 100*c.errRate)
+		}
+	}
+	return found
+}
+
+// roll closes the window if now is past it, folding it into the
+// baselines; idle windows in between count as no requests
+func (c *client) roll(now time.Time, window time.Duration) {
+	n := int(now.Sub(c.start) / window)
+	if n <= 0 {
+		return
+	}
+	c.rate = c.rate*(1-alpha) + alpha*float64(c.requests)
+	c.errRate = c.errRate*(1-alpha) + alpha*float64(c.errors)/float64(c.requests)
+	for range min(n-1, 50) { // after 50 the rate is as good as 0
+		c.rate *= 1 - alpha
+	}
+	c.windows++
+	c.start = c.start.Add(time.Duration(n) * window)
+	c.requests, c.errors = 0, 0
+	clear(c.ids)
+	clear(c.reported)
+}
+
+// Cleanup forgets the clients idle for longer than Forget and returns
+// how many
+func (d *Detector) Cleanup() int {
+	cutoff := d.now().Add(-d.cfg.Forget)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := 0
+	for key, c := range d.clients {
+		if c.last.Before(cutoff) {
+			delete(d.clients, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunCleanup calls Cleanup every interval until ctx is cancelled
+func (d *Detector) RunCleanup(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.Cleanup()
+		}
+	}
+}
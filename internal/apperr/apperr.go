@@ -0,0 +1,168 @@
+// Package apperr is the catalog of machine-readable error codes.
+//
+// Code that detects a problem returns an *Error with a Code; the HTTP
+// layer turns it into a problem+json response (status and title come
+// from the catalog) and logs the code. Clients and tests compare codes,
+// never message text, so messages can be reworded freely.
+package apperr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+type Code string
+
+const (
+	InvalidJSON          Code = "INVALID_JSON"
+	InvalidID            Code = "INVALID_ID"
+	NoFieldsToUpdate     Code = "NO_FIELDS_TO_UPDATE"
+	InvalidPage          Code = "INVALID_PAGE"
+	InvalidParam         Code = "INVALID_PARAM"
+	LimitTooLarge        Code = "LIMIT_TOO_LARGE"
+	ValidationFailed     Code = "VALIDATION_FAILED" // see Error.Fields
+	TaskNotFound         Code = "TASK_NOT_FOUND"
+	UserNotFound         Code = "USER_NOT_FOUND"
+	ParentNotFound       Code = "PARENT_NOT_FOUND" // parent_id names no live task
+	TaskCycle            Code = "TASK_CYCLE"       // parent_id would make a task its own ancestor
+	EmailTaken           Code = "EMAIL_TAKEN"
+	LastAdmin            Code = "LAST_ADMIN"            // the change would leave an organization without an admin
+	PreconditionFailed   Code = "PRECONDITION_FAILED"   // If-Match doesn't match the current ETag
+	PreconditionRequired Code = "PRECONDITION_REQUIRED" // no version or If-Match where it's mandatory
+	VersionConflict      Code = "VERSION_CONFLICT"      // the version sent isn't the current one; see Error.Current
+	RouteNotFound        Code = "ROUTE_NOT_FOUND"
+	IntegrationNotFound  Code = "INTEGRATION_NOT_FOUND"
+	JobNotFound          Code = "JOB_NOT_FOUND"     // no such job, or it isn't dead
+	WebhookNotFound      Code = "WEBHOOK_NOT_FOUND" // outbound (see webhooks.go)
+	APIKeyNotFound       Code = "API_KEY_NOT_FOUND"
+	OrgRequired          Code = "ORG_REQUIRED" // no X-Org-ID and no tenancy.default_org
+	OrgNotFound          Code = "ORG_NOT_FOUND"
+	TenantUnavailable    Code = "TENANT_UNAVAILABLE"   // every dedicated tenant pool is open and busy
+	Unauthenticated      Code = "UNAUTHENTICATED"      // credentials sent but not valid (a revoked or unknown API key)
+	LoginFailed          Code = "LOGIN_FAILED"         // a wrong password, or an OIDC login that was refused or didn't check out
+	AccountLocked        Code = "ACCOUNT_LOCKED"       // too many wrong passwords in a row; see Retry-After
+	ProviderUnavailable  Code = "PROVIDER_UNAVAILABLE" // the OIDC provider can't be reached
+	CSRFFailed           Code = "CSRF_FAILED"          // a cookie-authenticated write without the session's X-CSRF-Token
+	Forbidden            Code = "FORBIDDEN"            // the caller's role doesn't allow it (internal/authz)
+	InvalidSignature     Code = "INVALID_SIGNATURE"    // webhook HMAC missing or wrong
+	PayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	InvalidCSV           Code = "INVALID_CSV" // unreadable upload or header row
+	MethodNotAllowed     Code = "METHOD_NOT_ALLOWED"
+	RateLimited          Code = "RATE_LIMITED"
+	RequestInvalid       Code = "REQUEST_INVALID"
+	ResponseInvalid      Code = "RESPONSE_INVALID"
+	Timeout              Code = "TIMEOUT"
+	Internal             Code = "INTERNAL"
+)
+
+type entry struct {
+	Status int
+	Title  string
+}
+
+var catalog = map[Code]entry{
+	InvalidJSON:          {http.StatusBadRequest, "Invalid JSON body"},
+	InvalidID:            {http.StatusBadRequest, "Invalid ID"},
+	NoFieldsToUpdate:     {http.StatusBadRequest, "No fields to update"},
+	InvalidPage:          {http.StatusBadRequest, "Invalid limit or offset"},
+	InvalidParam:         {http.StatusBadRequest, "Invalid query parameter"},
+	LimitTooLarge:        {http.StatusBadRequest, "Limit exceeds the maximum page size"},
+	ValidationFailed:     {http.StatusUnprocessableEntity, "Validation failed"},
+	TaskNotFound:         {http.StatusNotFound, "Task not found"},
+	UserNotFound:         {http.StatusNotFound, "User not found"},
+	ParentNotFound:       {http.StatusUnprocessableEntity, "Parent task not found"},
+	TaskCycle:            {http.StatusUnprocessableEntity, "Task hierarchy cycle"},
+	EmailTaken:           {http.StatusConflict, "Email already taken"},
+	LastAdmin:            {http.StatusConflict, "Last admin"},
+	PreconditionFailed:   {http.StatusPreconditionFailed, "Precondition failed"},
+	PreconditionRequired: {http.StatusPreconditionRequired, "Precondition required"},
+	VersionConflict:      {http.StatusConflict, "Version conflict"},
+	RouteNotFound:        {http.StatusNotFound, "Not found"},
+	IntegrationNotFound:  {http.StatusNotFound, "Integration not found"},
+	JobNotFound:          {http.StatusNotFound, "Job not found"},
+	WebhookNotFound:      {http.StatusNotFound, "Webhook not found"},
+	APIKeyNotFound:       {http.StatusNotFound, "API key not found"},
+	OrgRequired:          {http.StatusBadRequest, "Organization required"},
+	OrgNotFound:          {http.StatusNotFound, "Organization not found"},
+	TenantUnavailable:    {http.StatusServiceUnavailable, "Tenant database unavailable"},
+	Unauthenticated:      {http.StatusUnauthorized, "Unauthenticated"},
+	LoginFailed:          {http.StatusUnauthorized, "Login failed"},
+	AccountLocked:        {http.StatusTooManyRequests, "Account locked"},
+	ProviderUnavailable:  {http.StatusBadGateway, "Identity provider unavailable"},
+	CSRFFailed:           {http.StatusForbidden, "CSRF check failed"},
+	Forbidden:            {http.StatusForbidden, "Forbidden"},
+	InvalidSignature:     {http.StatusUnauthorized, "Invalid signature"},
+	PayloadTooLarge:      {http.StatusRequestEntityTooLarge, "Payload too large"},
+	InvalidCSV:           {http.StatusBadRequest, "Invalid CSV"},
+	MethodNotAllowed:     {http.StatusMethodNotAllowed, "Method not allowed"},
+	RateLimited:          {http.StatusTooManyRequests, "Rate limit exceeded"},
+	RequestInvalid:       {http.StatusBadRequest, "Request does not match the API spec"},
+	ResponseInvalid:      {http.StatusInternalServerError, "Response does not match the API spec"},
+	Timeout:              {http.StatusGatewayTimeout, "Request timed out"},
+	Internal:             {http.StatusInternalServerError, "Internal server error"},
+}
+
+// Status is the HTTP status for the code (500 for unknown codes).
+func (c Code) Status() int {
+	if e, ok := catalog[c]; ok {
+		return e.Status
+	}
+	return http.StatusInternalServerError
+}
+
+// Title is the short, fixed human summary of the code.
+func (c Code) Title() string {
+	if e, ok := catalog[c]; ok {
+		return e.Title
+	}
+	return catalog[Internal].Title
+}
+
+// Error is a domain error: a Code plus a message for this occurrence
+// ("task 42 not found") and, optionally, the underlying cause.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+	Fields  []FieldError // per-field violations (VALIDATION_FAILED)
+	Current any          // the resource as it is now (VERSION_CONFLICT)
+}
+
+// FieldError — one invalid input field (see internal/validate)
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func New(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Wrap attaches a cause, so errors.Is(err, cause) keeps working.
+func Wrap(code Code, msg string, cause error) *Error {
+	return &Error{Code: code, Message: msg, Err: cause}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// From extracts the *Error from err's chain. A missed deadline becomes
+// Timeout; anything else (a raw DB error, a bug) becomes Internal, with
+// a message safe to show clients.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Wrap(Timeout, "the request took too long and was cancelled", err)
+	}
+	return Wrap(Internal, "internal server error", err)
+}
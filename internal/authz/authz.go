@@ -0,0 +1,53 @@
+// Package authz decides what a user may do, from their role.
+//
+// Three roles, each a superset of the next:
+//
+//	admin  — everything: any task, webhook or API key, users, roles, /admin
+//	member — reads, and writes to their own tasks, webhooks and API keys
+//	viewer — reads only
+//
+// Callers ask Allow for an Action on a resource owned by some user; the
+// HTTP, GraphQL and gRPC handlers turn a false into 403 FORBIDDEN.
+package authz
+
+import "slices"
+
+type Role string
+
+const (
+	Admin  Role = "admin"
+	Member Role = "member"
+	Viewer Role = "viewer"
+)
+
+// Roles — every role, most powerful first
+var Roles = []Role{Admin, Member, Viewer}
+
+func (r Role) Valid() bool { return slices.Contains(Roles, r) }
+
+type Action string
+
+const (
+	// Read — any GET; every role may
+	Read Action = "read"
+	// Write — create, change or delete a task, webhook or API key; only
+	// of their own for members
+	Write Action = "write"
+	// Manage — users, roles, bulk changes across users (reassign, CSV
+	// import) and /admin: admins only
+	Manage Action = "manage"
+)
+
+// Allow reports whether role may do action to a resource; own is true
+// when the resource belongs to the caller (for Write; ignored otherwise)
+func Allow(role Role, action Action, own bool) bool {
+	switch role {
+	case Admin:
+		return true
+	case Member:
+		return action == Read || action == Write && own
+	case Viewer:
+		return action == Read
+	}
+	return false // unknown role: nothing
+}
@@ -0,0 +1,94 @@
+// Package broker publishes task events to a message broker, so other
+// services can consume them without calling the API.
+//
+// A Publisher takes a batch of messages and returns once the broker has
+// them (or with an error: then the whole batch is sent again, so
+// consumers see each event at least once and dedupe on its id). Two
+// implementations, picked by config:
+//
+//   - "nats":       NATS core, over its text protocol (nats.go)
+//   - "kafka-rest": Kafka through a REST Proxy — Confluent's, or
+//     Redpanda's HTTP proxy (kafkarest.go)
+//
+// A native Kafka client needs a library this module doesn't vendor; the
+// REST Proxy speaks the same topics, keys and partitions.
+package broker
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Message — one event on its way to the broker
+type Message struct {
+	// Topic — the stream, "<prefix>.tasks". Kafka: the topic, with Key
+	// picking the partition; NATS: the subject, plus ".<event>".
+	Topic string
+	Key   string // the task ID: one task's events keep their order
+	Type  string // task.created, ...
+	Body  []byte // an Envelope, JSON
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msgs []Message) error
+	// Ping checks the broker is reachable and takes our credentials,
+	// without publishing anything
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// EnvelopeVersion — bumped on a change consumers must know about;
+// adding a field is not one
+const EnvelopeVersion = 1
+
+// Envelope — the JSON body of every message (EnvelopeSchema)
+type Envelope struct {
+	Version   int             `json:"version"`
+	ID        int64           `json:"id"` // the outbox event's: the same when redelivered
+	Type      string          `json:"type"`
+	Source    string          `json:"source"`
+	Time      time.Time       `json:"time"`
+	TaskID    int             `json:"task_id"`
+	OrgID     int             `json:"org_id"` // the task's organization
+	RequestID string          `json:"request_id,omitempty"`
+	Data      json.RawMessage `json:"data"` // a Task; for task.deleted {"id"}
+}
+
+// EnvelopeSchema — JSON Schema of Envelope, served at
+// /schemas/task-event.json
+//
+//go:embed envelope.schema.json
+var EnvelopeSchema []byte
+
+// Source — Envelope.Source of everything this service publishes
+const Source = "sandbox-go"
+
+// TasksTopic — the topic task events go to
+func TasksTopic(prefix string) string {
+	return prefix + ".tasks"
+}
+
+// NewMessage wraps an event in its envelope
+func NewMessage(topic string, e Envelope) (Message, error) {
+	e.Version, e.Source = EnvelopeVersion, Source
+	body, err := json.Marshal(e)
+	if err != nil {
+		return Message{}, fmt.Errorf("broker: encode %s envelope: %w", e.Type, err)
+	}
+	return Message{Topic: topic, Key: strconv.Itoa(e.TaskID), Type: e.Type, Body: body}, nil
+}
+
+// New returns the publisher for a broker type ("nats", "kafka-rest")
+func New(typ, url string, timeout time.Duration) (Publisher, error) {
+	switch typ {
+	case "nats":
+		return NewNATS(url, timeout)
+	case "kafka-rest":
+		return NewKafkaREST(url, timeout)
+	}
+	return nil, fmt.Errorf("broker: unknown type %q (want nats or kafka-rest)", typ)
+}
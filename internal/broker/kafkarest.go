@@ -0,0 +1,138 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sandbox-go/internal/httpclient"
+)
+
+// KafkaREST publishes to Kafka through a REST Proxy (the v2 API:
+// Confluent REST Proxy, Redpanda's HTTP proxy). A batch is one POST per
+// topic; the record key is the task ID, so one task's events land in one
+// partition, in order.
+type KafkaREST struct {
+	base   string // without a trailing slash
+	user   string
+	pass   string
+	client *http.Client
+}
+
+// NewKafkaREST — url is the proxy's, http[s]://[user:pass@]host:8082
+func NewKafkaREST(rawURL string, timeout time.Duration) (*KafkaREST, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("broker: bad Kafka REST Proxy URL %q (want http[s]://host:port)", rawURL)
+	}
+	// No retries here: the relay sends a batch that failed again
+	k := &KafkaREST{client: httpclient.New(httpclient.Config{Name: "kafka-rest", Timeout: timeout})}
+	if u.User != nil {
+		k.user = u.User.Username()
+		k.pass, _ = u.User.Password()
+		u.User = nil
+	}
+	k.base = strings.TrimRight(u.String(), "/")
+	return k, nil
+}
+
+const kafkaJSON = "application/vnd.kafka.json.v2+json"
+
+type kafkaRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+func (k *KafkaREST) Publish(ctx context.Context, msgs []Message) error {
+	// Topics in the order they first appear: all task events share one
+	var topics []string
+	byTopic := map[string][]kafkaRecord{}
+	for _, m := range msgs {
+		if _, ok := byTopic[m.Topic]; !ok {
+			topics = append(topics, m.Topic)
+		}
+		byTopic[m.Topic] = append(byTopic[m.Topic], kafkaRecord{Key: m.Key, Value: m.Body})
+	}
+	for _, topic := range topics {
+		if err := k.produce(ctx, topic, byTopic[topic]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ping lists the proxy's topics: it is up, and lets us in
+func (k *KafkaREST) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.base+"/topics", nil)
+	if err != nil {
+		return fmt.Errorf("broker: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+	if k.user != "" {
+		req.SetBasicAuth(k.user, k.pass)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("broker: Kafka REST Proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("broker: Kafka REST Proxy: %s", resp.Status)
+	}
+	return nil
+}
+
+func (k *KafkaREST) produce(ctx context.Context, topic string, records []kafkaRecord) error {
+	body, err := json.Marshal(map[string]any{"records": records})
+	if err != nil {
+		return fmt.Errorf("broker: encode records: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.base+"/topics/"+url.PathEscape(topic), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("broker: %w", err)
+	}
+	req.Header.Set("Content-Type", kafkaJSON)
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json, application/json")
+	if k.user != "" {
+		req.SetBasicAuth(k.user, k.pass)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("broker: Kafka REST Proxy: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("broker: Kafka REST Proxy: %s: %s", resp.Status, bytes.TrimSpace(respBody))
+	}
+
+	// 200 can still carry a failure per record
+	var result struct {
+		Offsets []struct {
+			Partition int    `json:"partition"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("broker: Kafka REST Proxy response: %w", err)
+	}
+	for i, o := range result.Offsets {
+		if o.Error != "" {
+			return fmt.Errorf("broker: produce to %s, record %d of %d: %s", topic, i+1, len(records), o.Error)
+		}
+	}
+	return nil
+}
+
+func (k *KafkaREST) Close() error {
+	k.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,194 @@
+package broker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATS publishes to a NATS server over its core text protocol: CONNECT
+// once, then PUB each message and PING — the PONG comes back after the
+// server has processed every PUB before it. Core NATS keeps nothing: a
+// subscriber that is down misses the message (put a JetStream stream on
+// the subjects to keep them).
+//
+// Subjects: "<topic>.<event>", the event without "task." —
+// sandbox.tasks.created, sandbox.tasks.updated, ... (subscribe to
+// sandbox.tasks.> for all of them).
+//
+// One connection, dialled on first use and again after an error. No TLS:
+// a server that requires it is refused.
+type NATS struct {
+	addr    string
+	user    string
+	pass    string
+	token   string
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	max  int // the server's max_payload
+}
+
+// NewNATS — url is nats://[user:pass@]host[:4222] (or nats://token@host)
+func NewNATS(rawURL string, timeout time.Duration) (*NATS, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Hostname() == "" {
+		return nil, fmt.Errorf("broker: bad NATS URL %q (want nats://host:port)", rawURL)
+	}
+	n := &NATS{addr: u.Host, timeout: timeout}
+	if u.Port() == "" {
+		n.addr = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			n.user, n.pass = u.User.Username(), pass
+		} else {
+			n.token = u.User.Username()
+		}
+	}
+	return n, nil
+}
+
+func natsSubject(m Message) string {
+	return m.Topic + "." + strings.TrimPrefix(m.Type, "task.")
+}
+
+func (n *NATS) Publish(ctx context.Context, msgs []Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		if err := n.connect(ctx); err != nil {
+			return err
+		}
+	}
+	if err := n.publish(ctx, msgs); err != nil {
+		n.drop() // the connection is in an unknown state: start over
+		return err
+	}
+	return nil
+}
+
+// Ping — an empty batch: connect (and authenticate) if need be, then
+// a PING for its PONG
+func (n *NATS) Ping(ctx context.Context) error {
+	return n.Publish(ctx, nil)
+}
+
+func (n *NATS) publish(ctx context.Context, msgs []Message) error {
+	n.setDeadline(ctx)
+	w := bufio.NewWriter(n.conn)
+	for _, m := range msgs {
+		if n.max > 0 && len(m.Body) > n.max {
+			return fmt.Errorf("broker: %s event of %d bytes is over the NATS max_payload %d", m.Type, len(m.Body), n.max)
+		}
+		fmt.Fprintf(w, "PUB %s %d\r\n", natsSubject(m), len(m.Body))
+		w.Write(m.Body)
+		w.WriteString("\r\n")
+	}
+	w.WriteString("PING\r\n")
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("broker: NATS publish: %w", err)
+	}
+	return n.awaitPong()
+}
+
+// awaitPong reads until the PONG, answering the server's PINGs
+func (n *NATS) awaitPong() error {
+	for {
+		line, err := n.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("broker: NATS read: %w", err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := n.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("broker: NATS write: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("broker: NATS server: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK, INFO updates: nothing to do
+	}
+}
+
+func (n *NATS) connect(ctx context.Context) error {
+	d := net.Dialer{Timeout: n.timeout}
+	conn, err := d.DialContext(ctx, "tcp", n.addr)
+	if err != nil {
+		return fmt.Errorf("broker: NATS connect: %w", err)
+	}
+	n.conn, n.r = conn, bufio.NewReader(conn)
+	if err := n.handshake(ctx); err != nil {
+		n.drop()
+		return err
+	}
+	return nil
+}
+
+func (n *NATS) handshake(ctx context.Context) error {
+	n.setDeadline(ctx)
+	line, err := n.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("broker: NATS read INFO: %w", err)
+	}
+	info, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "INFO ")
+	if !ok {
+		return fmt.Errorf("broker: NATS: expected INFO, got %q", line)
+	}
+	var server struct {
+		MaxPayload  int  `json:"max_payload"`
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(info), &server); err != nil {
+		return fmt.Errorf("broker: NATS INFO: %w", err)
+	}
+	if server.TLSRequired {
+		return errors.New("broker: the NATS server requires TLS, which this client doesn't do")
+	}
+	n.max = server.MaxPayload
+
+	connect, _ := json.Marshal(map[string]any{
+		"verbose": false, "pedantic": false, "name": Source, "lang": "go", "version": "1",
+		"user": n.user, "pass": n.pass, "auth_token": n.token,
+	})
+	if _, err := fmt.Fprintf(n.conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		return fmt.Errorf("broker: NATS write CONNECT: %w", err)
+	}
+	return n.awaitPong() // a refused CONNECT is an -ERR before it
+}
+
+// setDeadline — the timeout, or ctx's deadline if that is sooner
+func (n *NATS) setDeadline(ctx context.Context) {
+	deadline := time.Now().Add(n.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	n.conn.SetDeadline(deadline)
+}
+
+func (n *NATS) drop() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.r = nil, nil
+	}
+}
+
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.drop()
+	return nil
+}
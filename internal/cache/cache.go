@@ -0,0 +1,169 @@
+// Package cache keeps data that is read on almost every request but
+// rarely written — organizations, and the like — in memory, so that
+// not every request asks the database for it.
+//
+// A Cache holds at most Size entries, each for TTL; past Size the least
+// recently used goes. A miss calls the load function once, however many
+// requests want the key at that moment: the others wait for its result,
+// so an entry expiring under load is one query, not one per request
+// (no stampede). Errors aren't cached. Each instance has its own
+// entries: a write elsewhere shows here once the entry expires, unless
+// the writer calls Delete on this instance.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// fillTimeout — how long a load may take. It runs detached from the
+// request that started it, so that request going away doesn't fail
+// the others waiting for it.
+const fillTimeout = 10 * time.Second
+
+type Cache[K comparable, V any] struct {
+	name string
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	items map[K]*list.Element // of lru
+	lru   *list.List          // *entry[K, V], most recently used first
+	fills map[K]*fill[V]
+	stats Stats
+	now   func() time.Time
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	val     V
+	expires time.Time
+}
+
+// fill — a load in progress; val and err are set before done closes
+type fill[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// Stats — counters since New, for metrics
+type Stats struct {
+	Name    string
+	Hits    uint64 // found and fresh
+	Misses  uint64 // loaded, or waited for a load
+	Loads   uint64 // load calls (fewer than misses when they were shared)
+	Errors  uint64 // loads that failed
+	Evicted uint64 // pushed out by Size, not expired
+	Entries int
+}
+
+// New — name is for metrics ("orgs"); ttl 0 caches nothing, but still
+// shares concurrent loads
+func New[K comparable, V any](name string, size int, ttl time.Duration) *Cache[K, V] {
+	return &Cache[K, V]{
+		name:  name,
+		size:  size,
+		ttl:   ttl,
+		items: map[K]*list.Element{},
+		lru:   list.New(),
+		fills: map[K]*fill[V]{},
+		now:   time.Now,
+	}
+}
+
+// Get returns key's value: the cached one, or load's. ctx only bounds
+// the wait; see fillTimeout.
+func (c *Cache[K, V]) Get(ctx context.Context, key K, load func(context.Context) (V, error)) (V, error) {
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		ent := e.Value.(*entry[K, V])
+		if c.now().Before(ent.expires) {
+			c.lru.MoveToFront(e)
+			c.stats.Hits++
+			c.mu.Unlock()
+			return ent.val, nil
+		}
+		c.removeLocked(e)
+	}
+	c.stats.Misses++
+	f, ok := c.fills[key]
+	if !ok {
+		f = &fill[V]{done: make(chan struct{})}
+		c.fills[key] = f
+		c.stats.Loads++
+		go c.fill(context.WithoutCancel(ctx), key, f, load)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (c *Cache[K, V]) fill(ctx context.Context, key K, f *fill[V], load func(context.Context) (V, error)) {
+	ctx, cancel := context.WithTimeout(ctx, fillTimeout)
+	defer cancel()
+	f.val, f.err = load(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.fills, key)
+	defer close(f.done) // once stored: a waiter's next Get finds it
+	if f.err != nil {
+		c.stats.Errors++
+		return
+	}
+	c.setLocked(key, f.val)
+}
+
+// Set stores val for key, as if a load had returned it
+func (c *Cache[K, V]) Set(key K, val V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(key, val)
+}
+
+func (c *Cache[K, V]) setLocked(key K, val V) {
+	if c.ttl <= 0 {
+		return
+	}
+	if e, ok := c.items[key]; ok {
+		c.removeLocked(e)
+	}
+	c.items[key] = c.lru.PushFront(&entry[K, V]{key: key, val: val, expires: c.now().Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		c.removeLocked(c.lru.Back())
+		c.stats.Evicted++
+	}
+}
+
+// Delete drops key's entry, after a write that changed it. A load
+// already under way still stores what it read.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeLocked(e)
+	}
+}
+
+func (c *Cache[K, V]) removeLocked(e *list.Element) {
+	ent := c.lru.Remove(e).(*entry[K, V])
+	delete(c.items, ent.key)
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Name = c.name
+	s.Entries = c.lru.Len()
+	return s
+}
@@ -0,0 +1,98 @@
+// Package dedup makes at-least-once event delivery safe for consumers.
+//
+// Every event a consumer handles is recorded in processed_events, keyed
+// by (consumer, event ID), in the SAME transaction as the consumer's own
+// writes. A redelivered event finds its marker and is skipped; a handler
+// that fails rolls the marker back so the next delivery retries it.
+//
+// Side effects outside Postgres (sending an email) can't join the
+// transaction: do them last inside fn, so a failure before them rolls
+// everything back. A crash between the email and the commit can still
+// resend once — dedup shrinks that window, it can't close it.
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Store records processed events. Markers older than TTL are purged by
+// Cleanup; redelivery is only detected within that window, so TTL must
+// exceed the pipeline's maximum redelivery delay.
+type Store struct {
+	pool *pgxpool.Pool
+	TTL  time.Duration
+}
+
+func New(pool *pgxpool.Pool, ttl time.Duration) *Store {
+	return &Store{pool: pool, TTL: ttl}
+}
+
+// Process runs fn at most once per (consumer, eventID). It reports
+// whether fn ran (false means the event was a duplicate). fn's writes
+// must go through tx to commit atomically with the marker.
+func (s *Store) Process(ctx context.Context, consumer, eventID string, fn func(ctx context.Context, tx pgx.Tx) error) (bool, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("dedup: begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// A concurrent delivery of the same event blocks on the primary key
+	// until this transaction finishes, then sees it as a duplicate.
+	// An expired marker (not yet cleaned up) counts as new.
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO processed_events (consumer, event_id, expires_at)
+		VALUES ($1, $2, NOW() + $3::interval)
+		ON CONFLICT (consumer, event_id) DO UPDATE
+			SET processed_at = NOW(), expires_at = EXCLUDED.expires_at
+			WHERE processed_events.expires_at < NOW()`,
+		consumer, eventID, s.TTL,
+	)
+	if err != nil {
+		return false, fmt.Errorf("dedup: mark %s/%s: %w", consumer, eventID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil // duplicate
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("dedup: commit %s/%s: %w", consumer, eventID, err)
+	}
+	return true, nil
+}
+
+// Cleanup deletes expired markers and returns how many were removed.
+func (s *Store) Cleanup(ctx context.Context) (int64, error) {
+	tag, err := s.pool.Exec(ctx, "DELETE FROM processed_events WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("dedup: cleanup: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunCleanup calls Cleanup every interval until ctx is cancelled.
+// Errors are passed to onErr (may be nil) and don't stop the loop.
+func (s *Store) RunCleanup(ctx context.Context, every time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Cleanup(ctx); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
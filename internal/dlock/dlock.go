@@ -0,0 +1,205 @@
+// Package dlock is a distributed mutex built on Postgres advisory locks.
+//
+// Every replica talks to the same database, so an advisory lock taken by
+// one of them is visible to all others — enough to make sure only one
+// instance runs the scheduler, the outbox relay or the migrations at a
+// time, without adding Redis or ZooKeeper.
+//
+// Advisory locks belong to a database SESSION, so each held Lock pins
+// one pooled connection until it is released.
+package dlock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrTimeout is returned by Acquire when the lock is still held by
+// someone else after the timeout.
+var ErrTimeout = errors.New("dlock: timed out waiting for lock")
+
+// Locker hands out locks backed by one connection pool.
+type Locker struct {
+	pool *pgxpool.Pool
+
+	// PollInterval — how often Acquire retries while the lock is taken
+	PollInterval time.Duration
+
+	metrics metrics
+}
+
+func New(pool *pgxpool.Pool) *Locker {
+	return &Locker{pool: pool, PollInterval: 200 * time.Millisecond}
+}
+
+// Key maps a lock name to the int64 key Postgres expects. Every replica
+// computes the same key for the same name.
+func Key(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// TryAcquire takes the lock if it is free and returns ok=false
+// immediately if it isn't. The lock is released automatically when ctx
+// is cancelled.
+func (l *Locker) TryAcquire(ctx context.Context, name string) (lock *Lock, ok bool, err error) {
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("dlock: acquire conn: %w", err)
+	}
+
+	key := Key(name)
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Release()
+		return nil, false, fmt.Errorf("dlock: try lock %q: %w", name, err)
+	}
+	if !ok {
+		conn.Release()
+		l.metrics.contended.Add(1)
+		return nil, false, nil
+	}
+
+	return l.newLock(ctx, name, key, conn), true, nil
+}
+
+// Acquire waits up to timeout for the lock (timeout <= 0 waits until ctx
+// is done). The lock is released automatically when ctx is cancelled.
+//
+// It polls pg_try_advisory_lock instead of blocking in pg_advisory_lock
+// so that giving up never leaves a half-cancelled query on the
+// connection.
+func (l *Locker) Acquire(ctx context.Context, name string, timeout time.Duration) (*Lock, error) {
+	start := time.Now()
+	waitCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for {
+		lock, ok, err := l.TryAcquire(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			l.metrics.waitNanos.Add(int64(time.Since(start)))
+			return lock, nil
+		}
+
+		select {
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			l.metrics.timeouts.Add(1)
+			return nil, fmt.Errorf("%w %q after %v", ErrTimeout, name, timeout)
+		case <-time.After(l.PollInterval):
+		}
+	}
+}
+
+func (l *Locker) newLock(ctx context.Context, name string, key int64, conn *pgxpool.Conn) *Lock {
+	l.metrics.acquired.Add(1)
+	l.metrics.held.Add(1)
+
+	lock := &Lock{
+		Name:   name,
+		key:    key,
+		conn:   conn,
+		locker: l,
+		done:   make(chan struct{}),
+	}
+
+	// Auto-release: whoever holds the lock only has to cancel ctx
+	go func() {
+		select {
+		case <-ctx.Done():
+			lock.Release()
+		case <-lock.done:
+		}
+	}()
+
+	return lock
+}
+
+// -----------------------------------------------------------
+// LOCK
+// -----------------------------------------------------------
+
+type Lock struct {
+	Name string
+
+	key    int64
+	conn   *pgxpool.Conn
+	locker *Locker
+	once   sync.Once
+	done   chan struct{}
+}
+
+// Done is closed once the lock has been released.
+func (lk *Lock) Done() <-chan struct{} {
+	return lk.done
+}
+
+// Release unlocks and returns the connection to the pool. Safe to call
+// more than once and from several goroutines.
+func (lk *Lock) Release() {
+	lk.once.Do(func() {
+		// The caller's ctx may already be cancelled — unlock with our own
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if _, err := lk.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", lk.key); err != nil {
+			// Closing the session is the other way to drop the lock
+			lk.conn.Conn().Close(ctx)
+		}
+		lk.conn.Release()
+
+		lk.locker.metrics.held.Add(-1)
+		lk.locker.metrics.released.Add(1)
+		close(lk.done)
+	})
+}
+
+// -----------------------------------------------------------
+// METRICS
+// -----------------------------------------------------------
+
+type metrics struct {
+	acquired  atomic.Int64
+	released  atomic.Int64
+	contended atomic.Int64
+	timeouts  atomic.Int64
+	held      atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// Stats is a point-in-time snapshot of the Locker's counters.
+type Stats struct {
+	Acquired  int64         // locks successfully taken
+	Released  int64         // locks given back
+	Contended int64         // attempts that found the lock taken
+	Timeouts  int64         // Acquire calls that gave up
+	Held      int64         // locks currently held by this process
+	WaitTime  time.Duration // total time spent waiting in Acquire
+}
+
+func (l *Locker) Stats() Stats {
+	return Stats{
+		Acquired:  l.metrics.acquired.Load(),
+		Released:  l.metrics.released.Load(),
+		Contended: l.metrics.contended.Load(),
+		Timeouts:  l.metrics.timeouts.Load(),
+		Held:      l.metrics.held.Load(),
+		WaitTime:  time.Duration(l.metrics.waitNanos.Load()),
+	}
+}
@@ -0,0 +1,137 @@
+// Package events is an in-process publish/subscribe bus for change
+// events (task created, updated, deleted).
+//
+// Each event gets an increasing ID. The last N events stay in a ring
+// buffer so a client that reconnects can say "I saw up to ID 41" and get
+// everything after it — that is how SSE's Last-Event-ID works.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Event struct {
+	ID    uint64    `json:"id"`
+	Type  string    `json:"type"`   // e.g. "task.created"
+	OrgID int       `json:"org_id"` // whose event it is; 0 = no organization
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// subscriberBuffer — events a subscriber may lag behind before it is
+// dropped (it can reconnect and replay from its last ID)
+const subscriberBuffer = 64
+
+type Bus struct {
+	mu     sync.Mutex
+	lastID uint64
+	ring   []Event // oldest first, at most cap(ring) events
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBus keeps the last size events for replay.
+func NewBus(size int) *Bus {
+	return &Bus{
+		ring: make([]Event, 0, size),
+		subs: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish assigns the next ID, stores the event for replay and hands it
+// to every subscriber.
+func (b *Bus) Publish(typ string, orgID int, data any) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := Event{ID: b.lastID, Type: typ, OrgID: orgID, Time: time.Now().UTC(), Data: data}
+
+	if len(b.ring) == cap(b.ring) {
+		copy(b.ring, b.ring[1:])
+		b.ring = b.ring[:len(b.ring)-1]
+	}
+	b.ring = append(b.ring, e)
+
+	for s := range b.subs {
+		select {
+		case s.ch <- e:
+		default:
+			// Too slow: never block publishers. Closing tells the client
+			// to reconnect and catch up through the replay buffer.
+			b.remove(s)
+		}
+	}
+	return e
+}
+
+// Subscribe returns the buffered events after afterID (0 = none, just
+// live events) plus a subscription for everything published from now
+// on. Both are taken under one lock, so nothing is missed or repeated.
+func (b *Bus) Subscribe(afterID uint64) (*Subscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{bus: b, ch: make(chan Event, subscriberBuffer)}
+	if b.closed {
+		close(s.ch)
+		return s, nil
+	}
+	b.subs[s] = struct{}{}
+
+	var replay []Event
+	if afterID > 0 {
+		for _, e := range b.ring {
+			if e.ID > afterID {
+				replay = append(replay, e)
+			}
+		}
+	}
+	return s, replay
+}
+
+// LastID — the ID of the newest event, 0 before the first. IDs restart
+// with the process, so a client holding a bigger one is from before a
+// restart.
+func (b *Bus) LastID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID
+}
+
+// Close ends every subscription (their channels close) and refuses new
+// ones — used on shutdown so streaming handlers return.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.remove(s)
+	}
+}
+
+// remove must be called with b.mu held
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.ch)
+	}
+}
+
+type Subscription struct {
+	bus *Bus
+	ch  chan Event
+}
+
+// C delivers live events; it is closed when the subscription ends.
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
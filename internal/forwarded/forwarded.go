@@ -0,0 +1,178 @@
+// Package forwarded works out who a request really comes from when it
+// reached us through proxies (a load balancer, an ingress): the client's
+// IP and the scheme it used, from RFC 7239 Forwarded or, without one,
+// X-Forwarded-For and X-Forwarded-Proto.
+//
+// Those headers are whatever the client sent, plus what each proxy on
+// the way appended, so only the part the trusted proxies added is
+// believed. The address list is read from the right — the hop nearest
+// to us — skipping addresses of trusted proxies; the first one that
+// isn't is the client. A request from a peer that isn't trusted is
+// taken as it is: its headers are ignored.
+package forwarded
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies — the trusted ones; the zero value trusts none
+type Proxies struct {
+	prefixes []netip.Prefix
+}
+
+// ParseProxies — CIDRs ("10.0.0.0/8") or single addresses
+func ParseProxies(list []string) (*Proxies, error) {
+	p := &Proxies{}
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if strings.Contains(s, "/") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+			}
+			p.prefixes = append(p.prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", s, err)
+		}
+		p.prefixes = append(p.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return p, nil
+}
+
+// Trusts — is a one of the proxies?
+func (p *Proxies) Trusts(a netip.Addr) bool {
+	if p == nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// Client — where a request comes from
+type Client struct {
+	IP     string // "" if RemoteAddr isn't an address (tests, unix sockets)
+	Scheme string // http or https
+}
+
+// hop — one proxy's record of where it got the request from
+type hop struct {
+	addr  netip.Addr // invalid if the proxy didn't (or couldn't) say
+	proto string     // "" if it didn't say
+}
+
+// Client — r's client, by the rules in the package comment
+func (p *Proxies) Client(r *http.Request) Client {
+	c := Client{Scheme: "http"}
+	if r.TLS != nil {
+		c.Scheme = "https"
+	}
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return c
+	}
+	c.IP = peer.String()
+	if !p.Trusts(peer) {
+		return c
+	}
+
+	hops := forwardedHops(r.Header)
+	if hops == nil {
+		hops = xForwardedHops(r.Header)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if !h.addr.IsValid() {
+			break // unknown or garbled: nothing to its left can be checked
+		}
+		c.IP = h.addr.String()
+		if h.proto == "http" || h.proto == "https" {
+			c.Scheme = h.proto
+		}
+		if !p.Trusts(h.addr) {
+			break
+		}
+	}
+	return c
+}
+
+// forwardedHops — from Forwarded: for= and proto= of each element;
+// nil without the header
+func forwardedHops(header http.Header) []hop {
+	values := header.Values("Forwarded")
+	if len(values) == 0 {
+		return nil
+	}
+	var hops []hop
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			var h hop
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				val = strings.Trim(val, `"`)
+				switch strings.ToLower(k) {
+				case "for":
+					h.addr, _ = parseAddr(val)
+				case "proto":
+					h.proto = strings.ToLower(val)
+				}
+			}
+			hops = append(hops, h)
+		}
+	}
+	return hops
+}
+
+// xForwardedHops — from X-Forwarded-For, with X-Forwarded-Proto's
+// values matched up by position when there are as many of them, or
+// its last one (the nearest proxy's) for the nearest hop otherwise
+func xForwardedHops(header http.Header) []hop {
+	addrs := splitList(header.Values("X-Forwarded-For"))
+	protos := splitList(header.Values("X-Forwarded-Proto"))
+	hops := make([]hop, len(addrs))
+	for i, s := range addrs {
+		hops[i].addr, _ = parseAddr(s)
+		if len(protos) == len(addrs) {
+			hops[i].proto = strings.ToLower(protos[i])
+		}
+	}
+	if n := len(hops); n > 0 && len(protos) > 0 && len(protos) != n {
+		hops[n-1].proto = strings.ToLower(protos[len(protos)-1])
+	}
+	return hops
+}
+
+func splitList(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// parseAddr — an IP, with or without a port and IPv6 brackets
+func parseAddr(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	a, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return a.Unmap().WithZone(""), true
+}
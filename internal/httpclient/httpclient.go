@@ -0,0 +1,316 @@
+// Package httpclient makes the service's outbound HTTP clients — for
+// webhooks, the OIDC provider, the Kafka REST Proxy and whatever comes
+// next — so that none of them is an http.DefaultClient with no timeout.
+// A client from New has:
+//
+//   - timeouts on every step: dial, TLS handshake, response headers,
+//     and the whole request (Config.Timeout)
+//   - a connection pool of its own, kept warm between requests
+//   - retries of idempotent requests that failed in transit or got a
+//     502, 503 or 504, with backoff, within a budget: a share of the
+//     client's recent requests, so an outage isn't met with a flood
+//   - the X-Request-ID of the context, so the receiver's logs can be
+//     matched with ours, and its trace (traceparent, a span per call)
+//   - a report of every attempt to an Observer (metrics), with the
+//     time spent on DNS, connecting, TLS and the first byte, also
+//     logged at debug level
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"sandbox-go/internal/requestctx"
+	"sandbox-go/internal/tracing"
+)
+
+// RequestIDHeader — as the API's own; set on requests that don't have one
+const RequestIDHeader = "X-Request-ID"
+
+const (
+	defaultTimeout = 30 * time.Second
+	dialTimeout    = 5 * time.Second
+	tlsTimeout     = 5 * time.Second
+	idleTimeout    = 90 * time.Second
+	idlePerHost    = 16
+	baseBackoff    = 100 * time.Millisecond
+	maxBackoff     = 2 * time.Second
+	// budgetCap — the most retries a client can save up while all is well
+	budgetCap = 10
+)
+
+// Config — one client's settings; the zero value (with a Name) is
+// usable: a 30s timeout, no retries
+type Config struct {
+	// Name labels the client in metrics and logs: "webhooks", "oidc"
+	Name    string
+	Timeout time.Duration // for the whole request, retries included; 0 = 30s
+	// Retries — extra attempts of an idempotent request (GET, HEAD,
+	// OPTIONS, PUT, DELETE, or one with an Idempotency-Key); 0 = none
+	Retries int
+	// RetryBudget — retries allowed per request sent, on average
+	// (0.1: one in ten); 0 with Retries set = 0.2
+	RetryBudget float64
+	// NoRedirects — hand 3xx responses back rather than follow them
+	NoRedirects bool
+	// DialControl — a net.Dialer Control: it sees each address dialled,
+	// after DNS, and may refuse it. Set, the client dials direct: through
+	// a proxy it would only see the proxy's. nil = any address.
+	DialControl func(network, address string, c syscall.RawConn) error
+	Observer    Observer // nil = the one given to SetObserver, if any
+}
+
+// Attempt describes one finished attempt of a request
+type Attempt struct {
+	Client   string
+	Method   string
+	Host     string
+	Status   int   // 0 if there was no response
+	Err      error // nil if there was a response, whatever its status
+	Attempt  int   // 1 for the first
+	Retried  bool  // another attempt follows
+	Duration time.Duration
+	// DNS, Connect and TLS are zero for a pooled connection
+	DNS, Connect, TLS time.Duration
+	FirstByte         time.Duration // from sending to the first byte of the response
+}
+
+// Observer hears about every attempt. It is called from whatever
+// goroutine made the request, so it must be safe for concurrent use.
+type Observer interface {
+	ObserveRequest(Attempt)
+}
+
+var defaultObserver atomic.Pointer[Observer]
+
+// SetObserver — the Observer of clients without one of their own,
+// those made before the call included
+func SetObserver(o Observer) {
+	defaultObserver.Store(&o)
+}
+
+// New — a client for cfg
+func New(cfg Config) *http.Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Retries > 0 && cfg.RetryBudget <= 0 {
+		cfg.RetryBudget = 0.2
+	}
+	proxy := http.ProxyFromEnvironment
+	if cfg.DialControl != nil {
+		proxy = nil
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second, Control: cfg.DialControl}
+	base := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   tlsTimeout,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+		ResponseHeaderTimeout: cfg.Timeout,
+		IdleConnTimeout:       idleTimeout,
+		MaxIdleConns:          4 * idlePerHost,
+		MaxIdleConnsPerHost:   idlePerHost,
+		ForceAttemptHTTP2:     true,
+	}
+	c := &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &transport{cfg: cfg, base: base, budget: budgetCap},
+	}
+	if cfg.NoRedirects {
+		c.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+	}
+	return c
+}
+
+type transport struct {
+	cfg  Config
+	base http.RoundTripper
+
+	mu     sync.Mutex
+	budget float64 // retries that may be spent now
+}
+
+// RoundTrip — a client span around the attempts, if the call is made
+// within a trace
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Child(req.Context(), req.Method, tracing.KindClient,
+		tracing.String("http.request.method", req.Method),
+		tracing.String("server.address", req.URL.Host), // not the URL: webhook ones hold secrets
+		tracing.String("http.client", t.cfg.Name),
+	)
+	if span == nil {
+		return t.send(req)
+	}
+	defer span.End()
+	req = req.Clone(ctx)
+	tracing.Inject(ctx, req.Header)
+	resp, err := t.send(req)
+	span.SetError(err)
+	if resp != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			span.Fail(resp.Status)
+		}
+	}
+	return resp, err
+}
+
+func (t *transport) send(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := requestctx.RequestID(ctx); id != "" && req.Header.Get(RequestIDHeader) == "" {
+		req = req.Clone(ctx)
+		req.Header.Set(RequestIDHeader, id)
+	}
+	t.deposit()
+
+	retryable := t.cfg.Retries > 0 && idempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil)
+	for n := 1; ; n++ {
+		a := Attempt{Client: t.cfg.Name, Method: req.Method, Host: req.URL.Host, Attempt: n}
+		resp, err := t.attempt(req, &a)
+
+		a.Retried = retryable && n <= t.cfg.Retries && shouldRetry(resp, err) && ctx.Err() == nil && t.withdraw()
+		t.observe(ctx, a)
+		if !a.Retried {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) // so the connection can be reused
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+		select {
+		case <-time.After(backoff(n, resp)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attempt sends req once, timing its phases into a
+func (t *transport) attempt(req *http.Request, a *Attempt) (*http.Response, error) {
+	var dnsStart, connStart, tlsStart, wrote time.Time
+	var mu sync.Mutex // dials to several addresses can run at once; the first to connect counts
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { a.DNS = time.Since(dnsStart) },
+		ConnectStart: func(string, string) {
+			mu.Lock()
+			if connStart.IsZero() {
+				connStart = time.Now()
+			}
+			mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			mu.Lock()
+			if err == nil && a.Connect == 0 {
+				a.Connect = time.Since(connStart)
+			}
+			mu.Unlock()
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { a.TLS = time.Since(tlsStart) },
+		WroteRequest:      func(httptrace.WroteRequestInfo) { wrote = time.Now() },
+		GotFirstResponseByte: func() {
+			if !wrote.IsZero() {
+				a.FirstByte = time.Since(wrote)
+			}
+		},
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	a.Duration = time.Since(start)
+	if resp != nil {
+		a.Status = resp.StatusCode
+	}
+	a.Err = err
+	return resp, err
+}
+
+func (t *transport) observe(ctx context.Context, a Attempt) {
+	o := t.cfg.Observer
+	if o == nil {
+		if p := defaultObserver.Load(); p != nil {
+			o = *p
+		}
+	}
+	if o != nil {
+		o.ObserveRequest(a)
+	}
+	requestctx.Logger(ctx).Debug("outbound request", "client", a.Client, "method", a.Method, "host", a.Host,
+		"status", a.Status, "err", a.Err, "attempt", a.Attempt, "retried", a.Retried, "duration", a.Duration,
+		"dns", a.DNS, "connect", a.Connect, "tls", a.TLS, "first_byte", a.FirstByte)
+}
+
+// deposit — every request adds its share to the retry budget
+func (t *transport) deposit() {
+	t.mu.Lock()
+	t.budget = min(t.budget+t.cfg.RetryBudget, budgetCap)
+	t.mu.Unlock()
+}
+
+// withdraw — may a retry go ahead? It costs one from the budget.
+func (t *transport) withdraw() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.budget < 1 {
+		return false
+	}
+	t.budget--
+	return true
+}
+
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// shouldRetry — failed in transit, or the server (or a proxy before
+// it) says it's momentarily unable. A timeout of the whole request is
+// not retried: there's no time left for it.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff before attempt n+1: exponential with full jitter, or the
+// server's Retry-After if it sent a short one
+func backoff(n int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s >= 0 && time.Duration(s)*time.Second <= maxBackoff {
+			return time.Duration(s) * time.Second
+		}
+	}
+	d := min(baseBackoff<<(n-1), maxBackoff)
+	return rand.N(d) + 1
+}
@@ -0,0 +1,520 @@
+// Package jobs is a background job queue kept in Postgres (the jobs
+// table), so work survives restarts and any instance can pick it up.
+//
+// Workers claim one job at a time with SELECT ... FOR UPDATE SKIP
+// LOCKED: two workers never get the same job, and neither waits for
+// the other. A claimed job is leased, not locked for the whole run —
+// the handler works without holding a connection, and a job whose
+// worker died is taken again once the lease runs out.
+//
+// A failing job is retried with exponential backoff until MaxAttempts;
+// after that it stays in the table as "dead" (the dead letters, see
+// List and Retry) instead of retrying forever or disappearing.
+//
+// Delivery is at least once: a handler can run again after a crash or
+// a lost lease, so it must be safe to repeat.
+//
+// Due jobs are taken by priority, then oldest first: a reminder queued
+// behind a thousand bulk jobs still goes next. So that a steady stream
+// of urgent work can't starve the rest, every FairEvery-th claim of a
+// worker ignores priority and takes the job that has waited longest.
+// A job can also be held back until a time (NewJob.RunAt).
+//
+// A job enqueued while serving a request keeps its request ID: the
+// handler's context carries it again, and so does every line the job
+// logs, which ties the work back to the request that asked for it.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// Job states; jobs.status has a CHECK constraint with the same list
+const (
+	StatusQueued  = "queued"  // waiting for run_at
+	StatusRunning = "running" // leased by a worker
+	StatusDone    = "done"    // kept until Cleanup, so Key still deduplicates
+	StatusDead    = "dead"    // out of attempts; stays until retried
+)
+
+// ErrNotFound — Retry was given a job that doesn't exist or isn't dead
+var ErrNotFound = errors.New("jobs: no such dead job")
+
+// Priorities: any int works, higher runs first; these are the usual
+// ones. The zero value is PriorityNormal.
+const (
+	PriorityLow    = -10 // bulk work that can wait: exports, backfills
+	PriorityNormal = 0
+	PriorityHigh   = 10 // someone is waiting for it: reminders
+)
+
+// Job is one row of the queue
+type Job struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Key         *string         `json:"key"`
+	Payload     json.RawMessage `json:"payload"`
+	Priority    int             `json:"priority"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"` // including the one running now
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   *string         `json:"last_error"`
+	RequestID   string          `json:"request_id,omitempty"` // of the request that enqueued it
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at"`
+}
+
+// Handler does one job. A returned error schedules a retry, unless it
+// is Permanent.
+type Handler func(ctx context.Context, job Job) error
+
+// permanentError — see Permanent
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying (a payload that will never
+// parse, say): the job goes straight to the dead letters.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked by Permanent
+func IsPermanent(err error) bool {
+	return errors.As(err, new(permanentError))
+}
+
+// Outcomes of a run, as an Observer sees them
+const (
+	OutcomeDone  = "done"
+	OutcomeRetry = "retry" // failed, queued again with backoff
+	OutcomeDead  = "dead"  // failed for good
+)
+
+// RunInfo describes one finished run of a job
+type RunInfo struct {
+	Job      Job
+	Outcome  string
+	Wait     time.Duration // from due (run_at) to claimed
+	Duration time.Duration // of the handler
+}
+
+// Observer hears about every run, e.g. to keep metrics. It is called
+// from the workers, so it must be safe for concurrent use.
+type Observer interface {
+	ObserveRun(RunInfo)
+}
+
+// Queue enqueues jobs and runs the workers that consume them. Set the
+// exported fields before Run.
+type Queue struct {
+	pool     *pgxpool.Pool
+	handlers map[string]Handler
+	wake     chan struct{}
+
+	Workers      int           // concurrent jobs per instance
+	PollInterval time.Duration // how often idle workers look for due jobs
+	Timeout      time.Duration // per run; the lease is twice this
+	MaxAttempts  int           // default for Enqueue
+	BackoffBase  time.Duration // wait after the first failure; doubles each time
+	BackoffMax   time.Duration
+	// FairEvery — every n-th claim of a worker takes the longest-waiting
+	// due job whatever its priority; 0 = strict priority
+	FairEvery int
+	Log       *slog.Logger
+	Observer  Observer // nil = none
+}
+
+func New(pool *pgxpool.Pool) *Queue {
+	return &Queue{
+		pool:         pool,
+		handlers:     map[string]Handler{},
+		wake:         make(chan struct{}, 1),
+		Workers:      4,
+		PollInterval: time.Second,
+		Timeout:      time.Minute,
+		MaxAttempts:  5,
+		BackoffBase:  10 * time.Second,
+		BackoffMax:   time.Hour,
+		FairEvery:    5,
+		Log:          slog.Default(),
+	}
+}
+
+// Handle registers the handler for a kind. Workers only claim kinds
+// they have a handler for, so an instance running older code leaves
+// new kinds to the instances that know them.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// NewJob — what Enqueue needs; zero fields get defaults
+type NewJob struct {
+	Kind    string
+	Payload any // marshalled to JSON
+	// Key, when set, makes the enqueue idempotent: a second job with the
+	// same key is dropped for as long as the first one stays in the
+	// table (done jobs until Cleanup)
+	Key         string
+	Priority    int       // higher first; 0 = PriorityNormal
+	RunAt       time.Time // zero = now; later = not before then
+	MaxAttempts int       // 0 = Queue.MaxAttempts
+	// RequestID links the job to a request; "" = the one in ctx, if any
+	RequestID string
+}
+
+// Enqueue adds a job and reports whether it was added (false: a job
+// with its Key already exists)
+func (q *Queue) Enqueue(ctx context.Context, nj NewJob) (bool, error) {
+	payload, err := json.Marshal(nj.Payload)
+	if err != nil {
+		return false, fmt.Errorf("jobs: marshal %s payload: %w", nj.Kind, err)
+	}
+	var key, runAt, requestID any // nil → NULL / NOW()
+	if nj.Key != "" {
+		key = nj.Key
+	}
+	if nj.RequestID == "" {
+		nj.RequestID = requestctx.RequestID(ctx)
+	}
+	if nj.RequestID != "" {
+		requestID = nj.RequestID
+	}
+	if !nj.RunAt.IsZero() {
+		runAt = nj.RunAt
+	}
+	if nj.MaxAttempts == 0 {
+		nj.MaxAttempts = q.MaxAttempts
+	}
+
+	tag, err := q.pool.Exec(ctx, `
+		INSERT INTO jobs (kind, key, payload, priority, max_attempts, run_at, request_id)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()), $7)
+		ON CONFLICT (key) DO NOTHING`,
+		nj.Kind, key, payload, nj.Priority, nj.MaxAttempts, runAt, requestID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("jobs: enqueue %s: %w", nj.Kind, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	select {
+	case q.wake <- struct{}{}: // an idle worker here needn't wait for its poll
+	default:
+	}
+	return true, nil
+}
+
+// Run works the queue until ctx is cancelled, then waits for the jobs
+// in progress to finish (each within Timeout) before returning.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range q.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	kinds := make([]string, 0, len(q.handlers))
+	for kind := range q.handlers {
+		kinds = append(kinds, kind)
+	}
+
+	for claims := 1; ctx.Err() == nil; claims++ {
+		fair := q.FairEvery > 0 && claims%q.FairEvery == 0
+		job, wait, ok, err := q.claim(ctx, kinds, fair)
+		if err != nil && ctx.Err() == nil {
+			q.Log.Error("jobs: claim", "err", err)
+		}
+		if ok {
+			q.run(ctx, job, wait)
+			continue // there may be more
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// claim leases the next due job: queued and due, or running with an
+// expired lease (its worker is gone) — the highest priority first, or
+// with fair the one due longest. wait is how long it was due.
+func (q *Queue) claim(ctx context.Context, kinds []string, fair bool) (job Job, wait time.Duration, ok bool, err error) {
+	sql := sqlClaimByPriority
+	if fair {
+		sql = sqlClaimOldest
+	}
+	var waited float64
+	err = q.pool.QueryRow(ctx, sql, kinds, (2 * q.Timeout).Seconds()).Scan(append(jobFields(&job), &waited)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, 0, false, nil
+	}
+	if err != nil {
+		return Job{}, 0, false, err
+	}
+	return job, time.Duration(waited * float64(time.Second)), true, nil
+}
+
+// sqlClaim — the claim statement, ordered by %s; one string per order,
+// so each is one prepared statement
+const sqlClaim = `
+	UPDATE jobs SET status = 'running', attempts = attempts + 1,
+	       locked_until = NOW() + make_interval(secs => $2)
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE kind = ANY($1)
+		  AND ((status = 'queued' AND run_at <= NOW())
+		    OR (status = 'running' AND locked_until < NOW()))
+		ORDER BY %s LIMIT 1
+		FOR UPDATE SKIP LOCKED)
+	RETURNING ` + jobColumns + `, EXTRACT(EPOCH FROM NOW() - run_at)::float8`
+
+var (
+	sqlClaimByPriority = fmt.Sprintf(sqlClaim, "priority DESC, run_at, id")
+	sqlClaimOldest     = fmt.Sprintf(sqlClaim, "run_at, id")
+)
+
+// run calls the handler and records the outcome. The handler gets a
+// context of its own: shutdown stops workers from claiming, it doesn't
+// cut a job off halfway.
+func (q *Queue) run(ctx context.Context, job Job, wait time.Duration) {
+	log := q.Log.With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	if job.RequestID != "" {
+		log = log.With("request_id", job.RequestID)
+	}
+	if job.Attempts > job.MaxAttempts {
+		// Only a lease that kept running out gets here: the worker died
+		// mid-job every time
+		outcome := q.finish(log, job, errors.New("lease expired on every attempt"), true)
+		q.observe(RunInfo{Job: job, Outcome: outcome, Wait: wait})
+		return
+	}
+
+	runCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), q.Timeout)
+	defer cancel()
+	runCtx = requestctx.WithLogger(runCtx, log)
+	if job.RequestID != "" {
+		runCtx = requestctx.WithRequestID(runCtx, job.RequestID)
+	}
+	start := time.Now()
+	err := q.call(runCtx, job)
+	took := time.Since(start)
+	if err == nil {
+		log.Info("job done", "duration", took)
+	}
+	outcome := q.finish(log, job, err, IsPermanent(err))
+	q.observe(RunInfo{Job: job, Outcome: outcome, Wait: wait, Duration: took})
+}
+
+func (q *Queue) observe(run RunInfo) {
+	if q.Observer != nil {
+		q.Observer.ObserveRun(run)
+	}
+}
+
+// call runs the handler; a panic is that job's failure, not the worker's
+func (q *Queue) call(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return q.handlers[job.Kind](ctx, job)
+}
+
+// finish marks the job done, schedules its retry, or buries it, and
+// says which
+func (q *Queue) finish(log *slog.Logger, job Job, jobErr error, permanent bool) string {
+	// The worker's ctx may be cancelled by now; the outcome still counts
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	var outcome string
+	switch {
+	case jobErr == nil:
+		outcome = OutcomeDone
+		_, err = q.pool.Exec(ctx, `UPDATE jobs SET status = 'done', finished_at = NOW(), locked_until = NULL
+			WHERE id = $1`, job.ID)
+	case permanent || job.Attempts >= job.MaxAttempts:
+		outcome = OutcomeDead
+		log.Error("job failed for good", "err", jobErr)
+		_, err = q.pool.Exec(ctx, `UPDATE jobs SET status = 'dead', finished_at = NOW(), locked_until = NULL,
+			last_error = $2 WHERE id = $1`, job.ID, jobErr.Error())
+	default:
+		outcome = OutcomeRetry
+		delay := q.backoff(job.Attempts)
+		log.Warn("job failed, will retry", "err", jobErr, "retry_in", delay)
+		_, err = q.pool.Exec(ctx, `UPDATE jobs SET status = 'queued', run_at = NOW() + make_interval(secs => $3),
+			locked_until = NULL, last_error = $2 WHERE id = $1`, job.ID, jobErr.Error(), delay.Seconds())
+	}
+	if err != nil {
+		// The lease runs out and the job is run again
+		log.Error("jobs: record outcome", "err", err)
+	}
+	return outcome
+}
+
+// backoff — BackoffBase after the first failure, doubling up to
+// BackoffMax
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.BackoffBase
+	for i := 1; i < attempts && d < q.BackoffMax; i++ {
+		d *= 2
+	}
+	return min(d, q.BackoffMax)
+}
+
+const jobColumns = "id, kind, key, payload, priority, status, attempts, max_attempts, run_at, last_error, COALESCE(request_id, ''), created_at, finished_at"
+
+// jobFields — scan destinations matching jobColumns
+func jobFields(j *Job) []any {
+	return []any{&j.ID, &j.Kind, &j.Key, &j.Payload, &j.Priority, &j.Status, &j.Attempts, &j.MaxAttempts,
+		&j.RunAt, &j.LastError, &j.RequestID, &j.CreatedAt, &j.FinishedAt}
+}
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	err := row.Scan(jobFields(&j)...)
+	return j, err
+}
+
+// -----------------------------------------------------------
+// DEAD LETTERS AND HOUSEKEEPING
+// -----------------------------------------------------------
+
+// List returns jobs in one status, newest first
+func (q *Queue) List(ctx context.Context, status string, limit, offset int) ([]Job, error) {
+	rows, err := q.pool.Query(ctx, "SELECT "+jobColumns+" FROM jobs WHERE status = $1 ORDER BY id DESC LIMIT $2 OFFSET $3",
+		status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: list %s: %w", status, err)
+	}
+	defer rows.Close()
+
+	out := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("jobs: scan: %w", err)
+		}
+		out = append(out, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("jobs: rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+// Retry puts a dead job back in the queue with a fresh set of
+// attempts; last_error is kept until it runs
+func (q *Queue) Retry(ctx context.Context, id int64) (Job, error) {
+	job, err := scanJob(q.pool.QueryRow(ctx, `
+		UPDATE jobs SET status = 'queued', attempts = 0, run_at = NOW(), finished_at = NULL
+		WHERE id = $1 AND status = 'dead'
+		RETURNING `+jobColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Job{}, ErrNotFound
+	}
+	if err != nil {
+		return Job{}, fmt.Errorf("jobs: retry %d: %w", id, err)
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// KindStats — the queue right now, for one kind
+type KindStats struct {
+	Kind    string
+	Queued  int // due or not
+	Running int
+	Dead    int
+	// OldestDue — how long the longest-waiting due job has waited;
+	// growing means workers are not keeping up
+	OldestDue time.Duration
+}
+
+// Stats counts unfinished jobs by kind
+func (q *Queue) Stats(ctx context.Context) ([]KindStats, error) {
+	rows, err := q.pool.Query(ctx, `
+		SELECT kind,
+		       COUNT(*) FILTER (WHERE status = 'queued'),
+		       COUNT(*) FILTER (WHERE status = 'running'),
+		       COUNT(*) FILTER (WHERE status = 'dead'),
+		       COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(run_at) FILTER (WHERE status = 'queued' AND run_at <= NOW())), 0)::float8
+		FROM jobs WHERE status <> 'done'
+		GROUP BY kind ORDER BY kind`)
+	if err != nil {
+		return nil, fmt.Errorf("jobs: stats: %w", err)
+	}
+	defer rows.Close()
+
+	out := []KindStats{}
+	for rows.Next() {
+		var s KindStats
+		var oldest float64
+		if err := rows.Scan(&s.Kind, &s.Queued, &s.Running, &s.Dead, &oldest); err != nil {
+			return nil, fmt.Errorf("jobs: scan stats: %w", err)
+		}
+		s.OldestDue = time.Duration(oldest * float64(time.Second))
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("jobs: rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+// Cleanup deletes done jobs finished more than olderThan ago (dead ones
+// stay until someone looks at them) and returns how many went.
+func (q *Queue) Cleanup(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tag, err := q.pool.Exec(ctx,
+		"DELETE FROM jobs WHERE status = 'done' AND finished_at < NOW() - make_interval(secs => $1)",
+		olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("jobs: cleanup: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunCleanup calls Cleanup every interval until ctx is cancelled.
+// Errors are passed to onErr (may be nil) and don't stop the loop.
+func (q *Queue) RunCleanup(ctx context.Context, every, olderThan time.Duration, onErr func(error)) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := q.Cleanup(ctx, olderThan); err != nil && onErr != nil {
+				onErr(err)
+			}
+		}
+	}
+}
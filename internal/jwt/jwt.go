@@ -0,0 +1,191 @@
+// Package jwt signs and verifies JSON Web Tokens (RFC 7519) — only the
+// compact form and only the algorithms this service meets:
+//
+//	HS256 — our own access tokens, keyed with auth.token_secret
+//	RS256 — ID tokens of most OIDC providers (Google, Keycloak)
+//	ES256 — ID tokens of the others
+//
+// The key decides the algorithm: Verify takes the key for a token's
+// header and refuses a token whose alg doesn't fit it, so an RSA public
+// key is never mistaken for an HMAC secret ("alg confusion"), and "none"
+// is never accepted.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed = errors.New("jwt: malformed token")
+	ErrSignature = errors.New("jwt: invalid signature")
+	ErrExpired   = errors.New("jwt: token expired")
+	ErrNotYet    = errors.New("jwt: token not valid yet")
+)
+
+// Header — the fields of a token's header that matter for verifying it
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"` // which of the issuer's keys signed it
+	Typ string `json:"typ,omitempty"`
+}
+
+// Claims — the registered claims; embed it in a struct with the others
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"` // Unix seconds; 0 = never
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// Valid checks exp and nbf at now, allowing leeway of clock skew
+func (c Claims) Valid(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt != 0 && now.Add(-leeway).Unix() >= c.ExpiresAt {
+		return ErrExpired
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Unix() < c.NotBefore {
+		return ErrNotYet
+	}
+	return nil
+}
+
+// Audience — "aud" is one string or an array of them
+type Audience []string
+
+func (a Audience) Contains(s string) bool { return slices.Contains(a, s) }
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = Audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+var b64 = base64.RawURLEncoding
+
+// SignHS256 encodes claims (any JSON-marshalable value) as a token
+// signed with secret
+func SignHS256(claims any, secret []byte) (string, error) {
+	header, err := json.Marshal(Header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("jwt: encode claims: %w", err)
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+	return signed + "." + b64.EncodeToString(mac.Sum(nil)), nil
+}
+
+// KeyFunc returns the key to check a token with: []byte for HS256,
+// *rsa.PublicKey for RS256, *ecdsa.PublicKey for ES256
+type KeyFunc func(h Header) (any, error)
+
+// Verify checks token's signature with the key for its header and
+// decodes its payload into claims. It doesn't look at the claims:
+// check exp and nbf (Claims.Valid), iss and aud after it.
+func Verify(token string, key KeyFunc, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrMalformed
+	}
+	raw, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return ErrMalformed
+	}
+	var h Header
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return ErrMalformed
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return ErrMalformed
+	}
+
+	k, err := key(h)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(h.Alg, k, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return err
+	}
+
+	payload, err := b64.DecodeString(parts[1])
+	if err != nil {
+		return ErrMalformed
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("%w: claims: %v", ErrMalformed, err)
+	}
+	return nil
+}
+
+func verifySignature(alg string, key any, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch k := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			break
+		}
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return ErrSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return ErrSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" {
+			break
+		}
+		// r and s, 32 bytes each (RFC 7518 §3.4), not ASN.1
+		if len(sig) != 64 {
+			return ErrSignature
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return ErrSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("jwt: unsupported key type %T", key)
+	}
+	return fmt.Errorf("%w: alg %q doesn't fit a %T key", ErrSignature, alg, key)
+}
@@ -0,0 +1,359 @@
+// Package oidc is the relying-party side of OpenID Connect's
+// authorization code flow, with PKCE:
+//
+//  1. AuthURL — where to send the browser, with a state and nonce
+//     the caller keeps (a cookie) and a PKCE challenge
+//  2. the provider redirects back with ?code=&state=
+//  3. Exchange — the code (and the PKCE verifier) for an ID token,
+//     server to server
+//  4. Verify — the ID token's signature (the provider's JWKS), its
+//     issuer, audience, expiry and nonce
+//
+// The provider's endpoints come from its discovery document
+// (issuer + /.well-known/openid-configuration), fetched on first use
+// rather than at startup, so a provider outage breaks logins, not the
+// service. Works with Google, Keycloak and any provider that signs ID
+// tokens with RS256 or ES256.
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox-go/internal/jwt"
+)
+
+const (
+	// leeway — clock skew allowed between us and the provider
+	leeway = time.Minute
+	// keysRefresh — the JWKS is fetched again for an unknown kid (the
+	// provider rotated its keys), but not more often than this
+	keysRefresh = time.Minute
+	maxResponse = 1 << 20
+)
+
+// Config — one provider, as registered with it
+type Config struct {
+	Issuer       string // e.g. https://accounts.google.com, https://kc.example.com/realms/main
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string   // our callback, exactly as registered
+	Scopes       []string // openid is always asked for
+}
+
+// Identity — who the provider says signed in
+type Identity struct {
+	Issuer        string
+	Subject       string // stable and unique within Issuer; emails can change
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu     sync.Mutex
+	meta   *discovery // nil until fetched
+	keys   map[string]any
+	keysAt time.Time
+}
+
+type discovery struct {
+	Issuer        string `json:"issuer"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+func New(cfg Config, client *http.Client) *Provider {
+	return &Provider{cfg: cfg, client: client}
+}
+
+// RandomString — 32 random bytes, base64url: states, nonces, PKCE
+// verifiers
+func RandomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Challenge — the PKCE S256 challenge of verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL — the provider's login page for this state, nonce and PKCE
+// verifier
+func (p *Provider) AuthURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	scopes := []string{"openid"}
+	for _, s := range p.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange trades an authorization code for the raw ID token
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic: both parts form-encoded first (RFC 6749 §2.3.1)
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	var tok struct {
+		IDToken     string `json:"id_token"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	status, err := p.do(req, &tok)
+	if err != nil {
+		return "", fmt.Errorf("oidc: token endpoint: %w", err)
+	}
+	if tok.Error != "" {
+		return "", fmt.Errorf("oidc: token endpoint: %s: %s", tok.Error, tok.Description)
+	}
+	if status != http.StatusOK || tok.IDToken == "" {
+		return "", fmt.Errorf("oidc: token endpoint: status %d, no id_token", status)
+	}
+	return tok.IDToken, nil
+}
+
+// Verify checks an ID token and that it answers the login that sent
+// nonce
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (Identity, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	var claims struct {
+		jwt.Claims
+		Nonce         string   `json:"nonce"`
+		Email         string   `json:"email"`
+		EmailVerified flexBool `json:"email_verified"`
+		Name          string   `json:"name"`
+	}
+	if err := jwt.Verify(raw, func(h jwt.Header) (any, error) { return p.key(ctx, h.Kid) }, &claims); err != nil {
+		return Identity{}, fmt.Errorf("oidc: id token: %w", err)
+	}
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return Identity{}, fmt.Errorf("oidc: id token from %q, want %q", claims.Issuer, meta.Issuer)
+	case !claims.Audience.Contains(p.cfg.ClientID):
+		return Identity{}, errors.New("oidc: id token is for another client")
+	case claims.Nonce != nonce:
+		return Identity{}, errors.New("oidc: id token nonce doesn't match the login's")
+	case claims.Subject == "":
+		return Identity{}, errors.New("oidc: id token has no subject")
+	}
+	if err := claims.Valid(time.Now(), leeway); err != nil {
+		return Identity{}, fmt.Errorf("oidc: id token: %w", err)
+	}
+	return Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// discover fetches the discovery document once; a failed fetch is
+// tried again on the next call
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta discovery
+	status, err := p.do(req, &meta)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if meta.Issuer != strings.TrimSuffix(p.cfg.Issuer, "/") && meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q, want %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: missing endpoints")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// key — the provider's public key kid; "" takes the only one there is
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < keysRefresh {
+		return nil, fmt.Errorf("oidc: unknown key %q", kid)
+	}
+	keys, err := p.fetchKeys(ctx)
+	p.keysAt = time.Now() // a failure waits keysRefresh too
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	if k, ok := p.lookup(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key %q", kid)
+}
+
+func (p *Provider) lookup(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+// fetchKeys reads the JWKS; keys of other types or curves are skipped.
+// Call with p.mu held, after discover.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	status, err := p.do(req, &set)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("oidc: keys: %w", err)
+	}
+
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+				continue
+			}
+			keys[k.Kid] = pub
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("oidc: keys: no RSA or P-256 signing keys")
+	}
+	return keys, nil
+}
+
+// do sends req and decodes its JSON body into v, whatever the status
+func (p *Provider) do(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("status %d, decode: %w", resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// flexBool — email_verified is a JSON boolean, or with some providers
+// the string "true"
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	s := strings.Trim(string(data), `"`)
+	*b = flexBool(s == "true")
+	return nil
+}
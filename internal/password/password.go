@@ -0,0 +1,100 @@
+// Package password hashes and checks user passwords. New hashes are
+// argon2id, in the usual encoding:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+//
+// so each hash carries the parameters it was made with. Verify also
+// accepts bcrypt ($2a$, $2b$, $2y$), for passwords brought over from
+// elsewhere, and says when a hash that matched should be made again:
+// a bcrypt one, or argon2id with other parameters than the current
+// ones — raising the cost takes effect at each user's next login.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch  = errors.New("password: wrong password")
+	ErrMalformed = errors.New("password: malformed hash")
+)
+
+const (
+	saltLen = 16
+	keyLen  = 32
+)
+
+// Params — argon2id's cost. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// Hash — a new hash of pw, with a random salt
+func Hash(pw string, p Params) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("password salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(pw), salt, p.Iterations, p.Memory, p.Parallelism, keyLen)
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify checks pw against hash: nil, ErrMismatch or ErrMalformed.
+// rehash is true when pw matched but hash should be replaced with
+// Hash(pw, p).
+func Verify(hash, pw string, p Params) (rehash bool, err error) {
+	if strings.HasPrefix(hash, "$2") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(pw))
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, ErrMismatch
+		case err != nil:
+			return false, fmt.Errorf("%w: %v", ErrMalformed, err)
+		}
+		return true, nil
+	}
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return false, ErrMalformed
+	}
+	var version int
+	var got Params
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrMalformed
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &got.Memory, &got.Iterations, &got.Parallelism); err != nil {
+		return false, ErrMalformed
+	}
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrMalformed
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, ErrMalformed
+	}
+	if got.Iterations == 0 || got.Parallelism == 0 {
+		return false, ErrMalformed
+	}
+
+	other := argon2.IDKey([]byte(pw), salt, got.Iterations, got.Memory, got.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, ErrMismatch
+	}
+	return got != p || len(salt) != saltLen || len(key) != keyLen, nil
+}
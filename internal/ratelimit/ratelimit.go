@@ -0,0 +1,135 @@
+// Package ratelimit implements token-bucket rate limiting per client.
+//
+// Each client key (API key, IP, ...) gets a bucket holding up to Burst
+// tokens that refills at Rate tokens per second; a request spends one
+// token or is rejected. Limiter is an interface so a shared backend
+// (e.g. Redis, for several replicas) can replace the in-memory one.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision is the outcome of one Allow call.
+type Decision struct {
+	Allowed    bool
+	Limit      int           // bucket size (burst)
+	Remaining  int           // whole tokens left after this request
+	RetryAfter time.Duration // when the next token arrives, if rejected
+}
+
+// Limit — a bucket's size and how fast it refills (tokens per second)
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter decides whether the client identified by key may proceed.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+	// AllowLimit is Allow with a limit of the key's own rather than the
+	// limiter's; use the same limit for a key every time
+	AllowLimit(ctx context.Context, key string, l Limit) (Decision, error)
+}
+
+// -----------------------------------------------------------
+// IN-MEMORY STORE — good for a single instance
+// -----------------------------------------------------------
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type Memory struct {
+	rate  float64 // tokens per second
+	burst int
+	ttl   time.Duration // idle buckets older than this are dropped
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+// NewMemory creates an in-memory limiter. Idle buckets are kept for ttl
+// (a bucket idle long enough to refill completely carries no state, so
+// ttl only needs to exceed burst/rate).
+func NewMemory(rate float64, burst int, ttl time.Duration) *Memory {
+	return &Memory{
+		rate:    rate,
+		burst:   burst,
+		ttl:     ttl,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string) (Decision, error) {
+	return m.AllowLimit(ctx, key, Limit{Rate: m.rate, Burst: m.burst})
+}
+
+// AllowLimit — the bucket is still dropped after the limiter's ttl: a
+// key whose limit refills slower than that may get a full bucket early
+func (m *Memory) AllowLimit(_ context.Context, key string, l Limit) (Decision, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		m.buckets[key] = b
+	}
+
+	// Refill for the time since the last request, capped at burst
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+
+	d := Decision{Limit: l.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	}
+	d.Remaining = int(b.tokens)
+	return d, nil
+}
+
+// Cleanup drops buckets idle for longer than the TTL and returns how
+// many were removed.
+func (m *Memory) Cleanup() int {
+	cutoff := m.now().Add(-m.ttl)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	for key, b := range m.buckets {
+		if b.last.Before(cutoff) {
+			delete(m.buckets, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunCleanup calls Cleanup every interval until ctx is cancelled, so
+// one-off clients (scanners, rotating IPs) don't grow the map forever.
+func (m *Memory) RunCleanup(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Cleanup()
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package recur parses recurrence rules and computes when the next
+// occurrence is due.
+//
+// A rule is one of
+//
+//	daily              same time tomorrow
+//	weekly             same time next week
+//	0 9 * * 1-5        a cron expression: minute hour day-of-month month day-of-week
+//
+// Cron fields accept *, numbers, ranges (1-5), lists (1,15) and steps
+// (*/15, 0-30/10); day-of-week 0 and 7 are both Sunday. As in cron,
+// when both day-of-month and day-of-week are restricted a day matching
+// either one counts.
+package recur
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule — a parsed recurrence; use Parse
+type Rule struct {
+	text string
+	days int   // daily / weekly: fixed step in days
+	cron *cron // otherwise
+}
+
+// Parse accepts "daily", "weekly" (also "@daily", "@weekly") or a
+// five-field cron expression.
+func Parse(s string) (Rule, error) {
+	text := strings.TrimSpace(s)
+	switch strings.TrimPrefix(strings.ToLower(text), "@") {
+	case "daily":
+		return Rule{text: text, days: 1}, nil
+	case "weekly":
+		return Rule{text: text, days: 7}, nil
+	}
+	c, err := parseCron(text)
+	if err != nil {
+		return Rule{}, err
+	}
+	return Rule{text: text, cron: c}, nil
+}
+
+func (r Rule) String() string { return r.text }
+
+// Next — the first occurrence strictly after t, in t's location.
+// daily/weekly keep t's wall-clock time across DST changes.
+func (r Rule) Next(t time.Time) time.Time {
+	if r.cron == nil {
+		return t.AddDate(0, 0, r.days)
+	}
+	return r.cron.nextOrZero(t)
+}
+
+// NextAfter — the first occurrence after from that is also after now:
+// a series that fell behind (a daily task completed three days late)
+// resumes from today instead of producing occurrences already overdue.
+func (r Rule) NextAfter(from, now time.Time) time.Time {
+	if !from.Before(now) {
+		return r.Next(from)
+	}
+	if r.cron != nil {
+		return r.cron.nextOrZero(now) // cron times don't depend on where the series started
+	}
+	// Whole steps that were missed, then at most a step or two more
+	// (a step is shorter or longer than 24h across DST changes)
+	missed := int(now.Sub(from).Hours()/24) / r.days
+	next := from.AddDate(0, 0, missed*r.days)
+	for !next.After(now) {
+		next = next.AddDate(0, 0, r.days)
+	}
+	return next
+}
+
+// -----------------------------------------------------------
+// CRON
+// -----------------------------------------------------------
+
+type cron struct {
+	minute, hour, dom, month, dow uint64 // bit i set = value i allowed
+	domStar, dowStar              bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = [5]field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+func parseCron(s string) (*cron, error) {
+	parts := strings.Fields(s)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%q: want daily, weekly or a cron expression with 5 fields (minute hour day month weekday), got %d", s, len(parts))
+	}
+
+	var sets [5]uint64
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+		sets[i] = set
+	}
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1 // 7 is Sunday too
+	}
+	c := &cron{
+		minute: sets[0], hour: sets[1], dom: sets[2], month: sets[3], dow: sets[4],
+		domStar: parts[2] == "*", dowStar: parts[4] == "*",
+	}
+	if _, ok := c.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); !ok {
+		return nil, fmt.Errorf("%q never matches a date", s) // 30 * in February only
+	}
+	return c, nil
+}
+
+// parseField — "*", "5", "1-5", "*/15", "0-30/10", "1,15" and mixes
+func parseField(s string, f field) (uint64, error) {
+	var set uint64
+	for _, item := range strings.Split(s, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s: bad step %q", f.name, stepText)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = bound(loText, f); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = bound(hiText, f); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = f.max // "5/15" = from 5 on, every 15
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("%s: range %q runs backwards", f.name, rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func bound(s string, f field) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %q is not a number", f.name, s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%s: %d is outside %d-%d", f.name, n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// next walks forward a field at a time, skipping whole months, days
+// and hours that can't match, so even yearly rules take few steps
+func (c *cron) next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0) // leap days come round every 4 years
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// nextOrZero — never zero in practice: Parse checked the rule matches
+func (c *cron) nextOrZero(t time.Time) time.Time {
+	next, _ := c.next(t)
+	return next
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
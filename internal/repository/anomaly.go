@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// ANOMALIES — what internal/abuse noticed about a client's
+// traffic, one row per report, for GET /admin/anomalies. Every
+// instance writes its own; purged after abuse.retention.
+// -----------------------------------------------------------
+
+type Anomaly struct {
+	ID     int64  `json:"id"`
+	Client string `json:"client"` // "key:7", "user:3" or "ip:203.0.113.9"
+	Kind   string `json:"kind"`   // rate_spike, error_spike, new_endpoint, enumeration
+	Route  string `json:"route,omitempty"`
+	Detail string `json:"detail"`
+	OrgID  *int   `json:"org_id"` // the client's organization, if it authenticated
+	// RequestID, ClientIP — of the request that tipped it
+	RequestID string    `json:"request_id,omitempty"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// AnomalyFilter — zero fields match everything
+type AnomalyFilter struct {
+	Client   string
+	Kind     string
+	OrgID    *int
+	From, To time.Time // At >= From, At < To
+}
+
+type AnomalyRepository interface {
+	// Record stores a; its ID and At are set by the database
+	Record(ctx context.Context, a Anomaly) error
+	// List returns anomalies, newest first — of ctx's organization, if
+	// it has one
+	List(ctx context.Context, f AnomalyFilter, page Page) ([]Anomaly, error)
+	// Purge deletes those older than olderThan, by the database clock
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+type PgxAnomalyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxAnomalyRepository(db *pgxpool.Pool) *PgxAnomalyRepository {
+	return &PgxAnomalyRepository{db: db}
+}
+
+func (r *PgxAnomalyRepository) Record(ctx context.Context, a Anomaly) error {
+	var requestID, clientIP any // "" → NULL
+	if a.RequestID != "" {
+		requestID = a.RequestID
+	}
+	if a.ClientIP != "" {
+		clientIP = a.ClientIP
+	}
+	_, err := conn(ctx, r.db).Exec(ctx,
+		`INSERT INTO anomalies (client, kind, route, detail, org_id, request_id, client_ip)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7::inet)`,
+		a.Client, a.Kind, a.Route, a.Detail, a.OrgID, requestID, clientIP)
+	if err != nil {
+		return fmt.Errorf("record anomaly: %w", err)
+	}
+	return nil
+}
+
+const anomalyColumns = "id, client, kind, route, detail, org_id, COALESCE(request_id, ''), COALESCE(host(client_ip), ''), created_at"
+
+func (r *PgxAnomalyRepository) List(ctx context.Context, f AnomalyFilter, page Page) ([]Anomaly, error) {
+	org := orgScope(ctx)
+	q := newSelect(anomalyColumns, "anomalies").order("id DESC").paged(page).
+		where("(?::int IS NULL OR org_id = ?)", org, org)
+	if f.Client != "" {
+		q.where("client = ?", f.Client)
+	}
+	if f.Kind != "" {
+		q.where("kind = ?", f.Kind)
+	}
+	if f.OrgID != nil {
+		q.where("org_id = ?", *f.OrgID)
+	}
+	if !f.From.IsZero() {
+		q.where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q.where("created_at < ?", f.To)
+	}
+	sql, args := q.build()
+	rows, err := readConn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query anomalies: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Anomaly{}
+	for rows.Next() {
+		var a Anomaly
+		if err := rows.Scan(&a.ID, &a.Client, &a.Kind, &a.Route, &a.Detail, &a.OrgID, &a.RequestID, &a.ClientIP, &a.At); err != nil {
+			return nil, fmt.Errorf("scan anomaly: %w", err)
+		}
+		out = append(out, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+func (r *PgxAnomalyRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx,
+		"DELETE FROM anomalies WHERE created_at < NOW() - make_interval(secs => $1)", olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("purge anomalies: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
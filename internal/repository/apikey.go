@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// API KEYS — long-lived credentials for other services. A key
+// acts as the user who owns it (their organization, their role),
+// optionally read-only and with its own rate limit. Only the
+// SHA-256 of the secret is stored: the secret itself is shown
+// once, when the key is minted, and a key is looked up by the
+// hash of what the caller sends. Revoked keys stay, for the
+// record, and never match again.
+// -----------------------------------------------------------
+
+// API key scopes; api_keys.scope has a CHECK constraint with the same
+// list
+const (
+	ScopeRead  = "read"  // reads only, whatever the user's role
+	ScopeWrite = "write" // whatever the user's role allows
+)
+
+type APIKey struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"` // acts as this user
+	OrgID  int    `json:"org_id"`  // the user's organization
+	Name   string `json:"name"`
+	// Prefix — the secret's first characters, to tell keys apart
+	Prefix string `json:"prefix"`
+	Scope  string `json:"scope"`
+	// RateLimitRPS and RateLimitBurst replace rate_limit.rps and burst
+	// for this key; nil = the defaults
+	RateLimitRPS   *float64   `json:"rate_limit_rps"`
+	RateLimitBurst *int       `json:"rate_limit_burst"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"` // to the minute
+	RevokedAt      *time.Time `json:"revoked_at"`
+}
+
+// NewAPIKey — fields needed to mint a key
+type NewAPIKey struct {
+	UserID         int
+	Name           string
+	Prefix         string
+	Hash           string // hex SHA-256 of the secret
+	Scope          string
+	RateLimitRPS   *float64
+	RateLimitBurst *int
+}
+
+type APIKeyRepository interface {
+	// List returns the keys of one user (0 = everyone's), revoked ones
+	// included, by id
+	List(ctx context.Context, userID int, page Page) ([]APIKey, error)
+	Get(ctx context.Context, id int) (APIKey, error)
+	Create(ctx context.Context, k NewAPIKey) (APIKey, error)
+	// Revoke is a no-op for a key already revoked
+	Revoke(ctx context.Context, id int) (APIKey, error)
+	// Lookup finds the unrevoked key with this hash, in any organization
+	// (ErrNotFound if there is none)
+	Lookup(ctx context.Context, hash string) (APIKey, error)
+	// Touch records that a key was used just now
+	Touch(ctx context.Context, id int) error
+}
+
+type PgxAPIKeyRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxAPIKeyRepository(db *pgxpool.Pool) *PgxAPIKeyRepository {
+	return &PgxAPIKeyRepository{db: db}
+}
+
+const (
+	// apiKeyColumns — of api_keys k joined with users u
+	apiKeyColumns = "k.id, k.user_id, u.org_id, k.name, k.prefix, k.scope, k.rate_limit_rps, k.rate_limit_burst, k.created_at, k.last_used_at, k.revoked_at"
+	apiKeyFrom    = "api_keys k JOIN users u ON u.id = k.user_id"
+)
+
+func apiKeyNotFound(id int) error {
+	return apperr.Wrap(apperr.APIKeyNotFound, fmt.Sprintf("API key %d not found", id), ErrNotFound)
+}
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var k APIKey
+	err := row.Scan(&k.ID, &k.UserID, &k.OrgID, &k.Name, &k.Prefix, &k.Scope,
+		&k.RateLimitRPS, &k.RateLimitBurst, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	return k, err
+}
+
+func (r *PgxAPIKeyRepository) List(ctx context.Context, userID int, page Page) ([]APIKey, error) {
+	org := orgScope(ctx)
+	q := newSelect(apiKeyColumns, apiKeyFrom).order("k.id").paged(page).
+		where("(?::int IS NULL OR u.org_id = ?)", org, org)
+	if userID != 0 {
+		q.where("k.user_id = ?", userID)
+	}
+	sql, args := q.build()
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	out := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan API key: %w", err)
+		}
+		out = append(out, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+func (r *PgxAPIKeyRepository) Get(ctx context.Context, id int) (APIKey, error) {
+	k, err := scanAPIKey(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+apiKeyColumns+" FROM "+apiKeyFrom+" WHERE k.id = $1 AND ($2::int IS NULL OR u.org_id = $2)",
+		id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, apiKeyNotFound(id)
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("get API key %d: %w", id, err)
+	}
+	return k, nil
+}
+
+func (r *PgxAPIKeyRepository) Create(ctx context.Context, nk NewAPIKey) (APIKey, error) {
+	k, err := scanAPIKey(conn(ctx, r.db).QueryRow(ctx,
+		`WITH k AS (
+		   INSERT INTO api_keys (user_id, name, prefix, hash, scope, rate_limit_rps, rate_limit_burst)
+		   SELECT id, $2::text, $3::text, $4::text, $5::text, $6::float8, $7::int FROM users WHERE id = $1 AND ($8::int IS NULL OR org_id = $8)
+		   RETURNING *
+		 )
+		 SELECT `+apiKeyColumns+` FROM k JOIN users u ON u.id = k.user_id`,
+		nk.UserID, nk.Name, nk.Prefix, nk.Hash, nk.Scope, nk.RateLimitRPS, nk.RateLimitBurst, orgScope(ctx),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, userNotFound(nk.UserID)
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("create API key: %w", err)
+	}
+	return k, nil
+}
+
+func (r *PgxAPIKeyRepository) Revoke(ctx context.Context, id int) (APIKey, error) {
+	k, err := scanAPIKey(conn(ctx, r.db).QueryRow(ctx,
+		`WITH k AS (
+		   UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW())
+		   FROM users u
+		   WHERE api_keys.id = $1 AND u.id = api_keys.user_id AND ($2::int IS NULL OR u.org_id = $2)
+		   RETURNING api_keys.*
+		 )
+		 SELECT `+apiKeyColumns+` FROM k JOIN users u ON u.id = k.user_id`,
+		id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, apiKeyNotFound(id)
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("revoke API key %d: %w", id, err)
+	}
+	return k, nil
+}
+
+func (r *PgxAPIKeyRepository) Lookup(ctx context.Context, hash string) (APIKey, error) {
+	k, err := scanAPIKey(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+apiKeyColumns+" FROM "+apiKeyFrom+" WHERE k.hash = $1 AND k.revoked_at IS NULL", hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, ErrNotFound
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("look up API key: %w", err)
+	}
+	return k, nil
+}
+
+func (r *PgxAPIKeyRepository) Touch(ctx context.Context, id int) error {
+	if _, err := conn(ctx, r.db).Exec(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", id); err != nil {
+		return fmt.Errorf("touch API key %d: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// AUDIT LOG — every write to a task, user or webhook leaves a
+// row in audit_log with the record before and after, written
+// by the repository method in the transaction of the change:
+// no change without its entry, no entry for a rolled-back one.
+// Who: the authenticated user (requestctx.UserID), the request
+// ID and the client's IP; none of them for background work. Where: the record's
+// organization (its org_id; ctx's for a webhook). Batch writes by the
+// schedulers (recurrence, escalation, purge, reassignment) are
+// logged row by row like the rest.
+// -----------------------------------------------------------
+
+// Audited entities (AuditEntry.Entity)
+const (
+	AuditTask    = "task"
+	AuditUser    = "user"
+	AuditWebhook = "webhook"
+)
+
+// Audit actions (AuditEntry.Action). A task's delete moves it to the
+// trash (New has deleted_at); purge removes it for good.
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+	AuditPurge   = "purge"
+)
+
+type AuditEntry struct {
+	ID       int64  `json:"id"`
+	Entity   string `json:"entity"`
+	EntityID int    `json:"entity_id"`
+	Action   string `json:"action"`
+	OrgID    *int   `json:"org_id"` // nil for entries from before tenancy
+	// ActorID — the authenticated user who made the change; nil for
+	// anonymous requests and background work
+	ActorID   *int   `json:"actor_id"`
+	RequestID string `json:"request_id,omitempty"`
+	// ClientIP — where the request came from, past trusted proxies
+	ClientIP string `json:"client_ip,omitempty"`
+	// Changed — top-level fields that differ between Old and New, sorted;
+	// version and updated_at are left out (they change on every write)
+	Changed []string        `json:"changed"`
+	Old     json.RawMessage `json:"old"` // null on create
+	New     json.RawMessage `json:"new"` // null on purge and user/webhook delete
+	At      time.Time       `json:"at"`
+}
+
+// AuditFilter — zero fields match everything
+type AuditFilter struct {
+	Entity   string
+	EntityID int
+	OrgID    *int
+	ActorID  *int
+	From, To time.Time // At >= From, At < To
+}
+
+type AuditRepository interface {
+	// List returns entries, newest first — of ctx's organization, if
+	// it has one
+	List(ctx context.Context, f AuditFilter, page Page) ([]AuditEntry, error)
+}
+
+type PgxAuditRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxAuditRepository(db *pgxpool.Pool) *PgxAuditRepository {
+	return &PgxAuditRepository{db: db}
+}
+
+const auditColumns = "id, entity, entity_id, action, org_id, actor_id, COALESCE(request_id, ''), COALESCE(host(client_ip), ''), changed, old_values, new_values, created_at"
+
+func (r *PgxAuditRepository) List(ctx context.Context, f AuditFilter, page Page) ([]AuditEntry, error) {
+	org := orgScope(ctx)
+	q := newSelect(auditColumns, "audit_log").order("id DESC").paged(page).
+		where("(?::int IS NULL OR org_id = ?)", org, org)
+	if f.Entity != "" {
+		q.where("entity = ?", f.Entity)
+	}
+	if f.EntityID != 0 {
+		q.where("entity_id = ?", f.EntityID)
+	}
+	if f.OrgID != nil {
+		q.where("org_id = ?", *f.OrgID)
+	}
+	if f.ActorID != nil {
+		q.where("actor_id = ?", *f.ActorID)
+	}
+	if !f.From.IsZero() {
+		q.where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		q.where("created_at < ?", f.To)
+	}
+	sql, args := q.build()
+	rows, err := readConn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	out := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Entity, &e.EntityID, &e.Action, &e.OrgID, &e.ActorID, &e.RequestID, &e.ClientIP,
+			&e.Changed, &e.Old, &e.New, &e.At); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+// auditChange — one entry for writeAudit; Old and New are the records
+// as the API shows them (nil = none)
+type auditChange struct {
+	Entity   string
+	EntityID int
+	Action   string
+	Old, New any
+}
+
+// auditRow — an auditChange as jsonb_to_recordset reads it
+type auditRow struct {
+	Entity   string          `json:"entity"`
+	EntityID int             `json:"entity_id"`
+	Action   string          `json:"action"`
+	Changed  []string        `json:"changed"`
+	Old      json.RawMessage `json:"old_values"`
+	New      json.RawMessage `json:"new_values"`
+}
+
+// writeAudit logs changes in one statement. Call it with the
+// transaction that made them.
+func writeAudit(ctx context.Context, db dbtx, changes ...auditChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	list := make([]auditRow, len(changes))
+	for i, c := range changes {
+		row := auditRow{Entity: c.Entity, EntityID: c.EntityID, Action: c.Action, Old: jsonNull, New: jsonNull}
+		var err error
+		if c.Old != nil {
+			if row.Old, err = json.Marshal(c.Old); err != nil {
+				return fmt.Errorf("encode audit of %s %d: %w", c.Entity, c.EntityID, err)
+			}
+		}
+		if c.New != nil {
+			if row.New, err = json.Marshal(c.New); err != nil {
+				return fmt.Errorf("encode audit of %s %d: %w", c.Entity, c.EntityID, err)
+			}
+		}
+		if row.Changed, err = changedFields(row.Old, row.New); err != nil {
+			return fmt.Errorf("diff audit of %s %d: %w", c.Entity, c.EntityID, err)
+		}
+		list[i] = row
+	}
+	rows, err := json.Marshal(list)
+	if err != nil {
+		return fmt.Errorf("encode audit entries: %w", err)
+	}
+
+	var actor, requestID, clientIP any // nil → NULL
+	if id, ok := requestctx.UserID(ctx); ok {
+		actor = id
+	}
+	if id := requestctx.RequestID(ctx); id != "" {
+		requestID = id
+	}
+	if ip := requestctx.ClientIP(ctx); ip != "" {
+		clientIP = ip
+	}
+	_, err = db.Exec(ctx, `INSERT INTO audit_log (entity, entity_id, action, org_id, actor_id, request_id, client_ip, changed, old_values, new_values)
+		SELECT x.entity, x.entity_id, x.action,
+		       COALESCE((x.new_values->>'org_id')::int, (x.old_values->>'org_id')::int, $4::int), $2, $3, $5::inet,
+		       ARRAY(SELECT jsonb_array_elements_text(COALESCE(x.changed, '[]'))), x.old_values, x.new_values
+		FROM jsonb_to_recordset($1::jsonb) AS x(entity text, entity_id int, action text, changed jsonb, old_values jsonb, new_values jsonb)`,
+		rows, actor, requestID, orgScope(ctx), clientIP)
+	if err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+var jsonNull = json.RawMessage("null")
+
+// changedFields — the top-level keys whose values differ between two
+// JSON objects (null = no object), without version and updated_at
+func changedFields(old, new json.RawMessage) ([]string, error) {
+	var a, b map[string]json.RawMessage // null → nil map
+	if err := json.Unmarshal(old, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(new, &b); err != nil {
+		return nil, err
+	}
+	changed := []string{}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || !bytes.Equal(v, w) {
+			changed = append(changed, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	changed = slices.DeleteFunc(changed, func(k string) bool { return k == "version" || k == "updated_at" })
+	slices.Sort(changed)
+	return changed, nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// ESCALATIONS — rules for open tasks left overdue ("high
+// priority and two days late: bump it and tell someone").
+// task_escalations logs every firing and is also what makes
+// them idempotent: (task_id, rule) is unique, so a rule fires
+// once per task however often the job runs, on however many
+// instances.
+// -----------------------------------------------------------
+
+// EscalationRule — which tasks a rule matches, and what it does to them
+type EscalationRule struct {
+	Name       string
+	Priority   string        // "" = any
+	Tag        string        // "" = any
+	OverdueFor time.Duration // how long past due_date before it fires
+	// BumpPriority raises the priority one step (a high task stays high);
+	// AddTags tags the task
+	BumpPriority bool
+	AddTags      []string
+}
+
+// Escalation — one entry of the escalation log
+type Escalation struct {
+	ID           int       `json:"id"`
+	TaskID       int       `json:"task_id"`
+	Rule         string    `json:"rule"`
+	PriorityFrom string    `json:"priority_from"`
+	PriorityTo   string    `json:"priority_to"` // same as PriorityFrom when the rule doesn't bump
+	EscalatedAt  time.Time `json:"escalated_at"`
+}
+
+// Escalated — a firing and the task as it is afterwards
+type Escalated struct {
+	Escalation Escalation
+	Task       Task
+	Changed    bool // the rule changed the task's priority or tags
+}
+
+// EscalationFilter — zero fields match everything
+type EscalationFilter struct {
+	TaskID int
+	Rule   string
+}
+
+type EscalationRepository interface {
+	// Escalate applies rule to at most limit tasks it hasn't fired for
+	// yet, in one transaction
+	Escalate(ctx context.Context, rule EscalationRule, limit int) ([]Escalated, error)
+	// List returns the log, newest first
+	List(ctx context.Context, f EscalationFilter, page Page) ([]Escalation, error)
+}
+
+type PgxEscalationRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxEscalationRepository(db *pgxpool.Pool) *PgxEscalationRepository {
+	return &PgxEscalationRepository{db: db}
+}
+
+const (
+	escalationColumns = "id, task_id, rule, priority_from, priority_to, escalated_at"
+
+	// SKIP LOCKED: a task someone is editing right now is picked up on
+	// the next run
+	sqlDueEscalations = `SELECT ` + taskColumns + ` FROM tasks
+		WHERE deleted_at IS NULL AND NOT done
+		  AND due_date < NOW() - make_interval(secs => $1)
+		  AND ($2::text IS NULL OR priority = $2)
+		  AND ($3::text IS NULL OR EXISTS (SELECT 1 FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+		                                   WHERE tt.task_id = tasks.id AND g.name = $3))
+		  AND NOT EXISTS (SELECT 1 FROM task_escalations e WHERE e.task_id = tasks.id AND e.rule = $4)
+		ORDER BY id LIMIT $5
+		FOR UPDATE OF tasks SKIP LOCKED`
+)
+
+// bumpPriority — one step up; high is as far as it goes
+func bumpPriority(p string) string {
+	if i := slices.Index(Priorities, p); i >= 0 && i < len(Priorities)-1 {
+		return Priorities[i+1]
+	}
+	return p
+}
+
+func (r *PgxEscalationRepository) Escalate(ctx context.Context, rule EscalationRule, limit int) ([]Escalated, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var priority, tag any // nil → any
+	if rule.Priority != "" {
+		priority = rule.Priority
+	}
+	if rule.Tag != "" {
+		tag = rule.Tag
+	}
+	rows, err := tx.Query(ctx, sqlDueEscalations, rule.OverdueFor.Seconds(), priority, tag, rule.Name, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query tasks to escalate: %w", err)
+	}
+	var due []Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		due = append(due, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	out := make([]Escalated, 0, len(due))
+	for _, t := range due {
+		to := t.Priority
+		if rule.BumpPriority {
+			to = bumpPriority(t.Priority)
+		}
+		e := Escalation{TaskID: t.ID, Rule: rule.Name, PriorityFrom: t.Priority, PriorityTo: to}
+		if err := tx.QueryRow(ctx,
+			`INSERT INTO task_escalations (task_id, rule, priority_from, priority_to) VALUES ($1, $2, $3, $4)
+			 RETURNING id, escalated_at`,
+			e.TaskID, e.Rule, e.PriorityFrom, e.PriorityTo,
+		).Scan(&e.ID, &e.EscalatedAt); err != nil {
+			return nil, fmt.Errorf("log escalation of task %d: %w", t.ID, err)
+		}
+
+		changed := to != t.Priority || len(rule.AddTags) > 0
+		old := t
+		if changed {
+			if t, err = scanTask(tx.QueryRow(ctx,
+				"UPDATE tasks SET priority = $2, "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, t.ID, to,
+			)); err != nil {
+				return nil, fmt.Errorf("escalate task %d: %w", e.TaskID, err)
+			}
+		}
+		if len(rule.AddTags) > 0 {
+			if t.Tags, err = writeTaskTags(ctx, tx, t.ID, rule.AddTags, false); err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			if err := writeAudit(ctx, tx, auditChange{AuditTask, t.ID, AuditUpdate, old, t}); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, Escalated{Escalation: e, Task: t, Changed: changed})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return out, nil
+}
+
+func (r *PgxEscalationRepository) List(ctx context.Context, f EscalationFilter, page Page) ([]Escalation, error) {
+	org := orgScope(ctx)
+	q := newSelect(escalationColumns, "task_escalations").order("id DESC").paged(page).
+		where("(?::int IS NULL OR task_id IN (SELECT id FROM tasks WHERE org_id = ?))", org, org)
+	if f.TaskID != 0 {
+		q.where("task_id = ?", f.TaskID)
+	}
+	if f.Rule != "" {
+		q.where("rule = ?", f.Rule)
+	}
+	sql, args := q.build()
+	rows, err := readConn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query escalations: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Escalation{}
+	for rows.Next() {
+		var e Escalation
+		if err := rows.Scan(&e.ID, &e.TaskID, &e.Rule, &e.PriorityFrom, &e.PriorityTo, &e.EscalatedAt); err != nil {
+			return nil, fmt.Errorf("scan escalation: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// IDENTITIES — the accounts at OIDC providers users log in
+// with: (issuer, subject) names one for good — an email can
+// change hands, a subject can't — and a user may have several.
+// -----------------------------------------------------------
+
+type IdentityRepository interface {
+	// UserOf — the user linked to subject at issuer; ErrNotFound if
+	// none (in ctx's organization)
+	UserOf(ctx context.Context, issuer, subject string) (int, error)
+	// Link — a no-op if subject is linked already
+	Link(ctx context.Context, issuer, subject string, userID int) error
+}
+
+type PgxIdentityRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxIdentityRepository(db *pgxpool.Pool) *PgxIdentityRepository {
+	return &PgxIdentityRepository{db: db}
+}
+
+func (r *PgxIdentityRepository) UserOf(ctx context.Context, issuer, subject string) (int, error) {
+	var id int
+	err := conn(ctx, r.db).QueryRow(ctx,
+		`SELECT i.user_id FROM user_identities i JOIN users u ON u.id = i.user_id
+		 WHERE i.issuer = $1 AND i.subject = $2 AND ($3::int IS NULL OR u.org_id = $3)`,
+		issuer, subject, orgScope(ctx)).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get identity: %w", err)
+	}
+	return id, nil
+}
+
+func (r *PgxIdentityRepository) Link(ctx context.Context, issuer, subject string, userID int) error {
+	_, err := conn(ctx, r.db).Exec(ctx,
+		`INSERT INTO user_identities (issuer, subject, user_id) VALUES ($1, $2, $3)
+		 ON CONFLICT (issuer, subject) DO NOTHING`, issuer, subject, userID)
+	if err != nil {
+		return fmt.Errorf("link identity: %w", err)
+	}
+	return nil
+}
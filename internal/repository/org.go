@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/apperr"
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// ORGANIZATIONS — the tenants. Every user belongs to one, and
+// a task to its user's. The organization a request acts in is
+// in its context (requestctx.OrgID, set by the HTTP and gRPC
+// middleware), and every task, user, webhook and audit query
+// here passes it as a parameter:
+//   ($n::int IS NULL OR org_id = $n)
+// so a row of another organization is simply not there — not
+// found, never forbidden. Background work (the schedulers, the
+// job handlers) has no organization and sees every row.
+// -----------------------------------------------------------
+
+type Org struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type OrgRepository interface {
+	List(ctx context.Context, page Page) ([]Org, error)
+	Get(ctx context.Context, id int) (Org, error)
+	Create(ctx context.Context, name string) (Org, error)
+}
+
+type PgxOrgRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxOrgRepository(db *pgxpool.Pool) *PgxOrgRepository {
+	return &PgxOrgRepository{db: db}
+}
+
+const orgColumns = "id, name, created_at"
+
+func orgNotFound(id int) error {
+	return apperr.Wrap(apperr.OrgNotFound, fmt.Sprintf("organization %d not found", id), ErrNotFound)
+}
+
+// orgScope — ctx's organization as a query argument; nil (every
+// organization) for work outside a request
+func orgScope(ctx context.Context) any {
+	if id, ok := requestctx.OrgID(ctx); ok {
+		return id
+	}
+	return nil
+}
+
+// requireOrg — the organization a new user goes into
+func requireOrg(ctx context.Context) (int, error) {
+	id, ok := requestctx.OrgID(ctx)
+	if !ok {
+		return 0, apperr.New(apperr.OrgRequired, "a user can only be created within an organization")
+	}
+	return id, nil
+}
+
+func (r *PgxOrgRepository) List(ctx context.Context, page Page) ([]Org, error) {
+	sql, args := newSelect(orgColumns, "organizations").order("id").paged(page).build()
+	rows, err := readConn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query organizations: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Org{}
+	for rows.Next() {
+		var o Org
+		if err := rows.Scan(&o.ID, &o.Name, &o.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan organization: %w", err)
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+func (r *PgxOrgRepository) Get(ctx context.Context, id int) (Org, error) {
+	var o Org
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT "+orgColumns+" FROM organizations WHERE id = $1", id).
+		Scan(&o.ID, &o.Name, &o.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Org{}, orgNotFound(id)
+	}
+	if err != nil {
+		return Org{}, fmt.Errorf("get organization %d: %w", id, err)
+	}
+	return o, nil
+}
+
+func (r *PgxOrgRepository) Create(ctx context.Context, name string) (Org, error) {
+	var o Org
+	err := conn(ctx, r.db).QueryRow(ctx,
+		"INSERT INTO organizations (name) VALUES ($1) RETURNING "+orgColumns, name,
+	).Scan(&o.ID, &o.Name, &o.CreatedAt)
+	if err != nil {
+		return Org{}, fmt.Errorf("create organization: %w", err)
+	}
+	return o, nil
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// OUTBOX — task events written in the same transaction as the
+// change they describe, so an event exists exactly when its
+// change was committed. A relay takes the new ones in id order
+// (Relay), hands them on and numbers them (seq); every instance
+// then follows seq to see all of them in one order (Since).
+// Within one task the id order is the commit order: the row lock
+// on the task makes the second writer wait for the first.
+// -----------------------------------------------------------
+
+type OutboxEvent struct {
+	ID        int64           `json:"id"`
+	Seq       int64           `json:"seq"` // 0 = not relayed yet
+	Type      string          `json:"type"`
+	TaskID    int             `json:"task_id"`
+	OrgID     int             `json:"org_id"` // the task's organization
+	Data      json.RawMessage `json:"data"`
+	RequestID string          `json:"request_id,omitempty"` // of the change
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type OutboxRepository interface {
+	// Add records an event, with ctx's request ID and the task's
+	// organization; call it with the transaction of the change
+	Add(ctx context.Context, typ string, taskID int, data any) error
+	// Relay passes up to limit unrelayed events to fn, oldest first,
+	// and numbers them if fn succeeds. The events stay locked while fn
+	// runs; on error they are left for the next call (so fn may see an
+	// event again — at least once).
+	Relay(ctx context.Context, limit int, fn func([]OutboxEvent) error) (int, error)
+	// Since returns relayed events after seq, in seq order
+	Since(ctx context.Context, seq int64, limit int) ([]OutboxEvent, error)
+	// LastSeq — the highest seq so far, 0 before the first
+	LastSeq(ctx context.Context) (int64, error)
+	// Cleanup deletes relayed events older than olderThan — but none
+	// a cursor saved within olderThan hasn't passed yet
+	Cleanup(ctx context.Context, olderThan time.Duration) (int64, error)
+
+	// Cursor — the seq a named reader of Since has got to (ok false
+	// before its first SaveCursor); SaveCursor moves it
+	Cursor(ctx context.Context, name string) (seq int64, ok bool, err error)
+	SaveCursor(ctx context.Context, name string, seq int64) error
+}
+
+type PgxOutboxRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxOutboxRepository(db *pgxpool.Pool) *PgxOutboxRepository {
+	return &PgxOutboxRepository{db: db}
+}
+
+const outboxColumns = "id, COALESCE(seq, 0), type, task_id, COALESCE(org_id, 0), data, COALESCE(request_id, ''), created_at"
+
+func (r *PgxOutboxRepository) Add(ctx context.Context, typ string, taskID int, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("encode %s event: %w", typ, err)
+	}
+	var requestID any // nil → NULL: not made by a request
+	if id := requestctx.RequestID(ctx); id != "" {
+		requestID = id
+	}
+	_, err = conn(ctx, r.db).Exec(ctx,
+		`INSERT INTO outbox (type, task_id, org_id, data, request_id)
+		 VALUES ($1, $2, COALESCE($5::int, (SELECT org_id FROM tasks WHERE id = $2)), $3, $4)`,
+		typ, taskID, b, requestID, orgScope(ctx))
+	if err != nil {
+		return fmt.Errorf("record %s event of task %d: %w", typ, taskID, err)
+	}
+	return nil
+}
+
+func (r *PgxOutboxRepository) Relay(ctx context.Context, limit int, fn func([]OutboxEvent) error) (int, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin relay: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op after Commit
+
+	// No SKIP LOCKED: a second relay waits here rather than overtake
+	// this one with later events
+	list, err := outboxEvents(ctx, tx, "SELECT "+outboxColumns+` FROM outbox
+		WHERE seq IS NULL ORDER BY id LIMIT $1 FOR UPDATE`, limit)
+	if err != nil || len(list) == 0 {
+		return 0, err
+	}
+	if err := fn(list); err != nil {
+		return 0, err
+	}
+	// One by one, in id order: nextval in a multi-row UPDATE could
+	// number them in any order
+	for _, e := range list {
+		if _, err := tx.Exec(ctx, "UPDATE outbox SET seq = nextval('outbox_seq'), relayed_at = NOW() WHERE id = $1", e.ID); err != nil {
+			return 0, fmt.Errorf("number event %d: %w", e.ID, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit relay: %w", err)
+	}
+	return len(list), nil
+}
+
+func (r *PgxOutboxRepository) Since(ctx context.Context, seq int64, limit int) ([]OutboxEvent, error) {
+	return outboxEvents(ctx, conn(ctx, r.db), "SELECT "+outboxColumns+` FROM outbox
+		WHERE seq > $1 ORDER BY seq LIMIT $2`, seq, limit)
+}
+
+func (r *PgxOutboxRepository) LastSeq(ctx context.Context) (int64, error) {
+	var seq int64
+	if err := conn(ctx, r.db).QueryRow(ctx, "SELECT COALESCE(MAX(seq), 0) FROM outbox").Scan(&seq); err != nil {
+		return 0, fmt.Errorf("last outbox seq: %w", err)
+	}
+	return seq, nil
+}
+
+func (r *PgxOutboxRepository) Cleanup(ctx context.Context, olderThan time.Duration) (int64, error) {
+	// A cursor stuck for longer than olderThan (its reader gone, or down
+	// that long) holds nothing back: the outbox mustn't grow forever
+	tag, err := conn(ctx, r.db).Exec(ctx, `DELETE FROM outbox
+		WHERE seq IS NOT NULL AND relayed_at < NOW() - make_interval(secs => $1)
+		  AND seq <= COALESCE((SELECT MIN(seq) FROM outbox_cursors
+		                       WHERE updated_at >= NOW() - make_interval(secs => $1)), seq)`, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("clean up outbox: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (r *PgxOutboxRepository) Cursor(ctx context.Context, name string) (int64, bool, error) {
+	var seq int64
+	err := conn(ctx, r.db).QueryRow(ctx, "SELECT seq FROM outbox_cursors WHERE name = $1", name).Scan(&seq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("outbox cursor %s: %w", name, err)
+	}
+	return seq, true, nil
+}
+
+func (r *PgxOutboxRepository) SaveCursor(ctx context.Context, name string, seq int64) error {
+	_, err := conn(ctx, r.db).Exec(ctx, `INSERT INTO outbox_cursors (name, seq) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET seq = EXCLUDED.seq, updated_at = NOW()`, name, seq)
+	if err != nil {
+		return fmt.Errorf("save outbox cursor %s: %w", name, err)
+	}
+	return nil
+}
+
+func outboxEvents(ctx context.Context, db dbtx, sql string, args ...any) ([]OutboxEvent, error) {
+	rows, err := db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query outbox: %w", err)
+	}
+	defer rows.Close()
+
+	out := []OutboxEvent{}
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Seq, &e.Type, &e.TaskID, &e.OrgID, &e.Data, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox event: %w", err)
+		}
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// PASSWORDS — a user's password hash (internal/password) and
+// the count of failed logins since the last good one; enough
+// of those in a row lock the account for a while. Kept apart
+// from User so the hash never ends up in a response.
+// -----------------------------------------------------------
+
+// Credential — a user's password state
+type Credential struct {
+	UserID       int
+	Hash         string // "" = no password set
+	FailedLogins int
+	LockedUntil  *time.Time // nil = not locked
+}
+
+// Locked — is the account locked at now?
+func (c Credential) Locked(now time.Time) bool {
+	return c.LockedUntil != nil && c.LockedUntil.After(now)
+}
+
+type PasswordRepository interface {
+	// Get — ErrNotFound (as USER_NOT_FOUND) for a user who isn't in
+	// ctx's organization
+	Get(ctx context.Context, userID int) (Credential, error)
+	// Set stores a new hash, and clears the failures and any lockout
+	Set(ctx context.Context, userID int, hash string) error
+	// Fail counts a failed login. The max-th in a row (0 = none) locks
+	// the account for lockFor and starts the count again. It runs
+	// outside any transaction in ctx: the count has to outlast the
+	// 401 that rolls the request's back.
+	Fail(ctx context.Context, userID, max int, lockFor time.Duration) (Credential, error)
+	// Succeed clears the failures
+	Succeed(ctx context.Context, userID int) error
+}
+
+type PgxPasswordRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxPasswordRepository(db *pgxpool.Pool) *PgxPasswordRepository {
+	return &PgxPasswordRepository{db: db}
+}
+
+const credentialColumns = "id, COALESCE(password_hash, ''), failed_logins, locked_until"
+
+func scanCredential(row pgx.Row, userID int) (Credential, error) {
+	var c Credential
+	err := row.Scan(&c.UserID, &c.Hash, &c.FailedLogins, &c.LockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Credential{}, userNotFound(userID)
+	}
+	return c, err
+}
+
+func (r *PgxPasswordRepository) Get(ctx context.Context, userID int) (Credential, error) {
+	c, err := scanCredential(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+credentialColumns+" FROM users WHERE id = $1 AND ($2::int IS NULL OR org_id = $2)",
+		userID, orgScope(ctx)), userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Credential{}, fmt.Errorf("get password of user %d: %w", userID, err)
+	}
+	return c, err
+}
+
+func (r *PgxPasswordRepository) Set(ctx context.Context, userID int, hash string) error {
+	tag, err := conn(ctx, r.db).Exec(ctx,
+		`UPDATE users SET password_hash = $2, failed_logins = 0, locked_until = NULL
+		 WHERE id = $1 AND ($3::int IS NULL OR org_id = $3)`,
+		userID, hash, orgScope(ctx))
+	if err != nil {
+		return fmt.Errorf("set password of user %d: %w", userID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return userNotFound(userID)
+	}
+	return nil
+}
+
+func (r *PgxPasswordRepository) Fail(ctx context.Context, userID, max int, lockFor time.Duration) (Credential, error) {
+	c, err := scanCredential(dbOf(ctx, r.db).QueryRow(ctx,
+		`UPDATE users SET
+		   failed_logins = CASE WHEN $3::int > 0 AND failed_logins + 1 >= $3 THEN 0 ELSE failed_logins + 1 END,
+		   locked_until  = CASE WHEN $3::int > 0 AND failed_logins + 1 >= $3
+		                        THEN NOW() + make_interval(secs => $4::float8) ELSE locked_until END
+		 WHERE id = $1 AND ($2::int IS NULL OR org_id = $2)
+		 RETURNING `+credentialColumns,
+		userID, orgScope(ctx), max, lockFor.Seconds()), userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Credential{}, fmt.Errorf("count failed login of user %d: %w", userID, err)
+	}
+	return c, err
+}
+
+func (r *PgxPasswordRepository) Succeed(ctx context.Context, userID int) error {
+	if _, err := conn(ctx, r.db).Exec(ctx,
+		"UPDATE users SET failed_logins = 0 WHERE id = $1 AND ($2::int IS NULL OR org_id = $2) AND failed_logins > 0",
+		userID, orgScope(ctx)); err != nil {
+		return fmt.Errorf("reset failed logins of user %d: %w", userID, err)
+	}
+	return nil
+}
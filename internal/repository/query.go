@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------
+// QUERY BUILDER — for statements whose shape depends on the
+// input (optional filters, a chosen sort, only the changed
+// columns). SQL text only ever comes from fragments written in
+// this package; values always travel as $n parameters. Fragments
+// use ? for their values and the builder numbers them in order,
+// so a condition never has to know how many came before it.
+//
+// Hot read paths keep their fixed NULL-filter statements (see
+// sqlListTasks): one SQL string is one prepared statement, and
+// HotStatements warms exactly those.
+// -----------------------------------------------------------
+
+// queryArgs collects the values of a statement being built
+type queryArgs []any
+
+// bind appends vals and returns fragment with each ? replaced by its
+// $n. ? inside a quoted literal is left alone. A count that doesn't
+// match is a bug in the calling code, not bad input, so it panics.
+func (a *queryArgs) bind(fragment string, vals ...any) string {
+	var b strings.Builder
+	next, quoted := 0, false
+	for _, c := range fragment {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			if next == len(vals) {
+				panic(fmt.Sprintf("repository: %q has more ? than the %d values given", fragment, len(vals)))
+			}
+			*a = append(*a, vals[next])
+			next++
+			b.WriteString("$" + strconv.Itoa(len(*a)))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	if next != len(vals) {
+		panic(fmt.Sprintf("repository: %q has %d ? for %d values", fragment, next, len(vals)))
+	}
+	return b.String()
+}
+
+// selectQuery — SELECT columns FROM from [WHERE ...] [ORDER BY ...]
+// [LIMIT/OFFSET]
+type selectQuery struct {
+	columns, from string
+	conds         []string
+	orderBy       []string
+	page          Page
+	args          queryArgs
+}
+
+func newSelect(columns, from string) *selectQuery {
+	return &selectQuery{columns: columns, from: from}
+}
+
+// where adds a condition, ANDed with the others
+func (q *selectQuery) where(cond string, vals ...any) *selectQuery {
+	q.conds = append(q.conds, q.args.bind(cond, vals...))
+	return q
+}
+
+// order appends sort expressions. Pick them from a fixed map when the
+// client chooses the sort — never pass the client's text through.
+func (q *selectQuery) order(exprs ...string) *selectQuery {
+	q.orderBy = append(q.orderBy, exprs...)
+	return q
+}
+
+// paged adds LIMIT/OFFSET; a zero Limit means no limit
+func (q *selectQuery) paged(p Page) *selectQuery {
+	q.page = p
+	return q
+}
+
+// build returns the SQL and its arguments, in $n order
+func (q *selectQuery) build() (string, []any) {
+	var b strings.Builder
+	b.WriteString("SELECT " + q.columns + " FROM " + q.from)
+	writeWhere(&b, q.conds)
+	if len(q.orderBy) > 0 {
+		b.WriteString(" ORDER BY " + strings.Join(q.orderBy, ", "))
+	}
+	args := slices.Clone(q.args) // build twice, same result
+	if q.page.Limit > 0 {
+		b.WriteString(" " + args.bind("LIMIT ?", q.page.Limit))
+	}
+	if q.page.Offset > 0 {
+		b.WriteString(" " + args.bind("OFFSET ?", q.page.Offset))
+	}
+	return b.String(), args
+}
+
+// updateQuery — UPDATE table SET ... [WHERE ...] [RETURNING ...]
+type updateQuery struct {
+	table     string
+	sets      []string
+	conds     []string
+	returning string
+	args      queryArgs
+}
+
+func newUpdate(table string) *updateQuery {
+	return &updateQuery{table: table}
+}
+
+// set assigns one column a value
+func (q *updateQuery) set(column string, v any) *updateQuery {
+	q.sets = append(q.sets, q.args.bind(column+" = ?", v))
+	return q
+}
+
+// setExpr adds an assignment written out in full (e.g. touchTask)
+func (q *updateQuery) setExpr(expr string, vals ...any) *updateQuery {
+	q.sets = append(q.sets, q.args.bind(expr, vals...))
+	return q
+}
+
+func (q *updateQuery) where(cond string, vals ...any) *updateQuery {
+	q.conds = append(q.conds, q.args.bind(cond, vals...))
+	return q
+}
+
+func (q *updateQuery) returningColumns(columns string) *updateQuery {
+	q.returning = columns
+	return q
+}
+
+// empty — nothing set yet
+func (q *updateQuery) empty() bool {
+	return len(q.sets) == 0
+}
+
+func (q *updateQuery) build() (string, []any) {
+	var b strings.Builder
+	b.WriteString("UPDATE " + q.table + " SET " + strings.Join(q.sets, ", "))
+	writeWhere(&b, q.conds)
+	if q.returning != "" {
+		b.WriteString(" RETURNING " + q.returning)
+	}
+	return b.String(), q.args
+}
+
+// writeWhere parenthesizes each condition when there are several, so
+// an OR inside one can't leak into its neighbours
+func writeWhere(b *strings.Builder, conds []string) {
+	switch len(conds) {
+	case 0:
+	case 1:
+		b.WriteString(" WHERE " + conds[0])
+	default:
+		b.WriteString(" WHERE (" + strings.Join(conds, ") AND (") + ")")
+	}
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// -----------------------------------------------------------
+// REASSIGNMENT — hand someone's open tasks to someone else,
+// e.g. when they leave the team. Done and trashed tasks keep
+// their owner: they are history, not work.
+// -----------------------------------------------------------
+
+// Reassignment — which tasks Reassign moves, and to whom
+type Reassignment struct {
+	FromUserID int
+	ToUserID   int
+	Tag        string // "" = any; otherwise only tasks carrying this tag
+}
+
+// sqlReassignTasks — moved tasks stop matching the WHERE clause, so
+// calling it until it returns fewer than $4 rows moves them all. Both
+// users must be in ctx's organization ($5), and the tasks follow the
+// new owner's.
+// FOR UPDATE waits for a concurrent edit of the same task instead of
+// overwriting it or leaving it behind. The subquery also hands the old
+// updated_at to RETURNING: with the user ID, all that changes, so the
+// audit entry can show the task before.
+const sqlReassignTasks = `UPDATE tasks SET user_id = $2, org_id = (SELECT org_id FROM users WHERE id = $2), ` + touchTask + `
+	FROM (
+		SELECT id AS old_id, updated_at AS old_updated_at FROM tasks
+		WHERE user_id = $1 AND NOT done AND deleted_at IS NULL AND ($5::int IS NULL OR org_id = $5)
+		  AND EXISTS (SELECT 1 FROM users WHERE id = $2 AND ($5::int IS NULL OR org_id = $5))
+		  AND ($3::text IS NULL OR EXISTS (SELECT 1 FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+		                                   WHERE tt.task_id = tasks.id AND g.name = $3))
+		ORDER BY id LIMIT $4
+		FOR UPDATE) old
+	WHERE tasks.id = old.old_id
+	RETURNING ` + taskColumns + `, old.old_updated_at`
+
+// Reassign moves at most limit matching tasks in one statement, logged
+// in the same transaction (a savepoint if ctx carries one), and returns
+// them as they are now.
+func (r *PgxTaskRepository) Reassign(ctx context.Context, ra Reassignment, limit int) ([]Task, error) {
+	var tag any // nil → any
+	if ra.Tag != "" {
+		tag = ra.Tag
+	}
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlReassignTasks, ra.FromUserID, ra.ToUserID, tag, limit, orgScope(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("reassign tasks of user %d: %w", ra.FromUserID, err)
+	}
+	var (
+		moved   []Task
+		changes []auditChange
+	)
+	for rows.Next() {
+		var oldUpdatedAt time.Time
+		t, err := scanTask(rows, &oldUpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		old := t
+		old.UserID, old.Version, old.UpdatedAt = ra.FromUserID, t.Version-1, oldUpdatedAt
+		moved = append(moved, t)
+		changes = append(changes, auditChange{AuditTask, t.ID, AuditUpdate, old, t})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reassign tasks of user %d: %w", ra.FromUserID, err)
+	}
+	if err := writeAudit(ctx, tx, changes...); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return moved, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"sandbox-go/internal/recur"
+)
+
+// -----------------------------------------------------------
+// RECURRING TASKS — a series is a chain of ordinary tasks. Only
+// the newest occurrence carries the rule; once it is done or
+// past due, Recur creates the next one (same title, user,
+// priority, parent and tags, due at the rule's next time) and
+// hands the rule over, so every occurrence is spawned once.
+// Rules are evaluated in UTC, like every stored timestamp.
+// -----------------------------------------------------------
+
+// Occurrence — one step of a series: Previous no longer recurs, Next
+// is the task that took over (zero if the series ended)
+type Occurrence struct {
+	Previous Task
+	Next     Task
+}
+
+// sqlDueRecurrences — SKIP LOCKED so a tick that overlaps a slow one,
+// or a user editing the task, just leaves the row for next time
+const sqlDueRecurrences = `SELECT ` + taskColumns + `, NOW()::timestamp FROM tasks
+	WHERE recurrence IS NOT NULL AND deleted_at IS NULL AND (done OR due_date <= NOW())
+	ORDER BY id LIMIT $1
+	FOR UPDATE OF tasks SKIP LOCKED`
+
+// Recur — the whole batch commits together. The next due date is the
+// rule's first time after the previous due date that is still in the
+// future, so a daily task completed three days late comes back
+// tomorrow rather than three times overdue; without a due date the
+// series counts from now.
+func (r *PgxTaskRepository) Recur(ctx context.Context, limit int) ([]Occurrence, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, sqlDueRecurrences, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query recurring tasks: %w", err)
+	}
+	var (
+		due []Task
+		now time.Time // database clock, as in the WHERE clause
+	)
+	for rows.Next() {
+		t, err := scanTask(rows, &now)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		due = append(due, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	out := make([]Occurrence, 0, len(due))
+	for _, prev := range due {
+		occ, err := nextOccurrence(ctx, tx, prev, now)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, occ)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return out, nil
+}
+
+// nextOccurrence spawns prev's successor. A rule that no longer parses
+// (written by an older version) ends the series instead of failing the
+// batch on every tick: Next is then the zero Task.
+func nextOccurrence(ctx context.Context, tx pgx.Tx, prev Task, now time.Time) (Occurrence, error) {
+	var next Task
+	if rule, err := recur.Parse(*prev.Recurrence); err == nil {
+		from := now
+		if prev.DueDate != nil {
+			from = *prev.DueDate
+		}
+		due := rule.NextAfter(from.UTC(), now.UTC())
+
+		// The parent is inherited only while it is live, as Create requires
+		next, err = scanTask(tx.QueryRow(ctx,
+			`INSERT INTO tasks (org_id, user_id, parent_id, title, priority, due_date, recurrence)
+			 VALUES ($7, $1, (SELECT id FROM tasks WHERE id = $2 AND deleted_at IS NULL), $3, $4, $5, $6)
+			 RETURNING `+taskColumns,
+			prev.UserID, prev.ParentID, prev.Title, prev.Priority, due, prev.Recurrence, prev.OrgID,
+		))
+		if err != nil {
+			return Occurrence{}, fmt.Errorf("create next occurrence of task %d: %w", prev.ID, err)
+		}
+		if len(prev.Tags) > 0 {
+			if next.Tags, err = writeTaskTags(ctx, tx, next.ID, prev.Tags, false); err != nil {
+				return Occurrence{}, err
+			}
+		}
+	}
+
+	ended, err := scanTask(tx.QueryRow(ctx,
+		"UPDATE tasks SET recurrence = NULL, "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, prev.ID))
+	if err != nil {
+		return Occurrence{}, fmt.Errorf("end recurrence of task %d: %w", prev.ID, err)
+	}
+	changes := []auditChange{{AuditTask, prev.ID, AuditUpdate, prev, ended}}
+	if next.ID != 0 {
+		changes = append(changes, auditChange{AuditTask, next.ID, AuditCreate, nil, next})
+	}
+	if err := writeAudit(ctx, tx, changes...); err != nil {
+		return Occurrence{}, err
+	}
+	return Occurrence{Previous: ended, Next: next}, nil
+}
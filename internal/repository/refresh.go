@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// REFRESH TOKENS — long-lived, single-use: each refresh trades
+// one for a new access token and a new refresh token, and the
+// old one is revoked. Tokens descended from one login share a
+// family. Like API keys, only a SHA-256 of the token is stored.
+// A revoked token that comes back was stolen, or the thief's
+// copy already was: the whole family is revoked, and its
+// user logs in again. Rows are kept until they expire, so the
+// reuse can still be seen.
+// -----------------------------------------------------------
+
+// ErrTokenReused — Rotate was given a token that had been rotated or
+// revoked already; its family is revoked now
+var ErrTokenReused = errors.New("refresh token reused")
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	OrgID     int // the user's organization
+	Family    int // the ID of the first token of its line
+	ExpiresAt time.Time
+}
+
+type RefreshTokenRepository interface {
+	// Create starts a family for a new login; hash is the hex SHA-256
+	// of the token
+	Create(ctx context.Context, userID int, hash string, ttl time.Duration) (RefreshToken, error)
+	// Rotate revokes the token with hash and creates its successor in
+	// the same family, good for ttl (not only what was left). ErrNotFound
+	// if there is no such token or it has expired; ErrTokenReused (see
+	// above). It commits on its own, whatever transaction ctx has: a
+	// family revoked for reuse has to stay revoked.
+	Rotate(ctx context.Context, hash, newHash string, ttl time.Duration) (RefreshToken, error)
+	// Revoke revokes the family of the token with hash; a token that
+	// doesn't exist is no error
+	Revoke(ctx context.Context, hash string) error
+	// Purge deletes the tokens that have expired
+	Purge(ctx context.Context) (int64, error)
+}
+
+type PgxRefreshTokenRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxRefreshTokenRepository(db *pgxpool.Pool) *PgxRefreshTokenRepository {
+	return &PgxRefreshTokenRepository{db: db}
+}
+
+func scanRefreshToken(row pgx.Row) (RefreshToken, error) {
+	var t RefreshToken
+	err := row.Scan(&t.ID, &t.UserID, &t.OrgID, &t.Family, &t.ExpiresAt)
+	return t, err
+}
+
+// insertRefreshToken — family NULL starts a new one: the token's own
+// ID, taken from the sequence first so the row can name it
+const insertRefreshToken = `WITH t AS (
+	   INSERT INTO refresh_tokens (id, user_id, family, hash, expires_at)
+	   SELECT n.id, u.id, COALESCE($2::int, n.id), $3::text, NOW() + make_interval(secs => $4::float8)
+	   FROM users u, (SELECT nextval(pg_get_serial_sequence('refresh_tokens', 'id'))::int AS id) n
+	   WHERE u.id = $1
+	   RETURNING id, user_id, family, expires_at
+	 )
+	 SELECT t.id, t.user_id, u.org_id, t.family, t.expires_at FROM t JOIN users u ON u.id = t.user_id`
+
+func (r *PgxRefreshTokenRepository) Create(ctx context.Context, userID int, hash string, ttl time.Duration) (RefreshToken, error) {
+	t, err := scanRefreshToken(conn(ctx, r.db).QueryRow(ctx, insertRefreshToken, userID, nil, hash, ttl.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RefreshToken{}, userNotFound(userID)
+	}
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("create refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (r *PgxRefreshTokenRepository) Rotate(ctx context.Context, hash, newHash string, ttl time.Duration) (RefreshToken, error) {
+	tx, err := dbOf(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx)) // no-op after Commit
+
+	var id, userID, family int
+	var revoked, expired bool
+	err = tx.QueryRow(ctx,
+		`SELECT id, user_id, family, revoked_at IS NOT NULL, expires_at <= NOW()
+		 FROM refresh_tokens WHERE hash = $1 FOR UPDATE`, hash).Scan(&id, &userID, &family, &revoked, &expired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return RefreshToken{}, ErrNotFound
+	}
+	if err != nil {
+		return RefreshToken{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	switch {
+	case expired:
+		return RefreshToken{}, ErrNotFound
+	case revoked:
+		if _, err := tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE family = $1 AND revoked_at IS NULL", family); err != nil {
+			return RefreshToken{}, fmt.Errorf("revoke refresh token family %d: %w", family, err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return RefreshToken{}, fmt.Errorf("revoke refresh token family %d: %w", family, err)
+		}
+		return RefreshToken{}, ErrTokenReused
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE refresh_tokens SET revoked_at = NOW() WHERE id = $1", id); err != nil {
+		return RefreshToken{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	t, err := scanRefreshToken(tx.QueryRow(ctx, insertRefreshToken, userID, family, newHash, ttl.Seconds()))
+	if err != nil { // the user is there: the FOR UPDATE kept them
+		return RefreshToken{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return RefreshToken{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+	return t, nil
+}
+
+func (r *PgxRefreshTokenRepository) Revoke(ctx context.Context, hash string) error {
+	_, err := conn(ctx, r.db).Exec(ctx,
+		`UPDATE refresh_tokens SET revoked_at = NOW()
+		 WHERE family = (SELECT family FROM refresh_tokens WHERE hash = $1) AND revoked_at IS NULL`, hash)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token: %w", err)
+	}
+	return nil
+}
+
+func (r *PgxRefreshTokenRepository) Purge(ctx context.Context) (int64, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx, "DELETE FROM refresh_tokens WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("purge refresh tokens: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// sqlDueSoon — open, live tasks whose due date falls in the next $1
+// seconds, by id so a caller can page through them with $2
+const sqlDueSoon = `SELECT ` + taskColumns + ` FROM tasks
+	WHERE deleted_at IS NULL AND NOT done
+	  AND due_date >= NOW() AND due_date < NOW() + make_interval(secs => $1)
+	  AND id > $2
+	ORDER BY id LIMIT $3`
+
+// DueSoon is what the reminder job looks at: tasks due within the next
+// `within`, limit at a time, after afterID
+func (r *PgxTaskRepository) DueSoon(ctx context.Context, within time.Duration, afterID, limit int) ([]Task, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, sqlDueSoon, within.Seconds(), afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query tasks due soon: %w", err)
+	}
+	defer rows.Close()
+
+	tasks := []Task{}
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan task: %w", err)
+		}
+		tasks = append(tasks, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return tasks, nil
+}
@@ -0,0 +1,265 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// READ REPLICA — reads that may be a little behind go to a
+// streaming replica, the rest to the primary. A request opts
+// in with WithReplica (the HTTP middleware does for GET and
+// HEAD: a write, and whatever it reads first, stays on the
+// primary); then the methods that only read — task lists and
+// lookups, search, the audit log, ... — run on the replica.
+// Not inside a transaction, which is on the primary, and not
+// for a dedicated tenant, which has a database of its own.
+//
+// The replica is checked every few seconds: one that doesn't
+// answer, or is more than MaxLag behind, gets no reads until
+// it's back. A read that fails because it couldn't reach the
+// replica is run again on the primary and marks it down, so
+// the requests in between the checks don't fail either — also
+// when the failure only shows once the rows are read (a standby
+// cancelling the query), as long as none had been read yet. A
+// read cut off after its first rows still fails: they're gone
+// to the caller, and the primary's might not match.
+// Authentication (users, API keys, sessions) is always read
+// from the primary: a revoked key must stop working at once.
+// -----------------------------------------------------------
+
+// replicaLagQuery — how far behind the replica is; 0 when it has
+// replayed all it received, however old the last transaction (an idle
+// primary writes none)
+const replicaLagQuery = `SELECT CASE
+	   WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	   ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)
+	 END::float8`
+
+type Replica struct {
+	primary, replica *pgxpool.Pool
+	maxLag           time.Duration
+
+	up        atomic.Bool // the replica gets reads
+	primaryUp atomic.Bool
+	fallbacks atomic.Int64 // reads run again on the primary
+
+	mu      sync.Mutex
+	lag     time.Duration
+	lastErr string // why the replica is down
+}
+
+// NewReplica — the replica counts as down until the first Check
+func NewReplica(primary, replica *pgxpool.Pool, maxLag time.Duration) *Replica {
+	return &Replica{primary: primary, replica: replica, maxLag: maxLag}
+}
+
+var replicaKey = requestctx.NewKey[*Replica]("replica")
+
+// WithReplica — reads made with the returned context may go to r's
+// replica, while it's up
+func WithReplica(ctx context.Context, r *Replica) context.Context {
+	return replicaKey.With(ctx, r)
+}
+
+// readConn — conn, for a method that only reads: the replica if ctx
+// allows it and it's up
+func readConn(ctx context.Context, pool *pgxpool.Pool) dbtx {
+	if tx, ok := txKey.Get(ctx); ok && tx != nil {
+		return tx
+	}
+	if p, ok := dbKey.Get(ctx); ok && p != nil {
+		return p
+	}
+	if r, ok := replicaKey.Get(ctx); ok && r != nil && r.up.Load() {
+		return replicaConn{r}
+	}
+	return pool
+}
+
+// Check pings the primary and measures the replica's lag, and says
+// whether the replica gets reads
+func (r *Replica) Check(ctx context.Context) {
+	r.primaryUp.Store(r.primary.Ping(ctx) == nil)
+
+	var secs float64
+	err := r.replica.QueryRow(ctx, replicaLagQuery).Scan(&secs)
+	lag := time.Duration(secs * float64(time.Second))
+	if err == nil && lag > r.maxLag {
+		err = fmt.Errorf("%s behind, over max_lag %s", lag.Round(time.Second), r.maxLag)
+	}
+	r.mu.Lock()
+	r.lag = lag
+	r.lastErr = ""
+	if err != nil {
+		r.lastErr = err.Error()
+	}
+	r.mu.Unlock()
+	r.up.Store(err == nil)
+}
+
+// Run checks every interval until ctx is done
+func (r *Replica) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		check, cancel := context.WithTimeout(ctx, min(interval, 2*time.Second))
+		r.Check(check)
+		cancel()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ReplicaStatus — as of the last Check, for /readyz and metrics
+type ReplicaStatus struct {
+	PrimaryUp bool
+	Up        bool
+	Lag       time.Duration
+	Error     string // why it isn't up
+	Fallbacks int64  // reads the primary ran for it
+}
+
+func (r *Replica) Status() ReplicaStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return ReplicaStatus{
+		PrimaryUp: r.primaryUp.Load(),
+		Up:        r.up.Load(),
+		Lag:       r.lag,
+		Error:     r.lastErr,
+		Fallbacks: r.fallbacks.Load(),
+	}
+}
+
+// Pool — the replica's, for its stats
+func (r *Replica) Pool() *pgxpool.Pool { return r.replica }
+
+// fallBack — should a read that failed with err run on the primary?
+// If so the replica is down until the next Check.
+func (r *Replica) fallBack(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil || !unreachable(err) {
+		return false
+	}
+	r.up.Store(false)
+	r.mu.Lock()
+	r.lastErr = err.Error()
+	r.mu.Unlock()
+	r.fallbacks.Add(1)
+	return true
+}
+
+// unreachable — err says the replica couldn't serve the read, not that
+// the read was wrong: no connection, or a standby cancelling it
+// (conflict with recovery, 40001) or shutting down (class 57)
+func unreachable(err error) bool {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "40001" || strings.HasPrefix(pgErr.Code, "57")
+	}
+	var connErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connErr) || errors.As(err, &netErr) || pgconn.SafeToRetry(err)
+}
+
+// replicaConn — reads on the replica, again on the primary if it
+// can't be reached; Exec and Begin (not used in reads) on the primary
+type replicaConn struct {
+	r *Replica
+}
+
+func (c replicaConn) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	rows, err := c.r.replica.Query(ctx, sql, args...)
+	if c.r.fallBack(ctx, err) {
+		return c.r.primary.Query(ctx, sql, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &replicaRows{Rows: rows, c: c, ctx: ctx, sql: sql, args: args}, nil
+}
+
+func (c replicaConn) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return &replicaRow{c: c, ctx: ctx, sql: sql, args: args}
+}
+
+func (c replicaConn) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return c.r.primary.Exec(ctx, sql, args...)
+}
+
+func (c replicaConn) Begin(ctx context.Context) (pgx.Tx, error) {
+	return c.r.primary.Begin(ctx)
+}
+
+// replicaRow — QueryRow's error only shows in Scan, so that's where it
+// falls back
+type replicaRow struct {
+	c    replicaConn
+	ctx  context.Context
+	sql  string
+	args []any
+}
+
+func (row *replicaRow) Scan(dest ...any) error {
+	err := row.c.r.replica.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	if row.c.r.fallBack(row.ctx, err) {
+		return row.c.r.primary.QueryRow(row.ctx, row.sql, row.args...).Scan(dest...)
+	}
+	return err
+}
+
+// replicaRows — Query's rows on the replica: an error that ends them
+// before the first one (Err, once Next is false) falls back like
+// Query's own
+type replicaRows struct {
+	pgx.Rows
+	c    replicaConn
+	ctx  context.Context
+	sql  string
+	args []any
+	read bool  // a row went to the caller, or they're the primary's
+	err  error // the primary's Query failed
+}
+
+func (rows *replicaRows) Next() bool {
+	if rows.Rows.Next() {
+		rows.read = true
+		return true
+	}
+	if rows.read || !rows.c.r.fallBack(rows.ctx, rows.Rows.Err()) {
+		return false
+	}
+	rows.read = true
+	primary, err := rows.c.r.primary.Query(rows.ctx, rows.sql, rows.args...)
+	if err != nil {
+		rows.err = err
+		return false
+	}
+	rows.Rows = primary
+	return primary.Next()
+}
+
+func (rows *replicaRows) Err() error {
+	if rows.err != nil {
+		return rows.err
+	}
+	return rows.Rows.Err()
+}
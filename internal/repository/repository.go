@@ -0,0 +1,24 @@
+// Package repository keeps SQL out of the HTTP handlers.
+//
+// Handlers talk to interfaces (TaskRepository, ...) and never see pgx,
+// so the storage can be swapped — e.g. for an in-memory fake in tests —
+// without touching any HTTP code.
+package repository
+
+import "errors"
+
+// Page selects a slice of a list: at most Limit rows after skipping
+// Offset. The HTTP layer validates both before they get here.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// HotStatements — read queries worth preparing when the pool warms up.
+// The repositories run exactly these SQL strings, so warm-up primes
+// what the first requests will use.
+var HotStatements = []string{sqlListTasks, sqlGetTask, sqlListUsers, sqlGetUser}
+
+// ErrNotFound is the cause of every "not found" error; the error
+// itself is an *apperr.Error with a resource-specific code.
+var ErrNotFound = errors.New("not found")
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// SCHEMA CHECK — init.sql only runs on an empty database, so an
+// older one can lack what newer code queries (see its "Existing
+// databases" notes). CheckSchema tells before the first query
+// fails: every table the repositories use, and every column
+// added to one since it was first created.
+// -----------------------------------------------------------
+
+// schemaColumns — keep in sync with init.sql; a table with no columns
+// listed only has to exist
+var schemaColumns = map[string][]string{
+	"organizations":      nil,
+	"users":              {"org_id", "role", "password_hash", "failed_logins", "locked_until"},
+	"tasks":              {"org_id", "deleted_at", "updated_at", "version", "priority", "due_date", "parent_id", "recurrence", "title_search"},
+	"tags":               nil,
+	"task_tags":          nil,
+	"task_escalations":   nil,
+	"jobs":               {"priority"},
+	"schedules":          nil,
+	"outbox":             {"org_id"},
+	"outbox_cursors":     nil,
+	"webhooks":           nil,
+	"api_keys":           nil,
+	"user_identities":    nil,
+	"sessions":           nil,
+	"refresh_tokens":     nil,
+	"webhook_deliveries": nil,
+	"processed_events":   nil,
+	"audit_log":          {"org_id", "client_ip"},
+	"anomalies":          nil,
+}
+
+// CheckSchema returns what the database is missing — "table" or
+// "table.column", sorted; none = up to date
+func CheckSchema(ctx context.Context, db *pgxpool.Pool) ([]string, error) {
+	tables := make([]string, 0, len(schemaColumns))
+	for t := range schemaColumns {
+		tables = append(tables, t)
+	}
+	rows, err := db.Query(ctx,
+		`SELECT table_name, column_name FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = ANY($1)`, tables)
+	if err != nil {
+		return nil, fmt.Errorf("query schema: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[string]bool{} // "table" and "table.column"
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, fmt.Errorf("scan schema: %w", err)
+		}
+		found[table], found[table+"."+column] = true, true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+
+	missing := []string{}
+	for table, columns := range schemaColumns {
+		if !found[table] {
+			missing = append(missing, table)
+			continue
+		}
+		for _, c := range columns {
+			if !found[table+"."+c] {
+				missing = append(missing, table+"."+c)
+			}
+		}
+	}
+	slices.Sort(missing)
+	return missing, nil
+}
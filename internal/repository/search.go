@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// -----------------------------------------------------------
+// SEARCH — full-text search over task titles. tasks.title_search
+// is a generated tsvector (English stemming) with a GIN index,
+// so "tests" finds "Write test"; every term is also a prefix,
+// so "dep" finds "Deploy" while the user is still typing.
+// -----------------------------------------------------------
+
+// SearchResult — a matching task and how well it matched (higher is
+// better; only meaningful relative to the other results)
+type SearchResult struct {
+	Task
+	Rank float32 `json:"rank"`
+}
+
+// sqlSearchTasks — ordered by rank, then id, so pages don't shuffle
+// between requests when ranks tie
+const sqlSearchTasks = `SELECT ` + taskColumns + `, ts_rank(title_search, query) AS rank
+	FROM tasks, to_tsquery('english', $1) AS query
+	WHERE deleted_at IS NULL AND title_search @@ query AND ($4::int IS NULL OR org_id = $4)
+	ORDER BY rank DESC, id
+	LIMIT $2 OFFSET $3`
+
+// prefixQuery — "write te" → "write:* & te:*". Callers pass terms of
+// letters and digits only (see the handler), so nothing in them is
+// tsquery syntax.
+func prefixQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term + ":*"
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Search — live tasks whose title contains every term (as a word or
+// the start of one)
+func (r *PgxTaskRepository) Search(ctx context.Context, terms []string, page Page) ([]SearchResult, error) {
+	rows, err := readConn(ctx, r.db).Query(ctx, sqlSearchTasks, prefixQuery(terms), page.Limit, page.Offset, orgScope(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("search tasks: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var s SearchResult
+		var err error
+		if s.Task, err = scanTask(rows, &s.Rank); err != nil {
+			return nil, fmt.Errorf("scan search result: %w", err)
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// SESSIONS — cookie logins for browsers. Like API keys, only
+// the SHA-256 of the cookie's secret is stored; a session is
+// good until expires_at, and the purge job deletes it some time
+// after. Each has its own CSRF token, which the browser sends
+// back in a header on every write.
+// -----------------------------------------------------------
+
+type Session struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	OrgID     int       `json:"org_id"` // the user's organization
+	CSRFToken string    `json:"csrf_token"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewSession — fields needed to start a session
+type NewSession struct {
+	UserID    int
+	Hash      string // hex SHA-256 of the cookie's secret
+	CSRFToken string
+	TTL       time.Duration
+}
+
+type SessionRepository interface {
+	Create(ctx context.Context, s NewSession) (Session, error)
+	// Lookup finds the unexpired session with this hash, in any
+	// organization (ErrNotFound if there is none)
+	Lookup(ctx context.Context, hash string) (Session, error)
+	// Delete is a no-op for a session that's gone already
+	Delete(ctx context.Context, id int) error
+	// Purge deletes the sessions that have expired
+	Purge(ctx context.Context) (int64, error)
+}
+
+type PgxSessionRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxSessionRepository(db *pgxpool.Pool) *PgxSessionRepository {
+	return &PgxSessionRepository{db: db}
+}
+
+// sessionColumns — of sessions s joined with users u
+const sessionColumns = "s.id, s.user_id, u.org_id, s.csrf_token, s.created_at, s.expires_at"
+
+func scanSession(row pgx.Row) (Session, error) {
+	var s Session
+	err := row.Scan(&s.ID, &s.UserID, &s.OrgID, &s.CSRFToken, &s.CreatedAt, &s.ExpiresAt)
+	return s, err
+}
+
+func (r *PgxSessionRepository) Create(ctx context.Context, ns NewSession) (Session, error) {
+	s, err := scanSession(conn(ctx, r.db).QueryRow(ctx,
+		`WITH s AS (
+		   INSERT INTO sessions (user_id, hash, csrf_token, expires_at)
+		   SELECT id, $2::text, $3::text, NOW() + make_interval(secs => $4::float8) FROM users WHERE id = $1
+		   RETURNING *
+		 )
+		 SELECT `+sessionColumns+` FROM s JOIN users u ON u.id = s.user_id`,
+		ns.UserID, ns.Hash, ns.CSRFToken, ns.TTL.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, userNotFound(ns.UserID)
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("create session: %w", err)
+	}
+	return s, nil
+}
+
+func (r *PgxSessionRepository) Lookup(ctx context.Context, hash string) (Session, error) {
+	s, err := scanSession(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+sessionColumns+" FROM sessions s JOIN users u ON u.id = s.user_id WHERE s.hash = $1 AND s.expires_at > NOW()", hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Session{}, ErrNotFound
+	}
+	if err != nil {
+		return Session{}, fmt.Errorf("look up session: %w", err)
+	}
+	return s, nil
+}
+
+func (r *PgxSessionRepository) Delete(ctx context.Context, id int) error {
+	if _, err := conn(ctx, r.db).Exec(ctx, "DELETE FROM sessions WHERE id = $1", id); err != nil {
+		return fmt.Errorf("delete session %d: %w", id, err)
+	}
+	return nil
+}
+
+func (r *PgxSessionRepository) Purge(ctx context.Context) (int64, error) {
+	tag, err := conn(ctx, r.db).Exec(ctx, "DELETE FROM sessions WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("purge sessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// -----------------------------------------------------------
+// TAGS — many-to-many: tags(id, name) ⟷ task_tags ⟷ tasks
+// A task carries its tags as a sorted []string, read with the
+// row itself (tagsColumn), so there is no N+1 per list page.
+// Tags are created on first use and never deleted: an unused
+// tag is one small row, and keeps its id if it comes back.
+// -----------------------------------------------------------
+
+// tagsColumn — the task's tag names, part of taskColumns; also valid in
+// RETURNING, where it sees the row being written
+const tagsColumn = `COALESCE((SELECT array_agg(g.name ORDER BY g.name) FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+	WHERE tt.task_id = tasks.id), '{}') AS tags`
+
+// writeTaskTags adds tags to a task — or, with replace, makes them its
+// only tags — and returns the resulting set. Runs in the caller's
+// transaction so the task row and its tags change together.
+func writeTaskTags(ctx context.Context, tx pgx.Tx, taskID int, tags []string, replace bool) ([]string, error) {
+	if replace {
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM task_tags WHERE task_id = $1
+			   AND tag_id NOT IN (SELECT id FROM tags WHERE name = ANY($2))`,
+			taskID, tags); err != nil {
+			return nil, fmt.Errorf("clear tags of task %d: %w", taskID, err)
+		}
+	}
+	if len(tags) > 0 {
+		if _, err := tx.Exec(ctx,
+			"INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING",
+			tags); err != nil {
+			return nil, fmt.Errorf("create tags: %w", err)
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO task_tags (task_id, tag_id) SELECT $1, id FROM tags WHERE name = ANY($2)
+			 ON CONFLICT DO NOTHING`,
+			taskID, tags); err != nil {
+			return nil, fmt.Errorf("tag task %d: %w", taskID, err)
+		}
+	}
+	return taskTags(ctx, tx, taskID)
+}
+
+func taskTags(ctx context.Context, tx pgx.Tx, taskID int) ([]string, error) {
+	var tags []string
+	err := tx.QueryRow(ctx,
+		`SELECT COALESCE(array_agg(g.name ORDER BY g.name), '{}') FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+		 WHERE tt.task_id = $1`, taskID,
+	).Scan(&tags)
+	if err != nil {
+		return nil, fmt.Errorf("read tags of task %d: %w", taskID, err)
+	}
+	return tags, nil
+}
+
+// AddTags — the task's version moves too, so its ETag changes
+func (r *PgxTaskRepository) AddTags(ctx context.Context, id int, tags []string) (Task, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := lockLiveTask(ctx, tx, id)
+	if err != nil {
+		return Task{}, err
+	}
+	t, err := scanTask(tx.QueryRow(ctx,
+		"UPDATE tasks SET "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, id))
+	if err != nil {
+		return Task{}, fmt.Errorf("tag task %d: %w", id, err)
+	}
+	if t.Tags, err = writeTaskTags(ctx, tx, id, tags, false); err != nil {
+		return Task{}, err
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditTask, id, AuditUpdate, old, t}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+// RemoveTag only touches the task (and its ETag) if the tag was there
+func (r *PgxTaskRepository) RemoveTag(ctx context.Context, id int, tag string) (Task, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := lockLiveTask(ctx, tx, id)
+	if err != nil {
+		return Task{}, err
+	}
+	res, err := tx.Exec(ctx,
+		"DELETE FROM task_tags WHERE task_id = $1 AND tag_id = (SELECT id FROM tags WHERE name = $2)", id, tag)
+	if err != nil {
+		return Task{}, fmt.Errorf("untag task %d: %w", id, err)
+	}
+	if res.RowsAffected() == 0 {
+		return old, nil // nothing to remove
+	}
+
+	t, err := scanTask(tx.QueryRow(ctx,
+		"UPDATE tasks SET "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, id))
+	if err != nil {
+		return Task{}, fmt.Errorf("untag task %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditTask, id, AuditUpdate, old, t}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
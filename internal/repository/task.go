@@ -0,0 +1,526 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// MODELS
+// -----------------------------------------------------------
+
+type Task struct {
+	ID       int        `json:"id"`
+	OrgID    int        `json:"org_id"` // the user's organization
+	UserID   int        `json:"user_id"`
+	ParentID *int       `json:"parent_id"` // nil = top-level task
+	Title    string     `json:"title"`
+	Done     bool       `json:"done"`
+	Priority string     `json:"priority"` // low, medium or high
+	DueDate  *time.Time `json:"due_date"`
+	// Recurrence — daily, weekly or a cron expression (see internal/recur);
+	// nil = one-off. Only the latest occurrence of a series carries it.
+	Recurrence *string  `json:"recurrence"`
+	Tags       []string `json:"tags"` // sorted; [] when untagged
+	// Version — 1 when created, +1 on every write; updates send it back
+	// (optimistic locking), and it is the HTTP ETag
+	Version   int        `json:"version"`
+	UpdatedAt time.Time  `json:"updated_at"`           // changes on every write
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // set = in the trash
+}
+
+// Task priorities; tasks.priority has a CHECK constraint with the same list
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium" // default
+	PriorityHigh   = "high"
+)
+
+var Priorities = []string{PriorityLow, PriorityMedium, PriorityHigh}
+
+// NewTask — fields needed to create a task
+type NewTask struct {
+	UserID   int
+	ParentID *int // nil = top level; must be a live task
+	Title    string
+	Priority string     // "" = medium
+	DueDate  *time.Time // nil = no due date
+	Tags     []string
+	// Recurrence — "" = one-off; otherwise a rule recur.Parse accepts
+	Recurrence string
+}
+
+// SortDueDate — TaskFilter.Sort: soonest due first, tasks without a due
+// date last
+const SortDueDate = "due_date"
+
+// TaskFilter — zero fields match everything
+type TaskFilter struct {
+	UserIDs        []int // tasks of any of these users
+	ParentID       *int  // only direct subtasks of this task
+	Done           *bool
+	IncludeDeleted bool   // also return tasks in the trash
+	Overdue        *bool  // true: past due and not done; false: everything else
+	Priority       string // "" = any
+	Tag            string // "" = any; otherwise tasks carrying this tag
+	Sort           string // "" = by id, or SortDueDate
+}
+
+// TaskUpdate — nil fields are left unchanged
+type TaskUpdate struct {
+	Title    *string
+	Done     *bool
+	Priority *string
+	// DueDate is applied only when SetDueDate is true; nil then clears it
+	SetDueDate bool
+	DueDate    *time.Time
+	// Tags, when non-nil, replaces the task's tags (empty = remove all)
+	Tags *[]string
+	// ParentID is applied only when SetParentID is true; nil makes the
+	// task top-level. A parent that is the task or one of its subtasks
+	// fails with TASK_CYCLE.
+	SetParentID bool
+	ParentID    *int
+	// Recurrence is applied only when SetRecurrence is true; nil stops
+	// the series after this occurrence
+	SetRecurrence bool
+	Recurrence    *string
+	// IfVersion, when non-nil, makes the update conditional: it only
+	// applies if the row's version is one of these, otherwise Update
+	// fails with VERSION_CONFLICT, carrying the current task
+	IfVersion []int
+}
+
+// -----------------------------------------------------------
+// INTERFACE
+// -----------------------------------------------------------
+
+type TaskRepository interface {
+	List(ctx context.Context, f TaskFilter, page Page) ([]Task, error)
+	// Each calls fn for every task List would return, in the same order,
+	// without holding them all in memory; an error from fn stops it
+	Each(ctx context.Context, f TaskFilter, fn func(Task) error) error
+	Get(ctx context.Context, id int) (Task, error)
+	// Owner — the user a task belongs to, in the trash or not (for
+	// permission checks before a change)
+	Owner(ctx context.Context, id int) (int, error)
+	Create(ctx context.Context, t NewTask) (Task, error)
+	Update(ctx context.Context, id int, u TaskUpdate) (Task, error)
+	// Delete moves a task to the trash; Get, Update and Delete then
+	// treat it as not found until it is restored
+	Delete(ctx context.Context, id int) error
+	// AddTags tags a task (tags it already has are kept once);
+	// RemoveTag untags it, a no-op if the tag wasn't there
+	AddTags(ctx context.Context, id int, tags []string) (Task, error)
+	RemoveTag(ctx context.Context, id int, tag string) (Task, error)
+	// Restore takes a task out of the trash (a live task is returned
+	// unchanged)
+	Restore(ctx context.Context, id int) (Task, error)
+	// Search finds live tasks by title, best matches first (see search.go)
+	Search(ctx context.Context, terms []string, page Page) ([]SearchResult, error)
+	// Subtree returns every live descendant of a task, at most maxDepth
+	// levels down, parents before children
+	Subtree(ctx context.Context, id int, maxDepth int) ([]Task, error)
+	// Recur creates the next occurrence of recurring tasks that are done
+	// or past due, at most limit of them per call (see recurring.go)
+	Recur(ctx context.Context, limit int) ([]Occurrence, error)
+	// Reassign hands open tasks of one user to another, at most limit
+	// per call (see reassign.go)
+	Reassign(ctx context.Context, ra Reassignment, limit int) ([]Task, error)
+	// DueSoon lists open tasks due within the given time, by id (see
+	// reminder.go)
+	DueSoon(ctx context.Context, within time.Duration, afterID, limit int) ([]Task, error)
+	// Purge permanently removes tasks trashed longer than olderThan
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// -----------------------------------------------------------
+// PGX IMPLEMENTATION
+// -----------------------------------------------------------
+
+const (
+	// NULL filters match every row, so one prepared statement serves
+	// every combination; LIMIT NULL means no limit
+	taskColumns = "id, org_id, user_id, parent_id, title, done, priority, due_date, recurrence, " + tagsColumn + ", version, updated_at, deleted_at"
+
+	// touchTask — every write bumps the version and moves updated_at
+	// forward, at least by 1µs, so two writes within the same clock tick
+	// still tell apart by time
+	touchTask = "version = version + 1, updated_at = GREATEST(NOW()::timestamp, updated_at + interval '1 microsecond')"
+
+	sqlListTasks = `SELECT ` + taskColumns + ` FROM tasks
+		WHERE ($1::bigint[] IS NULL OR user_id = ANY($1)) AND ($2::boolean IS NULL OR done = $2)
+		  AND ($3::boolean OR deleted_at IS NULL)
+		  AND ($4::boolean IS NULL OR (COALESCE(due_date < NOW(), FALSE) AND NOT done) = $4)
+		  AND ($5::text IS NULL OR priority = $5)
+		  AND ($6::text IS NULL OR EXISTS (SELECT 1 FROM task_tags tt JOIN tags g ON g.id = tt.tag_id
+		                                   WHERE tt.task_id = tasks.id AND g.name = $6))
+		  AND ($7::int IS NULL OR parent_id = $7)
+		  AND ($11::int IS NULL OR org_id = $11)
+		ORDER BY CASE WHEN $8::text = 'due_date' THEN due_date END NULLS LAST, id
+		LIMIT $9 OFFSET $10`
+	sqlGetTask = "SELECT " + taskColumns + " FROM tasks WHERE id = $1 AND deleted_at IS NULL AND ($2::int IS NULL OR org_id = $2)"
+)
+
+// taskNotFound — TASK_NOT_FOUND that also matches errors.Is(err, ErrNotFound)
+func taskNotFound(id int) error {
+	return apperr.Wrap(apperr.TaskNotFound, fmt.Sprintf("task %d not found", id), ErrNotFound)
+}
+
+// taskChanged — the task exists but was modified since the client's
+// copy; current is the task as it is now
+func taskChanged(current Task) error {
+	err := apperr.New(apperr.VersionConflict, fmt.Sprintf("task %d was modified since it was read: it is at version %d", current.ID, current.Version))
+	err.Current = current
+	return err
+}
+
+// lockTask reads a task of ctx's organization, trashed or not, and
+// locks it until tx ends — the "before" of an audited change. Writes
+// by id go through it, so they can't reach another organization's.
+func lockTask(ctx context.Context, tx pgx.Tx, id int) (Task, error) {
+	t, err := scanTask(tx.QueryRow(ctx,
+		"SELECT "+taskColumns+" FROM tasks WHERE id = $1 AND ($2::int IS NULL OR org_id = $2) FOR UPDATE", id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, taskNotFound(id)
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("lock task %d: %w", id, err)
+	}
+	return t, nil
+}
+
+// lockLiveTask — lockTask, with a task in the trash not found
+func lockLiveTask(ctx context.Context, tx pgx.Tx, id int) (Task, error) {
+	t, err := lockTask(ctx, tx, id)
+	if err == nil && t.DeletedAt != nil {
+		return Task{}, taskNotFound(id)
+	}
+	return t, err
+}
+
+// scanTask reads one row of taskColumns, then any extra columns
+// selected after them into extra
+func scanTask(row pgx.Row, extra ...any) (Task, error) {
+	var t Task
+	dest := []any{&t.ID, &t.OrgID, &t.UserID, &t.ParentID, &t.Title, &t.Done, &t.Priority, &t.DueDate, &t.Recurrence, &t.Tags, &t.Version, &t.UpdatedAt, &t.DeletedAt}
+	err := row.Scan(append(dest, extra...)...)
+	return t, err
+}
+
+type PgxTaskRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxTaskRepository(db *pgxpool.Pool) *PgxTaskRepository {
+	return &PgxTaskRepository{db: db}
+}
+
+// List — a zero page.Limit returns every match (used for batch loads of
+// a known set of users; HTTP callers always pass a limit)
+func (r *PgxTaskRepository) List(ctx context.Context, f TaskFilter, page Page) ([]Task, error) {
+	tasks := []Task{} // empty slice, not nil (so JSON is [] not null)
+	err := r.each(ctx, f, page, func(t Task) error {
+		tasks = append(tasks, t)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Each — rows are scanned and handed to fn one at a time, straight off
+// the connection
+func (r *PgxTaskRepository) Each(ctx context.Context, f TaskFilter, fn func(Task) error) error {
+	return r.each(ctx, f, Page{}, fn)
+}
+
+func (r *PgxTaskRepository) each(ctx context.Context, f TaskFilter, page Page, fn func(Task) error) error {
+	var limit any // nil → LIMIT NULL
+	if page.Limit > 0 {
+		limit = page.Limit
+	}
+
+	var priority, tag any // nil → any
+	if f.Priority != "" {
+		priority = f.Priority
+	}
+	if f.Tag != "" {
+		tag = f.Tag
+	}
+
+	rows, err := readConn(ctx, r.db).Query(ctx, sqlListTasks,
+		f.UserIDs, f.Done, f.IncludeDeleted, f.Overdue, priority, tag, f.ParentID, f.Sort, limit, page.Offset, orgScope(ctx))
+	if err != nil {
+		return fmt.Errorf("query tasks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return fmt.Errorf("scan task: %w", err)
+		}
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration: %w", err)
+	}
+	return nil
+}
+
+func (r *PgxTaskRepository) Get(ctx context.Context, id int) (Task, error) {
+	t, err := scanTask(readConn(ctx, r.db).QueryRow(ctx, sqlGetTask, id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, taskNotFound(id)
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("get task %d: %w", id, err)
+	}
+	return t, nil
+}
+
+func (r *PgxTaskRepository) Owner(ctx context.Context, id int) (int, error) {
+	var userID int
+	err := readConn(ctx, r.db).QueryRow(ctx,
+		"SELECT user_id FROM tasks WHERE id = $1 AND ($2::int IS NULL OR org_id = $2)", id, orgScope(ctx),
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, taskNotFound(id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get owner of task %d: %w", id, err)
+	}
+	return userID, nil
+}
+
+func (r *PgxTaskRepository) Create(ctx context.Context, nt NewTask) (Task, error) {
+	if nt.Priority == "" {
+		nt.Priority = PriorityMedium
+	}
+
+	// The task, its tags and its audit entry are created together or
+	// not at all
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx) // no-op after Commit
+
+	if nt.ParentID != nil {
+		if err := checkParent(ctx, tx, 0, *nt.ParentID); err != nil {
+			return Task{}, err
+		}
+	}
+	var recurrence *string // "" → NULL
+	if nt.Recurrence != "" {
+		recurrence = &nt.Recurrence
+	}
+	// The task goes into its user's organization; a user outside ctx's
+	// is not found, like any other row there
+	t, err := scanTask(tx.QueryRow(ctx,
+		`INSERT INTO tasks (org_id, user_id, parent_id, title, priority, due_date, recurrence)
+		 SELECT org_id, id, $2::int, $3::text, $4::text, $5::timestamp, $6::text FROM users WHERE id = $1 AND ($7::int IS NULL OR org_id = $7)
+		 RETURNING `+taskColumns,
+		nt.UserID, nt.ParentID, nt.Title, nt.Priority, nt.DueDate, recurrence, orgScope(ctx),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, userNotFound(nt.UserID)
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("create task: %w", err)
+	}
+	if len(nt.Tags) > 0 {
+		if t.Tags, err = writeTaskTags(ctx, tx, t.ID, nt.Tags, false); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditTask, t.ID, AuditCreate, nil, t}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+// Update changes only the provided fields, in ONE statement, so the row
+// can never end up half-updated. With nothing to change it returns the
+// current row. The IfVersion check is part of the same statement, so
+// no other write can slip in between the check and the update. New
+// tags and the audit entry are written in the same transaction.
+func (r *PgxTaskRepository) Update(ctx context.Context, id int, u TaskUpdate) (Task, error) {
+	// Only column names we control go into the SQL text — values are
+	// always passed as $n parameters (see query.go)
+	q := newUpdate("tasks")
+	if u.Title != nil {
+		q.set("title", *u.Title)
+	}
+	if u.Done != nil {
+		q.set("done", *u.Done)
+	}
+	if u.Priority != nil {
+		q.set("priority", *u.Priority)
+	}
+	if u.SetDueDate {
+		q.set("due_date", u.DueDate)
+	}
+	if u.SetParentID {
+		q.set("parent_id", u.ParentID)
+	}
+	if u.SetRecurrence {
+		q.set("recurrence", u.Recurrence)
+	}
+	if q.empty() && u.Tags == nil {
+		t, err := r.Get(ctx, id)
+		if err == nil && u.IfVersion != nil && !slices.Contains(u.IfVersion, t.Version) {
+			return Task{}, taskChanged(t)
+		}
+		return t, err
+	}
+	q.setExpr(touchTask) // also when only the tags change
+	q.where("id = ? AND deleted_at IS NULL", id).returningColumns(taskColumns)
+	if u.IfVersion != nil {
+		q.where("version = ANY(?)", u.IfVersion)
+	}
+	query, args := q.build()
+
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := lockLiveTask(ctx, tx, id)
+	if err != nil {
+		return Task{}, err
+	}
+	if u.SetParentID && u.ParentID != nil {
+		if err := checkParent(ctx, tx, id, *u.ParentID); err != nil {
+			return Task{}, err
+		}
+	}
+	// The row is live and locked: no row back means IfVersion failed,
+	// and old is the current task
+	t, err := scanTask(tx.QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Task{}, taskChanged(old)
+	}
+	if err != nil {
+		return Task{}, fmt.Errorf("update task %d: %w", id, err)
+	}
+	if u.Tags != nil {
+		if t.Tags, err = writeTaskTags(ctx, tx, id, *u.Tags, true); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditTask, id, AuditUpdate, old, t}); err != nil {
+		return Task{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+func (r *PgxTaskRepository) Delete(ctx context.Context, id int) error {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := lockLiveTask(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	t, err := scanTask(tx.QueryRow(ctx,
+		"UPDATE tasks SET deleted_at = NOW(), "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, id))
+	if err != nil {
+		return fmt.Errorf("delete task %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditTask, id, AuditDelete, old, t}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (r *PgxTaskRepository) Restore(ctx context.Context, id int) (Task, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Task{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := lockTask(ctx, tx, id)
+	if err != nil {
+		return Task{}, err
+	}
+	t, err := scanTask(tx.QueryRow(ctx,
+		"UPDATE tasks SET deleted_at = NULL, "+touchTask+" WHERE id = $1 RETURNING "+taskColumns, id,
+	))
+	if err != nil {
+		return Task{}, fmt.Errorf("restore task %d: %w", id, err)
+	}
+	if old.DeletedAt != nil { // restoring a live task changes nothing worth logging
+		if err := writeAudit(ctx, tx, auditChange{AuditTask, id, AuditRestore, old, t}); err != nil {
+			return Task{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Task{}, fmt.Errorf("commit: %w", err)
+	}
+	return t, nil
+}
+
+// Purge compares against the database clock (NOW()), the same clock
+// that set deleted_at, so app servers with skewed clocks agree. The
+// tasks' last state goes to the audit log.
+func (r *PgxTaskRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		"DELETE FROM tasks WHERE deleted_at < NOW() - make_interval(secs => $1) RETURNING "+taskColumns,
+		olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("purge tasks: %w", err)
+	}
+	var changes []auditChange
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan task: %w", err)
+		}
+		changes = append(changes, auditChange{AuditTask, t.ID, AuditPurge, t, nil})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("purge tasks: %w", err)
+	}
+	if err := writeAudit(ctx, tx, changes...); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return int64(len(changes)), nil
+}
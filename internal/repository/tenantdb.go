@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// -----------------------------------------------------------
+// DEDICATED TENANTS — an organization too big to share may get
+// a schema of its own, or a database. TenantPools is the
+// catalog: org ID → where its data lives, and a pool for it,
+// opened on the first request and closed again once it's been
+// idle a while or others need the room. A request resolves its
+// pool once (WithDB) and every repository call in it runs there.
+//
+// A schema is put first on the search_path, with public after
+// it: the tables the tenant has copies of (tasks and the like)
+// are its own, the rest — users, API keys, the job queue, the
+// outbox — stay shared. The sweeps over tasks (trash purge,
+// recurrence, escalation, reminders) visit each pool in turn
+// (Orgs). A database of its own would share nothing, and the
+// job workers and the outbox relay only poll the shared one, so
+// config rejects a DSN until they reach it.
+// -----------------------------------------------------------
+
+// ErrPoolsExhausted — every dedicated pool that may be open is, and in
+// use
+var ErrPoolsExhausted = errors.New("too many dedicated tenant pools in use")
+
+// TenantDB — where a dedicated organization's data lives
+type TenantDB struct {
+	DSN      string // "" = the shared database
+	Schema   string // searched before public; "" = none
+	MaxConns int32  // 0 = 4
+}
+
+// defaultTenantConns — a dedicated pool's size, unless its TenantDB says
+const defaultTenantConns = 4
+
+type TenantPools struct {
+	shared  *pgxpool.Pool
+	catalog map[int]TenantDB
+	maxOpen int
+	idle    time.Duration
+
+	mu   sync.Mutex
+	open map[int]*list.Element // of lru
+	lru  *list.List            // *tenantPool, most recently used first
+}
+
+type tenantPool struct {
+	org      int
+	pool     *pgxpool.Pool
+	refs     int // requests using it now
+	lastUsed time.Time
+}
+
+// NewTenantPools — at most maxOpen dedicated pools at once; one unused
+// for idle is closed (see Run)
+func NewTenantPools(shared *pgxpool.Pool, catalog map[int]TenantDB, maxOpen int, idle time.Duration) *TenantPools {
+	return &TenantPools{
+		shared:  shared,
+		catalog: catalog,
+		maxOpen: maxOpen,
+		idle:    idle,
+		open:    map[int]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Dedicated — has org a database or schema of its own?
+func (p *TenantPools) Dedicated(org int) bool {
+	_, ok := p.catalog[org]
+	return ok
+}
+
+// Orgs — the dedicated organizations, in ID order
+func (p *TenantPools) Orgs() []int {
+	orgs := make([]int, 0, len(p.catalog))
+	for org := range p.catalog {
+		orgs = append(orgs, org)
+	}
+	slices.Sort(orgs)
+	return orgs
+}
+
+// Acquire — the pool of org's data, the shared one for most. Call
+// release once done with it: until then it won't be closed.
+func (p *TenantPools) Acquire(ctx context.Context, org int) (pool *pgxpool.Pool, release func(), err error) {
+	db, ok := p.catalog[org]
+	if !ok {
+		return p.shared, func() {}, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.open[org]
+	if !ok {
+		if len(p.open) >= p.maxOpen && !p.evictLocked() {
+			return nil, nil, ErrPoolsExhausted
+		}
+		pool, err := p.connect(ctx, db)
+		if err != nil {
+			return nil, nil, fmt.Errorf("open pool of organization %d: %w", org, err)
+		}
+		e = p.lru.PushFront(&tenantPool{org: org, pool: pool})
+		p.open[org] = e
+	}
+	p.lru.MoveToFront(e)
+	tp := e.Value.(*tenantPool)
+	tp.refs++
+	tp.lastUsed = time.Now()
+
+	var once sync.Once
+	return tp.pool, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			tp.refs--
+			tp.lastUsed = time.Now()
+			p.mu.Unlock()
+		})
+	}, nil
+}
+
+// connect — a pool for db, made like the shared one (its tracer, its
+// database if db names none) but small, and opening no connection
+// before it's asked for one
+func (p *TenantPools) connect(ctx context.Context, db TenantDB) (*pgxpool.Pool, error) {
+	base := p.shared.Config()
+	cfg := base
+	if db.DSN != "" {
+		var err error
+		if cfg, err = pgxpool.ParseConfig(db.DSN); err != nil {
+			return nil, err
+		}
+		cfg.ConnConfig.Tracer = base.ConnConfig.Tracer
+	}
+	cfg.MinConns = 0
+	cfg.MaxConns = db.MaxConns
+	if cfg.MaxConns <= 0 {
+		cfg.MaxConns = defaultTenantConns
+	}
+	if db.Schema != "" {
+		cfg.ConnConfig.RuntimeParams["search_path"] = `"` + db.Schema + `", public`
+	}
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+// evictLocked closes the least recently used pool no request holds;
+// false if they all are held
+func (p *TenantPools) evictLocked() bool {
+	for e := p.lru.Back(); e != nil; e = e.Prev() {
+		if tp := e.Value.(*tenantPool); tp.refs == 0 {
+			p.removeLocked(e)
+			return true
+		}
+	}
+	return false
+}
+
+func (p *TenantPools) removeLocked(e *list.Element) {
+	tp := p.lru.Remove(e).(*tenantPool)
+	delete(p.open, tp.org)
+	go tp.pool.Close() // waits for connections still checked out
+}
+
+// Sweep closes the pools unused for the idle time; it returns how many
+func (p *TenantPools) Sweep() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for e := p.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if tp := e.Value.(*tenantPool); tp.refs == 0 && time.Since(tp.lastUsed) >= p.idle {
+			p.removeLocked(e)
+			n++
+		}
+		e = prev
+	}
+	return n
+}
+
+// Run sweeps every half idle time until ctx is done
+func (p *TenantPools) Run(ctx context.Context) {
+	t := time.NewTicker(max(p.idle/2, time.Second))
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			p.Sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close closes every dedicated pool — once the requests using them
+// are done, like the shared one's Close
+func (p *TenantPools) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for p.lru.Len() > 0 {
+		tp := p.lru.Remove(p.lru.Front()).(*tenantPool)
+		delete(p.open, tp.org)
+		tp.pool.Close()
+	}
+}
+
+// TenantPoolStat — one open dedicated pool, for /admin
+type TenantPoolStat struct {
+	OrgID     int       `json:"org_id"`
+	InUse     int       `json:"in_use"` // requests holding it
+	Conns     int32     `json:"conns"`
+	IdleConns int32     `json:"idle_conns"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// Stats — the open dedicated pools, most recently used first
+func (p *TenantPools) Stats() []TenantPoolStat {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]TenantPoolStat, 0, p.lru.Len())
+	for e := p.lru.Front(); e != nil; e = e.Next() {
+		tp := e.Value.(*tenantPool)
+		s := tp.pool.Stat()
+		out = append(out, TenantPoolStat{
+			OrgID:     tp.org,
+			InUse:     tp.refs,
+			Conns:     s.TotalConns(),
+			IdleConns: s.IdleConns(),
+			LastUsed:  tp.lastUsed,
+		})
+	}
+	return out
+}
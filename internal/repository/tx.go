@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/requestctx"
+)
+
+// -----------------------------------------------------------
+// TRANSACTION SCOPE — a caller can make several repository calls
+// one unit by putting a transaction in the context (WithTx).
+// Every repository method then runs on it, and a method's own
+// Begin becomes a savepoint inside it: the method still can't
+// half-apply, and nothing it wrote is committed until the caller
+// commits.
+// -----------------------------------------------------------
+
+// dbtx — what the repositories use; *pgxpool.Pool and pgx.Tx both qualify
+type dbtx interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+var (
+	txKey = requestctx.NewKey[pgx.Tx]("tx")
+	dbKey = requestctx.NewKey[*pgxpool.Pool]("db")
+)
+
+// WithTx — repository calls made with the returned context run in tx.
+// A pgx.Tx is one connection: calls sharing it must not run concurrently.
+func WithTx(ctx context.Context, tx pgx.Tx) context.Context {
+	return txKey.With(ctx, tx)
+}
+
+// WithDB — repository calls made with the returned context run on
+// pool rather than their own: a dedicated tenant's (see tenantdb.go).
+// A transaction ctx had is left behind, it's on another database.
+func WithDB(ctx context.Context, pool *pgxpool.Pool) context.Context {
+	return txKey.With(dbKey.With(ctx, pool), nil)
+}
+
+// WithoutTx — ctx without its transaction, for work that outlives the
+// caller's (see App.Go); a dedicated tenant's pool stays
+func WithoutTx(ctx context.Context) context.Context {
+	return txKey.With(ctx, nil)
+}
+
+// conn — the context's transaction, else its pool, else pool
+func conn(ctx context.Context, pool *pgxpool.Pool) dbtx {
+	if tx, ok := txKey.Get(ctx); ok && tx != nil {
+		return tx
+	}
+	return dbOf(ctx, pool)
+}
+
+// dbOf — the context's pool, else pool: for what must run outside the
+// transaction (see PasswordRepository.Fail)
+func dbOf(ctx context.Context, pool *pgxpool.Pool) *pgxpool.Pool {
+	if p, ok := dbKey.Get(ctx); ok && p != nil {
+		return p
+	}
+	return pool
+}
+
+// Begin starts a transaction for use with WithTx — a savepoint if ctx
+// already carries one, so it nests inside a per-request transaction
+func Begin(ctx context.Context, pool *pgxpool.Pool) (pgx.Tx, error) {
+	return conn(ctx, pool).Begin(ctx)
+}
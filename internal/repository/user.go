@@ -0,0 +1,310 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// MODELS
+// -----------------------------------------------------------
+
+type User struct {
+	ID        int       `json:"id"`
+	OrgID     int       `json:"org_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"` // admin, member or viewer (internal/authz)
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewUser — fields needed to create a user
+type NewUser struct {
+	Name  string
+	Email string
+	Role  string // "" = member
+}
+
+// UserUpdate — nil fields are left unchanged
+type UserUpdate struct {
+	Name  *string
+	Email *string
+	Role  *string
+}
+
+// -----------------------------------------------------------
+// INTERFACE
+// -----------------------------------------------------------
+
+type UserRepository interface {
+	List(ctx context.Context, page Page) ([]User, error)
+	Get(ctx context.Context, id int) (User, error)
+	// GetByEmail — emails are unique within an organization: call it
+	// with one in ctx
+	GetByEmail(ctx context.Context, email string) (User, error)
+	// GetMany returns the users that exist among ids, in no particular
+	// order — one query for a whole batch (see the GraphQL loaders)
+	GetMany(ctx context.Context, ids []int) ([]User, error)
+	Create(ctx context.Context, u NewUser) (User, error)
+	// Update and Delete fail with LAST_ADMIN rather than leave an
+	// organization without an admin
+	Update(ctx context.Context, id int, u UserUpdate) (User, error)
+	// Delete also deletes the user's tasks (ON DELETE CASCADE)
+	Delete(ctx context.Context, id int) error
+}
+
+// -----------------------------------------------------------
+// PGX IMPLEMENTATION
+// -----------------------------------------------------------
+
+const (
+	userColumns  = "id, org_id, name, email, role, created_at"
+	sqlListUsers = "SELECT " + userColumns + " FROM users WHERE ($3::int IS NULL OR org_id = $3) ORDER BY id LIMIT $1 OFFSET $2"
+	sqlGetUser   = "SELECT " + userColumns + " FROM users WHERE id = $1 AND ($2::int IS NULL OR org_id = $2)"
+)
+
+func userNotFound(id int) error {
+	return apperr.Wrap(apperr.UserNotFound, fmt.Sprintf("user %d not found", id), ErrNotFound)
+}
+
+// emailTaken — emails are unique within an organization
+func emailTaken(email string) error {
+	return apperr.New(apperr.EmailTaken, fmt.Sprintf("email %s is already taken", email))
+}
+
+// isUniqueViolation — Postgres error 23505 (e.g. duplicate email)
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+type PgxUserRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxUserRepository(db *pgxpool.Pool) *PgxUserRepository {
+	return &PgxUserRepository{db: db}
+}
+
+func (r *PgxUserRepository) List(ctx context.Context, page Page) ([]User, error) {
+	return r.query(ctx, sqlListUsers, page.Limit, page.Offset, orgScope(ctx))
+}
+
+func (r *PgxUserRepository) GetMany(ctx context.Context, ids []int) ([]User, error) {
+	return r.query(ctx, "SELECT "+userColumns+" FROM users WHERE id = ANY($1) AND ($2::int IS NULL OR org_id = $2)", ids, orgScope(ctx))
+}
+
+func (r *PgxUserRepository) query(ctx context.Context, sql string, args ...any) ([]User, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return users, nil
+}
+
+func (r *PgxUserRepository) Get(ctx context.Context, id int) (User, error) {
+	u, err := scanUser(conn(ctx, r.db).QueryRow(ctx, sqlGetUser, id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, userNotFound(id)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("get user %d: %w", id, err)
+	}
+	return u, nil
+}
+
+func (r *PgxUserRepository) GetByEmail(ctx context.Context, email string) (User, error) {
+	u, err := scanUser(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+userColumns+" FROM users WHERE email = $1 AND ($2::int IS NULL OR org_id = $2)", email, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, apperr.Wrap(apperr.UserNotFound, fmt.Sprintf("no user with email %s", email), ErrNotFound)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("get user by email: %w", err)
+	}
+	return u, nil
+}
+
+// Every write is audited in its own transaction (a savepoint inside
+// the caller's)
+
+func (r *PgxUserRepository) Create(ctx context.Context, nu NewUser) (User, error) {
+	org, err := requireOrg(ctx)
+	if err != nil {
+		return User{}, err
+	}
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return User{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	u, err := scanUser(tx.QueryRow(ctx,
+		"INSERT INTO users (org_id, name, email, role) VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), 'member')) RETURNING "+userColumns,
+		org, nu.Name, nu.Email, nu.Role,
+	))
+	if isUniqueViolation(err) {
+		return User{}, emailTaken(nu.Email)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("create user: %w", err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditUser, u.ID, AuditCreate, nil, u}); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return User{}, fmt.Errorf("commit: %w", err)
+	}
+	return u, nil
+}
+
+// Update — COALESCE keeps the current value when a field is not
+// provided (NULL), so the change is a single statement
+func (r *PgxUserRepository) Update(ctx context.Context, id int, uu UserUpdate) (User, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return User{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if uu.Role != nil {
+		if err := lockUsersOrg(ctx, tx, id); err != nil {
+			return User{}, err
+		}
+	}
+	old, err := lockUser(ctx, tx, id)
+	if err != nil {
+		return User{}, err
+	}
+	if uu.Role != nil && *uu.Role != old.Role {
+		if err := keepAdmin(ctx, tx, old); err != nil {
+			return User{}, err
+		}
+	}
+	u, err := scanUser(tx.QueryRow(ctx,
+		`UPDATE users SET name = COALESCE($1, name), email = COALESCE($2, email), role = COALESCE($3, role)
+		 WHERE id = $4 RETURNING `+userColumns,
+		uu.Name, uu.Email, uu.Role, id,
+	))
+
+	switch {
+	case isUniqueViolation(err):
+		return User{}, emailTaken(*uu.Email)
+	case err != nil:
+		return User{}, fmt.Errorf("update user %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditUser, id, AuditUpdate, old, u}); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return User{}, fmt.Errorf("commit: %w", err)
+	}
+	return u, nil
+}
+
+// Delete — the audit log has the user; the tasks that go with it
+// (ON DELETE CASCADE) get no entries of their own
+func (r *PgxUserRepository) Delete(ctx context.Context, id int) error {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockUsersOrg(ctx, tx, id); err != nil {
+		return err
+	}
+	u, err := lockUser(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := keepAdmin(ctx, tx, u); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", id); err != nil {
+		return fmt.Errorf("delete user %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditUser, id, AuditDelete, u, nil}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.OrgID, &u.Name, &u.Email, &u.Role, &u.CreatedAt)
+	return u, err
+}
+
+// lockUser — the "before" of an audited change, locked until tx ends
+func lockUser(ctx context.Context, tx pgx.Tx, id int) (User, error) {
+	u, err := scanUser(tx.QueryRow(ctx, sqlGetUser+" FOR UPDATE", id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return User{}, userNotFound(id)
+	}
+	if err != nil {
+		return User{}, fmt.Errorf("lock user %d: %w", id, err)
+	}
+	return u, nil
+}
+
+// lockUsersOrg locks the organization of user id (none found: lockUser
+// says so) until tx ends. A change that may demote or delete an admin
+// takes it before the user, so two such changes in one organization
+// queue up instead of each holding its user and waiting for the
+// other's (a deadlock). NO KEY UPDATE: inserts that reference the
+// organization still go on.
+func lockUsersOrg(ctx context.Context, tx pgx.Tx, id int) error {
+	_, err := tx.Exec(ctx,
+		`SELECT o.id FROM organizations o JOIN users u ON u.org_id = o.id
+		 WHERE u.id = $1 AND ($2::int IS NULL OR u.org_id = $2) FOR NO KEY UPDATE OF o`,
+		id, orgScope(ctx))
+	if err != nil {
+		return fmt.Errorf("lock organization of user %d: %w", id, err)
+	}
+	return nil
+}
+
+// keepAdmin fails if u, about to be demoted or deleted, is the last
+// admin of their organization. Call it under lockUsersOrg: the count
+// is then of admins no other such change can touch until tx ends.
+func keepAdmin(ctx context.Context, tx pgx.Tx, u User) error {
+	if u.Role != "admin" {
+		return nil
+	}
+	var admins int
+	err := tx.QueryRow(ctx,
+		"SELECT count(*) FROM users WHERE org_id = $1 AND role = 'admin'", u.OrgID,
+	).Scan(&admins)
+	if err != nil {
+		return fmt.Errorf("count admins of organization %d: %w", u.OrgID, err)
+	}
+	if admins <= 1 {
+		return apperr.New(apperr.LastAdmin, fmt.Sprintf("user %d is the last admin of organization %d", u.ID, u.OrgID))
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/apperr"
+)
+
+// -----------------------------------------------------------
+// WEBHOOKS — URLs a user registered to hear about their tasks.
+// Every event sent is a row in webhook_deliveries first; the
+// job that posts it records each attempt there, which is the
+// delivery log the API shows.
+// -----------------------------------------------------------
+
+type Webhook struct {
+	ID     int      `json:"id"`
+	UserID int      `json:"user_id"` // events of this user's tasks
+	URL    string   `json:"url"`
+	Events []string `json:"events"` // task.created, task.updated, ...
+	Active bool     `json:"active"`
+	// Secret signs every delivery; the API shows it once, on create
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewWebhook — fields needed to register a webhook
+type NewWebhook struct {
+	UserID int
+	URL    string
+	Events []string
+	Secret string
+}
+
+// WebhookUpdate — nil fields are left unchanged
+type WebhookUpdate struct {
+	URL    *string
+	Events *[]string
+	Active *bool
+}
+
+// Delivery states; webhook_deliveries.status has a CHECK constraint
+// with the same list
+const (
+	DeliveryPending   = "pending" // not sent yet, or waiting for a retry
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed" // out of attempts
+)
+
+// Delivery — one event for one webhook, and how sending it went
+type Delivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	EventID        int64           `json:"event_id"` // the outbox event; receivers dedupe on it
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"` // the exact body sent
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus *int            `json:"response_status"` // of the last attempt; nil = no response
+	Error          *string         `json:"error"`           // of the last attempt
+	CreatedAt      time.Time       `json:"created_at"`
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+// DeliveryAttempt — the outcome of one POST
+type DeliveryAttempt struct {
+	ResponseStatus int    // 0 = no response
+	Error          string // "" = delivered
+	Final          bool   // no retry follows: a failure is for good
+}
+
+type WebhookRepository interface {
+	// List returns the webhooks of one user (0 = everyone's), by id
+	List(ctx context.Context, userID int, page Page) ([]Webhook, error)
+	Get(ctx context.Context, id int) (Webhook, error)
+	Create(ctx context.Context, w NewWebhook) (Webhook, error)
+	Update(ctx context.Context, id int, u WebhookUpdate) (Webhook, error)
+	// Delete also deletes its delivery log
+	Delete(ctx context.Context, id int) error
+
+	// Subscribed returns the active webhooks that want event for the
+	// given task: its owner's, trashed tasks included
+	Subscribed(ctx context.Context, event string, taskID int) ([]Webhook, error)
+	// CreateDelivery logs a pending delivery of an outbox event; for
+	// an event already logged it returns that delivery
+	CreateDelivery(ctx context.Context, webhookID int, eventID int64, event string, payload []byte) (Delivery, error)
+	// Delivery returns a delivery and the webhook it is for
+	Delivery(ctx context.Context, id int64) (Delivery, Webhook, error)
+	RecordAttempt(ctx context.Context, id int64, a DeliveryAttempt) error
+	// Deliveries returns a webhook's log, newest first; status "" = any
+	Deliveries(ctx context.Context, webhookID int, status string, page Page) ([]Delivery, error)
+}
+
+type PgxWebhookRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxWebhookRepository(db *pgxpool.Pool) *PgxWebhookRepository {
+	return &PgxWebhookRepository{db: db}
+}
+
+const (
+	// webhookInOrg — a webhook belongs to its user's organization; $2
+	// is ctx's (orgScope)
+	webhookInOrg    = "($2::int IS NULL OR user_id IN (SELECT id FROM users WHERE org_id = $2))"
+	webhookColumns  = "id, user_id, url, events, active, secret, created_at, updated_at"
+	deliveryColumns = "id, webhook_id, event_id, event, payload, status, attempts, response_status, error, created_at, last_attempt_at, delivered_at"
+)
+
+func webhookNotFound(id int) error {
+	return apperr.Wrap(apperr.WebhookNotFound, fmt.Sprintf("webhook %d not found", id), ErrNotFound)
+}
+
+func scanWebhook(row pgx.Row) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Events, &w.Active, &w.Secret, &w.CreatedAt, &w.UpdatedAt)
+	return w, err
+}
+
+func scanDelivery(row pgx.Row) (Delivery, error) {
+	var d Delivery
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.Error, &d.CreatedAt, &d.LastAttemptAt, &d.DeliveredAt)
+	return d, err
+}
+
+func (r *PgxWebhookRepository) List(ctx context.Context, userID int, page Page) ([]Webhook, error) {
+	org := orgScope(ctx)
+	q := newSelect(webhookColumns, "webhooks").order("id").paged(page).
+		where("(?::int IS NULL OR user_id IN (SELECT id FROM users WHERE org_id = ?))", org, org)
+	if userID != 0 {
+		q.where("user_id = ?", userID)
+	}
+	sql, args := q.build()
+	return r.webhooks(ctx, sql, args...)
+}
+
+func (r *PgxWebhookRepository) Subscribed(ctx context.Context, event string, taskID int) ([]Webhook, error) {
+	return r.webhooks(ctx, `SELECT `+webhookColumns+` FROM webhooks
+		WHERE active AND $1 = ANY(events)
+		  AND user_id = (SELECT user_id FROM tasks WHERE id = $2)
+		ORDER BY id`, event, taskID)
+}
+
+func (r *PgxWebhookRepository) webhooks(ctx context.Context, sql string, args ...any) ([]Webhook, error) {
+	rows, err := conn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Webhook{}
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan webhook: %w", err)
+		}
+		out = append(out, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
+
+func (r *PgxWebhookRepository) Get(ctx context.Context, id int) (Webhook, error) {
+	w, err := scanWebhook(conn(ctx, r.db).QueryRow(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND "+webhookInOrg, id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Webhook{}, webhookNotFound(id)
+	}
+	if err != nil {
+		return Webhook{}, fmt.Errorf("get webhook %d: %w", id, err)
+	}
+	return w, nil
+}
+
+// auditedWebhook — a webhook as the audit log keeps it: never the secret
+func auditedWebhook(w Webhook) Webhook {
+	w.Secret = ""
+	return w
+}
+
+func (r *PgxWebhookRepository) Create(ctx context.Context, nw NewWebhook) (Webhook, error) {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	w, err := scanWebhook(tx.QueryRow(ctx,
+		`INSERT INTO webhooks (user_id, url, events, secret)
+		 SELECT id, $2::text, $3::text[], $4::text FROM users WHERE id = $1 AND ($5::int IS NULL OR org_id = $5)
+		 RETURNING `+webhookColumns,
+		nw.UserID, nw.URL, nw.Events, nw.Secret, orgScope(ctx),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Webhook{}, userNotFound(nw.UserID)
+	}
+	if err != nil {
+		return Webhook{}, fmt.Errorf("create webhook: %w", err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditWebhook, w.ID, AuditCreate, nil, auditedWebhook(w)}); err != nil {
+		return Webhook{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Webhook{}, fmt.Errorf("commit: %w", err)
+	}
+	return w, nil
+}
+
+func (r *PgxWebhookRepository) Update(ctx context.Context, id int, u WebhookUpdate) (Webhook, error) {
+	q := newUpdate("webhooks")
+	if u.URL != nil {
+		q.set("url", *u.URL)
+	}
+	if u.Events != nil {
+		q.set("events", *u.Events)
+	}
+	if u.Active != nil {
+		q.set("active", *u.Active)
+	}
+	if q.empty() {
+		return r.Get(ctx, id)
+	}
+	q.setExpr("updated_at = NOW()")
+	q.where("id = ?", id).returningColumns(webhookColumns)
+	sql, args := q.build()
+
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	old, err := scanWebhook(tx.QueryRow(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND "+webhookInOrg+" FOR UPDATE", id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Webhook{}, webhookNotFound(id)
+	}
+	if err != nil {
+		return Webhook{}, fmt.Errorf("lock webhook %d: %w", id, err)
+	}
+	w, err := scanWebhook(tx.QueryRow(ctx, sql, args...))
+	if err != nil {
+		return Webhook{}, fmt.Errorf("update webhook %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditWebhook, id, AuditUpdate, auditedWebhook(old), auditedWebhook(w)}); err != nil {
+		return Webhook{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Webhook{}, fmt.Errorf("commit: %w", err)
+	}
+	return w, nil
+}
+
+func (r *PgxWebhookRepository) Delete(ctx context.Context, id int) error {
+	tx, err := conn(ctx, r.db).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	w, err := scanWebhook(tx.QueryRow(ctx, "DELETE FROM webhooks WHERE id = $1 AND "+webhookInOrg+" RETURNING "+webhookColumns, id, orgScope(ctx)))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return webhookNotFound(id)
+	}
+	if err != nil {
+		return fmt.Errorf("delete webhook %d: %w", id, err)
+	}
+	if err := writeAudit(ctx, tx, auditChange{AuditWebhook, id, AuditDelete, auditedWebhook(w), nil}); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (r *PgxWebhookRepository) CreateDelivery(ctx context.Context, webhookID int, eventID int64, event string, payload []byte) (Delivery, error) {
+	// DO UPDATE rather than DO NOTHING: RETURNING then has the
+	// existing row too
+	d, err := scanDelivery(conn(ctx, r.db).QueryRow(ctx, `INSERT INTO webhook_deliveries (webhook_id, event_id, event, payload)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (webhook_id, event_id) DO UPDATE SET event_id = EXCLUDED.event_id
+		RETURNING `+deliveryColumns,
+		webhookID, eventID, event, payload,
+	))
+	if err != nil {
+		return Delivery{}, fmt.Errorf("log delivery for webhook %d: %w", webhookID, err)
+	}
+	return d, nil
+}
+
+func (r *PgxWebhookRepository) Delivery(ctx context.Context, id int64) (Delivery, Webhook, error) {
+	row := conn(ctx, r.db).QueryRow(ctx, `SELECT d.id, d.webhook_id, d.event_id, d.event, d.payload, d.status, d.attempts,
+		       d.response_status, d.error, d.created_at, d.last_attempt_at, d.delivered_at,
+		       w.id, w.user_id, w.url, w.events, w.active, w.secret, w.created_at, w.updated_at
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1`, id)
+	var (
+		d Delivery
+		w Webhook
+	)
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.Event, &d.Payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.Error, &d.CreatedAt, &d.LastAttemptAt, &d.DeliveredAt,
+		&w.ID, &w.UserID, &w.URL, &w.Events, &w.Active, &w.Secret, &w.CreatedAt, &w.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Delivery{}, Webhook{}, fmt.Errorf("delivery %d: %w", id, ErrNotFound) // its webhook was deleted
+	}
+	if err != nil {
+		return Delivery{}, Webhook{}, fmt.Errorf("get delivery %d: %w", id, err)
+	}
+	return d, w, nil
+}
+
+func (r *PgxWebhookRepository) RecordAttempt(ctx context.Context, id int64, a DeliveryAttempt) error {
+	status := DeliveryPending
+	switch {
+	case a.Error == "":
+		status = DeliveryDelivered
+	case a.Final:
+		status = DeliveryFailed
+	}
+	var respStatus, errText any // nil → NULL
+	if a.ResponseStatus != 0 {
+		respStatus = a.ResponseStatus
+	}
+	if a.Error != "" {
+		errText = a.Error
+	}
+	_, err := conn(ctx, r.db).Exec(ctx, `UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, response_status = $3, error = $4, last_attempt_at = NOW(),
+		    delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() END
+		WHERE id = $1`, id, status, respStatus, errText)
+	if err != nil {
+		return fmt.Errorf("record attempt of delivery %d: %w", id, err)
+	}
+	return nil
+}
+
+func (r *PgxWebhookRepository) Deliveries(ctx context.Context, webhookID int, status string, page Page) ([]Delivery, error) {
+	q := newSelect(deliveryColumns, "webhook_deliveries").where("webhook_id = ?", webhookID).order("id DESC").paged(page)
+	if status != "" {
+		q.where("status = ?", status)
+	}
+	sql, args := q.build()
+	rows, err := readConn(ctx, r.db).Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	out := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan delivery: %w", err)
+		}
+		out = append(out, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+// Package requestctx holds the values middleware attaches to a request
+// context, behind typed getters and setters.
+//
+// Each value has its own Key. Keys are compared by identity, not name,
+// so two packages can't collide even if they pick the same name, and a
+// getter always returns the type its setter took — no ctx.Value and
+// type assertion at the call site.
+package requestctx
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Key — a typed context key; create one per value with NewKey, at
+// package level
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) With(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k, v)
+}
+
+// Get — the value, or T's zero value and false if ctx has none
+func (k *Key[T]) Get(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	return v, ok
+}
+
+// String names the key in fmt output of the context
+func (k *Key[T]) String() string { return "requestctx." + k.name }
+
+// -----------------------------------------------------------
+// WELL-KNOWN VALUES — set by the HTTP middleware, read anywhere
+// below it (handlers, repositories, jobs they start)
+// -----------------------------------------------------------
+
+var (
+	requestIDKey = NewKey[string]("request_id")
+	loggerKey    = NewKey[*slog.Logger]("logger")
+	userIDKey    = NewKey[int]("user_id")
+	orgIDKey     = NewKey[int]("org_id")
+	budgetKey    = NewKey[time.Duration]("budget")
+	clientIPKey  = NewKey[string]("client_ip")
+)
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return requestIDKey.With(ctx, id)
+}
+
+// RequestID — "" outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := requestIDKey.Get(ctx)
+	return id
+}
+
+func WithLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return loggerKey.With(ctx, l)
+}
+
+// Logger returns the request-scoped logger (already tagged with the
+// request ID), or the default logger outside a request
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := loggerKey.Get(ctx); ok && l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+// WithClientIP — the caller's address, past any trusted proxies
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return clientIPKey.With(ctx, ip)
+}
+
+// ClientIP — "" outside a request
+func ClientIP(ctx context.Context) string {
+	ip, _ := clientIPKey.Get(ctx)
+	return ip
+}
+
+// WithUserID — the authenticated caller
+func WithUserID(ctx context.Context, id int) context.Context {
+	return userIDKey.With(ctx, id)
+}
+
+// UserID — false for anonymous requests and background work
+func UserID(ctx context.Context) (int, bool) {
+	return userIDKey.Get(ctx)
+}
+
+// WithOrgID — the organization the request acts in
+func WithOrgID(ctx context.Context, id int) context.Context {
+	return orgIDKey.With(ctx, id)
+}
+
+func OrgID(ctx context.Context) (int, bool) {
+	return orgIDKey.Get(ctx)
+}
+
+// WithTimeout is context.WithTimeout that also records the budget, so
+// code further down can tell how much of it is left (see Remaining)
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return budgetKey.With(ctx, d), cancel
+}
+
+// Budget — the timeout the request was given; false without one
+func Budget(ctx context.Context) (time.Duration, bool) {
+	return budgetKey.Get(ctx)
+}
+
+// Remaining — time left before ctx's deadline (negative once it has
+// passed); false if ctx has no deadline
+func Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
@@ -0,0 +1,369 @@
+// Package schedule runs periodic jobs — the trash purge, recurrence,
+// escalation and reminder sweeps — on every instance, with their state
+// in Postgres (the schedules table) instead of in each process's
+// ticker.
+//
+// A job runs every Every, on the wall clock: an hourly job is due at
+// the top of the hour, wherever the instance that runs it started. The
+// next run is stored, so a restart neither runs a job early nor lets
+// it skip a turn, and all instances agree on it. Times come from the
+// database's clock, so replicas with skewed clocks agree too.
+//
+// Jitter spreads the runs: each one starts at its slot plus a random
+// delay below Jitter, so jobs sharing a boundary (every minutely job at
+// :00) don't all hit the database at once.
+//
+// Exactly one instance runs a due job: it takes the job's advisory lock
+// (internal/dlock), moves the stored next run on, then runs it holding
+// the lock. The others find the lock taken, or the next run moved, and
+// go back to sleep until it.
+//
+// A run that comes so late that the following slot has passed too (no
+// instance was up, or the last run took longer than Every) is a
+// misfire; the job's Misfire policy decides what happens to the slots
+// it missed: Skip drops them, RunOnce runs once for all of them,
+// CatchUp runs once per slot (up to CatchUpMax), back to back.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"sandbox-go/internal/dlock"
+)
+
+// Misfire policies (Job.Misfire)
+const (
+	Skip    = "skip"     // wait for the next slot; fine for sweeps that catch up by themselves
+	RunOnce = "run-once" // run once, late, for every missed slot together
+	CatchUp = "catch-up" // run once per missed slot
+)
+
+// Misfires — the valid Job.Misfire values
+var Misfires = []string{Skip, RunOnce, CatchUp}
+
+// Job — one periodic job. Every > 0; Jitter must be below Every.
+type Job struct {
+	Name    string // schedules.name, and the metrics label
+	Every   time.Duration
+	Jitter  time.Duration
+	Misfire string // "" = RunOnce
+	// Lock — the advisory lock held while it runs; "" = "schedule:<Name>"
+	Lock string
+	Run  func(ctx context.Context) error
+}
+
+func (j Job) lockName() string {
+	if j.Lock != "" {
+		return j.Lock
+	}
+	return "schedule:" + j.Name
+}
+
+// slotAfter — the first slot of j after t
+func (j Job) slotAfter(t time.Time) time.Time {
+	return t.Truncate(j.Every).Add(j.Every)
+}
+
+// jitter — this run's delay after its slot
+func (j Job) jitter() time.Duration {
+	if j.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(j.Jitter)
+}
+
+// RunInfo describes one run of a job
+type RunInfo struct {
+	Name     string
+	Start    time.Time
+	Late     time.Duration // from the slot to Start
+	Duration time.Duration
+	Err      error
+}
+
+// Observer hears about every run and misfire, e.g. to keep metrics. It
+// is called from each job's goroutine, so it must be safe for
+// concurrent use.
+type Observer interface {
+	ObserveSchedule(RunInfo)
+	// ObserveMisfire — missed slots of a job that its policy didn't run
+	ObserveMisfire(name, policy string, missed int)
+}
+
+// State — a job's row in the schedules table, as GET /admin/schedules
+// shows it
+type State struct {
+	Name    string `json:"name"`
+	Every   string `json:"every"`
+	Jitter  string `json:"jitter"`
+	Misfire string `json:"misfire"`
+	// DueAt — the slot of the next run; NextRunAt is DueAt plus its jitter
+	DueAt          time.Time  `json:"due_at"`
+	NextRunAt      time.Time  `json:"next_run_at"`
+	LastStartedAt  *time.Time `json:"last_started_at"`
+	LastFinishedAt *time.Time `json:"last_finished_at"`
+	LastError      string     `json:"last_error,omitempty"`
+	Runs           int64      `json:"runs"`
+	Missed         int64      `json:"missed"` // slots the misfire policy didn't run
+}
+
+// Scheduler runs the jobs added to it. Set the exported fields and Add
+// the jobs before Run.
+type Scheduler struct {
+	pool  *pgxpool.Pool
+	locks *dlock.Locker
+	jobs  []Job
+
+	// PollInterval — the longest an instance sleeps before it looks at a
+	// job again; it also wakes when the job's next run is due
+	PollInterval time.Duration
+	CatchUpMax   int // runs per misfire under CatchUp
+	Log          *slog.Logger
+	Observer     Observer // nil = none
+}
+
+func New(pool *pgxpool.Pool, locks *dlock.Locker) *Scheduler {
+	return &Scheduler{
+		pool:         pool,
+		locks:        locks,
+		PollInterval: time.Minute,
+		CatchUpMax:   10,
+		Log:          slog.Default(),
+	}
+}
+
+// Add registers a job; it is validated by Run
+func (s *Scheduler) Add(j Job) {
+	if j.Misfire == "" {
+		j.Misfire = RunOnce
+	}
+	s.jobs = append(s.jobs, j)
+}
+
+func (j Job) validate() error {
+	switch {
+	case j.Name == "" || j.Run == nil:
+		return fmt.Errorf("schedule: job %q needs a name and a Run func", j.Name)
+	case j.Every <= 0:
+		return fmt.Errorf("schedule: job %q: every must be positive", j.Name)
+	case j.Jitter < 0 || j.Jitter >= j.Every:
+		return fmt.Errorf("schedule: job %q: jitter %v must be at least 0 and below every (%v)", j.Name, j.Jitter, j.Every)
+	case !slices.Contains(Misfires, j.Misfire):
+		return fmt.Errorf("schedule: job %q: misfire %q must be one of %v", j.Name, j.Misfire, Misfires)
+	}
+	return nil
+}
+
+// Run runs every job on its own goroutine until ctx is cancelled, then
+// waits for the runs in progress. A bad job is an error right away.
+func (s *Scheduler) Run(ctx context.Context) error {
+	for _, j := range s.jobs {
+		if err := j.validate(); err != nil {
+			return err
+		}
+	}
+	var wg sync.WaitGroup
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, j)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (s *Scheduler) loop(ctx context.Context, j Job) {
+	log := s.Log.With("schedule", j.Name)
+	registered := false
+	for ctx.Err() == nil {
+		var (
+			wait time.Duration
+			err  error
+		)
+		if !registered {
+			// Until the database answers (it may start after us)
+			if err = s.register(ctx, j); err == nil {
+				registered = true
+			}
+		}
+		if registered {
+			wait, err = s.tick(ctx, j, log)
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Error("schedule: tick", "err", err)
+		}
+		if wait <= 0 || wait > s.PollInterval {
+			wait = s.PollInterval
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+	}
+}
+
+// dbNow — the database's clock, in the TIMESTAMP columns' terms
+func (s *Scheduler) dbNow(ctx context.Context) (now time.Time, err error) {
+	err = s.pool.QueryRow(ctx, "SELECT LOCALTIMESTAMP").Scan(&now)
+	if err != nil {
+		return now, fmt.Errorf("schedule: read clock: %w", err)
+	}
+	return now, nil
+}
+
+// register adds j's row on first start. A stored next run more than one
+// slot away (Every was shortened since) is moved to the next slot.
+func (s *Scheduler) register(ctx context.Context, j Job) error {
+	now, err := s.dbNow(ctx)
+	if err != nil {
+		return err
+	}
+	due := j.slotAfter(now)
+	_, err = s.pool.Exec(ctx, `
+		INSERT INTO schedules (name, due_at, next_run_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET due_at = EXCLUDED.due_at, next_run_at = EXCLUDED.next_run_at
+		WHERE schedules.due_at > $2`,
+		j.Name, due, due.Add(j.jitter()))
+	if err != nil {
+		return fmt.Errorf("schedule: register %s: %w", j.Name, err)
+	}
+	return nil
+}
+
+// tick runs j if it is due and returns how long until it is due next
+func (s *Scheduler) tick(ctx context.Context, j Job, log *slog.Logger) (time.Duration, error) {
+	var due, next, now time.Time
+	err := s.pool.QueryRow(ctx, "SELECT due_at, next_run_at, LOCALTIMESTAMP FROM schedules WHERE name = $1", j.Name).
+		Scan(&due, &next, &now)
+	if err != nil {
+		return 0, fmt.Errorf("schedule: read %s: %w", j.Name, err)
+	}
+	if now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	lock, ok, err := s.locks.TryAcquire(ctx, j.lockName())
+	if err != nil || !ok {
+		// Another instance is running it: it moves the next run on first
+		// thing, look again shortly
+		return time.Second, err
+	}
+	defer lock.Release()
+
+	// Read again under the lock: the instance that let go of it may have
+	// run this slot already
+	err = s.pool.QueryRow(ctx, "SELECT due_at, next_run_at, LOCALTIMESTAMP FROM schedules WHERE name = $1", j.Name).
+		Scan(&due, &next, &now)
+	if err != nil {
+		return 0, fmt.Errorf("schedule: read %s: %w", j.Name, err)
+	}
+	if now.Before(next) {
+		return next.Sub(now), nil
+	}
+
+	missed := int(now.Sub(due) / j.Every) // slots after due that have passed too
+	runs := 1
+	if missed > 0 {
+		switch j.Misfire {
+		case Skip:
+			runs = 0
+		case CatchUp:
+			runs = min(missed+1, s.CatchUpMax)
+		}
+		log.Warn("schedule: misfire", "due_at", due, "missed", missed, "policy", j.Misfire, "runs", runs)
+		if s.Observer != nil && missed+1 > runs {
+			s.Observer.ObserveMisfire(j.Name, j.Misfire, missed+1-runs)
+		}
+	}
+
+	// Move the next run on before running, so the others wait for it
+	nextDue := due.Add(time.Duration(missed+1) * j.Every)
+	nextRun := nextDue.Add(j.jitter())
+	_, err = s.pool.Exec(ctx, `
+		UPDATE schedules SET due_at = $2, next_run_at = $3, missed = missed + $4,
+		       last_started_at = CASE WHEN $5 THEN LOCALTIMESTAMP ELSE last_started_at END
+		WHERE name = $1`,
+		j.Name, nextDue, nextRun, missed+1-runs, runs > 0)
+	if err != nil {
+		return 0, fmt.Errorf("schedule: advance %s: %w", j.Name, err)
+	}
+
+	// The runs are for the last slots: under RunOnce the latest one
+	var runErr error
+	started := time.Now()
+	for i := range runs {
+		slot := due.Add(time.Duration(missed+1-runs+i) * j.Every)
+		late := now.Sub(slot) + time.Since(started)
+		if runErr = s.run(ctx, j, late); runErr != nil || ctx.Err() != nil {
+			break // the rest wait for the next slot
+		}
+	}
+	if runs > 0 {
+		var lastErr any // nil → NULL
+		if runErr != nil {
+			lastErr = runErr.Error()
+		}
+		_, err = s.pool.Exec(context.WithoutCancel(ctx),
+			"UPDATE schedules SET last_finished_at = LOCALTIMESTAMP, last_error = $2, runs = runs + $3 WHERE name = $1",
+			j.Name, lastErr, runs)
+		if err != nil {
+			return 0, fmt.Errorf("schedule: record run of %s: %w", j.Name, err)
+		}
+	}
+	return nextRun.Sub(now) - time.Since(started), nil
+}
+
+// run calls j.Run once; late is how long after its slot
+func (s *Scheduler) run(ctx context.Context, j Job, late time.Duration) error {
+	start := time.Now()
+	err := j.Run(ctx)
+	if s.Observer != nil {
+		s.Observer.ObserveSchedule(RunInfo{
+			Name: j.Name, Start: start, Late: late, Duration: time.Since(start), Err: err,
+		})
+	}
+	return err
+}
+
+// List returns the jobs added to s with their stored state, by name.
+// Jobs that haven't registered yet are left out.
+func (s *Scheduler) List(ctx context.Context) ([]State, error) {
+	names := make([]string, len(s.jobs))
+	for i, j := range s.jobs {
+		names[i] = j.Name
+	}
+	rows, err := s.pool.Query(ctx, `
+		SELECT name, due_at, next_run_at, last_started_at, last_finished_at, COALESCE(last_error, ''), runs, missed
+		FROM schedules WHERE name = ANY($1) ORDER BY name`, names)
+	if err != nil {
+		return nil, fmt.Errorf("schedule: list: %w", err)
+	}
+	defer rows.Close()
+
+	out := []State{}
+	for rows.Next() {
+		var st State
+		if err := rows.Scan(&st.Name, &st.DueAt, &st.NextRunAt, &st.LastStartedAt, &st.LastFinishedAt,
+			&st.LastError, &st.Runs, &st.Missed); err != nil {
+			return nil, fmt.Errorf("schedule: scan: %w", err)
+		}
+		i := slices.Index(names, st.Name)
+		j := s.jobs[i]
+		st.Every, st.Jitter, st.Misfire = j.Every.String(), j.Jitter.String(), j.Misfire
+		out = append(out, st)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("schedule: rows: %w", err)
+	}
+	return out, nil
+}
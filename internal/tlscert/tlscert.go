@@ -0,0 +1,136 @@
+// Package tlscert provides the REST API's TLS certificate, one of two ways:
+//
+//   - a key pair from files, re-read when they change, so a renewal
+//     (certbot, cert-manager) takes effect without a restart. A pair
+//     that doesn't load — the key written but not yet the certificate —
+//     is logged and the old one kept until the next check.
+//   - Let's Encrypt for the configured domains (autocert), answering
+//     its tls-alpn-01 challenges on the HTTPS port itself, which has to
+//     be reachable as :443. Certificates are cached in a directory and
+//     renewed before they expire.
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Reloader serves the key pair in certFile and keyFile, as of its last
+// Reload
+type Reloader struct {
+	certFile, keyFile string
+	log               *slog.Logger
+
+	cert  atomic.Pointer[tls.Certificate]
+	stamp stamp // of the files the loaded pair came from
+}
+
+// stamp — what tells a file changed, for both of them
+type stamp struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// Load reads the key pair; an error if it doesn't load, so a server
+// isn't started without one
+func Load(certFile, keyFile string, log *slog.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, log: log}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate — for tls.Config
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// TLSConfig — a server config presenting the current pair
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Reload re-reads the pair if either file changed since it was last
+// loaded; true if it did. On an error the pair already loaded stays.
+// Not safe to call concurrently (Watch calls it).
+func (r *Reloader) Reload() (bool, error) {
+	s, err := r.statFiles()
+	if err != nil {
+		return false, err
+	}
+	if r.cert.Load() != nil && s == r.stamp {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load TLS key pair %s, %s: %w", r.certFile, r.keyFile, err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return false, fmt.Errorf("parse TLS certificate %s: %w", r.certFile, err)
+	}
+	r.cert.Store(&cert)
+	r.stamp = s
+	r.log.Info("TLS certificate loaded", "file", r.certFile,
+		"subject", cert.Leaf.Subject.CommonName, "dns_names", cert.Leaf.DNSNames, "not_after", cert.Leaf.NotAfter)
+	return true, nil
+}
+
+func (r *Reloader) statFiles() (stamp, error) {
+	c, err := os.Stat(r.certFile)
+	if err != nil {
+		return stamp{}, fmt.Errorf("TLS certificate: %w", err)
+	}
+	k, err := os.Stat(r.keyFile)
+	if err != nil {
+		return stamp{}, fmt.Errorf("TLS key: %w", err)
+	}
+	return stamp{certMod: c.ModTime(), keyMod: k.ModTime(), certSize: c.Size(), keySize: k.Size()}, nil
+}
+
+// NotAfter — when the loaded certificate expires
+func (r *Reloader) NotAfter() time.Time {
+	return r.cert.Load().Leaf.NotAfter
+}
+
+// Watch checks the files every interval until ctx is done
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := r.Reload(); err != nil {
+				r.log.Error("TLS certificate reload failed; keeping the loaded one", "err", err, "not_after", r.NotAfter())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Autocert — a server config with certificates from Let's Encrypt for
+// domains (and no others), cached in cacheDir; email, if any, is given
+// to Let's Encrypt for expiry notices
+func Autocert(domains []string, cacheDir, email string) *tls.Config {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+	cfg := m.TLSConfig()
+	cfg.MinVersion = tls.VersionTLS12
+	return cfg
+}
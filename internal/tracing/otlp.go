@@ -0,0 +1,256 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// -----------------------------------------------------------
+// OTLP EXPORTER — ended spans go to a queue; one goroutine
+// posts them to <endpoint>/v1/traces in batches, every
+// exportInterval or as soon as a batch is full. A failed post
+// is logged and its spans are lost: a trace is worth less
+// than the memory to keep retrying it.
+// -----------------------------------------------------------
+
+const (
+	queueSize      = 4096
+	batchSize      = 512
+	exportInterval = 5 * time.Second
+	exportTimeout  = 10 * time.Second
+)
+
+type exporter struct {
+	url     string
+	headers map[string]string
+	service string
+	client  *http.Client // not httpclient's, which imports this package
+	log     *slog.Logger
+
+	queue   chan ended
+	dropped atomic.Int64 // since the last export
+
+	closeOnce sync.Once
+	done      chan struct{} // closed to stop run
+	stopped   chan struct{} // closed once run has sent the rest
+}
+
+type ended struct {
+	span *Span
+	end  time.Time
+}
+
+func newExporter(cfg Config, log *slog.Logger) *exporter {
+	e := &exporter{
+		url:     strings.TrimRight(cfg.Endpoint, "/") + "/v1/traces",
+		headers: cfg.Headers,
+		service: cfg.ServiceName,
+		client:  &http.Client{Timeout: exportTimeout},
+		log:     log,
+		queue:   make(chan ended, queueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) enqueue(s *Span, end time.Time) {
+	select {
+	case <-e.done:
+		e.dropped.Add(1)
+	case e.queue <- ended{s, end}:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.stopped)
+	t := time.NewTicker(exportInterval)
+	defer t.Stop()
+
+	batch := make([]ended, 0, batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.send(batch)
+			batch = batch[:0]
+		}
+		if n := e.dropped.Swap(0); n > 0 {
+			e.log.Warn("trace spans dropped: export queue full", "spans", n)
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) == batchSize {
+				flush()
+			}
+		case <-t.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case s := <-e.queue:
+					if batch = append(batch, s); len(batch) == batchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *exporter) shutdown(ctx context.Context) error {
+	e.closeOnce.Do(func() { close(e.done) })
+	select {
+	case <-e.stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("trace export: %w", ctx.Err())
+	}
+}
+
+func (e *exporter) send(batch []ended) {
+	body, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		e.log.Error("trace export failed", "err", err, "spans", len(batch))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		e.log.Error("trace export failed", "err", err, "spans", len(batch))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err == nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			err = errors.New(resp.Status)
+		}
+	}
+	if err != nil {
+		e.log.Warn("trace export failed", "err", err, "url", e.url, "spans", len(batch))
+	}
+}
+
+// OTLP/JSON, as opentelemetry-proto's ExportTraceServiceRequest: IDs
+// in hex, 64-bit integers as strings
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         Kind       `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"` // 0 unset, 2 error
+		Message string `json:"message,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		String *string  `json:"stringValue,omitempty"`
+		Int    *string  `json:"intValue,omitempty"`
+		Double *float64 `json:"doubleValue,omitempty"`
+		Bool   *bool    `json:"boolValue,omitempty"`
+	}
+)
+
+func (e *exporter) encode(batch []ended) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, b := range batch {
+		s := b.span
+		s.mu.Lock()
+		os := otlpSpan{
+			TraceID: s.sc.TraceID.String(),
+			SpanID:  s.sc.SpanID.String(),
+			Name:    s.name,
+			Kind:    s.kind,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(b.end.UnixNano(), 10),
+		}
+		if s.parent != (SpanID{}) {
+			os.ParentSpanID = s.parent.String()
+		}
+		for _, a := range s.attrs {
+			os.Attributes = append(os.Attributes, otlpAttribute(a))
+		}
+		if s.failed {
+			os.Status = otlpStatus{Code: 2, Message: s.msg}
+		}
+		s.mu.Unlock()
+		spans = append(spans, os)
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{otlpAttribute(String("service.name", e.service))}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "sandbox-go/internal/tracing"}, Spans: spans}},
+	}}}
+}
+
+func otlpAttribute(a Attr) otlpAttr {
+	var v otlpValue
+	switch x := a.Value.(type) {
+	case string:
+		v.String = &x
+	case int:
+		s := strconv.Itoa(x)
+		v.Int = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.Int = &s
+	case float64:
+		v.Double = &x
+	case bool:
+		v.Bool = &x
+	default:
+		s := fmt.Sprint(x)
+		v.String = &s
+	}
+	return otlpAttr{Key: a.Key, Value: v}
+}
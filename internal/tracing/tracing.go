@@ -0,0 +1,280 @@
+// Package tracing records spans — a request, the queries it ran, the
+// calls it made — and sends them to an OpenTelemetry collector (or
+// Jaeger, Tempo, ...) over OTLP/HTTP, JSON encoded, so a trace spans
+// every service that passes W3C trace context along.
+//
+// It is the part of the OTel SDK this service needs, on the standard
+// library only:
+//
+//   - a traceparent header coming in is the parent of the request's
+//     span, and its sampled flag decides; a request without one is a
+//     new trace, sampled at SampleRatio
+//   - spans below it (Child) need no tracer at hand: a DB query or an
+//     outbound call started from the request's context joins its trace
+//     — outside a trace they are no-ops
+//   - Inject sets traceparent on outgoing requests
+//   - finished spans are batched and sent in the background. When the
+//     collector is down or slow, spans past the queue are dropped,
+//     never blocking a request.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sandbox-go/internal/requestctx"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// SpanContext — what crosses process boundaries: the trace, the span
+// and whether the trace is recorded
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceparentHeader — W3C Trace Context
+const TraceparentHeader = "traceparent"
+
+// ParseTraceparent reads "00-<trace>-<span>-<flags>"; false if h isn't
+// one (a version past 00 is read the same, as the spec asks)
+func ParseTraceparent(h string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if len(parts[1]) != 2*len(sc.TraceID) || len(parts[2]) != 2*len(sc.SpanID) || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.Valid()
+}
+
+// Traceparent — sc as the header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Kind — OTLP's span kinds, the ones used here
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attr — a span attribute; Value is a string, int, int64, float64 or
+// bool (anything else is sent as its fmt %v)
+type Attr struct {
+	Key   string
+	Value any
+}
+
+func String(k, v string) Attr  { return Attr{k, v} }
+func Int(k string, v int) Attr { return Attr{k, v} }
+
+type Config struct {
+	// Endpoint — the collector's OTLP/HTTP base URL, http://host:4318;
+	// spans go to its /v1/traces
+	Endpoint string
+	Headers  map[string]string // sent with every export (API keys)
+	// ServiceName — service.name of every span
+	ServiceName string
+	// SampleRatio — share of new traces recorded, 0-1; one that comes
+	// with a traceparent follows its sampled flag
+	SampleRatio float64
+}
+
+// Tracer starts the root spans of requests; see Child for the rest
+type Tracer struct {
+	cfg Config
+	exp *exporter
+}
+
+// New starts the exporter; Shutdown sends what's left
+func New(cfg Config, log *slog.Logger) *Tracer {
+	return &Tracer{cfg: cfg, exp: newExporter(cfg, log)}
+}
+
+// Shutdown sends the spans still queued, waiting until ctx is done at
+// most; spans ended after it are dropped
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	return t.exp.shutdown(ctx)
+}
+
+// Span — one timed operation. Its methods are safe on a nil *Span
+// (tracing off, or no trace), so callers don't check.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent SpanID
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu     sync.Mutex
+	attrs  []Attr
+	failed bool
+	msg    string // why, if failed
+	ended  bool
+}
+
+var (
+	spanKey   = requestctx.NewKey[*Span]("span")
+	remoteKey = requestctx.NewKey[SpanContext]("remote_span")
+)
+
+// FromContext — the span ctx is in; nil if none
+func FromContext(ctx context.Context) *Span {
+	s, _ := spanKey.Get(ctx)
+	return s
+}
+
+// WithRemote — ctx with the parent span of another process (from its
+// traceparent), for Start
+func WithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return remoteKey.With(ctx, sc)
+}
+
+// Start begins a span: a child of the span in ctx, or of the remote
+// parent (WithRemote), or a new trace. A nil Tracer starts nothing.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if p := FromContext(ctx); p != nil {
+		s.sc.TraceID, s.parent, s.sc.Sampled = p.sc.TraceID, p.sc.SpanID, p.sc.Sampled
+	} else if r, ok := remoteKey.Get(ctx); ok && r.Valid() {
+		s.sc.TraceID, s.parent, s.sc.Sampled = r.TraceID, r.SpanID, r.Sampled
+	} else {
+		s.sc.TraceID = newTraceID()
+		s.sc.Sampled = rand.Float64() < t.cfg.SampleRatio
+	}
+	s.sc.SpanID = newSpanID()
+	return spanKey.With(ctx, s), s
+}
+
+// Child begins a span under the one in ctx, with its tracer; without
+// one it returns ctx and nil
+func Child(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	p := FromContext(ctx)
+	if p == nil {
+		return ctx, nil
+	}
+	return p.tracer.Start(ctx, name, kind, attrs...)
+}
+
+// Inject sets traceparent on h, for a call made from ctx's span
+func Inject(ctx context.Context, h http.Header) {
+	if s := FromContext(ctx); s != nil {
+		h.Set(TraceparentHeader, s.sc.Traceparent())
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id == (TraceID{}) {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id == (SpanID{}) {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+// Context — the span's IDs; the zero SpanContext for a nil span
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span failed; a nil err does nothing
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Fail(err.Error())
+}
+
+// Fail marks the span failed, for msg (a 500 is one without an error)
+func (s *Span) Fail(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.msg = true, msg
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export if its trace is
+// sampled; only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exp.enqueue(s, end)
+	}
+}
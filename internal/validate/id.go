@@ -0,0 +1,44 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"sandbox-go/internal/apperr"
+)
+
+// MaxID — the largest value a SERIAL (int4) column can hold; anything
+// bigger can't name a row and would only make Postgres complain.
+const MaxID = math.MaxInt32
+
+// ParseID is the one definition of a resource ID in a path segment or
+// a GraphQL ID: a positive integer in canonical form ("42", not "042"
+// or "+42") that fits in MaxID. Anything else is 400 INVALID_ID and
+// never reaches the database.
+func ParseID(s string) (int, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+		return 0, outOfRange(s)
+	}
+	if err != nil || strconv.FormatInt(n, 10) != s {
+		return 0, apperr.New(apperr.InvalidID, fmt.Sprintf("%q is not a valid ID", s))
+	}
+	return CheckID(n)
+}
+
+// CheckID applies the same rules to an ID that arrived as a number
+// (gRPC fields, JSON bodies).
+func CheckID(n int64) (int, error) {
+	if n < 1 {
+		return 0, apperr.New(apperr.InvalidID, fmt.Sprintf("%d is not a valid ID: IDs are positive integers", n))
+	}
+	if n > MaxID {
+		return 0, outOfRange(strconv.FormatInt(n, 10))
+	}
+	return int(n), nil
+}
+
+func outOfRange(s string) error {
+	return apperr.New(apperr.InvalidID, fmt.Sprintf("ID %s is out of range (max %d)", s, MaxID))
+}
@@ -0,0 +1,82 @@
+// Package validate collects every problem with an input instead of
+// stopping at the first one, so a client fixes its form in one round
+// trip:
+//
+//	err := validate.New().
+//		Required("title", req.Title).
+//		MaxLen("title", req.Title, 255).
+//		ID("user_id", req.UserID).
+//		Err()
+//
+// Err returns nil or an *apperr.Error with code VALIDATION_FAILED
+// (422) whose Fields list each violation. After a field fails, later
+// rules for the same field are skipped — "required" is enough, no need
+// to also report "not a valid email".
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"sandbox-go/internal/apperr"
+)
+
+type Validator struct {
+	errs   []apperr.FieldError
+	failed map[string]bool
+}
+
+func New() *Validator {
+	return &Validator{failed: map[string]bool{}}
+}
+
+// Check is the building block: records message for field unless ok.
+func (v *Validator) Check(field string, ok bool, message string) *Validator {
+	if !ok && !v.failed[field] {
+		v.failed[field] = true
+		v.errs = append(v.errs, apperr.FieldError{Field: field, Message: message})
+	}
+	return v
+}
+
+func (v *Validator) Required(field, value string) *Validator {
+	return v.Check(field, value != "", "required")
+}
+
+// MaxLen counts characters, like VARCHAR(n) does — not bytes.
+func (v *Validator) MaxLen(field, value string, n int) *Validator {
+	return v.Check(field, utf8.RuneCountInString(value) <= n, fmt.Sprintf("must be at most %d characters", n))
+}
+
+// Email accepts a bare address like "alice@example.com" (net/mail also
+// accepts "Alice <alice@example.com>", we don't).
+func (v *Validator) Email(field, value string) *Validator {
+	addr, err := mail.ParseAddress(value)
+	return v.Check(field, err == nil && addr.Address == value, "must be a valid email address")
+}
+
+// OneOf — value must be one of allowed (an enum column)
+func (v *Validator) OneOf(field, value string, allowed []string) *Validator {
+	return v.Check(field, slices.Contains(allowed, value), "must be one of "+strings.Join(allowed, ", "))
+}
+
+// ID — a reference to another row; 0 means it was not sent. Same range
+// as CheckID.
+func (v *Validator) ID(field string, id int) *Validator {
+	v.Check(field, id != 0, "required")
+	v.Check(field, id > 0, "must be a positive integer")
+	return v.Check(field, id <= MaxID, fmt.Sprintf("must be at most %d", MaxID))
+}
+
+// Err is nil when every rule passed.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	e := apperr.New(apperr.ValidationFailed, "request has invalid fields")
+	e.Fields = v.errs
+	return e
+}